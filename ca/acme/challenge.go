@@ -0,0 +1,66 @@
+package acme
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoClientCertificate = errors.New("challenge must be accepted over mTLS with a client certificate issued by the CA")
+)
+
+// validateHTTP01 fetches the key authorization from the identifier by HTTP (RFC 8555 section 8.3)
+func (s *Server) validateHTTP01(r *http.Request, domain, keyAuthorization string) error {
+	token := strings.SplitN(keyAuthorization, ".", 2)[0]
+	url := fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", net.JoinHostPort(domain, strconv.Itoa(s.cfg.HTTP01Port)), token)
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch the challenge: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected challenge response status %d", res.StatusCode)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	if err != nil {
+		return fmt.Errorf("failed to read the challenge: %w", err)
+	}
+
+	if strings.TrimSpace(string(b)) != keyAuthorization {
+		return errors.New("key authorization does not match")
+	}
+
+	return nil
+}
+
+// validateMTLSAttested validates the identifier by the mTLS client certificate of the challenge request
+// the certificate must be verified by the CA, not revoked and include the identifier in its DNS names
+func (s *Server) validateMTLSAttested(r *http.Request, domain string) error {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return errNoClientCertificate
+	}
+
+	c := r.TLS.PeerCertificates[0]
+	if s.ca.Store().IsRevoked(c.SerialNumber.Int64()) {
+		return errors.New("client certificate is revoked")
+	}
+
+	if err := c.VerifyHostname(domain); err != nil {
+		return fmt.Errorf("client certificate does not include the identifier: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jws is a flattened JSON web signature of an ACME request (RFC 8555 section 6.2)
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// protectedHeader is the JWS protected header, either the JWK (new account) or the key ID (account URL) is set
+type protectedHeader struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+	KID   string          `json:"kid,omitempty"`
+}
+
+// jwk is a JSON web key, only RSA and EC P-256 keys are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// parse decodes the JWS protected header and payload
+func (j *jws) parse() (*protectedHeader, []byte, error) {
+	headerBytes, err := base64.RawURLEncoding.DecodeString(j.Protected)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid protected header encoding: %w", err)
+	}
+
+	var header protectedHeader
+	err = json.Unmarshal(headerBytes, &header)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid protected header: %w", err)
+	}
+
+	if (len(header.JWK) == 0) == (header.KID == "") {
+		return nil, nil, errors.New("either jwk or kid is required in the protected header")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(j.Payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid payload encoding: %w", err)
+	}
+
+	return &header, payload, nil
+}
+
+// verify verifies the JWS signature by the public key
+func (j *jws) verify(alg string, pub crypto.PublicKey) error {
+	signature, err := base64.RawURLEncoding.DecodeString(j.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	hash := sha256.Sum256([]byte(j.Protected + "." + j.Payload))
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("unsupported algorithm %q for RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	case *ecdsa.PublicKey:
+		if alg != "ES256" {
+			return fmt.Errorf("unsupported algorithm %q for EC key", alg)
+		}
+		if len(signature) != 64 {
+			return errors.New("invalid ES256 signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, hash[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return errors.New("unsupported key type")
+	}
+}
+
+// publicKey decodes the JWK to a public key
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("invalid EC point")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// thumbprint returns the base64url-encoded JWK SHA-256 thumbprint (RFC 7638)
+func (k *jwk) thumbprint() string {
+	var canonical string
+	switch k.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	default:
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, k.Crv, k.Kty, k.X, k.Y)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,33 @@
+package acme
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const problemPrefix = "urn:ietf:params:acme:error:"
+
+// problem is an ACME error document (RFC 7807)
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func malformed(detail string) *problem {
+	return &problem{Type: problemPrefix + "malformed", Detail: detail, Status: http.StatusBadRequest}
+}
+
+func unauthorized(detail string) *problem {
+	return &problem{Type: problemPrefix + "unauthorized", Detail: detail, Status: http.StatusUnauthorized}
+}
+
+func badCSR(detail string) *problem {
+	return &problem{Type: problemPrefix + "badCSR", Detail: detail, Status: http.StatusBadRequest}
+}
+
+func writeProblem(w http.ResponseWriter, p *problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
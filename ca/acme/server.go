@@ -0,0 +1,909 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+const (
+	ChallengeHTTP01 = "http-01"
+
+	// ChallengeMTLSAttested is a custom challenge type for the internal names
+	// the client proves the control of the name by accepting the challenge over mTLS with a certificate issued by the CA including the name
+	ChallengeMTLSAttested = "mtls-attested-01"
+
+	statusPending     = "pending"
+	statusReady       = "ready"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+
+	// orderLifetime is the time that an order and its authorizations must be completed in
+	orderLifetime = 24 * time.Hour
+
+	// nonceLifetime is the time that a nonce can be used in
+	nonceLifetime = time.Hour
+
+	// maxRequestSize limits the JWS request body
+	maxRequestSize = 64 << 10
+
+	// maxNonces limits the number of the issued nonces which are not used yet, the oldest nonce is evicted by a new one
+	maxNonces = 10000
+
+	// maxSourceRequests limits the requests of a source address per sourceWindow, every response issues a fresh nonce
+	maxSourceRequests = 300
+	sourceWindow      = time.Minute
+
+	// maxSources limits the number of the source addresses counted in a window, the other sources are limited
+	maxSources = 10000
+
+	// maxAccounts limits the number of the accounts, the accounts without orders are evicted after an order lifetime of no use
+	maxAccounts = 10000
+
+	// maxAccountOrders limits the orders of an account which are not expired, and maxOrderIdentifiers the identifiers of an order
+	maxAccountOrders    = 100
+	maxOrderIdentifiers = 100
+
+	// evictInterval is the interval of removing the expired orders, their authorizations, challenges and certificates
+	evictInterval = time.Minute
+)
+
+// Config is the ACME server configuration
+type Config struct {
+	// Prefix is the path prefix of the ACME endpoints, the directory is served at [prefix]/directory
+	Prefix string
+
+	// Domains are the patterns of the identifiers the CA issues certificates for, matched by path.Match e.g. *.local
+	// the identifiers are lowercased and must be hostnames before they're matched
+	Domains []string
+
+	// HTTP01Port is the port that the http-01 challenges are validated on, 80 if it's zero
+	HTTP01Port int
+
+	// Expiration is the lifetime of the issued certificates
+	Expiration time.Duration
+}
+
+// Server is an ACME (RFC 8555) server subset issuing server certificates by the CA
+// it supports accounts, orders, authorizations, http-01 and mtls-attested-01 challenges, and certificate download
+type Server struct {
+	ca         *ca.CA
+	cfg        Config
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	nonces        map[string]time.Time
+	nonceRing     []string
+	nonceNext     int
+	sources       map[string]int
+	sourcesReset  time.Time
+	accounts      map[string]*account
+	accountsByKey map[string]*account
+	orders        map[string]*order
+	authzs        map[string]*authorization
+	challenges    map[string]*challenge
+	certs         map[string][]byte
+	evictAt       time.Time
+}
+
+type account struct {
+	id         string
+	status     string
+	contact    []string
+	key        crypto.PublicKey
+	thumbprint string
+	orders     int // orders which are not evicted
+	lastUsed   time.Time
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type order struct {
+	id          string
+	accountID   string
+	status      string
+	expires     time.Time
+	identifiers []identifier
+	authzIDs    []string
+	certID      string
+}
+
+type authorization struct {
+	id           string
+	accountID    string
+	status       string
+	identifier   identifier
+	expires      time.Time
+	challengeIDs []string
+}
+
+type challenge struct {
+	id        string
+	authzID   string
+	typ       string
+	token     string
+	status    string
+	validated time.Time
+	err       *problem
+}
+
+// NewServer returns a new instance of Server
+func NewServer(authority *ca.CA, cfg Config) *Server {
+	if cfg.HTTP01Port == 0 {
+		cfg.HTTP01Port = 80
+	}
+
+	return &Server{
+		ca:  authority,
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		nonces:        make(map[string]time.Time),
+		nonceRing:     make([]string, maxNonces),
+		sources:       make(map[string]int),
+		accounts:      make(map[string]*account),
+		accountsByKey: make(map[string]*account),
+		orders:        make(map[string]*order),
+		authzs:        make(map[string]*authorization),
+		challenges:    make(map[string]*challenge),
+		certs:         make(map[string][]byte),
+	}
+}
+
+// Register registers the ACME endpoints on the mux
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.Handle(s.cfg.Prefix+"/", s)
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the requests are not authenticated before a nonce is issued, so a source can not flood the nonces
+	if !s.allowSource(r) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(sourceWindow.Seconds())))
+		writeProblem(w, &problem{Type: problemPrefix + "rateLimited", Detail: "too many requests", Status: http.StatusTooManyRequests})
+		return
+	}
+
+	// every response includes a fresh nonce for the next request of the client
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+
+	route := strings.Split(strings.TrimPrefix(r.URL.Path, s.cfg.Prefix+"/"), "/")
+
+	switch {
+	case len(route) == 1 && route[0] == "directory":
+		s.handleDirectory(w, r)
+		return
+	case len(route) == 1 && route[0] == "new-nonce":
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	header, payload, acc, key, prob := s.authenticate(r)
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	// only the new account request is signed by the JWK, the others are signed by the account key
+	newAccount := len(route) == 1 && route[0] == "new-account"
+	if newAccount != (acc == nil) {
+		writeProblem(w, malformed("new-account must be signed by jwk, the other requests by kid"))
+		return
+	}
+
+	switch {
+	case newAccount:
+		s.handleNewAccount(w, r, header, key, payload)
+	case len(route) == 2 && route[0] == "account":
+		s.handleAccount(w, r, acc, route[1], payload)
+	case len(route) == 1 && route[0] == "new-order":
+		s.handleNewOrder(w, r, acc, payload)
+	case len(route) == 2 && route[0] == "order":
+		s.handleOrder(w, r, acc, route[1])
+	case len(route) == 3 && route[0] == "order" && route[2] == "finalize":
+		s.handleFinalize(w, r, acc, route[1], payload)
+	case len(route) == 2 && route[0] == "authz":
+		s.handleAuthorization(w, r, acc, route[1])
+	case len(route) == 2 && route[0] == "chall":
+		s.handleChallenge(w, r, acc, route[1])
+	case len(route) == 2 && route[0] == "cert":
+		s.handleCertificate(w, acc, route[1])
+	default:
+		writeProblem(w, &problem{Type: problemPrefix + "malformed", Detail: "not found", Status: http.StatusNotFound})
+	}
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	base := s.baseURL(r)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   base + "/new-nonce",
+		"newAccount": base + "/new-account",
+		"newOrder":   base + "/new-order",
+	})
+}
+
+// authenticate verifies the JWS request, its nonce and URL, and returns the header, payload and the account if it's signed by kid
+func (s *Server) authenticate(r *http.Request) (*protectedHeader, []byte, *account, *jwk, *problem) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		return nil, nil, nil, nil, malformed("failed to read request body")
+	}
+
+	var j jws
+	err = json.Unmarshal(body, &j)
+	if err != nil {
+		return nil, nil, nil, nil, malformed("invalid JWS")
+	}
+
+	header, payload, err := j.parse()
+	if err != nil {
+		return nil, nil, nil, nil, malformed(err.Error())
+	}
+
+	if !s.useNonce(header.Nonce) {
+		return nil, nil, nil, nil, &problem{Type: problemPrefix + "badNonce", Detail: "invalid or used nonce", Status: http.StatusBadRequest}
+	}
+
+	if header.URL != s.baseURL(r)+strings.TrimPrefix(r.URL.Path, s.cfg.Prefix) {
+		return nil, nil, nil, nil, unauthorized("JWS url does not match the request URL")
+	}
+
+	if len(header.JWK) > 0 {
+		var key jwk
+		err = json.Unmarshal(header.JWK, &key)
+		if err != nil {
+			return nil, nil, nil, nil, malformed("invalid jwk")
+		}
+
+		pub, err := key.publicKey()
+		if err != nil {
+			return nil, nil, nil, nil, &problem{Type: problemPrefix + "badPublicKey", Detail: err.Error(), Status: http.StatusBadRequest}
+		}
+
+		if err := j.verify(header.Alg, pub); err != nil {
+			return nil, nil, nil, nil, &problem{Type: problemPrefix + "badSignatureAlgorithm", Detail: err.Error(), Status: http.StatusBadRequest}
+		}
+
+		return header, payload, nil, &key, nil
+	}
+
+	accountPrefix := s.baseURL(r) + "/account/"
+	if !strings.HasPrefix(header.KID, accountPrefix) {
+		return nil, nil, nil, nil, &problem{Type: problemPrefix + "accountDoesNotExist", Detail: "unknown kid", Status: http.StatusBadRequest}
+	}
+
+	s.mu.Lock()
+	acc, ok := s.accounts[strings.TrimPrefix(header.KID, accountPrefix)]
+	if ok {
+		acc.lastUsed = time.Now()
+	}
+	s.mu.Unlock()
+	if !ok {
+		return nil, nil, nil, nil, &problem{Type: problemPrefix + "accountDoesNotExist", Detail: "unknown kid", Status: http.StatusBadRequest}
+	}
+
+	if acc.status != statusValid {
+		return nil, nil, nil, nil, unauthorized("account is not valid")
+	}
+
+	if err := j.verify(header.Alg, acc.key); err != nil {
+		return nil, nil, nil, nil, malformed(fmt.Sprintf("invalid signature: %s", err))
+	}
+
+	return header, payload, acc, nil, nil
+}
+
+func (s *Server) handleNewAccount(w http.ResponseWriter, r *http.Request, _ *protectedHeader, key *jwk, payload []byte) {
+	var req struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, malformed("invalid account request"))
+		return
+	}
+
+	thumbprint := key.thumbprint()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if acc, ok := s.accountsByKey[thumbprint]; ok {
+		w.Header().Set("Location", s.baseURL(r)+"/account/"+acc.id)
+		writeJSON(w, http.StatusOK, s.accountObject(r, acc))
+		return
+	}
+
+	if req.OnlyReturnExisting {
+		writeProblem(w, &problem{Type: problemPrefix + "accountDoesNotExist", Detail: "account does not exist", Status: http.StatusBadRequest})
+		return
+	}
+
+	// the new accounts are not authenticated, so the number of the accounts is limited
+	s.evictExpired(time.Now())
+	if len(s.accounts) >= maxAccounts {
+		writeProblem(w, &problem{Type: problemPrefix + "rateLimited", Detail: "too many accounts", Status: http.StatusTooManyRequests})
+		return
+	}
+
+	pub, _ := key.publicKey() // verified by authenticate
+	acc := &account{
+		id:         newID(),
+		status:     statusValid,
+		contact:    req.Contact,
+		key:        pub,
+		thumbprint: thumbprint,
+		lastUsed:   time.Now(),
+	}
+	s.accounts[acc.id] = acc
+	s.accountsByKey[thumbprint] = acc
+
+	w.Header().Set("Location", s.baseURL(r)+"/account/"+acc.id)
+	writeJSON(w, http.StatusCreated, s.accountObject(r, acc))
+}
+
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request, acc *account, id string, payload []byte) {
+	if acc.id != id {
+		writeProblem(w, unauthorized("account does not match the kid"))
+		return
+	}
+
+	var req struct {
+		Status  string   `json:"status"`
+		Contact []string `json:"contact"`
+	}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &req); err != nil {
+			writeProblem(w, malformed("invalid account request"))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if req.Contact != nil {
+		acc.contact = req.Contact
+	}
+	if req.Status == statusDeactivated {
+		acc.status = statusDeactivated
+	}
+
+	writeJSON(w, http.StatusOK, s.accountObject(r, acc))
+}
+
+func (s *Server) handleNewOrder(w http.ResponseWriter, r *http.Request, acc *account, payload []byte) {
+	var req struct {
+		Identifiers []identifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil || len(req.Identifiers) == 0 {
+		writeProblem(w, malformed("invalid order request"))
+		return
+	}
+
+	if len(req.Identifiers) > maxOrderIdentifiers {
+		writeProblem(w, malformed(fmt.Sprintf("order has more than %d identifiers", maxOrderIdentifiers)))
+		return
+	}
+
+	// the identifiers are the names of the http-01 URLs and the certificate SANs, so they must be hostnames
+	// before they're matched, the patterns match any characters but /
+	for i := range req.Identifiers {
+		req.Identifiers[i].Value = strings.ToLower(req.Identifiers[i].Value)
+	}
+
+	for _, id := range req.Identifiers {
+		if id.Type != "dns" || !validHostname(id.Value) || !s.allowedDomain(id.Value) {
+			writeProblem(w, &problem{Type: problemPrefix + "rejectedIdentifier", Detail: fmt.Sprintf("identifier %q is not allowed", id.Value), Status: http.StatusForbidden})
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the orders are held until they're expired, so the orders of an account are limited
+	s.evictExpired(time.Now())
+	if acc.orders >= maxAccountOrders {
+		writeProblem(w, &problem{Type: problemPrefix + "rateLimited", Detail: "too many orders of the account", Status: http.StatusTooManyRequests})
+		return
+	}
+
+	o := &order{
+		id:          newID(),
+		accountID:   acc.id,
+		status:      statusPending,
+		expires:     time.Now().Add(orderLifetime).UTC(),
+		identifiers: req.Identifiers,
+	}
+
+	for _, id := range req.Identifiers {
+		authz := &authorization{
+			id:         newID(),
+			accountID:  acc.id,
+			status:     statusPending,
+			identifier: id,
+			expires:    o.expires,
+		}
+
+		for _, typ := range []string{ChallengeHTTP01, ChallengeMTLSAttested} {
+			chal := &challenge{
+				id:      newID(),
+				authzID: authz.id,
+				typ:     typ,
+				token:   newID(),
+				status:  statusPending,
+			}
+			s.challenges[chal.id] = chal
+			authz.challengeIDs = append(authz.challengeIDs, chal.id)
+		}
+
+		s.authzs[authz.id] = authz
+		o.authzIDs = append(o.authzIDs, authz.id)
+	}
+	s.orders[o.id] = o
+	acc.orders++
+
+	w.Header().Set("Location", s.baseURL(r)+"/order/"+o.id)
+	writeJSON(w, http.StatusCreated, s.orderObject(r, o))
+}
+
+func (s *Server) handleOrder(w http.ResponseWriter, r *http.Request, acc *account, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok || o.accountID != acc.id {
+		writeProblem(w, &problem{Type: problemPrefix + "malformed", Detail: "order not found", Status: http.StatusNotFound})
+		return
+	}
+
+	s.updateOrderStatus(o)
+	writeJSON(w, http.StatusOK, s.orderObject(r, o))
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request, acc *account, id string, payload []byte) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, malformed("invalid finalize request"))
+		return
+	}
+
+	csrBytes, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		writeProblem(w, badCSR("invalid csr encoding"))
+		return
+	}
+
+	csr, err := cert.DecodeCSRFromDERBytes(csrBytes)
+	if err != nil {
+		writeProblem(w, badCSR(err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	o, ok := s.orders[id]
+	if !ok || o.accountID != acc.id {
+		s.mu.Unlock()
+		writeProblem(w, &problem{Type: problemPrefix + "malformed", Detail: "order not found", Status: http.StatusNotFound})
+		return
+	}
+
+	s.updateOrderStatus(o)
+	if o.status != statusReady {
+		s.mu.Unlock()
+		writeProblem(w, &problem{Type: problemPrefix + "orderNotReady", Detail: fmt.Sprintf("order is %s", o.status), Status: http.StatusForbidden})
+		return
+	}
+
+	// the CSR must request exactly the identifiers of the order
+	if !sameNames(o.identifiers, csr.DNSNames) || (csr.Subject.CommonName != "" && !contains(csr.DNSNames, csr.Subject.CommonName)) {
+		s.mu.Unlock()
+		writeProblem(w, badCSR("csr names do not match the order identifiers"))
+		return
+	}
+
+	o.status = "processing"
+	s.mu.Unlock()
+
+	issued, err := s.ca.IssueValidated("acme:"+acc.id, csr, s.cfg.Expiration)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		o.status = statusInvalid
+		writeProblem(w, &problem{Type: problemPrefix + "serverInternal", Detail: "failed to issue the certificate", Status: http.StatusInternalServerError})
+		return
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued.Raw})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Certificate().Raw})...)
+
+	o.certID = newID()
+	o.status = statusValid
+	s.certs[o.certID] = chain
+
+	w.Header().Set("Location", s.baseURL(r)+"/order/"+o.id)
+	writeJSON(w, http.StatusOK, s.orderObject(r, o))
+}
+
+func (s *Server) handleAuthorization(w http.ResponseWriter, r *http.Request, acc *account, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	authz, ok := s.authzs[id]
+	if !ok || authz.accountID != acc.id {
+		writeProblem(w, &problem{Type: problemPrefix + "malformed", Detail: "authorization not found", Status: http.StatusNotFound})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.authorizationObject(r, authz))
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request, acc *account, id string) {
+	s.mu.Lock()
+	chal, ok := s.challenges[id]
+	var authz *authorization
+	if ok {
+		authz = s.authzs[chal.authzID]
+	}
+	if !ok || authz.accountID != acc.id {
+		s.mu.Unlock()
+		writeProblem(w, &problem{Type: problemPrefix + "malformed", Detail: "challenge not found", Status: http.StatusNotFound})
+		return
+	}
+
+	pending := chal.status == statusPending && authz.status == statusPending && time.Now().Before(authz.expires)
+	value, token := authz.identifier.Value, chal.token
+	s.mu.Unlock()
+
+	// the challenge is validated synchronously, the client polls the authorization afterward
+	if pending {
+		var validationErr error
+		switch chal.typ {
+		case ChallengeHTTP01:
+			validationErr = s.validateHTTP01(r, value, token+"."+acc.thumbprint)
+		case ChallengeMTLSAttested:
+			validationErr = s.validateMTLSAttested(r, value)
+		}
+
+		s.mu.Lock()
+		if validationErr != nil {
+			chal.status = statusInvalid
+			chal.err = &problem{Type: problemPrefix + "incorrectResponse", Detail: validationErr.Error(), Status: http.StatusForbidden}
+			authz.status = statusInvalid
+		} else {
+			chal.status = statusValid
+			chal.validated = time.Now().UTC()
+			authz.status = statusValid
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Add("Link", fmt.Sprintf(`<%s/authz/%s>;rel="up"`, s.baseURL(r), authz.id))
+	writeJSON(w, http.StatusOK, s.challengeObject(r, chal))
+}
+
+func (s *Server) handleCertificate(w http.ResponseWriter, acc *account, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var owned bool
+	for _, o := range s.orders {
+		if o.certID == id && o.accountID == acc.id {
+			owned = true
+			break
+		}
+	}
+
+	chain, ok := s.certs[id]
+	if !ok || !owned {
+		writeProblem(w, &problem{Type: problemPrefix + "malformed", Detail: "certificate not found", Status: http.StatusNotFound})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(chain)
+}
+
+// updateOrderStatus updates the pending order status by its authorizations, the caller must hold the lock
+func (s *Server) updateOrderStatus(o *order) {
+	if o.status != statusPending {
+		return
+	}
+
+	if time.Now().After(o.expires) {
+		o.status = statusInvalid
+		return
+	}
+
+	ready := true
+	for _, id := range o.authzIDs {
+		switch s.authzs[id].status {
+		case statusInvalid, statusDeactivated:
+			o.status = statusInvalid
+			return
+		case statusPending:
+			ready = false
+		}
+	}
+
+	if ready {
+		o.status = statusReady
+	}
+}
+
+// evictExpired removes the expired orders with their authorizations, challenges and certificates, and the accounts without orders
+// which are not used in an order lifetime, at most once per evictInterval, the caller must hold the lock
+func (s *Server) evictExpired(now time.Time) {
+	if now.Before(s.evictAt) {
+		return
+	}
+	s.evictAt = now.Add(evictInterval)
+
+	for id, o := range s.orders {
+		if now.Before(o.expires) {
+			continue
+		}
+
+		for _, authzID := range o.authzIDs {
+			if authz, ok := s.authzs[authzID]; ok {
+				for _, chalID := range authz.challengeIDs {
+					delete(s.challenges, chalID)
+				}
+			}
+			delete(s.authzs, authzID)
+		}
+		delete(s.certs, o.certID)
+		delete(s.orders, id)
+
+		if acc, ok := s.accounts[o.accountID]; ok {
+			acc.orders--
+		}
+	}
+
+	for id, acc := range s.accounts {
+		if acc.orders == 0 && now.Sub(acc.lastUsed) > orderLifetime {
+			delete(s.accounts, id)
+			delete(s.accountsByKey, acc.thumbprint)
+		}
+	}
+}
+
+// validHostname reports whether the lowercase name is an RFC 1123 hostname of dot-separated labels of letters, digits and hyphens,
+// a label doesn't start or end with a hyphen, the wildcards are not hostnames
+func validHostname(name string) bool {
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (s *Server) allowedDomain(name string) bool {
+	for _, pattern := range s.cfg.Domains {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// baseURL returns the external URL of the ACME endpoints based on the request
+func (s *Server) baseURL(r *http.Request) string {
+	scheme := "https"
+	if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host + s.cfg.Prefix
+}
+
+// newNonce issues a new nonce, the oldest issued nonce is evicted if the ring is full, whether it's used or not
+func (s *Server) newNonce() string {
+	nonce := newID()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.nonces, s.nonceRing[s.nonceNext])
+	s.nonceRing[s.nonceNext] = nonce
+	s.nonceNext = (s.nonceNext + 1) % len(s.nonceRing)
+	s.nonces[nonce] = time.Now().Add(nonceLifetime)
+
+	return nonce
+}
+
+// allowSource counts the request of the source address in the current window and reports whether it's allowed
+func (s *Server) allowSource(r *http.Request) bool {
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.sourcesReset) {
+		s.sources = make(map[string]int)
+		s.sourcesReset = now.Add(sourceWindow)
+	}
+
+	count, ok := s.sources[source]
+	if !ok && len(s.sources) >= maxSources {
+		return false
+	}
+
+	if count >= maxSourceRequests {
+		return false
+	}
+
+	s.sources[source] = count + 1
+	return true
+}
+
+func (s *Server) useNonce(nonce string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires, ok := s.nonces[nonce]
+	delete(s.nonces, nonce)
+	return ok && time.Now().Before(expires)
+}
+
+func (s *Server) accountObject(r *http.Request, acc *account) map[string]any {
+	return map[string]any{
+		"status":  acc.status,
+		"contact": acc.contact,
+		"orders":  s.baseURL(r) + "/account/" + acc.id + "/orders",
+	}
+}
+
+func (s *Server) orderObject(r *http.Request, o *order) map[string]any {
+	base := s.baseURL(r)
+
+	authzURLs := make([]string, 0, len(o.authzIDs))
+	for _, id := range o.authzIDs {
+		authzURLs = append(authzURLs, base+"/authz/"+id)
+	}
+
+	obj := map[string]any{
+		"status":         o.status,
+		"expires":        o.expires.Format(time.RFC3339),
+		"identifiers":    o.identifiers,
+		"authorizations": authzURLs,
+		"finalize":       base + "/order/" + o.id + "/finalize",
+	}
+	if o.certID != "" {
+		obj["certificate"] = base + "/cert/" + o.certID
+	}
+	return obj
+}
+
+func (s *Server) authorizationObject(r *http.Request, authz *authorization) map[string]any {
+	challenges := make([]map[string]any, 0, len(authz.challengeIDs))
+	for _, id := range authz.challengeIDs {
+		challenges = append(challenges, s.challengeObject(r, s.challenges[id]))
+	}
+
+	return map[string]any{
+		"status":     authz.status,
+		"expires":    authz.expires.Format(time.RFC3339),
+		"identifier": authz.identifier,
+		"challenges": challenges,
+	}
+}
+
+func (s *Server) challengeObject(r *http.Request, chal *challenge) map[string]any {
+	obj := map[string]any{
+		"type":   chal.typ,
+		"url":    s.baseURL(r) + "/chall/" + chal.id,
+		"token":  chal.token,
+		"status": chal.status,
+	}
+	if !chal.validated.IsZero() {
+		obj["validated"] = chal.validated.Format(time.RFC3339)
+	}
+	if chal.err != nil {
+		obj["error"] = chal.err
+	}
+	return obj
+}
+
+// newID returns a random base64url-encoded identifier, used for the object IDs, nonces and challenge tokens
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// sameNames reports whether the DNS names are the same as the identifiers regardless of the order
+func sameNames(identifiers []identifier, names []string) bool {
+	if len(identifiers) != len(names) {
+		return false
+	}
+
+	values := make([]string, 0, len(identifiers))
+	for _, id := range identifiers {
+		values = append(values, strings.ToLower(id.Value))
+	}
+
+	sorted := make([]string, 0, len(names))
+	for _, n := range names {
+		sorted = append(sorted, strings.ToLower(n))
+	}
+
+	sort.Strings(values)
+	sort.Strings(sorted)
+	for i := range values {
+		if values[i] != sorted[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
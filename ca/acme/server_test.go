@@ -0,0 +1,464 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+// newTestServer returns a loopback TLS server serving the ACME endpoints of a new CA
+func newTestServer(t *testing.T, http01Port int) (*httptest.Server, *ca.CA) {
+	srv, authority, _ := newTestACMEServer(t, http01Port)
+	return srv, authority
+}
+
+// newTestACMEServer returns a loopback TLS server like newTestServer and the ACME server it serves
+func newTestACMEServer(t *testing.T, http01Port int) (*httptest.Server, *ca.CA, *Server) {
+	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCertBytes, err := cert.NewCA(caPrivateKey, 1, "test CA", "test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := cert.DecodeFromDERBytes(caCertBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := ca.OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &ca.Policy{Rules: []ca.Rule{
+		{Identity: "test", Subjects: []string{"*.internal"}, DNSNames: []string{"*.internal"}, MaxTTL: ca.Duration(time.Hour)},
+	}}
+
+	authority := ca.New(caCert, caPrivateKey, policy, store, nil, nil)
+
+	s := NewServer(authority, Config{
+		Prefix:     "/acme",
+		Domains:    []string{"localhost", "*.internal"},
+		HTTP01Port: http01Port,
+		Expiration: time.Hour,
+	})
+
+	mux := http.NewServeMux()
+	s.Register(mux)
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = &tls.Config{
+		ClientCAs:  certPool(caCert),
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv, authority, s
+}
+
+// newTestClient returns a new registered ACME client, the client certificate is optional
+func newTestClient(t *testing.T, srv *httptest.Server, clientCert *tls.Certificate) *acme.Client {
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient := srv.Client()
+	if clientCert != nil {
+		transport := httpClient.Transport.(*http.Transport).Clone()
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: srv.URL + "/acme/directory",
+		HTTPClient:   httpClient,
+	}
+
+	_, err = client.Register(context.Background(), &acme.Account{}, acme.AcceptTOS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// obtain completes an order for the domain by the challenge type and returns the issued certificate chain
+func obtain(ctx context.Context, client *acme.Client, domain, challengeType string, accept func(*acme.Challenge) error) ([][]byte, error) {
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var chal *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == challengeType {
+				chal = c
+			}
+		}
+		if chal == nil {
+			return nil, errors.New("challenge type is not offered")
+		}
+
+		if err := accept(chal); err != nil {
+			return nil, err
+		}
+
+		if _, err := client.Accept(ctx, chal); err != nil {
+			return nil, err
+		}
+
+		if _, err := client.WaitAuthorization(ctx, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{domain}}, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	return chain, err
+}
+
+func TestHTTP01(t *testing.T) {
+	keyAuthorizations := make(map[string]string)
+	challengeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyAuthorization, ok := keyAuthorizations[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(keyAuthorization))
+	}))
+	defer challengeServer.Close()
+
+	_, port, _ := net.SplitHostPort(challengeServer.Listener.Addr().String())
+	http01Port, _ := strconv.Atoi(port)
+
+	srv, authority := newTestServer(t, http01Port)
+	client := newTestClient(t, srv, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	chain, err := obtain(ctx, client, "localhost", ChallengeHTTP01, func(chal *acme.Challenge) error {
+		keyAuthorization, err := client.HTTP01ChallengeResponse(chal.Token)
+		keyAuthorizations[client.HTTP01ChallengePath(chal.Token)] = keyAuthorization
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(chain) != 2 {
+		t.Fatalf("expected leaf and CA certificates, got %d", len(chain))
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName: "localhost",
+		Roots:   certPool(authority.Certificate()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the validated server certificate can not authenticate as a client
+	if len(leaf.ExtKeyUsage) != 1 || leaf.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Fatalf("expected only the server authentication usage, got %v", leaf.ExtKeyUsage)
+	}
+}
+
+func TestHTTP01IncorrectResponse(t *testing.T) {
+	challengeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("invalid"))
+	}))
+	defer challengeServer.Close()
+
+	_, port, _ := net.SplitHostPort(challengeServer.Listener.Addr().String())
+	http01Port, _ := strconv.Atoi(port)
+
+	srv, _ := newTestServer(t, http01Port)
+	client := newTestClient(t, srv, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := obtain(ctx, client, "localhost", ChallengeHTTP01, func(*acme.Challenge) error { return nil })
+	if err == nil {
+		t.Fatal("expected the authorization to fail")
+	}
+}
+
+func TestMTLSAttested(t *testing.T) {
+	srv, authority := newTestServer(t, 0)
+
+	clientPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrBytes, err := cert.NewCSR(clientPrivateKey, "svc.internal", "", []string{"svc.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := cert.DecodeCSRFromDERBytes(csrBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the attesting certificate is a client certificate including the name
+	attested, err := authority.Issue(ca.IssueRequest{Requester: "test", CSR: csr, Expiration: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client := newTestClient(t, srv, &tls.Certificate{
+		Certificate: [][]byte{attested.Raw},
+		PrivateKey:  clientPrivateKey,
+	})
+
+	noop := func(*acme.Challenge) error { return nil }
+
+	chain, err := obtain(ctx, client, "svc.internal", ChallengeMTLSAttested, noop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "svc.internal" {
+		t.Fatalf("unexpected DNS names %v", leaf.DNSNames)
+	}
+
+	// the client certificate does not include the name
+	_, err = obtain(ctx, client, "other.internal", ChallengeMTLSAttested, noop)
+	if err == nil {
+		t.Fatal("expected the authorization of other name to fail")
+	}
+
+	// the challenge is not accepted over mTLS
+	_, err = obtain(ctx, newTestClient(t, srv, nil), "svc.internal", ChallengeMTLSAttested, noop)
+	if err == nil {
+		t.Fatal("expected the authorization without client certificate to fail")
+	}
+}
+
+func TestRejectedIdentifier(t *testing.T) {
+	srv, _ := newTestServer(t, 0)
+	client := newTestClient(t, srv, nil)
+
+	// the pattern *.internal matches the names which are not hostnames
+	for _, name := range []string{"example.com", "a@b.internal", "x?.internal", "*.internal"} {
+		_, err := client.AuthorizeOrder(context.Background(), acme.DomainIDs(name))
+
+		var acmeErr *acme.Error
+		if !errors.As(err, &acmeErr) || acmeErr.ProblemType != problemPrefix+"rejectedIdentifier" {
+			t.Fatalf("expected rejectedIdentifier error of %q, got %v", name, err)
+		}
+	}
+
+	// the identifiers are lowercased
+	order, err := client.AuthorizeOrder(context.Background(), acme.DomainIDs("App.Internal"))
+	if err != nil {
+		t.Fatalf("expected order, got err: %s", err)
+	}
+	if order.Identifiers[0].Value != "app.internal" {
+		t.Errorf("expected lowercase identifier app.internal, got %s", order.Identifiers[0].Value)
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "app.internal", want: true},
+		{name: "localhost", want: true},
+		{name: "a-1.b2.internal", want: true},
+		{name: "a@b.internal"},
+		{name: "x?.internal"},
+		{name: "*.internal"},
+		{name: "a/b.internal"},
+		{name: "-app.internal"},
+		{name: "app-.internal"},
+		{name: "app..internal"},
+		{name: "app.internal."},
+		{name: "App.internal"},
+		{name: ""},
+	}
+
+	for _, tt := range tests {
+		if got := validHostname(tt.name); got != tt.want {
+			t.Errorf("expected %q valid %t, got %t", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestOrderEviction(t *testing.T) {
+	srv, _, s := newTestACMEServer(t, 0)
+	client := newTestClient(t, srv, nil)
+	ctx := context.Background()
+
+	for i := 0; i < maxAccountOrders; i++ {
+		_, err := client.AuthorizeOrder(ctx, acme.DomainIDs("app.internal"))
+		if err != nil {
+			t.Fatalf("expected order %d, got err: %s", i, err)
+		}
+	}
+
+	// the orders of the account are limited until they're expired, the limited request is not retried
+	client.RetryBackoff = func(int, *http.Request, *http.Response) time.Duration { return 0 }
+	_, err := client.AuthorizeOrder(ctx, acme.DomainIDs("app.internal"))
+	var acmeErr *acme.Error
+	if !errors.As(err, &acmeErr) || acmeErr.ProblemType != problemPrefix+"rateLimited" {
+		t.Fatalf("expected rateLimited error, got %v", err)
+	}
+
+	s.mu.Lock()
+	for _, o := range s.orders {
+		o.expires = time.Now().Add(-time.Second)
+	}
+	s.evictAt = time.Time{}
+	s.evictExpired(time.Now())
+	orders, authzs, challenges := len(s.orders), len(s.authzs), len(s.challenges)
+	s.mu.Unlock()
+
+	if orders != 0 || authzs != 0 || challenges != 0 {
+		t.Fatalf("expected the expired orders to be evicted, got %d orders, %d authorizations and %d challenges", orders, authzs, challenges)
+	}
+
+	_, err = client.AuthorizeOrder(ctx, acme.DomainIDs("app.internal"))
+	if err != nil {
+		t.Fatalf("expected order after the eviction, got err: %s", err)
+	}
+
+	// the account without orders is evicted after an order lifetime of no use
+	s.mu.Lock()
+	for _, o := range s.orders {
+		o.expires = time.Now().Add(-time.Second)
+	}
+	for _, acc := range s.accounts {
+		acc.lastUsed = time.Now().Add(-orderLifetime - time.Second)
+	}
+	s.evictAt = time.Time{}
+	s.evictExpired(time.Now())
+	accounts := len(s.accounts)
+	s.mu.Unlock()
+
+	if accounts != 0 {
+		t.Fatalf("expected the unused account to be evicted, got %d accounts", accounts)
+	}
+}
+
+func TestNonceEviction(t *testing.T) {
+	s := NewServer(nil, Config{})
+
+	first := s.newNonce()
+	for i := 0; i < maxNonces-1; i++ {
+		s.newNonce()
+	}
+
+	// the ring is full, the next nonce evicts the oldest one
+	last := s.newNonce()
+	if len(s.nonces) != maxNonces {
+		t.Fatalf("expected %d stored nonces, got %d", maxNonces, len(s.nonces))
+	}
+
+	if s.useNonce(first) {
+		t.Fatal("expected the oldest nonce to be evicted")
+	}
+
+	if !s.useNonce(last) {
+		t.Fatal("expected the new nonce to be stored")
+	}
+
+	if s.useNonce(last) {
+		t.Fatal("expected the used nonce to be rejected")
+	}
+}
+
+func TestSourceRateLimit(t *testing.T) {
+	s := NewServer(nil, Config{Prefix: "/acme"})
+
+	newNonce := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodHead, "/acme/new-nonce", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < maxSourceRequests; i++ {
+		w := newNonce("192.0.2.1:1234")
+		if w.Code != http.StatusOK || w.Header().Get("Replay-Nonce") == "" {
+			t.Fatalf("expected a fresh nonce, got status %d", w.Code)
+		}
+	}
+
+	w := newNonce("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Replay-Nonce") != "" {
+		t.Fatal("expected no nonce for a limited request")
+	}
+
+	// the other sources are counted separately
+	w = newNonce("192.0.2.2:1234")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d for another source, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func certPool(c *x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c)
+	return pool
+}
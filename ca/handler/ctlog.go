@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/theredrad/certauthz/core/ctlog"
+)
+
+const (
+	// maxEntries limits the entries of a single entries request
+	maxEntries = 256
+)
+
+// CTLogHandler serves the issuance log endpoints for the verifiers and the monitors, they're not authenticated
+type CTLogHandler struct {
+	log *ctlog.Log
+}
+
+// NewCTLog returns a new instance of CTLogHandler
+func NewCTLog(l *ctlog.Log) *CTLogHandler {
+	return &CTLogHandler{
+		log: l,
+	}
+}
+
+// entriesResponse is the body of the entries response, every entry is a base64-encoded certificate in DER format
+type entriesResponse struct {
+	Entries [][]byte `json:"entries"`
+}
+
+// consistencyResponse is the body of the consistency proof response
+type consistencyResponse struct {
+	Consistency [][]byte `json:"consistency"`
+}
+
+// Register registers the issuance log endpoints on the mux
+func (h *CTLogHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/ctlog/sth", h.handleSignedTreeHead)
+	mux.HandleFunc("/v1/ctlog/proof", h.handleInclusionProof)
+	mux.HandleFunc("/v1/ctlog/consistency", h.handleConsistencyProof)
+	mux.HandleFunc("/v1/ctlog/entries", h.handleEntries)
+}
+
+// handleSignedTreeHead returns the latest signed tree head
+func (h *CTLogHandler) handleSignedTreeHead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sth, err := h.log.SignedTreeHead()
+	if err != nil {
+		writeCTLogError(w, err)
+		return
+	}
+
+	writeJSON(w, sth)
+}
+
+// handleInclusionProof returns the inclusion proof of the base64-encoded leaf hash, e.g. /v1/ctlog/proof?hash=[leaf hash]
+func (h *CTLogHandler) handleInclusionProof(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	leafHash, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash"))
+	if err != nil || len(leafHash) == 0 {
+		http.Error(w, "invalid leaf hash", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := h.log.InclusionProof(leafHash)
+	if err != nil {
+		writeCTLogError(w, err)
+		return
+	}
+
+	writeJSON(w, proof)
+}
+
+// handleConsistencyProof returns the consistency proof of two tree sizes, e.g. /v1/ctlog/consistency?first=10&second=20
+func (h *CTLogHandler) handleConsistencyProof(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	first, err1 := strconv.ParseUint(r.URL.Query().Get("first"), 10, 64)
+	second, err2 := strconv.ParseUint(r.URL.Query().Get("second"), 10, 64)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid tree sizes", http.StatusBadRequest)
+		return
+	}
+
+	proof, err := h.log.ConsistencyProof(first, second)
+	if err != nil {
+		writeCTLogError(w, err)
+		return
+	}
+
+	writeJSON(w, consistencyResponse{Consistency: proof})
+}
+
+// handleEntries returns the entries from the start to the end index (exclusive), e.g. /v1/ctlog/entries?start=0&end=10
+func (h *CTLogHandler) handleEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, err1 := strconv.ParseUint(r.URL.Query().Get("start"), 10, 64)
+	end, err2 := strconv.ParseUint(r.URL.Query().Get("end"), 10, 64)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid range", http.StatusBadRequest)
+		return
+	}
+
+	if end > start && end-start > maxEntries {
+		end = start + maxEntries
+	}
+
+	entries, err := h.log.Entries(start, end)
+	if err != nil {
+		writeCTLogError(w, err)
+		return
+	}
+
+	writeJSON(w, entriesResponse{Entries: entries})
+}
+
+// writeJSON writes the body in JSON format
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// writeCTLogError writes the issuance log error with a proper status code
+func writeCTLogError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ctlog.ErrNotIncluded), errors.Is(err, ctlog.ErrNoTreeHead):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ctlog.ErrInvalidRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/est"
+)
+
+// registerEST registers the EST (RFC 7030) endpoints on the mux
+func (h *Handler) registerEST(mux *http.ServeMux) {
+	mux.HandleFunc(est.CACertsPath, h.handleESTCACerts)
+	mux.HandleFunc(est.SimpleEnrollPath, h.handleESTSimpleEnroll)
+	mux.HandleFunc(est.SimpleReenrollPath, h.handleESTSimpleReenroll)
+}
+
+// handleESTCACerts returns the CA certificate as a base64-encoded PKCS#7 certs-only message
+func (h *Handler) handleESTCACerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeESTCertificate(w, h.ca.Certificate())
+}
+
+// handleESTSimpleEnroll issues a certificate for the CSR authenticated by the bootstrap credentials
+// the bootstrap credential defines the scopes of the certificate and the requester identity checked by the issuance policy
+func (h *Handler) handleESTSimpleEnroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	credential, err := h.authenticateBootstrap(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	csr, err := readESTCSR(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	issued, err := h.ca.Issue(ca.IssueRequest{
+		Requester: credential.RequesterIdentity(),
+		CSR:       csr,
+		Scopes:    credential.Scopes,
+	})
+	if err != nil {
+		writeCAError(w, err)
+		return
+	}
+
+	writeESTCertificate(w, issued)
+}
+
+// handleESTSimpleReenroll renews the mTLS client certificate for the CSR
+func (h *Handler) handleESTSimpleReenroll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current := peerCertificate(r)
+	if current == nil {
+		http.Error(w, "client certificate is required", http.StatusUnauthorized)
+		return
+	}
+
+	csr, err := readESTCSR(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renewed, err := h.ca.Renew(current, csr)
+	if err != nil {
+		writeCAError(w, err)
+		return
+	}
+
+	writeESTCertificate(w, renewed)
+}
+
+// authenticateBootstrap authenticates the HTTP basic credentials or the one-time token
+// the bootstrap file is read on every request, so the new credentials are used without restarting the server
+func (h *Handler) authenticateBootstrap(r *http.Request) (*ca.BootstrapCredential, error) {
+	bootstrap, err := ca.ReadBootstrapFromJSONFile(h.bootstrapPath)
+	if err != nil {
+		return nil, errors.New("bootstrap enrollment is not available")
+	}
+
+	if username, password, ok := r.BasicAuth(); ok {
+		credential, ok := bootstrap.AuthenticateBasic(username, password)
+		if !ok {
+			return nil, errors.New("invalid username or password")
+		}
+		return credential, nil
+	}
+
+	parsedHeader := strings.Split(r.Header.Get(authorizationHeader), " ")
+	if len(parsedHeader) != 2 || parsedHeader[0] != tokenType {
+		return nil, errors.New("bootstrap credentials are required")
+	}
+
+	credential, ok := bootstrap.AuthenticateToken(parsedHeader[1])
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+
+	// the token is used before the issuance, so a replayed request can not use it concurrently
+	err = h.ca.Store().UseToken(credential.TokenSHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return credential, nil
+}
+
+// readESTCSR reads the base64-encoded PKCS#10 request body
+func readESTCSR(r *http.Request) (*x509.CertificateRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		return nil, errors.New("failed to read request body")
+	}
+
+	csrBytes, err := est.DecodeBase64(body)
+	if err != nil {
+		return nil, errors.New("invalid csr encoding")
+	}
+
+	csr, err := cert.DecodeCSRFromDERBytes(csrBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid csr: %s", err)
+	}
+
+	return csr, nil
+}
+
+// writeESTCertificate writes the certificate as a base64-encoded PKCS#7 certs-only message
+func writeESTCertificate(w http.ResponseWriter, c *x509.Certificate) {
+	p7, err := cert.EncodePKCS7CertsOnly(c)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", est.CertsOnlyContentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.Write([]byte(base64.StdEncoding.EncodeToString(p7)))
+}
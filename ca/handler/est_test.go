@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/est"
+)
+
+const (
+	testUsername = "alice"
+	testPassword = "secret"
+	testToken    = "one-time-token"
+)
+
+// newTestESTServer returns a loopback TLS server serving the EST endpoints of a new CA
+// alice enrolls by the username and password and bob by the one-time token
+func newTestESTServer(t *testing.T) (*httptest.Server, *ca.CA) {
+	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCertBytes, err := cert.NewCA(caPrivateKey, 1, "test CA", "test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := cert.DecodeFromDERBytes(caCertBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	store, err := ca.OpenStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &ca.Policy{Rules: []ca.Rule{
+		{Identity: "alice", Scopes: []string{"*"}, DNSNames: []string{"localhost"}, MaxTTL: ca.Duration(time.Hour)},
+		{Identity: "bob", Scopes: []string{"*"}, DNSNames: []string{"localhost"}, MaxTTL: ca.Duration(time.Hour)},
+	}}
+
+	bootstrap, err := json.Marshal(ca.Bootstrap{Credentials: []ca.BootstrapCredential{
+		{Username: testUsername, PasswordSHA256: ca.HashSecret(testPassword), Scopes: []string{"user.read"}},
+		{TokenSHA256: ca.HashSecret(testToken), Identity: "bob", Scopes: []string{"user.write"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bootstrapPath := filepath.Join(dir, "bootstrap.json")
+	err = os.WriteFile(bootstrapPath, bootstrap, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authority := ca.New(caCert, caPrivateKey, policy, store, nil, nil)
+
+	mux := http.NewServeMux()
+	New(authority, nil, bootstrapPath, time.Hour).Register(mux)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	return srv, authority
+}
+
+// newTestESTClient returns a new EST client trusting the test server, the client certificate is optional
+func newTestESTClient(srv *httptest.Server, clientCert *tls.Certificate) *est.Client {
+	tlsConfig := srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	if clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	return est.NewClient(srv.URL, tlsConfig)
+}
+
+// newTestCSR returns a new private key and the CSR of the client name
+func newTestCSR(t *testing.T, clientName string) (*rsa.PrivateKey, []byte) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := cert.NewCSR(privateKey, clientName, "", []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return privateKey, csr
+}
+
+func TestESTCACerts(t *testing.T) {
+	srv, authority := newTestESTServer(t)
+
+	caCerts, err := newTestESTClient(srv, nil).CACerts(context.Background())
+	if err != nil {
+		t.Fatalf("expected CA certificates, got err: %s", err)
+	}
+
+	if len(caCerts) != 1 || !caCerts[0].Equal(authority.Certificate()) {
+		t.Fatalf("expected the CA certificate, got %d certificates", len(caCerts))
+	}
+}
+
+func TestESTPinnedCACert(t *testing.T) {
+	srv, authority := newTestESTServer(t)
+
+	sum := sha256.Sum256(authority.Certificate().Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+
+	caCert, err := est.PinnedCACert(context.Background(), srv.URL, fingerprint)
+	if err != nil {
+		t.Fatalf("expected the pinned CA certificate, got err: %s", err)
+	}
+	if !caCert.Equal(authority.Certificate()) {
+		t.Fatal("expected the CA certificate")
+	}
+
+	// the colon-separated form of the fingerprint is accepted
+	colons := make([]string, 0, len(sum))
+	for _, b := range sum {
+		colons = append(colons, hex.EncodeToString([]byte{b}))
+	}
+	_, err = est.PinnedCACert(context.Background(), srv.URL, strings.Join(colons, ":"))
+	if err != nil {
+		t.Fatalf("expected the pinned CA certificate by the colon-separated fingerprint, got err: %s", err)
+	}
+
+	other := sha256.Sum256([]byte("another certificate"))
+	_, err = est.PinnedCACert(context.Background(), srv.URL, hex.EncodeToString(other[:]))
+	if err == nil {
+		t.Fatal("expected error for a mismatched fingerprint, got nil")
+	}
+
+	_, err = est.PinnedCACert(context.Background(), srv.URL, "abcd")
+	if err == nil {
+		t.Fatal("expected error for an invalid fingerprint, got nil")
+	}
+}
+
+func TestESTSimpleEnroll(t *testing.T) {
+	srv, authority := newTestESTServer(t)
+	client := newTestESTClient(srv, nil)
+
+	privateKey, csr := newTestCSR(t, "alice")
+
+	// the enrollment request has no empty organization
+	parsed, err := cert.DecodeCSRFromDERBytes(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Subject.Organization) != 0 {
+		t.Fatalf("expected no organization in the CSR, got %q", parsed.Subject.Organization)
+	}
+
+	enrolled, err := client.SimpleEnroll(context.Background(), csr, est.BasicAuth(testUsername, testPassword))
+	if err != nil {
+		t.Fatalf("expected enrolled certificate, got err: %s", err)
+	}
+
+	if enrolled.Subject.CommonName != "alice" {
+		t.Fatalf("expected subject alice, got %s", enrolled.Subject.CommonName)
+	}
+
+	if scopes := cert.RawScopesFromCertificate(enrolled); scopes != "user.read" {
+		t.Fatalf("expected the bootstrap credential scopes, got %q", scopes)
+	}
+
+	err = enrolled.CheckSignatureFrom(authority.Certificate())
+	if err != nil {
+		t.Fatalf("expected certificate signed by the CA, got err: %s", err)
+	}
+
+	pub, ok := enrolled.PublicKey.(*rsa.PublicKey)
+	if !ok || !pub.Equal(&privateKey.PublicKey) {
+		t.Fatal("expected certificate of the CSR public key")
+	}
+}
+
+func TestESTSimpleEnrollUnauthenticated(t *testing.T) {
+	srv, _ := newTestESTServer(t)
+	client := newTestESTClient(srv, nil)
+
+	_, csr := newTestCSR(t, "alice")
+	for name, credentials := range map[string]est.Credentials{
+		"no credentials": nil,
+		"wrong password": est.BasicAuth(testUsername, "wrong"),
+		"unknown user":   est.BasicAuth("mallory", testPassword),
+		"unknown token":  est.OneTimeToken("unknown"),
+	} {
+		_, err := client.SimpleEnroll(context.Background(), csr, credentials)
+		if err == nil || !strings.Contains(err.Error(), "status 401") {
+			t.Fatalf("%s: expected unauthorized error, got %v", name, err)
+		}
+	}
+}
+
+func TestESTSimpleEnrollTokenReuse(t *testing.T) {
+	srv, _ := newTestESTServer(t)
+	client := newTestESTClient(srv, nil)
+
+	_, csr := newTestCSR(t, "bob")
+	enrolled, err := client.SimpleEnroll(context.Background(), csr, est.OneTimeToken(testToken))
+	if err != nil {
+		t.Fatalf("expected enrolled certificate, got err: %s", err)
+	}
+
+	if enrolled.Subject.CommonName != "bob" {
+		t.Fatalf("expected subject bob, got %s", enrolled.Subject.CommonName)
+	}
+
+	_, csr = newTestCSR(t, "bob")
+	_, err = client.SimpleEnroll(context.Background(), csr, est.OneTimeToken(testToken))
+	if err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("expected unauthorized error for the used token, got %v", err)
+	}
+}
+
+func TestESTSimpleReenroll(t *testing.T) {
+	srv, _ := newTestESTServer(t)
+
+	privateKey, csr := newTestCSR(t, "alice")
+	_, err := newTestESTClient(srv, nil).SimpleReenroll(context.Background(), csr)
+	if err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("expected unauthorized error without client certificate, got %v", err)
+	}
+
+	enrolled, err := newTestESTClient(srv, nil).SimpleEnroll(context.Background(), csr, est.BasicAuth(testUsername, testPassword))
+	if err != nil {
+		t.Fatalf("expected enrolled certificate, got err: %s", err)
+	}
+
+	client := newTestESTClient(srv, &tls.Certificate{
+		Certificate: [][]byte{enrolled.Raw},
+		PrivateKey:  privateKey,
+	})
+
+	_, csr = newTestCSR(t, "mallory")
+	renewed, err := client.SimpleReenroll(context.Background(), csr)
+	if err != nil {
+		t.Fatalf("expected renewed certificate, got err: %s", err)
+	}
+
+	// the renewed certificate keeps the current subject and scopes, the CSR subject is ignored
+	if renewed.Subject.CommonName != "alice" {
+		t.Fatalf("expected subject alice, got %s", renewed.Subject.CommonName)
+	}
+
+	if scopes := cert.RawScopesFromCertificate(renewed); scopes != "user.read" {
+		t.Fatalf("expected the current scopes, got %q", scopes)
+	}
+}
@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+	jwtCore "github.com/theredrad/certauthz/core/jwt"
+)
+
+const (
+	// maxRequestSize limits the request body of the endpoints
+	maxRequestSize = 64 << 10
+
+	authorizationHeader = "Authorization"
+	tokenType           = "Bearer"
+
+	// TokenAudience is the audience of the bearer tokens of the CA, e.g. of `cli generate token --audience ca`
+	// the tokens of the other audiences are signed by the CA for the resource servers, they're not accepted by the CA
+	TokenAudience = "http://ca.local"
+)
+
+var (
+	errUnauthenticated = errors.New("client certificate or bearer token is required")
+	errTokenAudience   = fmt.Errorf("token audience is not %s", TokenAudience)
+)
+
+// Handler serves the CA endpoints
+// the requester is authenticated by the mTLS client certificate or a bearer token of the CA audience signed by the CA
+type Handler struct {
+	ca             *ca.CA
+	tokenValidator *jwtCore.Validator
+	bootstrapPath  string
+	crlValidity    time.Duration
+}
+
+// New returns a new instance of Handler, the token validator is optional
+// the EST bootstrap enrollment is not available while the bootstrap credentials file does not exist
+func New(authority *ca.CA, tokenValidator *jwtCore.Validator, bootstrapPath string, crlValidity time.Duration) *Handler {
+	return &Handler{
+		ca:             authority,
+		tokenValidator: tokenValidator,
+		bootstrapPath:  bootstrapPath,
+		crlValidity:    crlValidity,
+	}
+}
+
+// Register registers the CA endpoints on the mux
+func (h *Handler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/v1/ca", h.handleCABundle)
+	mux.HandleFunc("/v1/crl", h.handleCRL)
+	mux.HandleFunc("/v1/certificates", h.handleIssue)
+	mux.HandleFunc("/v1/renew", h.handleRenew)
+	mux.HandleFunc("/v1/revoke", h.handleRevoke)
+	h.registerEST(mux)
+}
+
+// issueRequest is the body of the certificate issuance request
+type issueRequest struct {
+	// CSR is the base64-encoded certificate signing request in DER format
+	CSR string `json:"csr"`
+
+	// Scopes are separated by space
+	Scopes string `json:"scopes"`
+
+	// Expiration is a duration string e.g. 24h, the policy max TTL is used if it's empty
+	Expiration string `json:"expiration"`
+}
+
+// issueResponse is the body of the certificate issuance response
+type issueResponse struct {
+	// Certificate is the base64-encoded certificate in DER format
+	Certificate  string    `json:"certificate"`
+	SerialNumber string    `json:"serial_number"`
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// revokeRequest is the body of the certificate revocation request
+type revokeRequest struct {
+	SerialNumber string `json:"serial_number"`
+
+	// Reason is the CRL reason code, e.g. 1 for key compromise
+	Reason int `json:"reason"`
+}
+
+// handleCABundle returns the CA certificate in PEM format
+func (h *Handler) handleCABundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: h.ca.Certificate().Raw})
+}
+
+// handleCRL returns a new certificate revocation list in DER format
+func (h *Handler) handleCRL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	crl, err := h.ca.CRL(h.crlValidity)
+	if err != nil {
+		http.Error(w, "failed to create CRL", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
+
+// handleIssue issues a new certificate for the CSR if the requester is allowed by the policy
+func (h *Handler) handleIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requester, _, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req issueRequest
+	err = json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	csrBytes, err := base64.StdEncoding.DecodeString(req.CSR)
+	if err != nil {
+		http.Error(w, "invalid csr encoding", http.StatusBadRequest)
+		return
+	}
+
+	csr, err := cert.DecodeCSRFromDERBytes(csrBytes)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid csr: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	var expiration time.Duration
+	if req.Expiration != "" {
+		expiration, err = time.ParseDuration(req.Expiration)
+		if err != nil {
+			http.Error(w, "invalid expiration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	issued, err := h.ca.Issue(ca.IssueRequest{
+		Requester:  requester,
+		CSR:        csr,
+		Scopes:     strings.Fields(req.Scopes),
+		Expiration: expiration,
+	})
+	if err != nil {
+		writeCAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(issueResponse{
+		Certificate:  base64.StdEncoding.EncodeToString(issued.Raw),
+		SerialNumber: issued.SerialNumber.String(),
+		NotAfter:     issued.NotAfter,
+	})
+}
+
+// handleRenew renews the mTLS client certificate for the CSR in DER format and returns the renewed certificate in DER format
+func (h *Handler) handleRenew(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	current := peerCertificate(r)
+	if current == nil {
+		http.Error(w, "client certificate is required", http.StatusUnauthorized)
+		return
+	}
+
+	csrBytes, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	csr, err := cert.DecodeCSRFromDERBytes(csrBytes)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid csr: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	renewed, err := h.ca.Renew(current, csr)
+	if err != nil {
+		writeCAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-cert")
+	w.Write(renewed.Raw)
+}
+
+// handleRevoke revokes a certificate, a certificate can revoke itself, otherwise the policy must allow the requester
+func (h *Handler) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	requester, requesterCert, err := h.authenticate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var req revokeRequest
+	err = json.NewDecoder(io.LimitReader(r.Body, maxRequestSize)).Decode(&req)
+	if err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	serialNumber, err := strconv.ParseInt(req.SerialNumber, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid serial number", http.StatusBadRequest)
+		return
+	}
+
+	var requesterSerial *big.Int
+	if requesterCert != nil {
+		requesterSerial = requesterCert.SerialNumber
+	}
+
+	err = h.ca.Revoke(requester, requesterSerial, serialNumber, req.Reason)
+	if err != nil {
+		writeCAError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate returns the requester identity from the mTLS client certificate or the bearer token of the CA audience
+func (h *Handler) authenticate(r *http.Request) (string, *x509.Certificate, error) {
+	if c := peerCertificate(r); c != nil {
+		if h.ca.Store().IsRevoked(c.SerialNumber.Int64()) {
+			return "", nil, errors.New("client certificate is revoked")
+		}
+		return c.Subject.CommonName, c, nil
+	}
+
+	parsedHeader := strings.Split(r.Header.Get(authorizationHeader), " ")
+	if h.tokenValidator == nil || len(parsedHeader) != 2 || parsedHeader[0] != tokenType {
+		return "", nil, errUnauthenticated
+	}
+
+	token, err := h.tokenValidator.Validate(parsedHeader[1])
+	if err != nil {
+		return "", nil, err
+	}
+
+	// a token of a resource server could be replayed to the CA to issue a certificate of its client
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !claims.VerifyAudience(TokenAudience, true) {
+		return "", nil, errTokenAudience
+	}
+
+	client := jwtCore.ClientFromToken(token)
+	if client.Name == "" {
+		return "", nil, errors.New("token subject is missing")
+	}
+
+	return client.Name, nil, nil
+}
+
+// peerCertificate returns the client certificate verified by the TLS handshake
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// writeCAError writes the CA operation error with the matching status code
+func writeCAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ca.ErrNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ca.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ca.ErrAlreadyRevoked), errors.Is(err, ca.ErrTokenUsed):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	jwtCore "github.com/theredrad/certauthz/core/jwt"
+)
+
+func TestAuthenticateTokenAudience(t *testing.T) {
+	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validator := jwtCore.NewValidator(&caPrivateKey.PublicKey)
+	h := New(nil, &validator, "", time.Hour)
+
+	tests := []struct {
+		name    string
+		aud     any
+		wantErr bool
+	}{
+		{name: "CA audience", aud: TokenAudience},
+		{name: "CA audience in a list", aud: []string{"http://bob.local", TokenAudience}},
+		{name: "resource server audience", aud: "http://bob.local", wantErr: true},
+		{name: "no audience", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := jwt.MapClaims{
+				"sub":    "alice.local",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+				"scopes": []string{"bob.user.read"},
+			}
+			if tt.aud != nil {
+				claims["aud"] = tt.aud
+			}
+
+			token, err := jwtCore.Sign(caPrivateKey, claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "https://ca.local/v1/certificates", nil)
+			r.Header.Set(authorizationHeader, tokenType+" "+token)
+
+			requester, _, err := h.authenticate(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got requester %s", requester)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected requester, got err: %s", err)
+			}
+			if requester != "alice.local" {
+				t.Errorf("expected requester alice.local, got %s", requester)
+			}
+		})
+	}
+}
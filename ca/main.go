@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/theredrad/certauthz/ca/acme"
+	"github.com/theredrad/certauthz/ca/handler"
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/ctlog"
+	jwtCore "github.com/theredrad/certauthz/core/jwt"
+	"github.com/theredrad/certauthz/core/key"
+	"github.com/theredrad/certauthz/core/signer"
+)
+
+var (
+	primaryName = "primary"
+	host        = "0.0.0.0"
+	port        = 8686
+	path        = "../credentials"
+	policyPath  = "./ca/policy.example.json"
+	dbPath      = ""
+	auditPath   = ""
+	bootstrap   = ""
+	dnsNames    = "localhost"
+	crlValidity = 24 * time.Hour
+
+	auditCheckpoint = 100
+	ctlogDir        = ""
+
+	acmeDomains    = ""
+	acmeExpiration = 2160 * time.Hour
+	acmeHTTP01Port = 80
+
+	signerURI = ""
+
+	passphraseFile   = ""
+	passphraseEnv    = ""
+	passphrasePrompt = false
+)
+
+func init() {
+	flag.StringVar(&primaryName, "primary-name", "primary", "primary name including ca certificate and private key")
+	flag.StringVar(&host, "host", "0.0.0.0", "server host")
+	flag.IntVar(&port, "port", 8686, "server port")
+	flag.StringVar(&path, "path", "../credentials", "credentials path")
+	flag.StringVar(&policyPath, "policy", "./ca/policy.example.json", "issuance policy file in JSON format")
+	flag.StringVar(&dbPath, "db", "", "issuance database directory, [path]/[primary-name]/db if it's empty")
+	flag.StringVar(&auditPath, "audit-log", "", "audit log file, [path]/[primary-name]/audit.log if it's empty")
+	flag.IntVar(&auditCheckpoint, "audit-checkpoint", 100, "number of the audit records between the checkpoints signed by the CA signer")
+	flag.StringVar(&ctlogDir, "ctlog", "", "issuance log directory, every issued certificate is appended to the Merkle tree log, [path]/[primary-name]/ctlog if it's empty")
+	flag.StringVar(&bootstrap, "bootstrap", "", "EST bootstrap credentials file in JSON format, [path]/[primary-name]/bootstrap.json if it's empty")
+	flag.StringVar(&dnsNames, "dns", "localhost", "server certificate DNS names, separated by comma")
+	flag.DurationVar(&crlValidity, "crl-validity", 24*time.Hour, "certificate revocation list validity")
+	flag.StringVar(&acmeDomains, "acme-domains", "", "domain patterns that the ACME server issues certificates for, separated by comma e.g. *.internal, ACME is disabled if it's empty")
+	flag.DurationVar(&acmeExpiration, "acme-expiration", 2160*time.Hour, "ACME certificate expiration")
+	flag.IntVar(&acmeHTTP01Port, "acme-http01-port", 80, "port that the ACME http-01 challenges are validated on")
+	flag.StringVar(&signerURI, "signer", "", "CA signer URI, e.g. agent:///path/to/agent.sock?key=primary or pkcs11:token=primary;object=ca?module-path=/path/to/module&pin-source=/path/to/pin, [path]/[primary-name]/private.key if it's empty")
+	flag.StringVar(&passphraseFile, "passphrase-file", "", "file including the private keys passphrase")
+	flag.StringVar(&passphraseEnv, "passphrase-env", "", "environment variable including the private keys passphrase")
+	flag.BoolVar(&passphrasePrompt, "passphrase-prompt", false, "prompt for the private keys passphrase")
+	flag.Parse()
+
+	key.SetPassphraseFunc(key.NewPassphraseFunc(passphraseFile, passphraseEnv, passphrasePrompt))
+}
+
+func main() {
+	if dbPath == "" {
+		dbPath = fmt.Sprintf("%s/%s/db", path, primaryName)
+	}
+
+	if auditPath == "" {
+		auditPath = fmt.Sprintf("%s/%s/audit.log", path, primaryName)
+	}
+
+	if ctlogDir == "" {
+		ctlogDir = fmt.Sprintf("%s/%s/ctlog", path, primaryName)
+	}
+
+	if bootstrap == "" {
+		bootstrap = fmt.Sprintf("%s/%s/bootstrap.json", path, primaryName)
+	}
+
+	caCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, primaryName))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if signerURI == "" {
+		signerURI = fmt.Sprintf("%s/%s/private.key", path, primaryName)
+	}
+
+	// the CA private key can be kept out of the process by the signing agent or a PKCS#11 token
+	caSigner, err := signer.Open(signerURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	caPublicKey, ok := caSigner.Public().(*rsa.PublicKey)
+	if !ok {
+		log.Fatal("only RSA CA keys are supported")
+	}
+
+	policy, err := ca.ReadPolicyFromJSONFile(policyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := ca.OpenStore(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the audit log is hash-chained and the chain head is signed by the CA signer periodically and on shutdown
+	auditLogger, err := audit.Open(auditPath, audit.WithSigner(caSigner, auditCheckpoint))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer auditLogger.Close()
+
+	// every certificate is appended to the issuance log before it's returned, the tree heads are signed by the CA signer
+	issuanceLog, err := ctlog.Open(ctlogDir, caSigner)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer issuanceLog.Close()
+
+	authority := ca.New(caCert, caSigner, policy, store, auditLogger, issuanceLog)
+
+	// bearer tokens of the CA audience signed by the CA private key are accepted to authenticate the requester
+	tokenValidator := jwtCore.NewValidator(caPublicKey)
+
+	mux := http.NewServeMux()
+	handler.New(authority, &tokenValidator, bootstrap, crlValidity).Register(mux)
+	handler.NewCTLog(issuanceLog).Register(mux)
+
+	if acmeDomains != "" {
+		acme.NewServer(authority, acme.Config{
+			Prefix:     "/acme",
+			Domains:    strings.Split(acmeDomains, ","),
+			HTTP01Port: acmeHTTP01Port,
+			Expiration: acmeExpiration,
+		}).Register(mux)
+	}
+
+	tlsConfig, err := newTLSConfig(authority, strings.Split(dnsNames, ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server := http.Server{
+		Addr:      fmt.Sprintf("%s:%d", host, port),
+		Handler:   mux,
+		TLSConfig: tlsConfig,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		fmt.Printf("listening on %s:%d\n", host, port)
+		serverErr <- server.ListenAndServeTLS("", "")
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case s := <-sig:
+		log.Printf("received os signal: %s", s)
+	case err := <-serverErr:
+		log.Fatalf("server error: %s", err)
+	}
+}
+
+// newTLSConfig returns the tls config of the CA server with a certificate issued by the CA for the DNS names
+// the client certificate is verified if it's given, it authenticates the requester
+func newTLSConfig(authority *ca.CA, dnsNames []string) (*tls.Config, error) {
+	serverPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		return nil, err
+	}
+
+	csrBytes, err := cert.NewCSR(serverPrivateKey, "ca", "", dnsNames)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := cert.DecodeCSRFromDERBytes(csrBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	serverCert, err := authority.ServerCertificate(csr, 8760*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	caCertPool := x509.NewCertPool()
+	caCertPool.AddCert(authority.Certificate())
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{serverCert.Raw},
+			PrivateKey:  serverPrivateKey,
+			Leaf:        serverCert,
+		}},
+		ClientCAs:  caCertPool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
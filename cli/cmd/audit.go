@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+const (
+	operationMintToken = "mint-token"
+
+	// cliIdentity is the audit identity of the CLI operations signed by the CA signer directly
+	cliIdentity = "cli"
+)
+
+// newAuditCmd returns a new instance of cobra.Command including the audit log commands
+func newAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Verify the audit log",
+	}
+
+	cmd.AddCommand(newAuditVerifyCmd())
+
+	return cmd
+}
+
+// newAuditVerifyCmd returns a new instance of cobra.Command to verify the audit log hash chain and checkpoints
+func newAuditVerifyCmd() *cobra.Command {
+	var (
+		path              string
+		name              string
+		auditPath         string
+		headPath          string
+		publicKeyPath     string
+		allowUnsignedTail bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the audit log.",
+		Long: `Verify the audit log hash chain and the signatures of the checkpoints by the CA public key.
+A modified, removed or reordered record breaks the chain. The records after the last checkpoint are not signed, they can be truncated
+without breaking the chain, so the verification fails if there's any unsigned record unless --allow-unsigned-tail is passed.
+The verified head is written to the head file, the next verification fails if the log no longer includes it, e.g. it's truncated
+to an earlier checkpoint. Keep a copy of the head file somewhere else, so it can not be removed together with the log.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if auditPath == "" {
+				auditPath = fmt.Sprintf("%s/%s/audit.log", path, name)
+			}
+
+			if headPath == "" {
+				headPath = fmt.Sprintf("%s/%s/audit.head", path, name)
+			}
+
+			since, err := audit.ReadHeadFromJSONFile(headPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			pub, err := readAuditPublicKey(publicKeyPath, path, name)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			f, err := os.Open(auditPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			var report *audit.Report
+			if since != nil {
+				report, err = audit.VerifySince(f, pub, *since)
+			} else {
+				fmt.Printf("no verified head in %s, a truncation can not be detected\n", headPath)
+				report, err = audit.Verify(f, pub)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("records: %d, checkpoints: %d, head: %s\n", report.Records, report.Checkpoints, report.Head)
+
+			if report.Unsigned > 0 {
+				msg := fmt.Sprintf("%d records after the last checkpoint are not signed", report.Unsigned)
+				if !allowUnsignedTail {
+					fmt.Fprintln(os.Stderr, msg)
+					os.Exit(1)
+				}
+				fmt.Println(msg)
+			}
+
+			if head, ok := report.LastHead(); ok {
+				err = head.WriteJSONFile(headPath)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "failed to write the verified head:", err)
+					os.Exit(1)
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&name, "name", "n", "primary", "CA identifier")
+	cmd.Flags().StringVarP(&auditPath, "audit-log", "f", "", "audit log file, [path]/[name]/audit.log if it's empty")
+	cmd.Flags().StringVar(&headPath, "head-file", "", "last verified head file, [path]/[name]/audit.head if it's empty")
+	cmd.Flags().StringVar(&publicKeyPath, "public-key", "", "public key of the checkpoints signer in PEM or DER format, the CA certificate public key if it's empty")
+	cmd.Flags().BoolVar(&allowUnsignedTail, "allow-unsigned-tail", false, "do not fail if there're records after the last checkpoint")
+
+	return cmd
+}
+
+// readAuditPublicKey reads the checkpoints public key, the CA certificate public key by default
+func readAuditPublicKey(publicKeyPath, path, name string) (crypto.PublicKey, error) {
+	if publicKeyPath != "" {
+		return key.ReadRSAPublicKeyFromFile(publicKeyPath)
+	}
+
+	caCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, name))
+	if err != nil {
+		return nil, err
+	}
+
+	return caCert.PublicKey, nil
+}
+
+// logAudit appends the record to the audit log and signs the chain head by the signer if it's given
+// a failure is printed as the operation result is already decided
+func logAudit(auditPath, path, name string, signer crypto.Signer, r audit.Record) {
+	if auditPath == "" {
+		auditPath = fmt.Sprintf("%s/%s/audit.log", path, name)
+	}
+
+	var opts []audit.Option
+	if signer != nil {
+		opts = append(opts, audit.WithSigner(signer, 0)) // the checkpoint is signed on close
+	}
+
+	logger, err := audit.Open(auditPath, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to open audit log:", err)
+		return
+	}
+
+	err = logger.Log(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to write audit log:", err)
+	}
+
+	err = logger.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to sign audit log:", err)
+	}
+}
@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/ceremony"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+const (
+	operationCeremonySplit            = "ceremony-split"
+	operationCeremonySignIntermediate = "ceremony-sign-intermediate"
+	operationCeremonySignCRL          = "ceremony-sign-crl"
+)
+
+// newCeremonyCmd returns a new instance of cobra.Command including the root CA key ceremony commands
+func newCeremonyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ceremony",
+		Short: "Split the root CA key into shares and sign by a quorum of them",
+	}
+
+	signCmd := &cobra.Command{
+		Use:   "sign",
+		Short: "Sign an intermediate CA certificate or a CRL by the root CA key reconstructed from the shares",
+	}
+	signCmd.AddCommand(newCeremonySignIntermediateCmd(), newCeremonySignCRLCmd())
+
+	cmd.AddCommand(newCeremonySplitCmd(), signCmd)
+
+	return cmd
+}
+
+// newCeremonySplitCmd returns a new instance of cobra.Command to seal the CA key and split the wrapping key into shares
+func newCeremonySplitCmd() *cobra.Command {
+	var (
+		path      string
+		name      string
+		sharesDir string
+		total     int
+		threshold int
+		keepKey   bool
+		auditPath string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "split",
+		Short: "Seal the CA private key and split the wrapping key into shares.",
+		Long: `Encrypt the CA private key by a random wrapping key to [path]/[name]/private.key.sealed, and split the wrapping key into
+--shares shares, any --threshold of them reconstructs it. The shares are written to the shares directory as share-[index].json,
+to be handed out to the custodians. The plain private key file is removed unless --keep-key is set.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			privateKeyPath := fmt.Sprintf("%s/%s/private.key", path, name)
+			privateKey, err := key.ReadRSAPrivateKeyFromFile(privateKeyPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			privateKeyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			sealed, shares, err := ceremony.Seal(privateKeyDER, total, threshold)
+			ceremony.Zero(privateKeyDER)
+			ceremony.ZeroPrivateKey(privateKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = os.MkdirAll(sharesDir, 0700)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			for _, s := range shares {
+				err = ceremony.WriteJSONFile(fmt.Sprintf("%s/share-%d.json", sharesDir, s.Index), s)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+
+			err = ceremony.WriteJSONFile(fmt.Sprintf("%s.sealed", privateKeyPath), sealed)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if !keepKey {
+				err = os.Remove(privateKeyPath)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+
+			logAudit(auditPath, path, name, nil, audit.Record{
+				Operation: operationCeremonySplit,
+				Subject:   name,
+				Serial:    sealed.CeremonyID,
+				Outcome:   audit.OutcomeSuccess,
+			})
+
+			fmt.Printf("ceremony %s: the key is sealed, %d of %d shares are written to %s\n", sealed.CeremonyID, threshold, total, sharesDir)
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&name, "name", "n", "primary", "CA identifier")
+	cmd.Flags().StringVarP(&sharesDir, "out", "o", "../credentials/shares", "shares directory")
+	cmd.Flags().IntVarP(&total, "shares", "s", 5, "number of shares")
+	cmd.Flags().IntVarP(&threshold, "threshold", "t", 3, "number of shares required to reconstruct the key")
+	cmd.Flags().BoolVar(&keepKey, "keep-key", false, "keep the plain private key file")
+	cmd.Flags().StringVar(&auditPath, "audit-log", "", "audit log file, [path]/[name]/audit.log if it's empty")
+
+	return cmd
+}
+
+// newCeremonySignIntermediateCmd returns a new instance of cobra.Command to sign an intermediate CA certificate by the sealed root key
+func newCeremonySignIntermediateCmd() *cobra.Command {
+	var (
+		path             string
+		name             string
+		shareFiles       *[]string
+		intermediateName string
+		commonName       string
+		org              string
+		expiration       time.Duration
+		auditPath        string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "intermediate",
+		Short: "Sign an intermediate CA certificate.",
+		Long: `Sign an intermediate CA certificate for the public key of the intermediate directory (e.g. created by "create client").
+The root key is reconstructed in memory from the shares only for the signing. The certificate is written to [path]/[intermediate]/ca_certificate.crt`,
+		Run: func(cmd *cobra.Command, args []string) {
+			rootCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, name))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			publicKey, err := key.ReadRSAPublicKeyFromFile(fmt.Sprintf("%s/%s/public.pub", path, intermediateName))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			serialNumber, err := cert.NewSerialNumber()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if commonName == "" {
+				commonName = intermediateName
+			}
+
+			var certBytes []byte
+			indexes, err := withSealedKey(path, name, *shareFiles, func(rootKey *rsa.PrivateKey) error {
+				certBytes, err = cert.NewIntermediateCA(rootCert, publicKey, rootKey, serialNumber, commonName, org, expiration)
+				return err
+			})
+
+			r := audit.Record{
+				Operation: operationCeremonySignIntermediate,
+				Identity:  indexes,
+				Subject:   intermediateName,
+				Serial:    strconv.FormatInt(serialNumber, 10),
+				Outcome:   audit.OutcomeSuccess,
+			}
+			if err != nil {
+				r.Outcome, r.Error, r.Serial = audit.OutcomeFailure, err.Error(), ""
+			}
+			logAudit(auditPath, path, name, nil, r) // the records are signed by the next checkpoint of the CA
+
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = file.Write(fmt.Sprintf("%s/%s/ca_certificate.crt", path, intermediateName), certBytes)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&name, "name", "n", "primary", "root CA identifier")
+	shareFiles = cmd.Flags().StringArrayP("share", "s", nil, "share file, repeated for the quorum")
+	cmd.Flags().StringVarP(&intermediateName, "intermediate", "i", "intermediate", "intermediate CA identifier including the public key")
+	cmd.Flags().StringVarP(&commonName, "common-name", "c", "", "intermediate CA common name, the identifier is used if it's empty")
+	cmd.Flags().StringVarP(&org, "organization", "o", "RedRad", "intermediate CA organization")
+	cmd.Flags().DurationVarP(&expiration, "expiration", "e", 43800*time.Hour, "certification expiration")
+	cmd.Flags().StringVar(&auditPath, "audit-log", "", "audit log file, [path]/[name]/audit.log if it's empty")
+
+	return cmd
+}
+
+// newCeremonySignCRLCmd returns a new instance of cobra.Command to sign the CRL by the sealed root key
+func newCeremonySignCRLCmd() *cobra.Command {
+	var (
+		path       string
+		name       string
+		shareFiles *[]string
+		dbPath     string
+		outPath    string
+		validity   time.Duration
+		auditPath  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "crl",
+		Short: "Sign the certificate revocation list.",
+		Long:  `Sign the certificate revocation list of the issuance database revocations. The root key is reconstructed in memory from the shares only for the signing.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			rootCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, name))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if dbPath == "" {
+				dbPath = fmt.Sprintf("%s/%s/db", path, name)
+			}
+
+			store, err := ca.OpenStore(dbPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			var crl []byte
+			indexes, err := withSealedKey(path, name, *shareFiles, func(rootKey *rsa.PrivateKey) error {
+				crl, err = ca.New(rootCert, rootKey, nil, store, nil, nil).CRL(validity)
+				return err
+			})
+
+			r := audit.Record{
+				Operation: operationCeremonySignCRL,
+				Identity:  indexes,
+				Subject:   name,
+				Outcome:   audit.OutcomeSuccess,
+			}
+			if err != nil {
+				r.Outcome, r.Error = audit.OutcomeFailure, err.Error()
+			}
+			logAudit(auditPath, path, name, nil, r) // the records are signed by the next checkpoint of the CA
+
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if outPath == "" {
+				outPath = fmt.Sprintf("%s/%s/crl.der", path, name)
+			}
+
+			err = file.Write(outPath, crl)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&name, "name", "n", "primary", "root CA identifier")
+	shareFiles = cmd.Flags().StringArrayP("share", "s", nil, "share file, repeated for the quorum")
+	cmd.Flags().StringVar(&dbPath, "db", "", "issuance database directory, [path]/[name]/db if it's empty")
+	cmd.Flags().StringVarP(&outPath, "out", "o", "", "CRL file in DER format, [path]/[name]/crl.der if it's empty")
+	cmd.Flags().DurationVarP(&validity, "validity", "v", 720*time.Hour, "CRL validity")
+	cmd.Flags().StringVar(&auditPath, "audit-log", "", "audit log file, [path]/[name]/audit.log if it's empty")
+
+	return cmd
+}
+
+// withSealedKey reconstructs the sealed CA key from the shares, calls the sign function and zeroes the key
+// it returns the indexes of the shares, which are recorded as the identity in the audit log
+func withSealedKey(path, name string, shareFiles []string, sign func(*rsa.PrivateKey) error) (string, error) {
+	sealed, err := ceremony.ReadSealedKeyFromJSONFile(fmt.Sprintf("%s/%s/private.key.sealed", path, name))
+	if err != nil {
+		return "", err
+	}
+
+	shares := make([]ceremony.Share, 0, len(shareFiles))
+	indexes := make([]int, 0, len(shareFiles))
+	for _, f := range shareFiles {
+		s, err := ceremony.ReadShareFromJSONFile(f)
+		if err != nil {
+			return "", err
+		}
+		shares = append(shares, s)
+		indexes = append(indexes, s.Index)
+	}
+
+	sort.Ints(indexes)
+	identity := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		identity = append(identity, strconv.Itoa(i))
+	}
+	sharesIdentity := fmt.Sprintf("shares:%s", strings.Join(identity, ","))
+
+	privateKeyDER, err := sealed.Unseal(shares)
+	if err != nil {
+		return sharesIdentity, err
+	}
+	defer ceremony.Zero(privateKeyDER)
+
+	privateKey, err := key.DecodePrivateKeyFromDER(privateKeyDER)
+	if err != nil {
+		return sharesIdentity, err
+	}
+	defer ceremony.ZeroPrivateKey(privateKey)
+
+	return sharesIdentity, sign(privateKey)
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/ctlog"
+)
+
+const (
+	ctlogFlagUsage = "issuance log directory that the certificate is appended to, [path]/[ca-name]/ctlog if it's empty"
+)
+
+// newCTLogCmd returns a new instance of cobra.Command including the issuance log commands
+func newCTLogCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ctlog",
+		Short: "Verify the issuance log",
+	}
+
+	cmd.AddCommand(newCTLogTreeHeadCmd(), newCTLogProveCmd(), newCTLogConsistencyCmd())
+
+	return cmd
+}
+
+// newCTLogTreeHeadCmd returns a new instance of cobra.Command to print the latest signed tree head
+func newCTLogTreeHeadCmd() *cobra.Command {
+	var (
+		path     string
+		caName   string
+		ctlogDir string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "sth",
+		Short: "Print the latest signed tree head.",
+		Long:  `Print the latest signed tree head of the issuance log in JSON format after verifying its signature by the CA certificate. Keep it to verify the consistency of a later tree head.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			l, verifier, err := openCTLogVerifier(ctlogDir, path, caName)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer l.Close()
+
+			sth, err := l.SignedTreeHead()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = verifier.VerifyTreeHead(sth)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			json.NewEncoder(os.Stdout).Encode(sth)
+		},
+	}
+
+	addCTLogFlags(cmd, &path, &caName, &ctlogDir)
+
+	return cmd
+}
+
+// newCTLogProveCmd returns a new instance of cobra.Command to verify a certificate is included in the issuance log
+func newCTLogProveCmd() *cobra.Command {
+	var (
+		path       string
+		caName     string
+		ctlogDir   string
+		clientName string
+		certPath   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "prove",
+		Short: "Verify a certificate is included in the issuance log.",
+		Long:  `Verify the client certificate is included in the issuance log by its inclusion proof, the proof is printed in JSON format.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if certPath == "" {
+				certPath = fmt.Sprintf("%s/%s/certificate.crt", path, clientName)
+			}
+
+			c, err := cert.ReadFromFile(certPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			l, verifier, err := openCTLogVerifier(ctlogDir, path, caName)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer l.Close()
+
+			err = verifier.VerifyInclusion(c)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			proof, err := l.InclusionProof(ctlog.LeafHash(c.Raw))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			json.NewEncoder(os.Stdout).Encode(proof)
+		},
+	}
+
+	addCTLogFlags(cmd, &path, &caName, &ctlogDir)
+	cmd.Flags().StringVarP(&clientName, "client-name", "c", "alice", "client name")
+	cmd.Flags().StringVar(&certPath, "cert", "", "certificate file in PEM or DER format, [path]/[client-name]/certificate.crt if it's empty")
+
+	return cmd
+}
+
+// newCTLogConsistencyCmd returns a new instance of cobra.Command to verify the latest tree head is consistent with an earlier one
+func newCTLogConsistencyCmd() *cobra.Command {
+	var (
+		path     string
+		caName   string
+		ctlogDir string
+		oldPath  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "consistency",
+		Short: "Verify the issuance log is consistent with an earlier tree head.",
+		Long:  `Verify the latest signed tree head is consistent with an earlier signed tree head printed by "ctlog sth", so the log is only appended since then.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			b, err := os.ReadFile(oldPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			var old ctlog.SignedTreeHead
+			err = json.Unmarshal(b, &old)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			l, verifier, err := openCTLogVerifier(ctlogDir, path, caName)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer l.Close()
+
+			sth, err := l.SignedTreeHead()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			// the verifier checks every tree head is consistent with the previous one
+			for _, head := range []*ctlog.SignedTreeHead{&old, sth} {
+				err = verifier.VerifyTreeHead(head)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+
+			fmt.Printf("the log is consistent, tree size: %d -> %d\n", old.TreeSize, sth.TreeSize)
+		},
+	}
+
+	addCTLogFlags(cmd, &path, &caName, &ctlogDir)
+	cmd.Flags().StringVar(&oldPath, "old", "", "earlier signed tree head file in JSON format")
+	cmd.MarkFlagRequired("old")
+
+	return cmd
+}
+
+// addCTLogFlags adds the common flags of the issuance log commands
+func addCTLogFlags(cmd *cobra.Command, path, caName, ctlogDir *string) {
+	cmd.Flags().StringVarP(path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(caName, "ca-name", "a", "primary", "CA identifier")
+	cmd.Flags().StringVar(ctlogDir, "ctlog", "", "issuance log directory, [path]/[ca-name]/ctlog if it's empty")
+}
+
+// openCTLogVerifier opens the issuance log read-only and returns a verifier of it by the CA certificate public key
+func openCTLogVerifier(ctlogDir, path, caName string) (*ctlog.Log, *ctlog.Verifier, error) {
+	if ctlogDir == "" {
+		ctlogDir = fmt.Sprintf("%s/%s/ctlog", path, caName)
+	}
+
+	caCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, caName))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := ctlog.OpenReadOnly(ctlogDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return l, ctlog.NewVerifier(caCert.PublicKey, l), nil
+}
+
+// openIssuanceLog opens the issuance log of the issued certificates, the tree heads are signed by the CA signer
+func openIssuanceLog(ctlogDir, path, caName string, caSigner crypto.Signer) (*ctlog.Log, error) {
+	if ctlogDir == "" {
+		ctlogDir = fmt.Sprintf("%s/%s/ctlog", path, caName)
+	}
+
+	l, err := ctlog.Open(ctlogDir, caSigner)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
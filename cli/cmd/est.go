@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/est"
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+// newESTCmd returns a new instance of cobra.Command including the EST client commands
+func newESTCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "est",
+		Short: "Enroll certificates from the CA server by EST (RFC 7030)",
+	}
+
+	cmd.AddCommand(newESTEnrollCmd(), newESTReenrollCmd(), newESTTokenCmd())
+
+	return cmd
+}
+
+// newESTEnrollCmd returns a new instance of cobra.Command to enroll a new client by the bootstrap credentials
+func newESTEnrollCmd() *cobra.Command {
+	var (
+		path          string
+		caName        string
+		clientName    string
+		serverURL     string
+		caFingerprint string
+		token         string
+		username      string
+		password      string
+		keySize       int
+		dnsNames      *[]string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "enroll",
+		Short: "Enroll a new client certificate.",
+		Long: `Enroll a new client certificate by the HTTP basic credentials or a one-time token.
+The key pairs and the certificate will be stored in the client directory.
+The CA certificate is read from the CA directory, or fetched from the EST server and pinned by --ca-fingerprint if it does not exist.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var credentials est.Credentials
+			switch {
+			case token != "":
+				credentials = est.OneTimeToken(token)
+			case username != "":
+				credentials = est.BasicAuth(username, password)
+			default:
+				fmt.Fprintln(os.Stderr, "either --token or --username is required")
+				os.Exit(1)
+			}
+
+			ctx := context.Background()
+
+			caCert, err := readOrBootstrapCACert(ctx, fmt.Sprintf("%s/%s", path, caName), serverURL, caFingerprint)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			// generate the client private key, the key pairs are written after the enrollment, so a failed enrollment keeps the current files
+			clientPrivateKey, err := key.GeneratePrivateKey(keySize)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			csr, err := cert.NewCSR(clientPrivateKey, clientName, "", *dnsNames)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			client := est.NewClient(serverURL, &tls.Config{
+				RootCAs:    certPool(caCert),
+				MinVersion: tls.VersionTLS12,
+			})
+
+			enrolled, err := client.SimpleEnroll(ctx, csr, credentials)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = key.WriteKeyPair(fmt.Sprintf("%s/%s", path, clientName), clientPrivateKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			// write the client certificate to file
+			err = file.Write(fmt.Sprintf("%s/%s/certificate.crt", path, clientName), enrolled.Raw)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("certificate is enrolled, expires at %s\n", enrolled.NotAfter.Format(time.RFC3339))
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&caName, "ca-name", "a", "primary", "CA identifier")
+	cmd.Flags().StringVarP(&clientName, "client-name", "c", "alice", "client name")
+	cmd.Flags().StringVarP(&serverURL, "server", "u", "https://localhost:8686", "EST server URL")
+	cmd.Flags().StringVar(&caFingerprint, "ca-fingerprint", "", "hex-encoded SHA-256 fingerprint of the CA certificate to trust, if it does not exist in the CA directory")
+	cmd.Flags().StringVarP(&token, "token", "t", "", "one-time bootstrap token")
+	cmd.Flags().StringVar(&username, "username", "", "bootstrap username")
+	cmd.Flags().StringVar(&password, "password", "", "bootstrap password")
+	cmd.Flags().IntVarP(&keySize, "key-size", "k", 2048, "key size")
+	dnsNames = cmd.Flags().StringArrayP("dns", "d", []string{"localhost"}, "Certificate DNS names")
+
+	return cmd
+}
+
+// newESTReenrollCmd returns a new instance of cobra.Command to re-enroll the client certificate by the current certificate
+func newESTReenrollCmd() *cobra.Command {
+	var (
+		path       string
+		caName     string
+		clientName string
+		serverURL  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "reenroll",
+		Short: "Re-enroll the client certificate.",
+		Long:  `Re-enroll the client certificate authenticated by the current certificate. The certificate will be replaced in the client directory`,
+		Run: func(cmd *cobra.Command, args []string) {
+			caCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, caName))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			clientPrivateKey, err := key.ReadRSAPrivateKeyFromFile(fmt.Sprintf("%s/%s/private.key", path, clientName))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			current, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/certificate.crt", path, clientName))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			csr, err := cert.NewCSR(clientPrivateKey, current.Subject.CommonName, "", current.DNSNames)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			client := est.NewClient(serverURL, &tls.Config{
+				Certificates: []tls.Certificate{{
+					Certificate: [][]byte{current.Raw},
+					PrivateKey:  clientPrivateKey,
+				}},
+				RootCAs:    certPool(caCert),
+				MinVersion: tls.VersionTLS12,
+			})
+
+			renewed, err := client.SimpleReenroll(context.Background(), csr)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = file.WriteAtomic(fmt.Sprintf("%s/%s/certificate.crt", path, clientName), renewed.Raw, 0644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("certificate is re-enrolled, expires at %s\n", renewed.NotAfter.Format(time.RFC3339))
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&caName, "ca-name", "a", "primary", "CA identifier")
+	cmd.Flags().StringVarP(&clientName, "client-name", "c", "alice", "client name")
+	cmd.Flags().StringVarP(&serverURL, "server", "u", "https://localhost:8686", "EST server URL")
+
+	return cmd
+}
+
+// newESTTokenCmd returns a new instance of cobra.Command to create a one-time bootstrap token
+func newESTTokenCmd() *cobra.Command {
+	var (
+		bootstrapPath string
+		identity      string
+		scopes        string
+		expiration    time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Create a one-time bootstrap token.",
+		Long:  `Create a one-time bootstrap token for the EST enrollment. The token hash is added to the bootstrap credentials file of the CA server`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var bootstrap ca.Bootstrap
+			b, err := os.ReadFile(bootstrapPath)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			if len(b) > 0 {
+				err = json.Unmarshal(b, &bootstrap)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+			}
+
+			var tokenBytes [32]byte
+			_, err = rand.Read(tokenBytes[:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			token := base64.RawURLEncoding.EncodeToString(tokenBytes[:])
+
+			bootstrap.Credentials = append(bootstrap.Credentials, ca.BootstrapCredential{
+				TokenSHA256: ca.HashSecret(token),
+				Identity:    identity,
+				Scopes:      strings.Fields(scopes),
+				ExpiresAt:   time.Now().Add(expiration).UTC(),
+			})
+
+			b, err = json.MarshalIndent(bootstrap, "", "  ")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = file.WriteAtomic(bootstrapPath, b, 0600)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Println(token)
+		},
+	}
+
+	cmd.Flags().StringVarP(&bootstrapPath, "bootstrap", "b", "../credentials/primary/bootstrap.json", "bootstrap credentials file of the CA server")
+	cmd.Flags().StringVarP(&identity, "identity", "i", "alice", "requester identity checked by the issuance policy")
+	cmd.Flags().StringVarP(&scopes, "scopes", "s", "bob.user.read bob.user.write", "client scopes, separated by space")
+	cmd.Flags().DurationVarP(&expiration, "expiration", "e", 24*time.Hour, "token expiration")
+
+	return cmd
+}
+
+// readOrBootstrapCACert reads the CA certificate from the CA directory
+// if it does not exist, it's fetched from the EST server, pinned by the fingerprint and written to the CA directory
+func readOrBootstrapCACert(ctx context.Context, caDir, serverURL, fingerprint string) (*x509.Certificate, error) {
+	caPath := fmt.Sprintf("%s/ca_certificate.crt", caDir)
+	caCert, err := cert.ReadFromFile(caPath)
+	if err == nil {
+		return caCert, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if fingerprint == "" {
+		return nil, fmt.Errorf("CA certificate %s does not exist, --ca-fingerprint is required to bootstrap it", caPath)
+	}
+
+	c, err := est.PinnedCACert(ctx, serverURL, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(caDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	err = file.Write(caPath, c.Raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// certPool returns a new certificate pool including the certificate
+func certPool(c *x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c)
+	return pool
+}
@@ -0,0 +1,425 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+// exportFlags are the input and output flags shared by the export commands
+type exportFlags struct {
+	path     string
+	name     string
+	caName   string
+	certPath string
+	keyPath  string
+	caPath   string
+	outPath  string
+}
+
+// certPathOrDefault returns the certificate file, [path]/[name]/certificate.crt by default
+func (f *exportFlags) certPathOrDefault() string {
+	if f.certPath != "" {
+		return f.certPath
+	}
+	return fmt.Sprintf("%s/%s/certificate.crt", f.path, f.name)
+}
+
+// keyPathOrDefault returns the private key file, [path]/[name]/private.key by default
+func (f *exportFlags) keyPathOrDefault() string {
+	if f.keyPath != "" {
+		return f.keyPath
+	}
+	return fmt.Sprintf("%s/%s/private.key", f.path, f.name)
+}
+
+// caPathOrDefault returns the CA certificates file, [path]/[ca-name]/ca_certificate.crt by default, it's empty if --ca-name is empty
+func (f *exportFlags) caPathOrDefault() string {
+	if f.caPath != "" || f.caName == "" {
+		return f.caPath
+	}
+	return fmt.Sprintf("%s/%s/ca_certificate.crt", f.path, f.caName)
+}
+
+// chain reads the certificate and its CA certificates, the leaf is the first
+func (f *exportFlags) chain() ([]*x509.Certificate, error) {
+	chain, err := cert.ReadChainFromFile(f.certPathOrDefault())
+	if err != nil {
+		return nil, err
+	}
+
+	if caPath := f.caPathOrDefault(); caPath != "" {
+		caCerts, err := cert.ReadChainFromFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, caCerts...)
+	}
+
+	return chain, nil
+}
+
+// write writes the bytes to the output file with the permission, or to the stdout if the output is empty
+func (f *exportFlags) write(b []byte, perm os.FileMode) error {
+	if f.outPath == "" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	return file.WriteAtomic(f.outPath, b, perm)
+}
+
+// add adds the shared flags to the command
+func (f *exportFlags) add(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&f.name, "name", "n", "alice", "client name")
+	cmd.Flags().StringVarP(&f.caName, "ca-name", "a", "primary", "CA identifier of the chain, the chain is not included if it's empty")
+	cmd.Flags().StringVar(&f.certPath, "cert", "", "certificate file in PEM or DER format, [path]/[name]/certificate.crt if it's empty")
+	cmd.Flags().StringVar(&f.keyPath, "key", "", "private key file in PEM or DER format, [path]/[name]/private.key if it's empty")
+	cmd.Flags().StringVar(&f.caPath, "ca-cert", "", "CA certificates file in PEM or DER format, [path]/[ca-name]/ca_certificate.crt if it's empty")
+	cmd.Flags().StringVarP(&f.outPath, "out", "o", "", "output file, the stdout if it's empty")
+}
+
+// newExportCmd returns a new instance of cobra.Command including the export commands
+func newExportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the credentials in PEM, PKCS#12 or JWK format",
+	}
+
+	cmd.AddCommand(newExportPEMCmd(), newExportPKCS12Cmd(), newExportJWKCmd(), newExportJWKSCmd())
+
+	return cmd
+}
+
+// newExportPEMCmd returns a new instance of cobra.Command to export the certificate chain and the private key in PEM format
+func newExportPEMCmd() *cobra.Command {
+	var (
+		f           exportFlags
+		withKey     bool
+		passwordSrc passwordFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "pem",
+		Short: "Export the certificate chain in PEM format.",
+		Long: `Export the certificate followed by its CA certificates in PEM format.
+The private key is appended in PKCS#8 PEM format by --with-key, it's encrypted if --password-file or --password-env is given.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			chain, err := f.chain()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			var buf bytes.Buffer
+			err = cert.EncodeToPEM(&buf, chain...)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			perm := os.FileMode(0644)
+			if withKey {
+				privateKey, err := key.ReadPrivateKeyFromFile(f.keyPathOrDefault())
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				err = checkKeyPair(privateKey, chain[0])
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+
+				var password []byte
+				if passwordSrc.set() {
+					password, err = passwordSrc.read()
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+				}
+
+				err = key.EncodeEncryptedPrivateKeyToPEM(&buf, privateKey, password)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				perm = 0600
+			}
+
+			err = f.write(buf.Bytes(), perm)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	f.add(cmd)
+	passwordSrc.add(cmd, "private key")
+	cmd.Flags().BoolVar(&withKey, "with-key", false, "append the private key")
+
+	return cmd
+}
+
+// newExportPKCS12Cmd returns a new instance of cobra.Command to export the certificate chain and the private key in a PKCS#12 bundle
+func newExportPKCS12Cmd() *cobra.Command {
+	var (
+		f           exportFlags
+		passwordSrc passwordFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "pkcs12",
+		Short: "Export the certificate chain and the private key in a PKCS#12 bundle.",
+		Long: `Export the certificate, its CA certificates and the private key in a password protected PKCS#12 (.p12, .pfx) bundle, e.g. for a Java keystore or .NET.
+The bundle password is given by --password-file or --password-env, the bundle is written to --out.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if f.outPath == "" {
+				fmt.Fprintln(os.Stderr, "--out is required, the PKCS#12 bundle is binary")
+				os.Exit(1)
+			}
+
+			if !passwordSrc.set() {
+				fmt.Fprintln(os.Stderr, "one of --password-file or --password-env is required")
+				os.Exit(1)
+			}
+
+			password, err := passwordSrc.read()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			chain, err := f.chain()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			privateKey, err := key.ReadPrivateKeyFromFile(f.keyPathOrDefault())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = checkKeyPair(privateKey, chain[0])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			// the legacy 3DES and RC2 encryption is readable by all the Java and .NET versions
+			pfx, err := pkcs12.Encode(rand.Reader, privateKey, chain[0], chain[1:], string(password))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = f.write(pfx, 0600)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	f.add(cmd)
+	passwordSrc.add(cmd, "PKCS#12 bundle")
+
+	return cmd
+}
+
+// newExportJWKCmd returns a new instance of cobra.Command to export the public key in JWK format
+func newExportJWKCmd() *cobra.Command {
+	var (
+		f             exportFlags
+		publicKeyPath string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "jwk",
+		Short: "Export the public key in JWK format.",
+		Long: `Export the public key of the certificate in JWK format, the certificate chain is included as x5c.
+The public key file given by --public-key is exported without a certificate chain.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			jwk, err := newExportJWK(&f, publicKeyPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			b, err := json.MarshalIndent(jwk, "", "  ")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = f.write(append(b, '\n'), 0644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	f.add(cmd)
+	cmd.Flags().StringVar(&publicKeyPath, "public-key", "", "public key file in PEM or DER format, the certificate is used if it's empty")
+
+	return cmd
+}
+
+// newExportJWKSCmd returns a new instance of cobra.Command to export the public keys in a JWK set
+func newExportJWKSCmd() *cobra.Command {
+	var (
+		f     exportFlags
+		names *[]string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "jwks [file...]",
+		Short: "Export the public keys in a JWK set.",
+		Long: `Export the public keys in a JWK set, e.g. to publish the token or the checkpoint verification keys.
+The arguments are certificate or public key files in PEM or DER format, the certificates of the --name clients and the --ca-name CA are exported without arguments.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var jwks key.JWKS
+
+			add := func(pk crypto.PublicKey, certs []*x509.Certificate) {
+				jwk, err := key.NewJWK(pk)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				jwk.SetCertificates(certs...)
+				jwks.Keys = append(jwks.Keys, jwk)
+			}
+
+			for _, path := range args {
+				// the file is a certificate chain or a public key
+				if chain, err := cert.ReadChainFromFile(path); err == nil {
+					add(chain[0].PublicKey, chain)
+					continue
+				}
+
+				pk, err := key.ReadPublicKeyFromFile(path)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				add(pk, nil)
+			}
+
+			if len(args) == 0 {
+				for _, name := range *names {
+					c, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/certificate.crt", f.path, name))
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+					add(c.PublicKey, []*x509.Certificate{c})
+				}
+
+				if f.caName != "" {
+					caCerts, err := cert.ReadChainFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", f.path, f.caName))
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+					add(caCerts[0].PublicKey, caCerts)
+				}
+			}
+
+			b, err := json.MarshalIndent(jwks, "", "  ")
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = f.write(append(b, '\n'), 0644)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&f.path, "path", "p", "../credentials", "credentials path")
+	names = cmd.Flags().StringArrayP("name", "n", nil, "client name, repeated for more clients")
+	cmd.Flags().StringVarP(&f.caName, "ca-name", "a", "", "CA identifier, the CA certificate is not included if it's empty")
+	cmd.Flags().StringVarP(&f.outPath, "out", "o", "", "output file, the stdout if it's empty")
+
+	return cmd
+}
+
+// newExportJWK returns the JWK of the public key file, or of the certificate including its chain
+func newExportJWK(f *exportFlags, publicKeyPath string) (*key.JWK, error) {
+	if publicKeyPath != "" {
+		pk, err := key.ReadPublicKeyFromFile(publicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return key.NewJWK(pk)
+	}
+
+	chain, err := f.chain()
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := key.NewJWK(chain[0].PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk.SetCertificates(chain...)
+
+	return jwk, nil
+}
+
+// checkKeyPair returns an error if the private key is not of the certificate, e.g. a --key of another client
+func checkKeyPair(privateKey crypto.Signer, c *x509.Certificate) error {
+	pk, ok := privateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pk.Equal(c.PublicKey) {
+		return fmt.Errorf("the private key does not match the certificate of %s", c.Subject.CommonName)
+	}
+	return nil
+}
+
+// passwordFlags are the sources of the password of an exported credential, separated from the passphrase of the read private keys
+type passwordFlags struct {
+	file string
+	env  string
+}
+
+// set reports whether a password source is given
+func (p *passwordFlags) set() bool {
+	return p.file != "" || p.env != ""
+}
+
+// read reads the password from the file or the environment variable
+func (p *passwordFlags) read() ([]byte, error) {
+	f := key.NewPassphraseFunc(p.file, p.env, false)
+	if f == nil {
+		return nil, errors.New("no password source is given")
+	}
+	return f("")
+}
+
+// add adds the password flags to the command
+func (p *passwordFlags) add(cmd *cobra.Command, of string) {
+	cmd.Flags().StringVar(&p.file, "password-file", "", fmt.Sprintf("file including the %s password", of))
+	cmd.Flags().StringVar(&p.env, "password-env", "", fmt.Sprintf("environment variable including the %s password", of))
+}
@@ -0,0 +1,669 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+// inspectField is a line of the inspection in text format
+type inspectField struct {
+	name  string
+	value string
+}
+
+// inspection is the result of an inspect command, it's printed in text format by its fields or in JSON format by itself
+type inspection interface {
+	fields() []inspectField
+}
+
+// newInspectCmd returns a new instance of cobra.Command including the inspect commands
+func newInspectCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "inspect",
+		Short: "Inspect the certificates, keys, tokens, CSRs and CRLs",
+		Long: `Inspect the credentials in PEM or DER format. The input is a file, the stdin if it's - or empty,
+or the value itself, e.g. the base64 X-Client-Cert header value or a token.`,
+	}
+
+	cmd.PersistentFlags().StringVarP(&output, "output", "o", "text", "output format, text or json")
+
+	cmd.AddCommand(
+		newInspectSubCmd("certificate [file|-|base64]", "Inspect the certificate or the certificate chain.", &output, inspectCertificates),
+		newInspectSubCmd("key [file|-]", "Inspect the private or the public key.", &output, inspectKey),
+		newInspectSubCmd("token [file|-|token]", "Inspect the JWT header and claims, the signature is not verified.", &output, inspectToken),
+		newInspectSubCmd("csr [file|-|base64]", "Inspect the certificate signing request.", &output, inspectCSR),
+		newInspectSubCmd("crl [file|-]", "Inspect the certificate revocation list.", &output, inspectCRL),
+	)
+
+	return cmd
+}
+
+// newInspectSubCmd returns a new instance of cobra.Command inspecting the input by the function and printing the inspections
+func newInspectSubCmd(use, short string, output *string, inspect func(b []byte, path string) ([]inspection, error)) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var arg string
+			if len(args) > 0 {
+				arg = args[0]
+			}
+
+			b, path, err := readInspectInput(arg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			inspections, err := inspect(b, path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = printInspections(os.Stdout, *output, inspections)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// readInspectInput reads the stdin if the argument is - or empty, the file if it exists, otherwise the argument is the value itself
+// the path of the file is returned, it's empty for the stdin and the value
+func readInspectInput(arg string) ([]byte, string, error) {
+	if arg == "" || arg == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		return b, "", err
+	}
+
+	if _, err := os.Stat(arg); err == nil {
+		b, err := os.ReadFile(arg)
+		return b, arg, err
+	}
+
+	// a missing file is reported as is, unless the argument looks like a value
+	if strings.ContainsAny(arg, "/\\") && !strings.HasPrefix(arg, "ey") && len(arg) < 256 {
+		return nil, "", fmt.Errorf("%s does not exist", arg)
+	}
+
+	return []byte(arg), "", nil
+}
+
+// decodeBase64Input decodes the base64 value, e.g. the X-Client-Cert header value with or without the header name
+// the PEM and the binary DER inputs are returned as is
+func decodeBase64Input(b []byte) []byte {
+	if cert.IsPEM(b) {
+		return b
+	}
+
+	s := strings.TrimSpace(string(b))
+	if name, value, ok := strings.Cut(s, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "X-Client-Cert") {
+		s = strings.TrimSpace(value)
+	}
+
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := enc.DecodeString(s); err == nil {
+			return decoded
+		}
+	}
+
+	return b
+}
+
+// printInspections prints the inspections in text format, separated by an empty line, or in JSON format
+// the JSON output of a single inspection is an object, a certificate chain is an array
+func printInspections(w io.Writer, output string, inspections []inspection) error {
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if len(inspections) == 1 {
+			return enc.Encode(inspections[0])
+		}
+		return enc.Encode(inspections)
+	case "text":
+		for i, in := range inspections {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			for _, f := range in.fields() {
+				if f.value == "" {
+					continue
+				}
+				fmt.Fprintf(tw, "%s:\t%s\n", f.name, f.value)
+			}
+
+			err := tw.Flush()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, use text or json", output)
+	}
+}
+
+// certificateInspection is the inspection of a certificate
+type certificateInspection struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	Status             string    `json:"status"`
+	IsCA               bool      `json:"is_ca"`
+	DNSNames           []string  `json:"dns_names,omitempty"`
+	IPAddresses        []string  `json:"ip_addresses,omitempty"`
+	EmailAddresses     []string  `json:"email_addresses,omitempty"`
+	URIs               []string  `json:"uris,omitempty"`
+	Scopes             []string  `json:"scopes,omitempty"`
+	KeyAlgorithm       string    `json:"key_algorithm"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	KeyUsage           []string  `json:"key_usage,omitempty"`
+	ExtKeyUsage        []string  `json:"ext_key_usage,omitempty"`
+	SHA256Fingerprint  string    `json:"sha256_fingerprint"`
+	Thumbprint         string    `json:"thumbprint"`
+	PublicKeySHA256    string    `json:"public_key_sha256"`
+}
+
+func (c *certificateInspection) fields() []inspectField {
+	return []inspectField{
+		{"Subject", c.Subject},
+		{"Issuer", c.Issuer},
+		{"Serial number", c.SerialNumber},
+		{"Not before", c.NotBefore.Format(time.RFC3339)},
+		{"Not after", c.NotAfter.Format(time.RFC3339)},
+		{"Status", c.Status},
+		{"CA", fmt.Sprint(c.IsCA)},
+		{"DNS names", strings.Join(c.DNSNames, ", ")},
+		{"IP addresses", strings.Join(c.IPAddresses, ", ")},
+		{"Email addresses", strings.Join(c.EmailAddresses, ", ")},
+		{"URIs", strings.Join(c.URIs, ", ")},
+		{"Scopes", strings.Join(c.Scopes, " ")},
+		{"Key algorithm", c.KeyAlgorithm},
+		{"Signature algorithm", c.SignatureAlgorithm},
+		{"Key usage", strings.Join(c.KeyUsage, ", ")},
+		{"Extended key usage", strings.Join(c.ExtKeyUsage, ", ")},
+		{"SHA-256 fingerprint", c.SHA256Fingerprint},
+		{"Thumbprint", c.Thumbprint},
+		{"Public key SHA-256", c.PublicKeySHA256},
+	}
+}
+
+// inspectCertificates inspects the certificates of the input, the leaf is the first of a chain
+func inspectCertificates(b []byte, _ string) ([]inspection, error) {
+	certs, err := cert.DecodeCertificates(decodeBase64Input(b))
+	if err != nil {
+		return nil, err
+	}
+
+	inspections := make([]inspection, len(certs))
+	for i, c := range certs {
+		inspections[i] = newCertificateInspection(c)
+	}
+
+	return inspections, nil
+}
+
+// newCertificateInspection returns the inspection of the certificate
+func newCertificateInspection(c *x509.Certificate) *certificateInspection {
+	in := &certificateInspection{
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		SerialNumber:       serialNumberString(c.SerialNumber),
+		NotBefore:          c.NotBefore.UTC(),
+		NotAfter:           c.NotAfter.UTC(),
+		Status:             validityStatus(c.NotBefore, c.NotAfter),
+		IsCA:               c.IsCA,
+		DNSNames:           c.DNSNames,
+		EmailAddresses:     c.EmailAddresses,
+		KeyAlgorithm:       key.KeyType(c.PublicKey),
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		KeyUsage:           keyUsageNames(c.KeyUsage),
+		SHA256Fingerprint:  cert.Fingerprint(c.Raw),
+		Thumbprint:         cert.Thumbprint(c.Raw),
+		PublicKeySHA256:    publicKeyPin(c.RawSubjectPublicKeyInfo),
+	}
+
+	for _, ip := range c.IPAddresses {
+		in.IPAddresses = append(in.IPAddresses, ip.String())
+	}
+
+	for _, u := range c.URIs {
+		in.URIs = append(in.URIs, u.String())
+	}
+
+	// the usages without a name are printed by their number, and the usages unknown to the x509 package by their OID
+	for _, u := range c.ExtKeyUsage {
+		name, ok := extKeyUsageNames[u]
+		if !ok {
+			name = fmt.Sprintf("extended key usage %d", u)
+		}
+		in.ExtKeyUsage = append(in.ExtKeyUsage, name)
+	}
+
+	for _, oid := range c.UnknownExtKeyUsage {
+		in.ExtKeyUsage = append(in.ExtKeyUsage, oid.String())
+	}
+
+	if raw := cert.RawScopesFromCertificate(c); raw != "" {
+		in.Scopes = strings.Fields(raw)
+	}
+
+	return in
+}
+
+// keyInspection is the inspection of a private or a public key
+type keyInspection struct {
+	Type            string `json:"type"`
+	Encoding        string `json:"encoding"`
+	Encrypted       bool   `json:"encrypted"`
+	KeyAlgorithm    string `json:"key_algorithm,omitempty"`
+	PublicKeySHA256 string `json:"public_key_sha256,omitempty"`
+	JWKThumbprint   string `json:"jwk_thumbprint,omitempty"`
+}
+
+func (k *keyInspection) fields() []inspectField {
+	fields := []inspectField{
+		{"Type", k.Type},
+		{"Encoding", k.Encoding},
+		{"Encrypted", fmt.Sprint(k.Encrypted)},
+		{"Key algorithm", k.KeyAlgorithm},
+		{"Public key SHA-256", k.PublicKeySHA256},
+		{"JWK thumbprint", k.JWKThumbprint},
+	}
+
+	if k.Encrypted && k.KeyAlgorithm == "" {
+		fields = append(fields, inspectField{"Note", "inspect the key file with --passphrase-file, --passphrase-env or --passphrase-prompt to decrypt it"})
+	}
+
+	return fields
+}
+
+// inspectKey inspects the private key, or the public key if the input is not a private key
+// an encrypted private key file is decrypted by the passphrase, the key of an encrypted input without the passphrase is not inspected
+func inspectKey(b []byte, path string) ([]inspection, error) {
+	in := &keyInspection{
+		Type:      "private",
+		Encoding:  "DER",
+		Encrypted: key.IsEncryptedPrivateKey(b),
+	}
+	if cert.IsPEM(b) {
+		in.Encoding = "PEM"
+	}
+
+	var (
+		pk  crypto.Signer
+		err error
+	)
+	if path != "" {
+		pk, err = key.ReadPrivateKeyFromFile(path)
+	} else {
+		pk, err = key.DecodePrivateKey(b, nil)
+	}
+
+	switch {
+	case err == nil:
+		return []inspection{in}, in.setPublicKey(pk.Public())
+	case errors.Is(err, key.ErrPassphraseRequired):
+		return []inspection{in}, nil
+	case in.Encrypted:
+		return nil, err
+	}
+
+	// the input is not a private key
+	pub, pubErr := key.DecodePublicKey(b)
+	if pubErr != nil {
+		return nil, fmt.Errorf("neither a private key (%s) nor a public key (%s)", err, pubErr)
+	}
+
+	in.Type = "public"
+	return []inspection{in}, in.setPublicKey(pub)
+}
+
+// setPublicKey sets the algorithm and the fingerprints of the public key
+func (k *keyInspection) setPublicKey(pub crypto.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+
+	k.KeyAlgorithm = key.KeyType(pub)
+	k.PublicKeySHA256 = publicKeyPin(der)
+
+	if jwk, err := key.NewJWK(pub); err == nil {
+		k.JWKThumbprint = jwk.Kid
+	}
+
+	return nil
+}
+
+// tokenInspection is the inspection of a JWT
+type tokenInspection struct {
+	Header    map[string]interface{} `json:"header"`
+	Claims    map[string]interface{} `json:"claims"`
+	Subject   string                 `json:"subject,omitempty"`
+	Scopes    []string               `json:"scopes,omitempty"`
+	IssuedAt  *time.Time             `json:"issued_at,omitempty"`
+	NotBefore *time.Time             `json:"not_before,omitempty"`
+	ExpiresAt *time.Time             `json:"expires_at,omitempty"`
+	Status    string                 `json:"status"`
+}
+
+func (t *tokenInspection) fields() []inspectField {
+	fields := []inspectField{
+		{"Subject", t.Subject},
+		{"Scopes", strings.Join(t.Scopes, " ")},
+		{"Issued at", formatTime(t.IssuedAt)},
+		{"Not before", formatTime(t.NotBefore)},
+		{"Expires at", formatTime(t.ExpiresAt)},
+		{"Status", t.Status},
+	}
+
+	for _, name := range sortedMapKeys(t.Header) {
+		fields = append(fields, inspectField{"Header " + name, fmt.Sprint(t.Header[name])})
+	}
+
+	for _, name := range sortedMapKeys(t.Claims) {
+		fields = append(fields, inspectField{"Claim " + name, formatClaim(t.Claims[name])})
+	}
+
+	return fields
+}
+
+// inspectToken decodes the JWT header and claims without verifying the signature, the Bearer prefix is trimmed
+func inspectToken(b []byte, _ string) ([]inspection, error) {
+	tokenString := strings.TrimSpace(string(b))
+	if prefix := "bearer "; len(tokenString) > len(prefix) && strings.EqualFold(tokenString[:len(prefix)], prefix) {
+		tokenString = strings.TrimSpace(tokenString[len(prefix):])
+	}
+
+	claims := jwt.MapClaims{}
+	token, _, err := new(jwt.Parser).ParseUnverified(tokenString, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &tokenInspection{
+		Header:    token.Header,
+		Claims:    claims,
+		IssuedAt:  numericDate(claims["iat"]),
+		NotBefore: numericDate(claims["nbf"]),
+		ExpiresAt: numericDate(claims["exp"]),
+		Status:    "valid",
+	}
+
+	in.Subject, _ = claims["sub"].(string)
+	if scopes, ok := claims["scopes"].([]interface{}); ok {
+		for _, s := range scopes {
+			in.Scopes = append(in.Scopes, fmt.Sprint(s))
+		}
+	}
+
+	now := time.Now()
+	switch {
+	case in.ExpiresAt != nil && now.After(*in.ExpiresAt):
+		in.Status = fmt.Sprintf("expired %s ago", now.Sub(*in.ExpiresAt).Round(time.Second))
+	case in.NotBefore != nil && now.Before(*in.NotBefore):
+		in.Status = fmt.Sprintf("not valid for %s", in.NotBefore.Sub(now).Round(time.Second))
+	case in.ExpiresAt != nil:
+		in.Status = fmt.Sprintf("valid, expires in %s", in.ExpiresAt.Sub(now).Round(time.Second))
+	}
+
+	return []inspection{in}, nil
+}
+
+// csrInspection is the inspection of a certificate signing request
+type csrInspection struct {
+	Subject            string   `json:"subject"`
+	DNSNames           []string `json:"dns_names,omitempty"`
+	IPAddresses        []string `json:"ip_addresses,omitempty"`
+	EmailAddresses     []string `json:"email_addresses,omitempty"`
+	URIs               []string `json:"uris,omitempty"`
+	KeyAlgorithm       string   `json:"key_algorithm"`
+	SignatureAlgorithm string   `json:"signature_algorithm"`
+	PublicKeySHA256    string   `json:"public_key_sha256"`
+}
+
+func (c *csrInspection) fields() []inspectField {
+	return []inspectField{
+		{"Subject", c.Subject},
+		{"DNS names", strings.Join(c.DNSNames, ", ")},
+		{"IP addresses", strings.Join(c.IPAddresses, ", ")},
+		{"Email addresses", strings.Join(c.EmailAddresses, ", ")},
+		{"URIs", strings.Join(c.URIs, ", ")},
+		{"Key algorithm", c.KeyAlgorithm},
+		{"Signature algorithm", c.SignatureAlgorithm},
+		{"Public key SHA-256", c.PublicKeySHA256},
+	}
+}
+
+// inspectCSR inspects the certificate signing request, its signature is checked
+func inspectCSR(b []byte, _ string) ([]inspection, error) {
+	csr, err := cert.DecodeCSR(decodeBase64Input(b))
+	if err != nil {
+		return nil, err
+	}
+
+	in := &csrInspection{
+		Subject:            csr.Subject.String(),
+		DNSNames:           csr.DNSNames,
+		EmailAddresses:     csr.EmailAddresses,
+		KeyAlgorithm:       key.KeyType(csr.PublicKey),
+		SignatureAlgorithm: csr.SignatureAlgorithm.String(),
+		PublicKeySHA256:    publicKeyPin(csr.RawSubjectPublicKeyInfo),
+	}
+
+	for _, ip := range csr.IPAddresses {
+		in.IPAddresses = append(in.IPAddresses, ip.String())
+	}
+
+	for _, u := range csr.URIs {
+		in.URIs = append(in.URIs, u.String())
+	}
+
+	return []inspection{in}, nil
+}
+
+// crlInspection is the inspection of a certificate revocation list
+type crlInspection struct {
+	Issuer             string          `json:"issuer"`
+	ThisUpdate         time.Time       `json:"this_update"`
+	NextUpdate         time.Time       `json:"next_update"`
+	Status             string          `json:"status"`
+	SignatureAlgorithm string          `json:"signature_algorithm"`
+	SHA256Fingerprint  string          `json:"sha256_fingerprint"`
+	Revoked            []revokedSerial `json:"revoked"`
+}
+
+// revokedSerial is a revoked certificate of the revocation list
+type revokedSerial struct {
+	SerialNumber string    `json:"serial_number"`
+	RevokedAt    time.Time `json:"revoked_at"`
+}
+
+func (c *crlInspection) fields() []inspectField {
+	fields := []inspectField{
+		{"Issuer", c.Issuer},
+		{"This update", c.ThisUpdate.Format(time.RFC3339)},
+		{"Next update", c.NextUpdate.Format(time.RFC3339)},
+		{"Status", c.Status},
+		{"Signature algorithm", c.SignatureAlgorithm},
+		{"SHA-256 fingerprint", c.SHA256Fingerprint},
+		{"Revoked", fmt.Sprint(len(c.Revoked))},
+	}
+
+	for _, r := range c.Revoked {
+		fields = append(fields, inspectField{"  " + r.SerialNumber, "revoked at " + r.RevokedAt.Format(time.RFC3339)})
+	}
+
+	return fields
+}
+
+// inspectCRL inspects the certificate revocation list in PEM or DER format, its signature is not checked
+func inspectCRL(b []byte, _ string) ([]inspection, error) {
+	// the fingerprint is of the DER bytes, as the CRL is published by the CA /v1/crl endpoint
+	der := b
+	if block, _ := pem.Decode(b); block != nil {
+		der = block.Bytes
+	}
+
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return nil, err
+	}
+
+	in := &crlInspection{
+		Issuer:             crl.Issuer.String(),
+		ThisUpdate:         crl.ThisUpdate.UTC(),
+		NextUpdate:         crl.NextUpdate.UTC(),
+		Status:             validityStatus(crl.ThisUpdate, crl.NextUpdate),
+		SignatureAlgorithm: crl.SignatureAlgorithm.String(),
+		SHA256Fingerprint:  cert.Fingerprint(der),
+		Revoked:            []revokedSerial{},
+	}
+
+	for _, r := range crl.RevokedCertificates {
+		in.Revoked = append(in.Revoked, revokedSerial{SerialNumber: serialNumberString(r.SerialNumber), RevokedAt: r.RevocationTime.UTC()})
+	}
+
+	return []inspection{in}, nil
+}
+
+// validityStatus returns whether now is in the validity period and the remaining time, e.g. valid, expires in 720h0m0s
+func validityStatus(notBefore, notAfter time.Time) string {
+	now := time.Now()
+	switch {
+	case now.Before(notBefore):
+		return fmt.Sprintf("not valid for %s", notBefore.Sub(now).Round(time.Second))
+	case !notAfter.IsZero() && now.After(notAfter):
+		return fmt.Sprintf("expired %s ago", now.Sub(notAfter).Round(time.Second))
+	case notAfter.IsZero():
+		return "valid"
+	default:
+		return fmt.Sprintf("valid, expires in %s", notAfter.Sub(now).Round(time.Second))
+	}
+}
+
+// serialNumberString returns the serial number in decimal and in colon-separated hex as printed by openssl, e.g. 10 (0A)
+func serialNumberString(n *big.Int) string {
+	b := n.Bytes()
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02X", v)
+	}
+	return fmt.Sprintf("%s (%s)", n.String(), strings.Join(parts, ":"))
+}
+
+// publicKeyPin returns the base64 SHA-256 of the DER-encoded subject public key info, the HPKP pin-sha256 value
+func publicKeyPin(spki []byte) string {
+	digest := sha256.Sum256(spki)
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// keyUsageNames returns the names of the key usage bits in order
+func keyUsageNames(u x509.KeyUsage) []string {
+	names := []string{"digital signature", "content commitment", "key encipherment", "data encipherment", "key agreement", "certificate sign", "CRL sign", "encipher only", "decipher only"}
+
+	var usages []string
+	for i, name := range names {
+		if u&(1<<i) != 0 {
+			usages = append(usages, name)
+		}
+	}
+	return usages
+}
+
+// extKeyUsageNames are the names of the extended key usages
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "server auth",
+	x509.ExtKeyUsageClientAuth:      "client auth",
+	x509.ExtKeyUsageCodeSigning:     "code signing",
+	x509.ExtKeyUsageEmailProtection: "email protection",
+	x509.ExtKeyUsageTimeStamping:    "time stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP signing",
+}
+
+// numericDate returns the time of the JWT numeric date claim, nil if it's not set
+func numericDate(v interface{}) *time.Time {
+	var seconds float64
+	switch n := v.(type) {
+	case float64:
+		seconds = n
+	case json.Number:
+		f, err := n.Float64()
+		if err != nil {
+			return nil
+		}
+		seconds = f
+	default:
+		return nil
+	}
+
+	t := time.Unix(int64(seconds), 0).UTC()
+	return &t
+}
+
+// formatTime returns the time in RFC 3339 format, empty if it's nil
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatClaim returns the claim value in JSON format, the strings are not quoted
+func formatClaim(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(bytes.TrimSpace(b))
+}
+
+// sortedMapKeys returns the keys of the map in order
+func sortedMapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
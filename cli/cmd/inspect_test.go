@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadInspectInput(t *testing.T) {
+	dir := t.TempDir()
+
+	filePath := filepath.Join(dir, "certificate.pem")
+	err := os.WriteFile(filePath, []byte("file content"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdinPath := filepath.Join(dir, "stdin")
+	err = os.WriteFile(stdinPath, []byte("stdin content"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a long base64 value includes the / character, it's not a missing file
+	longValue := strings.Repeat("ab/c", 100)
+
+	tests := []struct {
+		name    string
+		arg     string
+		want    string
+		path    string
+		wantErr bool
+	}{
+		{name: "stdin by empty argument", arg: "", want: "stdin content"},
+		{name: "stdin by dash", arg: "-", want: "stdin content"},
+		{name: "file", arg: filePath, want: "file content", path: filePath},
+		{name: "missing file", arg: filepath.Join(dir, "missing.pem"), wantErr: true},
+		{name: "missing relative file", arg: "credentials\\alice\\certificate.crt", wantErr: true},
+		{name: "token", arg: "eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJhbGljZSJ9.c2ln", want: "eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiJhbGljZSJ9.c2ln"},
+		{name: "token with a slash", arg: "eyJhbGciOiJSUzI1NiJ9/eyJzdWIiOiJhbGljZSJ9", want: "eyJhbGciOiJSUzI1NiJ9/eyJzdWIiOiJhbGljZSJ9"},
+		{name: "long base64 value", arg: longValue, want: longValue},
+		{name: "short value", arg: "MIIB", want: "MIIB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin, err := os.Open(stdinPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer stdin.Close()
+
+			original := os.Stdin
+			os.Stdin = stdin
+			defer func() { os.Stdin = original }()
+
+			b, path, err := readInspectInput(tt.arg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected input, got err: %s", err)
+			}
+
+			if string(b) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, b)
+			}
+			if path != tt.path {
+				t.Errorf("expected path %q, got %q", tt.path, path)
+			}
+		})
+	}
+}
+
+func TestDecodeBase64Input(t *testing.T) {
+	// the DER bytes are not a valid base64 string
+	der := []byte{0x30, 0x82, 0x01, 0x0a, 0x02, 0x82, 0x01, 0x01, 0x00, 0xff}
+	pemInput := []byte("\n-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n")
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  []byte
+	}{
+		{name: "PEM", input: pemInput, want: pemInput},
+		{name: "DER", input: der, want: der},
+		{name: "standard base64", input: []byte(base64.StdEncoding.EncodeToString(der)), want: der},
+		{name: "raw standard base64", input: []byte(base64.RawStdEncoding.EncodeToString(der)), want: der},
+		{name: "URL base64", input: []byte(base64.URLEncoding.EncodeToString(der)), want: der},
+		{name: "raw URL base64", input: []byte(base64.RawURLEncoding.EncodeToString(der)), want: der},
+		{name: "surrounding white spaces", input: []byte(" " + base64.StdEncoding.EncodeToString(der) + "\n"), want: der},
+		{name: "header", input: []byte("X-Client-Cert: " + base64.StdEncoding.EncodeToString(der)), want: der},
+		{name: "lowercase header", input: []byte("x-client-cert:" + base64.StdEncoding.EncodeToString(der)), want: der},
+		{name: "another header", input: []byte("Authorization: " + base64.StdEncoding.EncodeToString(der)), want: []byte("Authorization: " + base64.StdEncoding.EncodeToString(der))},
+		{name: "not base64", input: []byte("not base64!"), want: []byte("not base64!")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeBase64Input(tt.input)
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/key"
+)
+
+// newKeyCmd returns a new instance of cobra.Command including the private key commands
+func newKeyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "key",
+		Short: "Manage the private keys",
+	}
+
+	cmd.AddCommand(newEncryptKeyCmd())
+
+	return cmd
+}
+
+// newEncryptKeyCmd returns a new instance of cobra.Command to encrypt an existing private key by the passphrase
+func newEncryptKeyCmd() *cobra.Command {
+	var (
+		name string
+		path string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt a private key.",
+		Long: `Encrypt an existing private key by the passphrase given by --passphrase-file, --passphrase-env or --passphrase-prompt.
+The private key is read (decrypted by the same passphrase if it's already encrypted) and written back encrypted with 0600 permission.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !cmd.Flags().Changed("passphrase-file") && !cmd.Flags().Changed("passphrase-env") && !cmd.Flags().Changed("passphrase-prompt") {
+				fmt.Fprintln(os.Stderr, "one of --passphrase-file, --passphrase-env or --passphrase-prompt is required")
+				os.Exit(1)
+			}
+
+			privateKeyPath := fmt.Sprintf("%s/%s/private.key", path, name)
+
+			privateKey, err := key.ReadRSAPrivateKeyFromFile(privateKeyPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			err = key.WritePrivateKey(privateKeyPath, privateKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&name, "name", "n", "primary", "CA or client name")
+
+	return cmd
+}
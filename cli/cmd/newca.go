@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -11,6 +15,7 @@ import (
 	"github.com/theredrad/certauthz/core/cert"
 	"github.com/theredrad/certauthz/core/file"
 	"github.com/theredrad/certauthz/core/key"
+	"github.com/theredrad/certauthz/core/signer"
 )
 
 // newCACommand returns an instance combra.Command to create a new CA certificate
@@ -23,6 +28,7 @@ func newCACommand() *cobra.Command {
 		keySize      int
 		expiration   time.Duration
 		serialNumber int64
+		signerURI    string
 	)
 
 	cmd := &cobra.Command{
@@ -30,15 +36,22 @@ func newCACommand() *cobra.Command {
 		Short: "Create a new CA certificate.",
 		Long:  `Create a new CA certificate. Key pairs will be stored in the credentials directory`,
 		Run: func(cmd *cobra.Command, args []string) {
-			// generate primary private key
-			primaryPrivateKey, err := key.GenerateKeyPair(fmt.Sprintf("%s/%s", path, name), keySize)
+			var err error
+
+			// generate primary private key, or use the existing key of the signer backend
+			var primarySigner crypto.Signer
+			if signerURI != "" {
+				primarySigner, err = newCASignerKeyPair(fmt.Sprintf("%s/%s", path, name), signerURI)
+			} else {
+				primarySigner, err = key.GenerateKeyPair(fmt.Sprintf("%s/%s", path, name), keySize)
+			}
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
 			// generate a new CA certificate in DER format
-			bytes, err := cert.NewCA(primaryPrivateKey, &primaryPrivateKey.PublicKey, serialNumber, commonName, org, expiration)
+			bytes, err := cert.NewCA(primarySigner, serialNumber, commonName, org, expiration)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
@@ -70,6 +83,38 @@ func newCACommand() *cobra.Command {
 	cmd.Flags().IntVarP(&keySize, "key-size", "k", 2048, "key size")
 	cmd.Flags().DurationVarP(&expiration, "expiration", "e", 8760*time.Hour, "certification expiration")
 	cmd.Flags().Int64VarP(&serialNumber, "serial-number", "s", serial, "certification serial number")
+	cmd.Flags().StringVar(&signerURI, "signer", "", "signer URI of an existing CA key, e.g. agent:///path/to/agent.sock?key=primary or pkcs11:token=primary;object=ca?module-name=soft&module-path=/path/to/tokens&pin-source=/path/to/pin, a new private key is generated if it's empty")
 
 	return cmd
 }
+
+// newCASignerKeyPair opens the signer and writes its public key in DER format to the CA directory, the private key is kept by the signer backend
+func newCASignerKeyPair(dir, signerURI string) (crypto.Signer, error) {
+	primarySigner, err := signer.Open(signerURI)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := primarySigner.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("only RSA CA keys are supported")
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	err = key.EncodePublicKeyToDER(&buf, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = file.Write(fmt.Sprintf("%s/public.pub", dir), buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	return primarySigner, nil
+}
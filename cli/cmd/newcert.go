@@ -9,6 +9,8 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/ca"
 	"github.com/theredrad/certauthz/core/cert"
 	"github.com/theredrad/certauthz/core/file"
 	"github.com/theredrad/certauthz/core/key"
@@ -25,6 +27,9 @@ func newCertificateCmd() *cobra.Command {
 		dnsNames     *[]string
 		expiration   time.Duration
 		scopes       string
+		signerURI    string
+		auditPath    string
+		ctlogDir     string
 	)
 
 	cmd := &cobra.Command{
@@ -33,39 +38,62 @@ func newCertificateCmd() *cobra.Command {
 		Long:  `Create a new client certificate. It will be stored in the client directory`,
 		Run: func(cmd *cobra.Command, args []string) {
 			// read CA certificate
-			caCert, err := cert.ReadFromDERFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, caName))
+			caCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, caName))
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
-			// read primary private key
-			primaryPrivateKey, err := key.ReadRSAPrivateKeyFromDERFile(fmt.Sprintf("%s/%s/private.key", path, caName))
+			// open the CA signer, the primary private key by default
+			caSigner, err := openSigner(signerURI, path, caName)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
+			// the certificate is appended to the issuance log before it's written
+			issuanceLog, err := openIssuanceLog(ctlogDir, path, caName, caSigner)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer issuanceLog.Close()
+
 			// read client public key
-			clientPublicKey, err := key.ReadRSAPublicKeyFromDERFile(fmt.Sprintf("%s/%s/public.pub", path, clientName))
+			clientPublicKey, err := key.ReadRSAPublicKeyFromFile(fmt.Sprintf("%s/%s/public.pub", path, clientName))
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
 			// generate a new certificate in DER format. the scopes are stored as a custom extension in the certificate
-			clientCert, err := cert.NewCert(caCert, clientPublicKey, primaryPrivateKey, serialNumber, clientName, org, scopes, *dnsNames, expiration)
+			clientCert, err := cert.NewCert(caCert, clientPublicKey, caSigner, serialNumber, clientName, org, scopes, *dnsNames, expiration)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 
+			err = issuanceLog.Append(clientCert)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "failed to append the certificate to the issuance log:", err)
+				os.Exit(1)
+			}
+
 			// write the client certificate to file
 			err = file.Write(fmt.Sprintf("%s/%s/certificate.crt", path, clientName), clientCert)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+
+			logAudit(auditPath, path, caName, caSigner, audit.Record{
+				Operation: ca.OperationIssue,
+				Identity:  cliIdentity,
+				Subject:   clientName,
+				Serial:    strconv.FormatInt(serialNumber, 10),
+				Scopes:    scopes,
+				Outcome:   audit.OutcomeSuccess,
+			})
 		},
 	}
 
@@ -81,6 +109,9 @@ func newCertificateCmd() *cobra.Command {
 	cmd.Flags().DurationVarP(&expiration, "expiration", "e", 8760*time.Hour, "certification expiration")
 	cmd.Flags().StringVarP(&scopes, "scopes", "s", "bob.user.read bob.user.write", "client scopes, separated by space")
 	dnsNames = cmd.Flags().StringArrayP("dns", "d", []string{"localhost"}, "Certificate DNS names")
+	cmd.Flags().StringVar(&signerURI, "signer", "", signerFlagUsage)
+	cmd.Flags().StringVar(&auditPath, "audit-log", "", "audit log file, [path]/[ca-name]/audit.log if it's empty")
+	cmd.Flags().StringVar(&ctlogDir, "ctlog", "", ctlogFlagUsage)
 
 	return cmd
 }
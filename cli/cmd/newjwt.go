@@ -9,8 +9,9 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/spf13/cobra"
 
+	"github.com/theredrad/certauthz/core/audit"
 	"github.com/theredrad/certauthz/core/file"
-	"github.com/theredrad/certauthz/core/key"
+	jwtCore "github.com/theredrad/certauthz/core/jwt"
 )
 
 // newJWTTokenCmd returns a new instance of cobra.Command to generate a JWT token
@@ -22,6 +23,8 @@ func newJWTTokenCmd() *cobra.Command {
 		scopes      string
 		primaryName string
 		expiration  time.Duration
+		signerURI   string
+		auditPath   string
 	)
 
 	cmd := &cobra.Command{
@@ -29,8 +32,8 @@ func newJWTTokenCmd() *cobra.Command {
 		Short: "Generate a new token.",
 		Long:  `Generate a new token. It will be stored in the client directory`,
 		Run: func(cmd *cobra.Command, args []string) {
-			// read the primary private key
-			primaryPrivateKey, err := key.ReadRSAPrivateKeyFromDERFile(fmt.Sprintf("%s/%s/private.key", path, primaryName))
+			// open the primary signer, the primary private key by default
+			primarySigner, err := openSigner(signerURI, path, primaryName)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
@@ -44,11 +47,8 @@ func newJWTTokenCmd() *cobra.Command {
 				"scopes": strings.Split(scopes, " "),
 			}
 
-			// a new jwt token with the claims
-			token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-
-			// sign the token
-			tokenStr, err := token.SignedString(primaryPrivateKey)
+			// a new jwt token with the claims signed by the primary signer
+			tokenStr, err := jwtCore.Sign(primarySigner, claims)
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
@@ -59,6 +59,14 @@ func newJWTTokenCmd() *cobra.Command {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
+
+			logAudit(auditPath, path, primaryName, primarySigner, audit.Record{
+				Operation: operationMintToken,
+				Identity:  cliIdentity,
+				Subject:   clientName,
+				Scopes:    scopes,
+				Outcome:   audit.OutcomeSuccess,
+			})
 		},
 	}
 
@@ -68,6 +76,8 @@ func newJWTTokenCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&scopes, "scopes", "s", "bob.user.read bob.user.write", "scopes space-separated")
 	cmd.Flags().StringVarP(&audience, "audience", "d", "bob", "audience client identifier")
 	cmd.Flags().DurationVarP(&expiration, "expiration", "e", time.Hour*864000, "token expiration")
+	cmd.Flags().StringVar(&signerURI, "signer", "", signerFlagUsage)
+	cmd.Flags().StringVar(&auditPath, "audit-log", "", "audit log file, [path]/[primary-name]/audit.log if it's empty")
 
 	return cmd
 }
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/key"
+	"github.com/theredrad/certauthz/core/renewal"
+)
+
+// newRenewCmd returns a new instance of cobra.Command to run the certificate renewal agent
+func newRenewCmd() *cobra.Command {
+	var (
+		path          string
+		caName        string
+		clientName    string
+		caURL         string
+		expiration    time.Duration
+		fraction      float64
+		checkInterval time.Duration
+		retryInterval time.Duration
+		pid           int
+		pidFile       string
+		once          bool
+		signerURI     string
+		ctlogDir      string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "renew",
+		Short: "Renew the client certificate before it expires.",
+		Long: `Watch the client certificate and renew it when the fraction of its lifetime is passed.
+The certificate is renewed by the CA private key directly, or by sending a CSR to the CA endpoint if --ca-url is set.
+The renewed certificate replaces the certificate file atomically, and SIGHUP is sent to the --pid or --pid-file process.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// read CA certificate, it's used to sign the certificate directly or to verify the CA endpoint
+			caCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/ca_certificate.crt", path, caName))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			issuer, err := newRenewalIssuer(caCert, path, caName, clientName, caURL, signerURI, ctlogDir, expiration)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			var notifiers []renewal.Notifier
+			if pid != 0 || pidFile != "" {
+				notifiers = append(notifiers, &renewal.SignalNotifier{PID: pid, PIDFile: pidFile})
+			}
+			notifiers = append(notifiers, renewal.NotifierFunc(func(certPath string, renewed *x509.Certificate) error {
+				fmt.Printf("certificate %s is renewed, expires at %s\n", certPath, renewed.NotAfter.Format(time.RFC3339))
+				return nil
+			}))
+
+			agent, err := renewal.NewAgent(renewal.Config{
+				CertPath:      fmt.Sprintf("%s/%s/certificate.crt", path, clientName),
+				Fraction:      fraction,
+				CheckInterval: checkInterval,
+				RetryInterval: retryInterval,
+				Issuer:        issuer,
+				Notifiers:     notifiers,
+				OnError: func(err error) {
+					fmt.Fprintln(os.Stderr, err)
+				},
+			})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+			defer cancel()
+
+			if once {
+				next, err := agent.RenewIfDue(ctx)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				fmt.Printf("next renewal at %s\n", next.Format(time.RFC3339))
+				return
+			}
+
+			_ = agent.Run(ctx) // returns when the context is canceled by a signal
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&caName, "ca-name", "a", "primary", "CA identifier")
+	cmd.Flags().StringVarP(&clientName, "client-name", "c", "alice", "client name")
+	cmd.Flags().StringVarP(&caURL, "ca-url", "u", "", "CA renewal endpoint, the CA private key is used directly if it's empty")
+	cmd.Flags().DurationVarP(&expiration, "expiration", "e", 24*time.Hour, "renewed certification expiration, only used with the CA private key")
+	cmd.Flags().Float64VarP(&fraction, "fraction", "f", renewal.DefaultFraction, "fraction of the certificate lifetime after which it's renewed")
+	cmd.Flags().DurationVar(&checkInterval, "check-interval", renewal.DefaultCheckInterval, "maximum time between two checks of the certificate")
+	cmd.Flags().DurationVar(&retryInterval, "retry-interval", 10*time.Second, "wait time after a failed renewal")
+	cmd.Flags().IntVar(&pid, "pid", 0, "process id to send SIGHUP after renewal")
+	cmd.Flags().StringVar(&pidFile, "pid-file", "", "file including the process id to send SIGHUP after renewal")
+	cmd.Flags().BoolVar(&once, "once", false, "renew once if it's due and exit")
+	cmd.Flags().StringVar(&signerURI, "signer", "", signerFlagUsage+", only used without --ca-url")
+	cmd.Flags().StringVar(&ctlogDir, "ctlog", "", ctlogFlagUsage+", only used without --ca-url")
+
+	return cmd
+}
+
+// newRenewalIssuer returns the CSR issuer if the CA URL is set, otherwise the direct issuer using the CA signer
+func newRenewalIssuer(caCert *x509.Certificate, path, caName, clientName, caURL, signerURI, ctlogDir string, expiration time.Duration) (renewal.Issuer, error) {
+	if caURL != "" {
+		// read client private key, it signs the CSR and authenticates the client to the CA endpoint
+		clientPrivateKey, err := key.ReadRSAPrivateKeyFromFile(fmt.Sprintf("%s/%s/private.key", path, clientName))
+		if err != nil {
+			return nil, err
+		}
+
+		return &renewal.CSRIssuer{
+			URL:              caURL,
+			CACert:           caCert,
+			ClientPrivateKey: clientPrivateKey,
+			Timeout:          30 * time.Second,
+		}, nil
+	}
+
+	// open the CA signer, the primary private key by default
+	caSigner, err := openSigner(signerURI, path, caName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the renewed certificates are appended to the issuance log, it's kept open while the agent runs
+	issuanceLog, err := openIssuanceLog(ctlogDir, path, caName, caSigner)
+	if err != nil {
+		return nil, err
+	}
+
+	return &renewal.DirectIssuer{
+		CACert:      caCert,
+		CASigner:    caSigner,
+		Expiration:  expiration,
+		IssuanceLog: issuanceLog,
+	}, nil
+}
@@ -5,13 +5,29 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/key"
 )
 
 func Execute() {
+	var (
+		passphraseFile   string
+		passphraseEnv    string
+		passphrasePrompt bool
+	)
+
 	rootCmd := &cobra.Command{
 		Use: "cli",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// the private keys are encrypted on write and decrypted on read by the passphrase
+			key.SetPassphraseFunc(key.NewPassphraseFunc(passphraseFile, passphraseEnv, passphrasePrompt))
+		},
 	}
 
+	rootCmd.PersistentFlags().StringVar(&passphraseFile, "passphrase-file", "", "file including the private keys passphrase")
+	rootCmd.PersistentFlags().StringVar(&passphraseEnv, "passphrase-env", "", "environment variable including the private keys passphrase")
+	rootCmd.PersistentFlags().BoolVar(&passphrasePrompt, "passphrase-prompt", false, "prompt for the private keys passphrase")
+
 	createCmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create a new CA, Certificate, and Client",
@@ -26,6 +42,16 @@ func Execute() {
 	rootCmd.AddCommand(generateCmd)
 	createCmd.AddCommand(newClientCmd(), newCACommand(), newCertificateCmd())
 	generateCmd.AddCommand(newJWTTokenCmd())
+	rootCmd.AddCommand(newRenewCmd())
+	rootCmd.AddCommand(newESTCmd())
+	rootCmd.AddCommand(newKeyCmd())
+	rootCmd.AddCommand(newSignerCmd())
+	rootCmd.AddCommand(newCeremonyCmd())
+	rootCmd.AddCommand(newAuditCmd())
+	rootCmd.AddCommand(newCTLogCmd())
+	rootCmd.AddCommand(newExportCmd())
+	rootCmd.AddCommand(newInspectCmd())
+	rootCmd.AddCommand(newVerifyCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/signer"
+)
+
+const (
+	signerFlagUsage = "signer URI, e.g. agent:///path/to/agent.sock?key=primary or pkcs11:token=primary;object=ca?module-name=soft&module-path=/path/to/tokens&pin-source=/path/to/pin, the CA private key file is used if it's empty"
+)
+
+// newSignerCmd returns a new instance of cobra.Command including the signer backend commands
+func newSignerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "signer",
+		Short: "Run the signing agent and manage the software tokens",
+	}
+
+	tokenCmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage the software PKCS#11 tokens",
+	}
+	tokenCmd.AddCommand(newSoftTokenInitCmd(), newSoftTokenImportCmd())
+
+	cmd.AddCommand(newSignerAgentCmd(), tokenCmd)
+
+	return cmd
+}
+
+// newSignerAgentCmd returns a new instance of cobra.Command to run the signing agent
+func newSignerAgentCmd() *cobra.Command {
+	var (
+		path       string
+		socketPath string
+		names      *[]string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run the signing agent.",
+		Long: `Run the signing agent on a unix socket, like ssh-agent. The private keys of the names are loaded into the agent only,
+the CLI, the client and the CA server sign by them using --signer agent://[socket]?key=[name] without reading the private keys.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			signers := make(map[string]crypto.Signer)
+			for _, name := range *names {
+				s, err := signer.OpenFile(fmt.Sprintf("%s/%s/private.key", path, name))
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				signers[name] = s
+			}
+
+			l, err := signer.ListenUnix(socketPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+			go func() {
+				<-sig
+				l.Close()
+			}()
+
+			fmt.Printf("signing agent is listening on %s for %s\n", socketPath, strings.Join(*names, ", "))
+
+			err = signer.NewAgent(signers).Serve(l)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&socketPath, "socket", "s", "../credentials/agent.sock", "unix socket path")
+	names = cmd.Flags().StringArrayP("name", "n", []string{"primary"}, "CA or client names whose private keys are served")
+
+	return cmd
+}
+
+// newSoftTokenInitCmd returns a new instance of cobra.Command to initialize a software token
+func newSoftTokenInitCmd() *cobra.Command {
+	var (
+		dir   string
+		label string
+		pin   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Initialize a software token.",
+		Long:  `Initialize a software PKCS#11 token in the tokens directory, which is used as the module-path of the pkcs11 signer URI with the module-name soft`,
+		Run: func(cmd *cobra.Command, args []string) {
+			err := signer.NewSoftModule(dir).InitToken(label, pin)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&dir, "dir", "d", "../credentials/tokens", "tokens directory")
+	cmd.Flags().StringVarP(&label, "label", "l", "primary", "token label")
+	cmd.Flags().StringVar(&pin, "pin", "", "token user PIN")
+
+	return cmd
+}
+
+// newSoftTokenImportCmd returns a new instance of cobra.Command to import a private key to a software token
+func newSoftTokenImportCmd() *cobra.Command {
+	var (
+		dir         string
+		label       string
+		pin         string
+		path        string
+		name        string
+		objectLabel string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import a private key to a software token.",
+		Long:  `Import the private key of the CA or client to the software token as a key object, the private key file can be removed afterward`,
+		Run: func(cmd *cobra.Command, args []string) {
+			privateKey, err := signer.OpenFile(fmt.Sprintf("%s/%s/private.key", path, name))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			if objectLabel == "" {
+				objectLabel = name
+			}
+
+			err = signer.NewSoftModule(dir).ImportKey(label, pin, objectLabel, privateKey)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&dir, "dir", "d", "../credentials/tokens", "tokens directory")
+	cmd.Flags().StringVarP(&label, "label", "l", "primary", "token label")
+	cmd.Flags().StringVar(&pin, "pin", "", "token user PIN")
+	cmd.Flags().StringVarP(&path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&name, "name", "n", "primary", "CA or client name")
+	cmd.Flags().StringVarP(&objectLabel, "object", "o", "", "key object label, the name is used if it's empty")
+
+	return cmd
+}
+
+// openSigner opens the signer by the URI, the private key of the name directory is used if the URI is empty
+func openSigner(uri, path, name string) (crypto.Signer, error) {
+	if uri == "" {
+		uri = fmt.Sprintf("%s/%s/private.key", path, name)
+	}
+	return signer.Open(uri)
+}
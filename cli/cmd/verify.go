@@ -0,0 +1,532 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/spf13/cobra"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/common"
+	"github.com/theredrad/certauthz/server/config"
+	"github.com/theredrad/certauthz/server/web"
+)
+
+// verifyFlags are the trust flags shared by the verify commands, they're the server flags of the same names
+// the trust bundle and the listener of the server configuration file are used instead if it's set
+type verifyFlags struct {
+	path          string
+	primaryName   string
+	caPath        string
+	publicKeyPath string
+	crlPath       string
+	ctlogDir      string
+	scopePolicy   string
+	configPath    string
+	listenerName  string
+	at            string
+	scopes        *[]string
+}
+
+// add adds the shared flags to the command
+func (f *verifyFlags) add(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&f.path, "path", "p", "../credentials", "credentials path")
+	cmd.Flags().StringVarP(&f.primaryName, "primary-name", "a", "primary", "primary name including ca certificate and public key")
+	cmd.Flags().StringVar(&f.configPath, "config", "", "server configuration file in JSON or YAML format, the trust flags are ignored if it's set, e.g. --ca and --crl")
+	cmd.Flags().StringVar(&f.listenerName, "listener", "", "listener of the server configuration file the credentials are verified as, required if it has more than one listener")
+	cmd.Flags().StringVar(&f.at, "at", "", "time the credentials are verified at in RFC 3339 or Unix seconds, e.g. the X-Timestamp of a captured request, the current time if it's empty")
+	f.scopes = cmd.Flags().StringArrayP("scope", "s", nil, "required scope, repeated or separated by space, required in addition to the listener required scopes")
+}
+
+// addCA adds the flags of the certificate validation to the command
+func (f *verifyFlags) addCA(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.caPath, "ca", "", "CA certificate file in PEM or DER format, [path]/[primary-name]/ca_certificate.crt if it's empty")
+	cmd.Flags().StringVar(&f.crlPath, "crl", "", "certificate revocation list file in DER format signed by the CA, the revocation is not checked if it's empty")
+	cmd.Flags().StringVar(&f.ctlogDir, "ctlog", "", "issuance log directory, the client certificates must be included in the log if it's set")
+}
+
+// addPublicKey adds the flag of the token validation to the command
+func (f *verifyFlags) addPublicKey(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.publicKeyPath, "public-key", "", "token authority public key file in PEM or DER format, [path]/[primary-name]/public.pub if it's empty")
+}
+
+// requiredScopes returns the required scopes of the flags
+func (f *verifyFlags) requiredScopes() []string {
+	var scopes []string
+	for _, s := range *f.scopes {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return scopes
+}
+
+// listener returns the trust bundle and the listener of the configuration file, or of the flags as the server configures
+// its listener by the flags without a configuration file, the required scopes of the flags are required in addition
+func (f *verifyFlags) listener() (config.TrustBundle, config.Listener, error) {
+	if f.configPath == "" {
+		bundle := config.TrustBundle{
+			CACertificate:  f.caPath,
+			TokenPublicKey: f.publicKeyPath,
+			CRL:            f.crlPath,
+			IssuanceLog:    f.ctlogDir,
+		}
+		if bundle.CACertificate == "" {
+			bundle.CACertificate = fmt.Sprintf("%s/%s/ca_certificate.crt", f.path, f.primaryName)
+		}
+		if bundle.TokenPublicKey == "" {
+			bundle.TokenPublicKey = fmt.Sprintf("%s/%s/public.pub", f.path, f.primaryName)
+		}
+
+		return bundle, config.Listener{
+			Name:           "default",
+			Mode:           config.ModePlain,
+			AuthMethods:    []string{config.AuthToken, config.AuthCertificate},
+			RequiredScopes: f.requiredScopes(),
+			ScopePolicy:    f.scopePolicy,
+		}, nil
+	}
+
+	cfg, err := config.Read(f.configPath)
+	if err != nil {
+		return config.TrustBundle{}, config.Listener{}, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return config.TrustBundle{}, config.Listener{}, err
+	}
+
+	for _, l := range cfg.Listeners {
+		if l.Name == f.listenerName || f.listenerName == "" && len(cfg.Listeners) == 1 {
+			l.RequiredScopes = append(l.RequiredScopes, f.requiredScopes()...)
+			return cfg.TrustBundles[l.TrustBundle], l, nil
+		}
+	}
+
+	if f.listenerName == "" {
+		return config.TrustBundle{}, config.Listener{}, fmt.Errorf("--listener is required, %s has %d listeners", f.configPath, len(cfg.Listeners))
+	}
+	return config.TrustBundle{}, config.Listener{}, fmt.Errorf("listener %q is not in %s", f.listenerName, f.configPath)
+}
+
+// options returns the middleware options of the verify commands, the checks are traced and verified at the --at time
+func (f *verifyFlags) options() ([]web.Option, error) {
+	opts := []web.Option{web.WithTracer(printCheck)}
+	if f.at == "" {
+		return opts, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, f.at)
+	if err != nil {
+		seconds, parseErr := strconv.ParseInt(f.at, 10, 64)
+		if parseErr != nil {
+			return nil, fmt.Errorf("invalid --at %q, it's not in RFC 3339 or Unix seconds", f.at)
+		}
+		at = time.Unix(seconds, 0)
+	}
+
+	return append(opts, web.WithClock(func() time.Time { return at })), nil
+}
+
+// certificateMiddleware returns the certificate middleware of the trust bundle, the issuance log and the revocation list
+// are checked by the same options as the server does and the certificates are validated through the cache
+func certificateMiddleware(bundle config.TrustBundle, opts ...web.Option) (*web.CertificateMiddleware, error) {
+	caCert, err := cert.ReadCAFromFile(bundle.CACertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	bundleOpts, _, err := bundle.Options(caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, web.WithCertificateCache(cert.NewVerifiedCache(1, nil)))
+	return web.NewCertificateMiddleware(bundle.CACertificate, append(opts, bundleOpts...)...)
+}
+
+// tokenMiddleware returns the token middleware of the trust bundle by its token public key
+func tokenMiddleware(bundle config.TrustBundle, opts ...web.Option) (*web.JWTokenMiddleware, error) {
+	if bundle.TokenPublicKey == "" {
+		return nil, errors.New("the trust bundle has no token public key")
+	}
+
+	return web.NewJWTokenMiddleware(bundle.TokenPublicKey, opts...)
+}
+
+// load returns the trust bundle, the listener and the middleware options of the flags
+func (f *verifyFlags) load() (config.TrustBundle, config.Listener, []web.Option, error) {
+	bundle, l, err := f.listener()
+	if err != nil {
+		return config.TrustBundle{}, config.Listener{}, nil, err
+	}
+
+	opts, err := f.options()
+	if err != nil {
+		return config.TrustBundle{}, config.Listener{}, nil, err
+	}
+
+	return bundle, l, opts, nil
+}
+
+// newVerifyCmd returns a new instance of cobra.Command including the verify commands
+func newVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the certificates, tokens and requests as the server does",
+		Long: `Verify the credentials by the checks of the server middlewares and print the result of every check,
+e.g. to reproduce why a request is rejected. The command exits with 1 if a check is failed.`,
+	}
+
+	cmd.AddCommand(newVerifyCertificateCmd(), newVerifyTokenCmd(), newVerifyRequestCmd())
+
+	return cmd
+}
+
+// newVerifyCertificateCmd returns a new instance of cobra.Command to verify a client certificate
+func newVerifyCertificateCmd() *cobra.Command {
+	var f verifyFlags
+
+	cmd := &cobra.Command{
+		Use:   "certificate [file|-|base64]",
+		Short: "Verify the client certificate and its scopes.",
+		Long: `Verify the client certificate by the CA certificate and the revocation list as the certificate middleware does, and check it has the required scopes.
+The input is a file, the stdin if it's - or empty, or the base64 X-Client-Cert header value.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var arg string
+			if len(args) > 0 {
+				arg = args[0]
+			}
+
+			b, _, err := readInspectInput(arg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			clientCert, err := cert.DecodeCertificate(decodeBase64Input(b))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			bundle, l, opts, err := f.load()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			m, err := certificateMiddleware(bundle, opts...)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			clientCert, _, err = m.VerifyCertificate(clientCert.Raw)
+			if err != nil {
+				os.Exit(1)
+			}
+
+			client := common.Client{
+				Name:   clientCert.Subject.CommonName,
+				Scopes: cert.ScopesFromCertificate(clientCert),
+			}
+			if !checkScopes(client, l.RequiredScopes) {
+				os.Exit(1)
+			}
+
+			fmt.Printf("client %q is authorized\n", client.Name)
+		},
+	}
+
+	f.add(cmd)
+	f.addCA(cmd)
+
+	return cmd
+}
+
+// newVerifyTokenCmd returns a new instance of cobra.Command to verify a token
+func newVerifyTokenCmd() *cobra.Command {
+	var (
+		f        verifyFlags
+		audience string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "token [file|-|token]",
+		Short: "Verify the token and its scopes, and its audience by the CLI only.",
+		Long: `Verify the token signature and expiry by the public key as the token middleware does, and check it has the required scopes.
+The input is a file, the stdin if it's - or empty, or the token itself; the Bearer prefix is trimmed.
+The --aud audience is a CLI-only check, the server does not check it: it's printed as a "cli-only" check after the decision of the server checks,
+and the command exits with 1 if it's failed although the server authorizes the token.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var arg string
+			if len(args) > 0 {
+				arg = args[0]
+			}
+
+			b, _, err := readInspectInput(arg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			tokenString := strings.TrimSpace(string(b))
+			if fields := strings.Fields(tokenString); len(fields) == 2 && strings.EqualFold(fields[0], "bearer") {
+				tokenString = fields[1]
+			}
+
+			bundle, l, opts, err := f.load()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			m, err := tokenMiddleware(bundle, opts...)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			client, _, err := m.VerifyToken(tokenString)
+			if err != nil {
+				os.Exit(1)
+			}
+
+			if !checkScopes(client, l.RequiredScopes) {
+				os.Exit(1)
+			}
+
+			fmt.Printf("client %q is authorized\n", client.Name)
+
+			// the audience is checked after the decision of the server checks, it's not a part of it
+			if audience != "" {
+				// the signature is verified above, the claims are only read
+				claims := jwt.MapClaims{}
+				_, _, err = new(jwt.Parser).ParseUnverified(tokenString, claims)
+				if err == nil && !claims.VerifyAudience(audience, true) {
+					err = fmt.Errorf("token audience %v is not %s", claims["aud"], audience)
+				}
+
+				printCheck(web.Check{Method: cliOnlyCheck, Name: "audience", Code: web.CodeInvalidToken, Err: err})
+				if err != nil {
+					fmt.Println("the audience check is not a check of the server, the server authorizes the token")
+					os.Exit(1)
+				}
+			}
+		},
+	}
+
+	f.add(cmd)
+	f.addPublicKey(cmd)
+	cmd.Flags().StringVar(&audience, "aud", "", "token audience of the CLI-only check, e.g. http://bob.local, the server does not check it, it's not checked if it's empty")
+
+	return cmd
+}
+
+// newVerifyRequestCmd returns a new instance of cobra.Command to verify a captured request
+func newVerifyRequestCmd() *cobra.Command {
+	var (
+		f           verifyFlags
+		headersPath string
+		bodyPath    string
+		method      string
+		rawURL      string
+		certPath    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "request",
+		Short: "Verify a captured request.",
+		Long: `Verify a captured request by the certificate or the token middleware and the scope middleware of the server, the middleware is chosen as the server does:
+the token middleware if the request has a Bearer authorization header, the certificate middleware otherwise.
+The headers file is the request line and the headers as sent on the wire, or only the headers with --method and --url.
+The scopes are required as the server mounts the request path: the required scopes for the /cert, /token and /hmac routes of the demo handler,
+and the route scopes of the listener in addition for the other paths, e.g. of the authorization service and the upstream.
+The request is verified at --at, e.g. its X-Timestamp, a signed request is stale 10 minutes after its X-Timestamp, and a replayed nonce is not detected.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			r, err := readCapturedRequest(headersPath, bodyPath, method, rawURL)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			bundle, l, opts, err := f.load()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			// the clients sign the https URI on the tls and mtls listeners
+			if l.Mode != config.ModePlain && r.TLS == nil {
+				r.TLS = &tls.ConnectionState{}
+			}
+
+			var authenticate web.Middlware
+			if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+				m, err := tokenMiddleware(bundle, opts...)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				authenticate = m.Handle
+			} else {
+				if certPath != "" {
+					// the X-Client-Cert-ID thumbprint is resolved to the certificate, as it's registered on the server
+					clientCert, err := cert.ReadFromFile(certPath)
+					if err != nil {
+						fmt.Fprintln(os.Stderr, err)
+						os.Exit(1)
+					}
+
+					store := web.NewCertificateStore(1, nil)
+					store.Register(clientCert.Raw)
+					opts = append(opts, web.WithCertificateStore(store))
+				}
+
+				m, err := certificateMiddleware(bundle, opts...)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					os.Exit(1)
+				}
+				authenticate = m.Handle
+			}
+
+			// the scopes are checked by the same middleware as the server mounts for the request path
+			scopeMiddleware, err := l.ScopeMiddlewareOf(r.URL.Path, opts...)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			var client common.Client
+			handler := web.WrapMiddlewares([]web.Middlware{
+				authenticate,
+				scopeMiddleware,
+			}, func(w http.ResponseWriter, r *http.Request) {
+				client = web.ClientFromContext(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != http.StatusOK {
+				fmt.Printf("request is denied: %d %s\n", w.Code, strings.TrimSpace(w.Body.String()))
+				os.Exit(1)
+			}
+
+			fmt.Printf("request of client %q is authorized\n", client.Name)
+		},
+	}
+
+	f.add(cmd)
+	f.addCA(cmd)
+	f.addPublicKey(cmd)
+	cmd.Flags().StringVar(&headersPath, "headers", "", "file of the captured request line and headers, or the headers only")
+	cmd.Flags().StringVar(&bodyPath, "body", "", "file of the captured request body, the body after the headers in the headers file is used if it's empty")
+	cmd.Flags().StringVarP(&method, "method", "X", http.MethodGet, "request method if the headers file has not the request line")
+	cmd.Flags().StringVar(&rawURL, "url", "", "request URL if the headers file has not the request line, e.g. http://localhost:8585/cert, an https URL is of a request received over TLS")
+	cmd.Flags().StringVar(&certPath, "cert", "", "client certificate file the X-Client-Cert-ID header refers to")
+	cmd.Flags().StringVar(&f.scopePolicy, "scope-policy", "", "scope policy file of the routes in JSON format, the scopes of the routes are not checked if it's empty")
+	cmd.MarkFlagRequired("headers")
+
+	return cmd
+}
+
+// readCapturedRequest reads the request of the headers file and the body file as the server reads it from the wire
+// the request line is made of the method and the URL if the headers file starts with a header
+// the request is received over TLS if the URL is https, the clients sign the https URI
+func readCapturedRequest(headersPath, bodyPath, method, rawURL string) (*http.Request, error) {
+	head, err := os.ReadFile(headersPath)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var host string
+	firstLine, _, _ := strings.Cut(string(head), "\n")
+	if fields := strings.Fields(firstLine); len(fields) != 3 || !strings.HasPrefix(fields[2], "HTTP/") {
+		if rawURL == "" {
+			return nil, errors.New("--url is required, the headers file has not the request line")
+		}
+		host = u.Host
+
+		head = append([]byte(fmt.Sprintf("%s %s HTTP/1.1\r\n", method, u.RequestURI())), head...)
+	}
+
+	// the headers end with an empty line, the captured body follows it
+	if bodyPath != "" || !bytes.Contains(head, []byte("\n\r\n")) && !bytes.Contains(head, []byte("\n\n")) {
+		head = append(bytes.TrimRight(head, "\r\n"), "\r\n\r\n"...)
+	}
+
+	r, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid captured request: %w", err)
+	}
+
+	if r.Host == "" {
+		r.Host = host
+	}
+
+	if bodyPath != "" {
+		body, err := os.ReadFile(bodyPath)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+	}
+
+	r.RemoteAddr = "127.0.0.1:0"
+	if u.Scheme == "https" {
+		r.TLS = &tls.ConnectionState{}
+	}
+
+	return r, nil
+}
+
+// checkScopes checks the client has the required scopes as the scope middleware does and prints the check
+func checkScopes(client common.Client, required []string) bool {
+	var err error
+	if !client.Scopes.HasAll(required) {
+		err = fmt.Errorf("client %q has not the required scopes: %s", client.Name, strings.Join(required, " "))
+	}
+
+	printCheck(web.Check{Method: "scope", Name: web.CheckScopes, Code: web.CodeInsufficientScope, Err: err})
+	return err == nil
+}
+
+// cliOnlyCheck is the method of the checks of the CLI which the server does not perform, they're not a part of its decision
+const cliOnlyCheck = "cli-only"
+
+// printCheck prints the result of the check, the error code and the error of a failed check
+func printCheck(c web.Check) {
+	if c.Passed() {
+		fmt.Printf("PASS  %s: %s\n", c.Method, c.Name)
+		return
+	}
+
+	code := c.Code
+	if code == "" {
+		code = web.CodeInternalError
+	}
+	fmt.Printf("FAIL  %s: %s: %s: %s\n", c.Method, c.Name, code, c.Err)
+}
@@ -1,36 +1,64 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/theredrad/certauthz/client/util"
+	"github.com/theredrad/certauthz/core/cert"
 	"github.com/theredrad/certauthz/core/hmac"
 	"github.com/theredrad/certauthz/core/key"
+	"github.com/theredrad/certauthz/core/signer"
 	coreTLS "github.com/theredrad/certauthz/core/tls"
 )
 
+const (
+	// clientCertIDHeader is the thumbprint of the registered client certificate
+	clientCertIDHeader = "X-Client-Cert-ID"
+
+	// codeUnknownCertificateID is the error code of the server if the thumbprint is not registered
+	codeUnknownCertificateID = "unknown_certificate_id"
+)
+
 var (
 	primaryName = "primary"
 	clientName  = "alice"
 	serverAddr  = "http://localhost:8585"
 	method      = "cert"
 	path        = "../credentials"
+
+	signerURI = ""
+
+	certByReference = false
+
+	passphraseFile   = ""
+	passphraseEnv    = ""
+	passphrasePrompt = false
 )
 
 func init() {
 	flag.StringVar(&primaryName, "primary-name", "primary", "primary name including ca certificate and public key")
 	flag.StringVar(&clientName, "client-name", "alice", "client name")
 	flag.StringVar(&serverAddr, "server-addr", "http://localhost:8585", "server address")
-	flag.StringVar(&method, "auth-method", "cert", "authorization method. e.g. cert, token")
+	flag.StringVar(&method, "auth-method", "cert", "authorization method. e.g. cert, token, session, mtls")
 	flag.StringVar(&path, "path", "../credentials", "credentials path")
+	flag.StringVar(&signerURI, "signer", "", "signer URI of the client key for the cert auth method, e.g. agent:///path/to/agent.sock?key=alice, [path]/[client-name]/private.key if it's empty")
+	flag.BoolVar(&certByReference, "cert-by-reference", false, "send the registered certificate thumbprint instead of the certificate for the cert auth method, it's stored in [path]/[client-name]/certificate.id")
+	flag.StringVar(&passphraseFile, "passphrase-file", "", "file including the private keys passphrase")
+	flag.StringVar(&passphraseEnv, "passphrase-env", "", "environment variable including the private keys passphrase")
+	flag.BoolVar(&passphrasePrompt, "passphrase-prompt", false, "prompt for the private keys passphrase")
 	flag.Parse()
+
+	key.SetPassphraseFunc(key.NewPassphraseFunc(passphraseFile, passphraseEnv, passphrasePrompt))
 }
 
 func main() {
@@ -44,22 +72,15 @@ func main() {
 	switch method {
 	case "token":
 		r, err = newTokenRequest()
+	case "session":
+		// the session key is returned over TLS only, the server certificate is validated by the CA certificate
+		client.Transport = newTLSTransport()
+		r, err = newSessionRequest(client)
 	case "mtls":
-		tlsConfig, err := coreTLS.NewClientConfig(
-			fmt.Sprintf("%s/%s/ca_certificate.crt", path, primaryName),
-			fmt.Sprintf("%s/%s/certificate.crt", path, clientName),
-			fmt.Sprintf("%s/%s/private.key", path, clientName),
-		)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		client.Transport = &http.Transport{
-			TLSClientConfig: tlsConfig,
-		}
+		client.Transport = newTLSTransport()
 		r, err = newTLSRequest()
 	default:
-		r, err = newCertRequest()
+		r, err = newCertRequest(readCertificateID())
 	}
 	if err != nil {
 		fmt.Println("Error initializing request:", err)
@@ -72,8 +93,32 @@ func main() {
 		fmt.Println("Error making request:", err)
 		return
 	}
+
+	// the server doesn't know the thumbprint, e.g. it's restarted, the request is sent again with the certificate
+	if r.Header.Get(clientCertIDHeader) != "" && isProblem(resp, codeUnknownCertificateID) {
+		resp.Body.Close()
+
+		r, err = newCertRequest("")
+		if err != nil {
+			fmt.Println("Error initializing request:", err)
+			return
+		}
+
+		resp, err = client.Do(r)
+		if err != nil {
+			fmt.Println("Error making request:", err)
+			return
+		}
+	}
 	defer resp.Body.Close()
 
+	if id := resp.Header.Get(clientCertIDHeader); id != "" && certByReference {
+		err = ioutil.WriteFile(certificateIDPath(), []byte(id), 0600)
+		if err != nil {
+			fmt.Println("Error writing certificate id:", err)
+		}
+	}
+
 	// Read the response
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -103,33 +148,57 @@ func newTokenRequest() (*http.Request, error) {
 	return r, nil
 }
 
-// newCertRequest initializes and returns http ruquest for certificate auth method
-func newCertRequest() (*http.Request, error) {
-	r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/cert", serverAddr), nil)
+// newTLSTransport returns the transport presenting the client certificate and validating the server certificate by the CA certificate
+func newTLSTransport() *http.Transport {
+	tlsConfig, err := coreTLS.NewClientConfig(
+		fmt.Sprintf("%s/%s/ca_certificate.crt", path, primaryName),
+		fmt.Sprintf("%s/%s/certificate.crt", path, clientName),
+		fmt.Sprintf("%s/%s/private.key", path, clientName),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("error while initializing new reuqest: %w", err)
+		log.Fatal(err)
 	}
 
-	// read the client private key (requester)
-	clientPrivateKeyBytes, err := ioutil.ReadFile(fmt.Sprintf("%s/%s/private.key", path, clientName))
-	if err != nil {
-		return nil, fmt.Errorf("error while reading client private key: %w", err)
+	return &http.Transport{
+		TLSClientConfig: tlsConfig,
 	}
+}
+
+// newCertRequest initializes and returns http ruquest for certificate auth method
+// the certificate thumbprint is sent instead of the certificate if the id is not empty
+func newCertRequest(certID string) (*http.Request, error) {
+	return newSignedCertRequest(http.MethodGet, fmt.Sprintf("%s/cert", serverAddr), certID)
+}
 
-	// decode the client private key
-	clientPrivateKey, err := key.DecodePrivateKeyFromDER(clientPrivateKeyBytes)
+// newSignedCertRequest initializes and returns http request signed by the client private key
+func newSignedCertRequest(method, url, certID string) (*http.Request, error) {
+	r, err := http.NewRequest(method, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error while decoding client private key: %w", err)
+		return nil, fmt.Errorf("error while initializing new reuqest: %w", err)
+	}
+
+	if signerURI == "" {
+		signerURI = fmt.Sprintf("%s/%s/private.key", path, clientName)
 	}
 
-	// reads the client certificate
-	clientCert, err := ioutil.ReadFile(fmt.Sprintf("%s/%s/certificate.crt", path, clientName))
+	// open the client signer (requester), the private key file is decrypted by the passphrase if it's encrypted
+	clientSigner, err := signer.Open(signerURI)
 	if err != nil {
-		return nil, fmt.Errorf("error while reading client certificate: %w", err)
+		return nil, fmt.Errorf("error while opening client signer: %w", err)
 	}
 
-	// encode the certificate with base64 and set it in the request's header
-	r.Header.Add("X-Client-Cert", base64.StdEncoding.EncodeToString(clientCert))
+	if certID != "" {
+		r.Header.Add(clientCertIDHeader, certID)
+	} else {
+		// reads the client certificate in PEM or DER format, it's sent in DER format
+		clientCert, err := cert.ReadFromFile(fmt.Sprintf("%s/%s/certificate.crt", path, clientName))
+		if err != nil {
+			return nil, fmt.Errorf("error while reading client certificate: %w", err)
+		}
+
+		// encode the certificate with base64 and set it in the request's header
+		r.Header.Add("X-Client-Cert", base64.StdEncoding.EncodeToString(clientCert.Raw))
+	}
 
 	nonce, err := util.GenerateRandomNonce()
 	if err != nil {
@@ -151,7 +220,7 @@ func newCertRequest() (*http.Request, error) {
 	}
 
 	// sign the request with client private key, thus the server can validate the request signature
-	signature, err := hmac.Sign(clientPrivateKey, hmac.Params{
+	signature, err := hmac.Sign(clientSigner, hmac.Params{
 		Method:    r.Method,
 		BodyMD5:   bodyHash,
 		URI:       r.URL.String(),
@@ -166,6 +235,104 @@ func newCertRequest() (*http.Request, error) {
 	return r, nil
 }
 
+// newSessionRequest creates a session by a certificate signed request, and returns http request for session auth method
+// the request is signed by the session key with HMAC-SHA256
+func newSessionRequest(client *http.Client) (*http.Request, error) {
+	sessionReq, err := newSignedCertRequest(http.MethodPost, fmt.Sprintf("%s/session", serverAddr), readCertificateID())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(sessionReq)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error while creating session: %s", body)
+	}
+
+	var session struct {
+		SessionID string `json:"session_id"`
+		Key       string `json:"key"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&session)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding session: %w", err)
+	}
+
+	sessionKey, err := base64.StdEncoding.DecodeString(session.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding session key: %w", err)
+	}
+
+	r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/hmac", serverAddr), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while initializing new reuqest: %w", err)
+	}
+
+	nonce, err := util.GenerateRandomNonce()
+	if err != nil {
+		return nil, fmt.Errorf("error while generating nonce: %w", err)
+	}
+
+	nonceStr := strconv.FormatUint(nonce, 10)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	r.Header.Add("X-Session-ID", session.SessionID)
+	r.Header.Add("X-Nonce", nonceStr)
+	r.Header.Add("X-Timestamp", timestamp)
+
+	// sign the request with the session key, the server validates it without the public key operation
+	r.Header.Add("X-Signature", hmac.SignMAC(sessionKey, hmac.Params{
+		Method:    r.Method,
+		URI:       r.URL.String(),
+		Nonce:     nonceStr,
+		Timestamp: timestamp,
+	}))
+
+	return r, nil
+}
+
+// readCertificateID returns the thumbprint of the registered client certificate, it's empty if the certificate is not sent by reference or it's not registered yet
+func readCertificateID() string {
+	if !certByReference {
+		return ""
+	}
+
+	b, err := ioutil.ReadFile(certificateIDPath())
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// certificateIDPath returns the file path of the registered client certificate thumbprint
+func certificateIDPath() string {
+	return fmt.Sprintf("%s/%s/certificate.id", path, clientName)
+}
+
+// isProblem reports whether the response is a problem of the error code, the body is kept for reading
+func isProblem(resp *http.Response, code string) bool {
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("Content-Type") != "application/problem+json" {
+		return false
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var p struct {
+		Code string `json:"code"`
+	}
+	return json.Unmarshal(body, &p) == nil && p.Code == code
+}
+
 func newTLSRequest() (*http.Request, error) {
 	r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/", serverAddr), nil)
 	if err != nil {
@@ -0,0 +1,114 @@
+package rpc
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/theredrad/certauthz/client/util"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/hmac"
+	coreTLS "github.com/theredrad/certauthz/core/tls"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	ErrMissingRequestInfo = errors.New("request info of the call is missing")
+)
+
+// TokenCredentials implements credentials.PerRPCCredentials to send the client JWT in the authorization metadata
+type TokenCredentials struct {
+	Token string
+
+	// Insecure allows the token to be sent on an insecure connection, e.g. to a local sidecar
+	Insecure bool
+}
+
+// GetRequestMetadata returns the authorization metadata of the token
+func (c TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + c.Token,
+	}, nil
+}
+
+// RequireTransportSecurity reports whether the token is sent on the secure connections only, the token is a bearer credential
+func (c TokenCredentials) RequireTransportSecurity() bool {
+	return !c.Insecure
+}
+
+// CertificateCredentials implements credentials.PerRPCCredentials to send the client certificate and sign every call by the client private key
+// the signature is of the POST method and the full method name as the URI, like the signed HTTP requests without a body
+type CertificateCredentials struct {
+	// Certificate is the client certificate in DER format
+	Certificate []byte
+	Signer      crypto.Signer
+}
+
+// NewCertificateCredentials reads the client certificate from the PEM or DER file and returns a new instance of CertificateCredentials
+func NewCertificateCredentials(certPath string, signer crypto.Signer) (*CertificateCredentials, error) {
+	clientCert, err := cert.ReadFromFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateCredentials{
+		Certificate: clientCert.Raw,
+		Signer:      signer,
+	}, nil
+}
+
+// GetRequestMetadata returns the client certificate, the nonce, the timestamp and the signature metadata of the call
+func (c *CertificateCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	ri, ok := credentials.RequestInfoFromContext(ctx)
+	if !ok {
+		return nil, ErrMissingRequestInfo
+	}
+
+	nonce, err := util.GenerateRandomNonce()
+	if err != nil {
+		return nil, fmt.Errorf("error while generating nonce: %w", err)
+	}
+	nonceStr := strconv.FormatUint(nonce, 10)
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signature, err := hmac.Sign(c.Signer, hmac.Params{
+		Method:    http.MethodPost,
+		URI:       ri.Method,
+		Nonce:     nonceStr,
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while signing the call: %w", err)
+	}
+
+	return map[string]string{
+		"x-client-cert": base64.StdEncoding.EncodeToString(c.Certificate),
+		"x-nonce":       nonceStr,
+		"x-timestamp":   timestamp,
+		"x-signature":   signature,
+	}, nil
+}
+
+// RequireTransportSecurity reports true, the signature covers the method name only, not the messages and the authority of the call,
+// so on an insecure connection the messages could be replaced under the signed metadata or the call replayed to another server
+func (c *CertificateCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// NewTLSCredentials returns the mTLS transport credentials of the client certificate and private key, the server is verified by the CA certificate
+// the server name is verified by the server certificate if it's not empty
+func NewTLSCredentials(caPath, clientCertPath, clientPrivateKeyPath, serverName string) (credentials.TransportCredentials, error) {
+	config, err := coreTLS.NewClientConfig(caPath, clientCertPath, clientPrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	config.ServerName = serverName
+
+	return credentials.NewTLS(config), nil
+}
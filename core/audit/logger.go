@@ -0,0 +1,362 @@
+package audit
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeAllow   = "allow"
+	OutcomeDeny    = "deny"
+
+	// OperationAuthorize is the operation of an allow/deny decision of the authentication middlewares
+	OperationAuthorize = "authorize"
+
+	// OperationCheckpoint is the operation of a record signing the hash of the previous record, the chain head
+	OperationCheckpoint = "checkpoint"
+)
+
+var (
+	// genesisHash is the previous hash of the first record of a log
+	genesisHash = hex.EncodeToString(make([]byte, sha256.Size))
+
+	ErrUnchainedLog = errors.New("audit log is not hash-chained, move it aside to start a new log")
+)
+
+// Record is an audit trail entry of an operation
+// the records are chained by the SHA-256 hash of the previous record, the checkpoint records sign the chain head
+type Record struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Identity  string    `json:"identity,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	Serial    string    `json:"serial,omitempty"`
+	Scopes    string    `json:"scopes,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	Signature string    `json:"signature,omitempty"`
+	PrevHash  string    `json:"prev_hash"`
+	Hash      string    `json:"hash,omitempty"`
+}
+
+// Option configures the logger
+type Option func(*Logger)
+
+// WithSigner signs a checkpoint after every n records and on close by the signer, e.g. the CA private key
+func WithSigner(signer crypto.Signer, every int) Option {
+	return func(l *Logger) {
+		l.signer = signer
+		l.every = every
+	}
+}
+
+// Logger appends the audit records to a file as hash-chained JSON lines
+// the file is locked while a record is appended, so several processes (e.g. the CA server and the CLI) can share the log
+type Logger struct {
+	mu sync.Mutex
+	f  *os.File
+
+	signer  crypto.Signer
+	every   int
+	pending int // records since the last checkpoint
+
+	// the chain head, refreshed from the file if it's appended by another process
+	size     int64
+	seq      uint64
+	head     string
+	lastSign bool // the head is a checkpoint
+}
+
+// Open opens the audit log file in append mode and returns a new instance of Logger, the chain is continued from the last record
+func Open(path string, opts ...Option) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Logger{f: f, head: genesisHash}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	err = l.withLock(l.refresh)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Log appends the record to the log, the time is set if it's zero
+// a checkpoint is appended if the logger has a signer and the checkpoint interval is reached
+func (l *Logger) Log(r Record) error {
+	if l == nil {
+		return nil
+	}
+
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.withLock(func() error {
+		err := l.append(r)
+		if err != nil {
+			return err
+		}
+
+		l.pending++
+		if l.signer == nil || l.every <= 0 || l.pending < l.every {
+			return nil
+		}
+
+		return l.checkpoint()
+	})
+}
+
+// Checkpoint appends a checkpoint signing the chain head, it's a no-op if the logger has no signer or the head is already signed
+func (l *Logger) Checkpoint() error {
+	if l == nil || l.signer == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.withLock(l.checkpoint)
+}
+
+// Close signs the chain head if the logger has a signer and closes the log file
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	err := l.Checkpoint()
+	if err != nil {
+		l.f.Close()
+		return err
+	}
+
+	return l.f.Close()
+}
+
+// checkpoint appends a checkpoint signing the chain head, the file must be locked
+func (l *Logger) checkpoint() error {
+	if l.signer == nil || l.lastSign {
+		return nil
+	}
+
+	signature, err := signHead(l.signer, l.head)
+	if err != nil {
+		return fmt.Errorf("failed to sign the audit log checkpoint: %w", err)
+	}
+
+	err = l.append(Record{
+		Time:      time.Now().UTC(),
+		Operation: OperationCheckpoint,
+		Outcome:   OutcomeSuccess,
+		Signature: signature,
+	})
+	if err != nil {
+		return err
+	}
+
+	l.pending = 0
+	l.lastSign = true
+	return l.f.Sync()
+}
+
+// append chains the record to the head and writes it, the file must be locked
+func (l *Logger) append(r Record) error {
+	r.Seq = l.seq
+	r.PrevHash = l.head
+
+	hash, err := hashRecord(r)
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	n, err := l.f.Write(append(b, '\n'))
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	l.seq++
+	l.head = hash
+	l.lastSign = r.Operation == OperationCheckpoint
+	return nil
+}
+
+// refresh reads the chain head from the last record if the file is changed by another process, the file must be locked
+func (l *Logger) refresh() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == l.size {
+		return nil
+	}
+
+	size, err := l.truncatePartial(info.Size())
+	if err != nil {
+		return err
+	}
+
+	line, err := lastLine(l.f, size)
+	if err != nil {
+		return err
+	}
+	l.size = size
+
+	if len(line) == 0 {
+		l.seq, l.head, l.lastSign = 0, genesisHash, false
+		return nil
+	}
+
+	var last Record
+	err = json.Unmarshal(line, &last)
+	if err != nil {
+		return fmt.Errorf("invalid last audit record: %w", err)
+	}
+
+	if last.Hash == "" {
+		return fmt.Errorf("%w: %s", ErrUnchainedLog, l.f.Name())
+	}
+
+	l.seq, l.head, l.lastSign = last.Seq+1, last.Hash, last.Operation == OperationCheckpoint
+	return nil
+}
+
+// truncatePartial removes a partial last record, e.g. of a crash while it's written, and returns the new size of the file
+// the records are written by a single write ending with a new line, so the partial record is never chained, the file must be locked
+func (l *Logger) truncatePartial(size int64) (int64, error) {
+	if size == 0 {
+		return 0, nil
+	}
+
+	last := make([]byte, 1)
+	_, err := l.f.ReadAt(last, size-1)
+	if err != nil {
+		return 0, err
+	}
+
+	if last[0] == '\n' {
+		return size, nil
+	}
+
+	partial, err := lastLine(l.f, size)
+	if err != nil {
+		return 0, err
+	}
+
+	size -= int64(len(partial))
+	err = l.f.Truncate(size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove the partial audit record: %w", err)
+	}
+
+	log.Printf("removed a partial audit record of %d bytes at the end of %s", len(partial), l.f.Name())
+	return size, nil
+}
+
+// withLock runs the function while the file is exclusively locked and the chain head is refreshed
+func (l *Logger) withLock(fn func() error) error {
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("failed to lock the audit log: %w", err)
+	}
+	defer syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+
+	err = l.refresh()
+	if err != nil {
+		return err
+	}
+
+	return fn()
+}
+
+// hashRecord returns the hex-encoded SHA-256 hash of the record JSON without the hash, the previous hash is included
+func hashRecord(r Record) (string, error) {
+	r.Hash = ""
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// signHead signs the chain head hash by the signer and returns the base64-encoded signature
+func signHead(signer crypto.Signer, head string) (string, error) {
+	digest, err := hex.DecodeString(head)
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// lastLine returns the last non-empty line of the file of the size, it's read backward by chunks
+func lastLine(f *os.File, size int64) ([]byte, error) {
+	const chunkSize = 4096
+
+	var tail []byte
+	for offset := size; offset > 0; {
+		n := int64(chunkSize)
+		if n > offset {
+			n = offset
+		}
+		offset -= n
+
+		chunk := make([]byte, n)
+		_, err := f.ReadAt(chunk, offset)
+		if err != nil {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+
+		trimmed := bytes.TrimRight(tail, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+		if offset == 0 {
+			return trimmed, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/theredrad/certauthz/core/key"
+)
+
+func TestLoggerVerify(t *testing.T) {
+	privateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected private key, got err: %s", err)
+	}
+
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := Open(logPath, WithSigner(privateKey, 2))
+	if err != nil {
+		t.Fatalf("expected logger, got err: %s", err)
+	}
+
+	for _, subject := range []string{"alice", "bob", "carol"} {
+		err = logger.Log(Record{Operation: "issue", Subject: subject, Outcome: OutcomeSuccess})
+		if err != nil {
+			t.Fatalf("expected record, got err: %s", err)
+		}
+	}
+
+	err = logger.Close()
+	if err != nil {
+		t.Fatalf("expected close, got err: %s", err)
+	}
+
+	// the chain is continued by a logger without a signer, e.g. a ceremony
+	logger, err = Open(logPath)
+	if err != nil {
+		t.Fatalf("expected logger, got err: %s", err)
+	}
+
+	err = logger.Log(Record{Operation: "revoke", Serial: "2", Outcome: OutcomeSuccess})
+	if err != nil {
+		t.Fatalf("expected record, got err: %s", err)
+	}
+	logger.Close()
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("expected log content, got err: %s", err)
+	}
+
+	report, err := Verify(bytes.NewReader(content), &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("expected valid log, got err: %s", err)
+	}
+
+	// alice, bob, checkpoint, carol, checkpoint, revoke
+	if report.Records != 6 || report.Checkpoints != 2 || report.LastCheckpoint != 4 || report.Unsigned != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+
+	tests := []struct {
+		name    string
+		content []byte
+		err     error
+	}{
+		{
+			name:    "modified record",
+			content: bytes.Replace(content, []byte(`"subject":"bob"`), []byte(`"subject":"eve"`), 1),
+			err:     ErrTampered,
+		},
+		{
+			name:    "removed record",
+			content: bytes.Join([][]byte{lines[0], lines[2], lines[3]}, nil),
+			err:     ErrTampered,
+		},
+		{
+			name:    "removed head",
+			content: bytes.Join(lines[1:], nil),
+			err:     ErrTruncated,
+		},
+		{
+			name:    "wrong signer",
+			content: content,
+			err:     ErrTampered,
+		},
+	}
+
+	otherKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected private key, got err: %s", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &privateKey.PublicKey
+			if tt.name == "wrong signer" {
+				pub = &otherKey.PublicKey
+			}
+
+			_, err := Verify(bytes.NewReader(tt.content), pub)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestVerifySince(t *testing.T) {
+	privateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected private key, got err: %s", err)
+	}
+
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := Open(logPath, WithSigner(privateKey, 1))
+	if err != nil {
+		t.Fatalf("expected logger, got err: %s", err)
+	}
+
+	for _, subject := range []string{"alice", "bob"} {
+		err = logger.Log(Record{Operation: "issue", Subject: subject, Outcome: OutcomeSuccess})
+		if err != nil {
+			t.Fatalf("expected record, got err: %s", err)
+		}
+	}
+	logger.Close()
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("expected log content, got err: %s", err)
+	}
+
+	report, err := Verify(bytes.NewReader(content), &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("expected valid log, got err: %s", err)
+	}
+
+	// alice, checkpoint, bob, checkpoint
+	head, ok := report.LastHead()
+	if !ok || head.Seq != 3 {
+		t.Fatalf("expected the head of record 3, got %+v", head)
+	}
+
+	_, err = VerifySince(bytes.NewReader(content), &privateKey.PublicKey, head)
+	if err != nil {
+		t.Fatalf("expected the log to include the head, got err: %s", err)
+	}
+
+	// the log truncated to the first checkpoint is valid by itself, but not since the head
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	truncated := bytes.Join(lines[:2], nil)
+
+	_, err = Verify(bytes.NewReader(truncated), &privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("expected valid truncated log, got err: %s", err)
+	}
+
+	_, err = VerifySince(bytes.NewReader(truncated), &privateKey.PublicKey, head)
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+
+	_, err = VerifySince(bytes.NewReader(content), &privateKey.PublicKey, Head{Seq: head.Seq, Hash: genesisHash})
+	if !errors.Is(err, ErrTampered) {
+		t.Fatalf("expected ErrTampered for another head, got %v", err)
+	}
+}
+
+func TestOpenPartialRecord(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := Open(logPath)
+	if err != nil {
+		t.Fatalf("expected logger, got err: %s", err)
+	}
+
+	err = logger.Log(Record{Operation: "issue", Subject: "alice", Outcome: OutcomeSuccess})
+	if err != nil {
+		t.Fatalf("expected record, got err: %s", err)
+	}
+	logger.Close()
+
+	complete, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("expected log content, got err: %s", err)
+	}
+
+	// a crash while the second record is written
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"seq":1,"prev_hash":`))
+	f.Close()
+
+	logger, err = Open(logPath)
+	if err != nil {
+		t.Fatalf("expected the partial record to be removed, got err: %s", err)
+	}
+
+	err = logger.Log(Record{Operation: "issue", Subject: "bob", Outcome: OutcomeSuccess})
+	if err != nil {
+		t.Fatalf("expected record, got err: %s", err)
+	}
+	logger.Close()
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("expected log content, got err: %s", err)
+	}
+
+	if !bytes.HasPrefix(content, complete) {
+		t.Fatal("expected the complete records to be kept")
+	}
+
+	report, err := Verify(bytes.NewReader(content), nil)
+	if err != nil {
+		t.Fatalf("expected valid log, got err: %s", err)
+	}
+
+	if report.Records != 2 {
+		t.Fatalf("expected 2 records, got %d", report.Records)
+	}
+}
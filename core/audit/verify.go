@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/signer"
+)
+
+var (
+	ErrTampered  = errors.New("audit log is tampered")
+	ErrTruncated = errors.New("audit log is truncated")
+)
+
+// Head is a verified chain head, it's kept to detect a truncation of the log to an earlier record, even a signed one
+type Head struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// ReadHeadFromJSONFile reads the last verified head from the JSON file
+func ReadHeadFromJSONFile(filePath string) (*Head, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var h Head
+	err = json.Unmarshal(b, &h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audit head: %w", err)
+	}
+
+	return &h, nil
+}
+
+// WriteJSONFile writes the head to the JSON file atomically
+func (h Head) WriteJSONFile(filePath string) error {
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(filePath, b, 0600)
+}
+
+// Report is the result of an audit log verification
+type Report struct {
+	Records     int
+	Checkpoints int
+	Head        string // hash of the last record
+
+	// LastCheckpoint is the sequence number of the last checkpoint, records after it are not signed
+	// the log may be truncated after the last checkpoint without being detected
+	LastCheckpoint int64
+	Unsigned       int
+
+	// since is the previously verified head, the record of its sequence number must have its hash
+	since *Head
+}
+
+// LastHead returns the head of the last record, it's false if the log is empty
+func (rep *Report) LastHead() (Head, bool) {
+	if rep.Records == 0 {
+		return Head{}, false
+	}
+	return Head{Seq: uint64(rep.Records - 1), Hash: rep.Head}, true
+}
+
+// Verify reads the audit log and verifies the hash chain and the checkpoint signatures by the public key
+// a modified, removed or reordered record is reported by ErrTampered, a removed head of the log by ErrTruncated
+func Verify(r io.Reader, pub crypto.PublicKey) (*Report, error) {
+	return verify(r, pub, nil)
+}
+
+// VerifySince verifies the audit log like Verify and checks it still includes the previously verified head
+// a log truncated to a record before the head, even to a checkpoint, is reported by ErrTruncated
+func VerifySince(r io.Reader, pub crypto.PublicKey, since Head) (*Report, error) {
+	return verify(r, pub, &since)
+}
+
+func verify(r io.Reader, pub crypto.PublicKey, since *Head) (*Report, error) {
+	report := &Report{Head: genesisHash, LastCheckpoint: -1, since: since}
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return report, err
+		}
+
+		line = bytes.TrimRight(line, "\n")
+		if len(line) > 0 {
+			verr := report.verifyRecord(line, pub)
+			if verr != nil {
+				return report, verr
+			}
+		}
+
+		if err == io.EOF {
+			if since != nil && uint64(report.Records) <= since.Seq {
+				return report, fmt.Errorf("%w: the log has %d records, the last verified head is record %d", ErrTruncated, report.Records, since.Seq)
+			}
+			return report, nil
+		}
+	}
+}
+
+// verifyRecord verifies the record is chained to the head and the checkpoint signature, the report is updated
+func (rep *Report) verifyRecord(line []byte, pub crypto.PublicKey) error {
+	var r Record
+	err := json.Unmarshal(line, &r)
+	if err != nil {
+		return fmt.Errorf("%w: record %d is not valid: %s", ErrTampered, rep.Records, err)
+	}
+
+	seq := uint64(rep.Records)
+	if r.Seq != seq {
+		if rep.Records == 0 {
+			return fmt.Errorf("%w: the first record sequence number is %d", ErrTruncated, r.Seq)
+		}
+		return fmt.Errorf("%w: expected record %d, got %d", ErrTampered, seq, r.Seq)
+	}
+
+	if r.PrevHash != rep.Head {
+		return fmt.Errorf("%w: record %d is not chained to the previous record", ErrTampered, seq)
+	}
+
+	hash, err := hashRecord(r)
+	if err != nil {
+		return err
+	}
+
+	// the record is re-encoded to detect any change not covered by the fields, e.g. an unknown field
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if r.Hash != hash || !bytes.Equal(encoded, line) {
+		return fmt.Errorf("%w: record %d hash mismatch", ErrTampered, seq)
+	}
+
+	if r.Operation == OperationCheckpoint {
+		err = verifyHead(pub, r.PrevHash, r.Signature)
+		if err != nil {
+			return fmt.Errorf("%w: checkpoint %d signature is not valid: %s", ErrTampered, seq, err)
+		}
+
+		rep.Checkpoints++
+		rep.LastCheckpoint = int64(seq)
+		rep.Unsigned = 0
+	} else {
+		rep.Unsigned++
+	}
+
+	if rep.since != nil && seq == rep.since.Seq && r.Hash != rep.since.Hash {
+		return fmt.Errorf("%w: record %d is not the last verified head", ErrTampered, seq)
+	}
+
+	rep.Records++
+	rep.Head = r.Hash
+	return nil
+}
+
+// verifyHead verifies the base64-encoded signature of the chain head hash by the public key
+func verifyHead(pub crypto.PublicKey, head, signature string) error {
+	digest, err := hex.DecodeString(head)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+
+	return signer.Verify(pub, digest, sig)
+}
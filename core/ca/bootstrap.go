@@ -0,0 +1,106 @@
+package ca
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// BootstrapCredential authenticates the first enrollment of a client which has no certificate yet
+// it's either an HTTP basic username and password or a one-time token, only their SHA-256 hashes are stored
+type BootstrapCredential struct {
+	Username       string `json:"username,omitempty"`
+	PasswordSHA256 string `json:"password_sha256,omitempty"`
+	TokenSHA256    string `json:"token_sha256,omitempty"`
+
+	// Identity is the requester identity checked by the issuance policy, the username is used if it's empty
+	Identity string `json:"identity,omitempty"`
+
+	// Scopes of the enrolled certificate
+	Scopes []string `json:"scopes"`
+
+	// ExpiresAt is the credential expiration time, the credential never expires if it's zero
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Bootstrap is the list of the bootstrap credentials
+type Bootstrap struct {
+	Credentials []BootstrapCredential `json:"credentials"`
+}
+
+// ReadBootstrapFromJSONFile reads the bootstrap credentials from the JSON file
+func ReadBootstrapFromJSONFile(filePath string) (*Bootstrap, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var bs Bootstrap
+	err = json.Unmarshal(b, &bs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bootstrap credentials: %w", err)
+	}
+
+	for i, c := range bs.Credentials {
+		if (c.Username == "") == (c.TokenSHA256 == "") {
+			return nil, fmt.Errorf("bootstrap credential %d: either username or token_sha256 is required", i)
+		}
+
+		if c.Username == "" && c.Identity == "" {
+			return nil, fmt.Errorf("bootstrap credential %d: identity is required for a token", i)
+		}
+	}
+
+	return &bs, nil
+}
+
+// HashSecret returns the hex-encoded SHA-256 hash of the password or token, as stored in the bootstrap file
+func HashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// AuthenticateBasic returns the credential matching the username and password
+func (b *Bootstrap) AuthenticateBasic(username, password string) (*BootstrapCredential, bool) {
+	hash := HashSecret(password)
+	for i := range b.Credentials {
+		c := &b.Credentials[i]
+		if c.Username != "" && c.Username == username && secretEqual(c.PasswordSHA256, hash) && !c.expired() {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// AuthenticateToken returns the credential matching the one-time token
+// the token must be marked as used in the store by the caller, so it can not be used again
+func (b *Bootstrap) AuthenticateToken(token string) (*BootstrapCredential, bool) {
+	hash := HashSecret(token)
+	for i := range b.Credentials {
+		c := &b.Credentials[i]
+		if c.TokenSHA256 != "" && secretEqual(c.TokenSHA256, hash) && !c.expired() {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// RequesterIdentity returns the identity checked by the issuance policy
+func (c *BootstrapCredential) RequesterIdentity() string {
+	if c.Identity != "" {
+		return c.Identity
+	}
+	return c.Username
+}
+
+func (c *BootstrapCredential) expired() bool {
+	return !c.ExpiresAt.IsZero() && time.Now().After(c.ExpiresAt)
+}
+
+func secretEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,255 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+const (
+	OperationIssue  = "issue"
+	OperationRenew  = "renew"
+	OperationRevoke = "revoke"
+)
+
+var (
+	// reasonCodeOID is the CRL entry extension of the revocation reason
+	reasonCodeOID = asn1.ObjectIdentifier{2, 5, 29, 21}
+)
+
+// IssueRequest is a request of an authenticated identity to issue a certificate for the CSR
+type IssueRequest struct {
+	Requester  string
+	CSR        *x509.CertificateRequest
+	Scopes     []string
+	Expiration time.Duration
+}
+
+// CA issues, renews and revokes certificates based on the issuance policy
+// every operation is recorded in the audit log
+type CA struct {
+	cert        *x509.Certificate
+	privateKey  crypto.Signer
+	policy      *Policy
+	store       *Store
+	audit       *audit.Logger
+	issuanceLog cert.IssuanceLog
+}
+
+// New returns a new instance of CA, the audit logger and the issuance log are optional
+// every issued certificate is appended to the issuance log before it's returned
+func New(caCert *x509.Certificate, caPrivateKey crypto.Signer, policy *Policy, store *Store, auditLogger *audit.Logger, issuanceLog cert.IssuanceLog) *CA {
+	return &CA{
+		cert:        caCert,
+		privateKey:  caPrivateKey,
+		policy:      policy,
+		store:       store,
+		audit:       auditLogger,
+		issuanceLog: issuanceLog,
+	}
+}
+
+// Certificate returns the CA certificate
+func (c *CA) Certificate() *x509.Certificate {
+	return c.cert
+}
+
+// Store returns the issuance database
+func (c *CA) Store() *Store {
+	return c.store
+}
+
+// Issue issues a new certificate for the CSR if the policy allows the requester
+func (c *CA) Issue(req IssueRequest) (issued *x509.Certificate, err error) {
+	subject := req.CSR.Subject.CommonName
+	scopes := strings.Join(req.Scopes, " ")
+	defer func() {
+		c.log(OperationIssue, req.Requester, subject, scopes, serialString(issued), err)
+	}()
+
+	expiration, err := c.policy.Check(req.Requester, subject, req.Scopes, req.CSR.DNSNames, req.Expiration)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.sign(req.CSR, subject, scopes, req.CSR.DNSNames, expiration, false)
+}
+
+// Renew issues a new certificate with the same subject, scopes and DNS names of the current certificate for the CSR public key
+// the current certificate must be verified by the caller, e.g. by mTLS handshake
+// the renewed certificate lifetime is the same as the current one, capped by the policy of the current certificate subject
+func (c *CA) Renew(current *x509.Certificate, csr *x509.CertificateRequest) (renewed *x509.Certificate, err error) {
+	subject := current.Subject.CommonName
+	scopes := cert.RawScopesFromCertificate(current)
+	defer func() {
+		c.log(OperationRenew, subject, subject, scopes, serialString(renewed), err)
+	}()
+
+	if c.store.IsRevoked(current.SerialNumber.Int64()) {
+		return nil, fmt.Errorf("%w: serial number %s", ErrAlreadyRevoked, current.SerialNumber)
+	}
+
+	// the policy may be changed since the current certificate is issued
+	expiration, err := c.policy.Check(subject, subject, strings.Fields(scopes), current.DNSNames, current.NotAfter.Sub(current.NotBefore))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.sign(csr, subject, scopes, current.DNSNames, expiration, false)
+}
+
+// ServerCertificate issues a certificate for the CA server itself, it is not checked by the policy
+func (c *CA) ServerCertificate(csr *x509.CertificateRequest, expiration time.Duration) (*x509.Certificate, error) {
+	return c.IssueValidated(csr.Subject.CommonName, csr, expiration)
+}
+
+// IssueValidated issues a server certificate without scopes for the DNS names of the CSR, it can not authenticate as a client
+// the caller must validate the requester controls the DNS names (e.g. by ACME challenges), it is not checked by the policy
+func (c *CA) IssueValidated(requester string, csr *x509.CertificateRequest, expiration time.Duration) (issued *x509.Certificate, err error) {
+	subject := csr.Subject.CommonName
+	if subject == "" && len(csr.DNSNames) > 0 {
+		subject = csr.DNSNames[0]
+	}
+
+	defer func() {
+		c.log(OperationIssue, requester, subject, "", serialString(issued), err)
+	}()
+
+	return c.sign(csr, subject, "", csr.DNSNames, expiration, true)
+}
+
+// Revoke revokes the certificate by the serial number
+// the requester certificate serial number is passed if the requester is authenticated by a certificate, so it can revoke itself
+func (c *CA) Revoke(requester string, requesterSerial *big.Int, serialNumber int64, reason int) (err error) {
+	defer func() {
+		c.log(OperationRevoke, requester, "", "", strconv.FormatInt(serialNumber, 10), err)
+	}()
+
+	self := requesterSerial != nil && requesterSerial.Int64() == serialNumber
+	if !self && !c.policy.CanRevoke(requester) {
+		return fmt.Errorf("%w: identity %q can not revoke other certificates", ErrNotAllowed, requester)
+	}
+
+	// the requester certificate is verified by the caller, it may not be issued by the CA service e.g. by the CLI
+	if _, err := c.store.Get(serialNumber); err != nil && !self {
+		return err
+	}
+
+	return c.store.Revoke(serialNumber, reason)
+}
+
+// CRL returns a new certificate revocation list in DER format signed by the CA, valid for the duration
+func (c *CA) CRL(validity time.Duration) ([]byte, error) {
+	revocations := c.store.Revocations()
+
+	entries := make([]pkix.RevokedCertificate, 0, len(revocations))
+	for _, r := range revocations {
+		entry := pkix.RevokedCertificate{
+			SerialNumber:   big.NewInt(r.SerialNumber),
+			RevocationTime: r.RevokedAt,
+		}
+
+		if r.Reason != 0 {
+			reason, err := asn1.Marshal(asn1.Enumerated(r.Reason))
+			if err != nil {
+				return nil, err
+			}
+			entry.Extensions = []pkix.Extension{{Id: reasonCodeOID, Value: reason}}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	now := time.Now()
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificates: entries,
+		Number:              big.NewInt(now.UnixNano()),
+		ThisUpdate:          now,
+		NextUpdate:          now.Add(validity),
+	}, c.cert, c.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return crl, nil
+}
+
+// sign issues the certificate and stores it in the issuance database, a server certificate has no client authentication usage
+func (c *CA) sign(csr *x509.CertificateRequest, subject, scopes string, dnsNames []string, expiration time.Duration, server bool) (*x509.Certificate, error) {
+	serialNumber, err := cert.NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	var org string
+	if len(c.cert.Subject.Organization) > 0 {
+		org = c.cert.Subject.Organization[0]
+	}
+
+	var certBytes []byte
+	if server {
+		certBytes, err = cert.NewServerCert(c.cert, csr.PublicKey, c.privateKey, serialNumber, subject, org, dnsNames, expiration)
+	} else {
+		certBytes, err = cert.NewCert(c.cert, csr.PublicKey, c.privateKey, serialNumber, subject, org, scopes, dnsNames, expiration)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificate is not returned if it can not be appended to the issuance log
+	if c.issuanceLog != nil {
+		err = c.issuanceLog.Append(certBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to append the certificate to the issuance log: %w", err)
+		}
+	}
+
+	issued, err := cert.DecodeFromDERBytes(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.store.Save(issued)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store the issued certificate: %w", err)
+	}
+
+	return issued, nil
+}
+
+// log appends the operation to the audit log
+func (c *CA) log(operation, identity, subject, scopes, serial string, opErr error) {
+	r := audit.Record{
+		Operation: operation,
+		Identity:  identity,
+		Subject:   subject,
+		Serial:    serial,
+		Scopes:    scopes,
+		Outcome:   audit.OutcomeSuccess,
+	}
+
+	if opErr != nil {
+		r.Outcome = audit.OutcomeFailure
+		r.Error = opErr.Error()
+	}
+
+	_ = c.audit.Log(r) // the audit logger is nil-safe
+}
+
+// serialString returns the certificate serial number, empty if the certificate is nil
+func serialString(c *x509.Certificate) string {
+	if c == nil {
+		return ""
+	}
+	return c.SerialNumber.String()
+}
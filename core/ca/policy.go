@@ -0,0 +1,163 @@
+package ca
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+var (
+	ErrNotAllowed = errors.New("not allowed by the issuance policy")
+)
+
+// Duration is a time.Duration that is decoded from a duration string in JSON, e.g. "24h"
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule is the issuance policy of the requesting identities matching the identity pattern
+// the patterns are matched by path.Match, e.g. "bob.*" matches "bob.user.read"
+type Rule struct {
+	// Identity pattern of the requester, e.g. the common name of the requester certificate or the token subject
+	Identity string `json:"identity"`
+
+	// Subjects patterns of the certificate common names the requester can request, only the requester identity itself if it's empty
+	Subjects []string `json:"subjects"`
+
+	// Scopes patterns of the allowed certificate scopes
+	Scopes []string `json:"scopes"`
+
+	// DNSNames patterns of the allowed certificate DNS names (SANs)
+	DNSNames []string `json:"dns_names"`
+
+	// MaxTTL is the maximum certificate lifetime
+	MaxTTL Duration `json:"max_ttl"`
+
+	// Revoke allows the requester to revoke any certificate, a certificate can always be revoked by itself
+	Revoke bool `json:"revoke"`
+}
+
+// Policy is a list of rules, the first rule matching the requester identity is applied
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// ReadPolicyFromJSONFile reads the issuance policy from the JSON file
+func ReadPolicyFromJSONFile(filePath string) (*Policy, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %w", err)
+	}
+
+	for i, r := range p.Rules {
+		if r.Identity == "" {
+			return nil, fmt.Errorf("policy rule %d: identity is required", i)
+		}
+
+		if r.MaxTTL <= 0 {
+			return nil, fmt.Errorf("policy rule %d: max_ttl must be positive", i)
+		}
+
+		for _, pattern := range append(append(append([]string{r.Identity}, r.Subjects...), r.Scopes...), r.DNSNames...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("policy rule %d: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+
+	return &p, nil
+}
+
+// Rule returns the first rule matching the requester identity
+func (p *Policy) Rule(identity string) (*Rule, bool) {
+	for i := range p.Rules {
+		if match(p.Rules[i].Identity, identity) {
+			return &p.Rules[i], true
+		}
+	}
+	return nil, false
+}
+
+// Check checks the certificate request of the requester against the policy
+// it returns the certificate lifetime, the requested expiration is capped by the max TTL of the rule
+func (p *Policy) Check(identity, subject string, scopes, dnsNames []string, expiration time.Duration) (time.Duration, error) {
+	rule, ok := p.Rule(identity)
+	if !ok {
+		return 0, fmt.Errorf("%w: no rule for identity %q", ErrNotAllowed, identity)
+	}
+
+	if len(rule.Subjects) == 0 {
+		if subject != identity {
+			return 0, fmt.Errorf("%w: identity %q can only request its own certificate", ErrNotAllowed, identity)
+		}
+	} else if !matchAny(rule.Subjects, subject) {
+		return 0, fmt.Errorf("%w: subject %q", ErrNotAllowed, subject)
+	}
+
+	for _, s := range scopes {
+		if !matchAny(rule.Scopes, s) {
+			return 0, fmt.Errorf("%w: scope %q", ErrNotAllowed, s)
+		}
+	}
+
+	for _, n := range dnsNames {
+		if !matchAny(rule.DNSNames, n) {
+			return 0, fmt.Errorf("%w: DNS name %q", ErrNotAllowed, n)
+		}
+	}
+
+	maxTTL := time.Duration(rule.MaxTTL)
+	if expiration <= 0 || expiration > maxTTL {
+		return maxTTL, nil
+	}
+
+	return expiration, nil
+}
+
+// CanRevoke reports whether the requester is allowed to revoke other certificates
+func (p *Policy) CanRevoke(identity string) bool {
+	rule, ok := p.Rule(identity)
+	return ok && rule.Revoke
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if match(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func match(pattern, s string) bool {
+	ok, _ := path.Match(pattern, s) // patterns are validated on read
+	return ok
+}
@@ -0,0 +1,65 @@
+package ca
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{
+			Identity: "provisioner",
+			Subjects: []string{"*"},
+			Scopes:   []string{"*"},
+			DNSNames: []string{"*.local"},
+			MaxTTL:   Duration(720 * time.Hour),
+		},
+		{
+			Identity: "alice",
+			Scopes:   []string{"bob.user.*"},
+			DNSNames: []string{"localhost"},
+			MaxTTL:   Duration(24 * time.Hour),
+		},
+	}}
+
+	tests := []struct {
+		name       string
+		identity   string
+		subject    string
+		scopes     []string
+		dnsNames   []string
+		expiration time.Duration
+		want       time.Duration
+		allowed    bool
+	}{
+		{"own certificate", "alice", "alice", []string{"bob.user.read"}, []string{"localhost"}, time.Hour, time.Hour, true},
+		{"capped expiration", "alice", "alice", []string{"bob.user.read"}, nil, 48 * time.Hour, 24 * time.Hour, true},
+		{"default expiration", "alice", "alice", nil, nil, 0, 24 * time.Hour, true},
+		{"other subject", "alice", "bob", []string{"bob.user.read"}, nil, time.Hour, 0, false},
+		{"scope not allowed", "alice", "alice", []string{"alice.user.read"}, nil, time.Hour, 0, false},
+		{"dns name not allowed", "alice", "alice", nil, []string{"bob.local"}, time.Hour, 0, false},
+		{"provisioner for another subject", "provisioner", "bob", []string{"alice.user.read"}, []string{"bob.local"}, time.Hour, time.Hour, true},
+		{"unknown identity", "mallory", "mallory", nil, nil, time.Hour, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policy.Check(tt.identity, tt.subject, tt.scopes, tt.dnsNames, tt.expiration)
+			if !tt.allowed {
+				if !errors.Is(err, ErrNotAllowed) {
+					t.Fatalf("expected ErrNotAllowed, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected allowed, got err: %s", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("expected expiration %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
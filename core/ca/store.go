@@ -0,0 +1,296 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+)
+
+// thumbprintLength is the length of the base64url-encoded SHA-256 thumbprint without padding
+const thumbprintLength = 43
+
+var (
+	ErrNotFound       = errors.New("certificate not found")
+	ErrAlreadyRevoked = errors.New("certificate is already revoked")
+	ErrTokenUsed      = errors.New("token is already used")
+)
+
+// Revocation is a revoked certificate entry
+type Revocation struct {
+	SerialNumber int64     `json:"serial_number"`
+	RevokedAt    time.Time `json:"revoked_at"`
+	Reason       int       `json:"reason"`
+}
+
+// Store is the issuance database in a directory
+// the issued certificates are stored as issued/[serial].crt in DER format, the revocations in revoked.json
+// and the hashes of the used one-time tokens in used_tokens.json
+// the thumbprint index maps the certificate thumbprints to the serial numbers as thumbprints/[thumbprint] files,
+// so a certificate is found by its thumbprint without scanning the issued certificates, also by another process
+type Store struct {
+	dir string
+
+	mu         sync.RWMutex
+	revoked    map[int64]Revocation
+	usedTokens map[string]struct{}
+}
+
+// OpenStore creates the store directory if not exists and returns a new instance of Store
+// the thumbprint index of a store created before the index is built from the issued certificates
+func OpenStore(dir string) (*Store, error) {
+	err := os.MkdirAll(filepath.Join(dir, "issued"), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "thumbprints"))
+	if errors.Is(err, os.ErrNotExist) {
+		err = buildThumbprintIndex(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		dir:        dir,
+		revoked:    make(map[int64]Revocation),
+		usedTokens: make(map[string]struct{}),
+	}
+
+	b, err := os.ReadFile(s.revokedPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(b) > 0 {
+		var revocations []Revocation
+		err = json.Unmarshal(b, &revocations)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode revocations: %w", err)
+		}
+
+		for _, r := range revocations {
+			s.revoked[r.SerialNumber] = r
+		}
+	}
+
+	b, err = os.ReadFile(s.usedTokensPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(b) > 0 {
+		var usedTokens []string
+		err = json.Unmarshal(b, &usedTokens)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode used tokens: %w", err)
+		}
+
+		for _, t := range usedTokens {
+			s.usedTokens[t] = struct{}{}
+		}
+	}
+
+	return s, nil
+}
+
+// Save stores the issued certificate and indexes its thumbprint
+func (s *Store) Save(c *x509.Certificate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := file.WriteAtomic(s.certPath(c.SerialNumber.Int64()), c.Raw, 0600)
+	if err != nil {
+		return err
+	}
+
+	return writeThumbprint(s.dir, c)
+}
+
+// Get returns the issued certificate by the serial number
+func (s *Store) Get(serialNumber int64) (*x509.Certificate, error) {
+	c, err := cert.ReadFromFile(s.certPath(serialNumber))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return c, err
+}
+
+// GetByThumbprint returns the issued certificate by the thumbprint of its DER bytes, it's looked up in the thumbprint index
+func (s *Store) GetByThumbprint(thumbprint string) (*x509.Certificate, error) {
+	// the thumbprint is a file name of the index, it's the base64url-encoded SHA-256 only
+	if _, err := base64.RawURLEncoding.DecodeString(thumbprint); err != nil || len(thumbprint) != thumbprintLength {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	b, err := os.ReadFile(filepath.Join(s.dir, "thumbprints", thumbprint))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid thumbprint index entry %s: %w", thumbprint, err)
+	}
+
+	c, err := s.Get(serialNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Thumbprint(c.Raw) != thumbprint {
+		return nil, ErrNotFound
+	}
+
+	return c, nil
+}
+
+// Revoke stores the certificate revocation
+func (s *Store) Revoke(serialNumber int64, reason int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revoked[serialNumber]; ok {
+		return ErrAlreadyRevoked
+	}
+
+	s.revoked[serialNumber] = Revocation{
+		SerialNumber: serialNumber,
+		RevokedAt:    time.Now().UTC(),
+		Reason:       reason,
+	}
+
+	err := s.writeRevocations()
+	if err != nil {
+		delete(s.revoked, serialNumber)
+		return err
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether the certificate is revoked
+func (s *Store) IsRevoked(serialNumber int64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.revoked[serialNumber]
+	return ok
+}
+
+// Revocations returns the revoked certificates sorted by the revocation time
+func (s *Store) Revocations() []Revocation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedRevocations()
+}
+
+// UseToken marks the one-time token hash as used, it returns ErrTokenUsed if it's already used
+func (s *Store) UseToken(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.usedTokens[tokenHash]; ok {
+		return ErrTokenUsed
+	}
+
+	s.usedTokens[tokenHash] = struct{}{}
+
+	usedTokens := make([]string, 0, len(s.usedTokens))
+	for t := range s.usedTokens {
+		usedTokens = append(usedTokens, t)
+	}
+	sort.Strings(usedTokens)
+
+	b, err := json.MarshalIndent(usedTokens, "", "  ")
+	if err == nil {
+		err = file.WriteAtomic(s.usedTokensPath(), b, 0600)
+	}
+	if err != nil {
+		delete(s.usedTokens, tokenHash)
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) sortedRevocations() []Revocation {
+	revocations := make([]Revocation, 0, len(s.revoked))
+	for _, r := range s.revoked {
+		revocations = append(revocations, r)
+	}
+	sort.Slice(revocations, func(i, j int) bool {
+		return revocations[i].RevokedAt.Before(revocations[j].RevokedAt)
+	})
+	return revocations
+}
+
+// writeRevocations writes the revocations file, the caller must hold the lock
+func (s *Store) writeRevocations() error {
+	b, err := json.MarshalIndent(s.sortedRevocations(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(s.revokedPath(), b, 0600)
+}
+
+// buildThumbprintIndex indexes the thumbprints of the issued certificates in the store directory
+func buildThumbprintIndex(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "issued", "*.crt"))
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Join(dir, "thumbprints"), 0700)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		c, err := cert.ReadFromFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to index %s: %w", path, err)
+		}
+
+		err = writeThumbprint(dir, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeThumbprint writes the thumbprint index entry of the certificate
+func writeThumbprint(dir string, c *x509.Certificate) error {
+	return file.WriteAtomic(filepath.Join(dir, "thumbprints", cert.Thumbprint(c.Raw)), []byte(c.SerialNumber.String()), 0600)
+}
+
+func (s *Store) certPath(serialNumber int64) string {
+	return filepath.Join(s.dir, "issued", fmt.Sprintf("%d.crt", serialNumber))
+}
+
+func (s *Store) revokedPath() string {
+	return filepath.Join(s.dir, "revoked.json")
+}
+
+func (s *Store) usedTokensPath() string {
+	return filepath.Join(s.dir, "used_tokens.json")
+}
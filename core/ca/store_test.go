@@ -0,0 +1,111 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+// newTestCertificate returns a new certificate of the serial number signed by a new CA
+func newTestCertificate(t *testing.T, serialNumber int64) *x509.Certificate {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCertBytes, err := cert.NewCA(privateKey, 1, "test CA", "test", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, err := cert.DecodeFromDERBytes(caCertBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := cert.NewCert(caCert, &privateKey.PublicKey, privateKey, serialNumber, "alice", "test", "user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := cert.DecodeFromDERBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestStoreGetByThumbprint(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCertificate(t, 2)
+	err = store.Save(c)
+	if err != nil {
+		t.Fatalf("expected saved certificate, got err: %s", err)
+	}
+
+	found, err := store.GetByThumbprint(cert.Thumbprint(c.Raw))
+	if err != nil {
+		t.Fatalf("expected certificate by thumbprint, got err: %s", err)
+	}
+	if !found.Equal(c) {
+		t.Fatal("expected the saved certificate")
+	}
+
+	other := newTestCertificate(t, 3)
+	for name, thumbprint := range map[string]string{
+		"unknown":   cert.Thumbprint(other.Raw),
+		"empty":     "",
+		"traversal": "../issued/2.crt",
+		"invalid":   "not a thumbprint",
+	} {
+		_, err = store.GetByThumbprint(thumbprint)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: expected not found error, got %v", name, err)
+		}
+	}
+}
+
+func TestOpenStoreBuildsThumbprintIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestCertificate(t, 2)
+	err = store.Save(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a store created before the index has the issued certificates only
+	err = os.RemoveAll(filepath.Join(dir, "thumbprints"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenStore(dir)
+	if err != nil {
+		t.Fatalf("expected opened store, got err: %s", err)
+	}
+
+	found, err := store.GetByThumbprint(cert.Thumbprint(c.Raw))
+	if err != nil {
+		t.Fatalf("expected certificate by the built index, got err: %s", err)
+	}
+	if !found.Equal(c) {
+		t.Fatal("expected the saved certificate")
+	}
+}
@@ -0,0 +1,250 @@
+package ceremony
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/shamir"
+)
+
+var (
+	ErrShareChecksum     = errors.New("share checksum does not match, the share file is corrupted")
+	ErrShareMismatch     = errors.New("share does not belong to the sealed key")
+	ErrNotEnoughShares   = errors.New("not enough shares to reach the threshold")
+	ErrUnsealFailed      = errors.New("failed to unseal the key")
+	errInvalidCeremonyID = errors.New("invalid ceremony id")
+)
+
+// SealedKey is a private key encrypted by a random wrapping key, which is split into shares held by the custodians
+type SealedKey struct {
+	CeremonyID string    `json:"ceremony_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Threshold  int       `json:"threshold"`
+	Total      int       `json:"total"`
+
+	// ShareSHA256 are the hex-encoded SHA-256 hashes of the shares by the share index, they detect forged or swapped shares
+	ShareSHA256 map[string]string `json:"share_sha256"`
+
+	// Nonce and Ciphertext are the AES-256-GCM encrypted PKCS#8 private key, the ceremony id is the additional data
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Share is a share of the wrapping key held by a custodian
+type Share struct {
+	CeremonyID string `json:"ceremony_id"`
+	Index      int    `json:"index"`
+	Threshold  int    `json:"threshold"`
+	Total      int    `json:"total"`
+	Share      []byte `json:"share"`
+
+	// Checksum is the hex-encoded SHA-256 of the ceremony id, the index and the share, it detects corrupted share files
+	Checksum string `json:"checksum"`
+}
+
+// Seal encrypts the private key in PKCS#8 DER format by a random wrapping key and splits the wrapping key into the total shares
+// any threshold of the shares unseals the key
+func Seal(privateKeyDER []byte, total, threshold int) (*SealedKey, []Share, error) {
+	wrappingKey := make([]byte, 32)
+	defer Zero(wrappingKey)
+
+	if _, err := rand.Read(wrappingKey); err != nil {
+		return nil, nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, nil, err
+	}
+
+	sealed := &SealedKey{
+		CeremonyID:  hex.EncodeToString(id),
+		CreatedAt:   time.Now().UTC(),
+		Threshold:   threshold,
+		Total:       total,
+		ShareSHA256: make(map[string]string),
+	}
+
+	parts, err := shamir.Split(wrappingKey, total, threshold)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gcm, err := newGCM(wrappingKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, nil, err
+	}
+	sealed.Ciphertext = gcm.Seal(nil, sealed.Nonce, privateKeyDER, []byte(sealed.CeremonyID))
+
+	shares := make([]Share, 0, len(parts))
+	for _, p := range parts {
+		s := Share{
+			CeremonyID: sealed.CeremonyID,
+			Index:      int(p[0]),
+			Threshold:  threshold,
+			Total:      total,
+			Share:      p,
+		}
+		s.Checksum = s.checksum()
+
+		sum := sha256.Sum256(p)
+		sealed.ShareSHA256[strconv.Itoa(s.Index)] = hex.EncodeToString(sum[:])
+		shares = append(shares, s)
+	}
+
+	return sealed, shares, nil
+}
+
+// Unseal verifies the shares, reconstructs the wrapping key and returns the private key in PKCS#8 DER format
+// the caller should Zero the returned bytes after use
+func (k *SealedKey) Unseal(shares []Share) ([]byte, error) {
+	if k.CeremonyID == "" {
+		return nil, errInvalidCeremonyID
+	}
+
+	parts := make([][]byte, 0, len(shares))
+	seen := make(map[int]bool)
+	for _, s := range shares {
+		if err := k.Verify(s); err != nil {
+			return nil, fmt.Errorf("share %d: %w", s.Index, err)
+		}
+
+		if seen[s.Index] {
+			continue
+		}
+		seen[s.Index] = true
+		parts = append(parts, s.Share)
+	}
+
+	if len(parts) < k.Threshold {
+		return nil, fmt.Errorf("%w: %d of %d", ErrNotEnoughShares, len(parts), k.Threshold)
+	}
+
+	wrappingKey, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, err
+	}
+	defer Zero(wrappingKey)
+
+	gcm, err := newGCM(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyDER, err := gcm.Open(nil, k.Nonce, k.Ciphertext, []byte(k.CeremonyID))
+	if err != nil {
+		return nil, ErrUnsealFailed
+	}
+
+	return privateKeyDER, nil
+}
+
+// Verify checks the share integrity and that it belongs to the sealed key
+func (k *SealedKey) Verify(s Share) error {
+	if subtle.ConstantTimeCompare([]byte(s.checksum()), []byte(s.Checksum)) != 1 {
+		return ErrShareChecksum
+	}
+
+	if s.CeremonyID != k.CeremonyID || len(s.Share) == 0 || int(s.Share[0]) != s.Index {
+		return ErrShareMismatch
+	}
+
+	sum := sha256.Sum256(s.Share)
+	expected, ok := k.ShareSHA256[strconv.Itoa(s.Index)]
+	if !ok || subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(expected)) != 1 {
+		return ErrShareMismatch
+	}
+
+	return nil
+}
+
+func (s Share) checksum() string {
+	h := sha256.New()
+	h.Write([]byte(s.CeremonyID))
+	h.Write([]byte{byte(s.Index)})
+	h.Write(s.Share)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// WriteJSONFile writes the sealed key or the share to the file in JSON format with 0600 permission
+func WriteJSONFile(path string, v any) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(path, b, 0600)
+}
+
+// ReadSealedKeyFromJSONFile reads the sealed key file
+func ReadSealedKeyFromJSONFile(path string) (*SealedKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var k SealedKey
+	err = json.Unmarshal(b, &k)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sealed key file: %w", err)
+	}
+
+	return &k, nil
+}
+
+// ReadShareFromJSONFile reads the share file
+func ReadShareFromJSONFile(path string) (Share, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Share{}, err
+	}
+
+	var s Share
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return Share{}, fmt.Errorf("invalid share file %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// Zero overwrites the bytes with zeros, e.g. the unsealed key after signing
+func Zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ZeroPrivateKey overwrites the private parts of the RSA key with zeros, best effort as the big integers may be copied
+func ZeroPrivateKey(k *rsa.PrivateKey) {
+	k.D.SetInt64(0)
+	for _, p := range k.Primes {
+		p.SetInt64(0)
+	}
+	k.Precomputed = rsa.PrecomputedValues{}
+}
@@ -0,0 +1,54 @@
+package ceremony
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealUnseal(t *testing.T) {
+	privateKeyDER := []byte("private key in PKCS#8 DER format")
+
+	sealed, shares, err := Seal(privateKeyDER, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsealed, err := sealed.Unseal([]Share{shares[4], shares[0], shares[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(unsealed, privateKeyDER) {
+		t.Fatal("unsealed key does not match")
+	}
+
+	_, err = sealed.Unseal(shares[:2])
+	if !errors.Is(err, ErrNotEnoughShares) {
+		t.Fatalf("expected ErrNotEnoughShares, got %v", err)
+	}
+
+	// a duplicate share does not count towards the threshold
+	_, err = sealed.Unseal([]Share{shares[0], shares[1], shares[1]})
+	if !errors.Is(err, ErrNotEnoughShares) {
+		t.Fatalf("expected ErrNotEnoughShares for a duplicate share, got %v", err)
+	}
+
+	corrupted := shares[1]
+	corrupted.Share = append([]byte{}, corrupted.Share...)
+	corrupted.Share[5] ^= 0xff
+	_, err = sealed.Unseal([]Share{shares[0], corrupted, shares[2]})
+	if !errors.Is(err, ErrShareChecksum) {
+		t.Fatalf("expected ErrShareChecksum, got %v", err)
+	}
+
+	// a share of another ceremony with a valid checksum
+	_, otherShares, err := Seal(privateKeyDER, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sealed.Unseal([]Share{shares[0], otherShares[1], shares[2]})
+	if !errors.Is(err, ErrShareMismatch) {
+		t.Fatalf("expected ErrShareMismatch, got %v", err)
+	}
+}
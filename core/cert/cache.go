@@ -0,0 +1,204 @@
+package cert
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"crypto/x509"
+	"sync"
+	"time"
+)
+
+// the cache events reported to the observer, e.g. to be counted in the metrics
+const (
+	CacheHit         = "hit"
+	CacheMiss        = "miss"
+	CacheEvicted     = "evicted"
+	CacheExpired     = "expired"
+	CacheInvalidated = "invalidated"
+)
+
+const (
+	// failureTTL is the time a failed validation is cached for, the failure may be transient, e.g. an issuance log which is not synced yet
+	failureTTL = time.Minute
+)
+
+// VerifiedCache is an LRU cache of the parsed and validated certificates keyed by the validator and the SHA-256 of the DER bytes,
+// so a certificate sent on every request is parsed and verified once until the result is valid
+type VerifiedCache struct {
+	size    int
+	observe func(event string)
+
+	statsMu sync.Mutex
+	events  map[string]uint64
+
+	mu      sync.Mutex
+	entries *list.List
+	index   map[cacheKey]*list.Element
+}
+
+// CacheStats is the number of the cached results and the number of the cache events since the cache is created
+type CacheStats struct {
+	Entries       int    `json:"entries"`
+	Size          int    `json:"size"`
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Expirations   uint64 `json:"expirations"`
+	Invalidations uint64 `json:"invalidations"`
+}
+
+// cacheKey is the key of the validation result of a certificate by a validator, the results of the validators are cached separately,
+// e.g. of the certificate and the XFCC middlewares of the listeners of a trust bundle
+type cacheKey struct {
+	validator *Validator
+	digest    [sha256.Size]byte
+}
+
+// cacheEntry is the validation result of a certificate
+type cacheEntry struct {
+	cacheKey
+	cert    *x509.Certificate
+	err     error
+	expires time.Time
+}
+
+// NewVerifiedCache returns a new instance of VerifiedCache holding up to size certificates
+// the observer is called on every cache event if it's not nil
+func NewVerifiedCache(size int, observe func(event string)) *VerifiedCache {
+	if observe == nil {
+		observe = func(string) {}
+	}
+
+	return &VerifiedCache{
+		size:    size,
+		observe: observe,
+		events:  make(map[string]uint64),
+		entries: list.New(),
+		index:   make(map[cacheKey]*list.Element),
+	}
+}
+
+// Validate parses the DER-encoded certificate and validates it by the validator, the result is cached
+// until the expiry of its chain, e.g. of the CA, or the next update of the revocation information
+// a result of another validator is not used, e.g. after the trust bundle is reloaded
+func (c *VerifiedCache) Validate(v *Validator, der []byte) (*x509.Certificate, error) {
+	key := cacheKey{validator: v, digest: sha256.Sum256(der)}
+	now := time.Now()
+
+	c.mu.Lock()
+	if e, ok := c.index[key]; ok {
+		entry := e.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			c.entries.MoveToFront(e)
+			c.mu.Unlock()
+			c.event(CacheHit)
+			return entry.cert, entry.err
+		}
+
+		c.remove(e)
+		c.mu.Unlock()
+		c.event(CacheExpired)
+	} else {
+		c.mu.Unlock()
+	}
+	c.event(CacheMiss)
+
+	// a malformed certificate is not cached, it would only fill the cache
+	cert, err := DecodeFromDERBytes(der)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{
+		cacheKey: key,
+		cert:     cert,
+	}
+
+	entry.expires, entry.err = v.validate(cert, nil)
+	if entry.err != nil {
+		entry.cert = nil
+		entry.expires = now.Add(failureTTL)
+		if cert.NotAfter.Before(entry.expires) {
+			entry.expires = cert.NotAfter
+		}
+	}
+
+	c.add(entry)
+
+	return entry.cert, entry.err
+}
+
+// Purge removes all the cached results, e.g. when the trust bundle or the revocation list is changed
+func (c *VerifiedCache) Purge() {
+	c.mu.Lock()
+	n := c.entries.Len()
+	c.entries.Init()
+	c.index = make(map[cacheKey]*list.Element)
+	c.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		c.event(CacheInvalidated)
+	}
+}
+
+// Len returns the number of the cached results
+func (c *VerifiedCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
+
+// Stats returns the number of the cached results and the cache events
+func (c *VerifiedCache) Stats() CacheStats {
+	stats := CacheStats{
+		Entries: c.Len(),
+		Size:    c.size,
+	}
+
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	stats.Hits = c.events[CacheHit]
+	stats.Misses = c.events[CacheMiss]
+	stats.Evictions = c.events[CacheEvicted]
+	stats.Expirations = c.events[CacheExpired]
+	stats.Invalidations = c.events[CacheInvalidated]
+
+	return stats
+}
+
+// event counts the cache event and reports it to the observer
+func (c *VerifiedCache) event(event string) {
+	c.statsMu.Lock()
+	c.events[event]++
+	c.statsMu.Unlock()
+
+	c.observe(event)
+}
+
+// add adds the entry and evicts the least recently used entries over the size
+func (c *VerifiedCache) add(entry *cacheEntry) {
+	c.mu.Lock()
+	if e, ok := c.index[entry.cacheKey]; ok {
+		// validated concurrently by another request
+		c.remove(e)
+	}
+	c.index[entry.cacheKey] = c.entries.PushFront(entry)
+
+	var evicted int
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+		evicted++
+	}
+	c.mu.Unlock()
+
+	for i := 0; i < evicted; i++ {
+		c.event(CacheEvicted)
+	}
+}
+
+// remove removes the element, the cache must be locked
+func (c *VerifiedCache) remove(e *list.Element) {
+	c.entries.Remove(e)
+	delete(c.index, e.Value.(*cacheEntry).cacheKey)
+}
@@ -0,0 +1,164 @@
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifiedCache(t *testing.T) {
+	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected ca private key, got err: %s", err)
+	}
+
+	caBytes, err := NewCA(caPrivateKey, 1, "Test CA", "Test Org", time.Hour)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	caCert, err := DecodeFromDERBytes(caBytes)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientCerts := make([][]byte, 3)
+	for i := range clientCerts {
+		clientCerts[i], err = NewCert(caCert, &caPrivateKey.PublicKey, caPrivateKey, int64(i+2), "alice", "Test Org", "bob.user.read", nil, time.Hour)
+		if err != nil {
+			t.Fatalf("expected client cert, got err: %s", err)
+		}
+	}
+
+	crlPath := filepath.Join(t.TempDir(), "crl.der")
+	writeCRL := func(revoked ...int64) {
+		entries := make([]pkix.RevokedCertificate, 0, len(revoked))
+		for _, serial := range revoked {
+			entries = append(entries, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+		}
+
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			RevokedCertificates: entries,
+			Number:              big.NewInt(time.Now().UnixNano()),
+			ThisUpdate:          time.Now(),
+			NextUpdate:          time.Now().Add(30 * time.Minute),
+		}, caCert, caPrivateKey)
+		if err != nil {
+			t.Fatalf("expected crl, got err: %s", err)
+		}
+
+		err = os.WriteFile(crlPath, crl, 0600)
+		if err != nil {
+			t.Fatalf("expected crl file, got err: %s", err)
+		}
+	}
+
+	writeCRL()
+	crl, err := NewCRLChecker(crlPath, caCert)
+	if err != nil {
+		t.Fatalf("expected crl checker, got err: %s", err)
+	}
+
+	events := make(map[string]int)
+	cache := NewVerifiedCache(2, func(event string) {
+		events[event]++
+	})
+	validator := NewValidator(caCert, WithRevocationChecker(crl))
+
+	for i := 0; i < 2; i++ {
+		_, err = cache.Validate(validator, clientCerts[0])
+		if err != nil {
+			t.Fatalf("expected valid cert, got err: %s", err)
+		}
+	}
+	if events[CacheHit] != 1 || events[CacheMiss] != 1 {
+		t.Errorf("expected 1 hit and 1 miss, got %v", events)
+	}
+
+	// the result is valid until the next update of the revocation list
+	if until := validator.ValidUntil([][]*x509.Certificate{{crl.issuer}}); !until.Equal(crl.NextUpdate()) {
+		t.Errorf("expected valid until %s, got %s", crl.NextUpdate(), until)
+	}
+
+	// the least recently used certificate is evicted
+	cache.Validate(validator, clientCerts[1])
+	cache.Validate(validator, clientCerts[2])
+	if events[CacheEvicted] != 1 || cache.Len() != 2 {
+		t.Errorf("expected 1 eviction of 2 entries, got %v and %d entries", events, cache.Len())
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 1 || stats.Entries != 2 || stats.Size != 2 {
+		t.Errorf("expected the stats of the events, got %+v", stats)
+	}
+
+	// the revoked certificate is rejected after the cache is purged
+	writeCRL(3)
+	changed, err := crl.Reload()
+	if err != nil || !changed {
+		t.Fatalf("expected changed crl, got %t, err: %v", changed, err)
+	}
+	cache.Purge()
+
+	_, err = cache.Validate(validator, clientCerts[1])
+	if !errors.Is(err, ErrRevoked) {
+		t.Errorf("expected revoked cert, got err: %v", err)
+	}
+
+	// a result of another validator is not used, e.g. after the trust bundle is reloaded
+	hits := events[CacheHit]
+	cache.Validate(NewValidator(caCert), clientCerts[1])
+	if events[CacheHit] != hits {
+		t.Errorf("expected miss by another validator, got %v", events)
+	}
+
+	// the result of the validator is kept, e.g. of another listener of the trust bundle
+	_, err = cache.Validate(validator, clientCerts[1])
+	if !errors.Is(err, ErrRevoked) || events[CacheHit] != hits+1 {
+		t.Errorf("expected hit of the validator result, got %v, err: %v", events, err)
+	}
+}
+
+func TestValidUntilChain(t *testing.T) {
+	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("expected ca private key, got err: %s", err)
+	}
+
+	// the CA expires before the client certificate
+	caBytes, err := NewCA(caPrivateKey, 1, "Test CA", "Test Org", time.Hour)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	caCert, err := DecodeFromDERBytes(caBytes)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientBytes, err := NewCert(caCert, &caPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	clientCert, err := DecodeFromDERBytes(clientBytes)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	until, err := NewValidator(caCert).validate(clientCert, nil)
+	if err != nil {
+		t.Fatalf("expected valid cert, got err: %s", err)
+	}
+
+	if !until.Equal(caCert.NotAfter) {
+		t.Errorf("expected valid until the CA expiry %s, got %s", caCert.NotAfter, until)
+	}
+}
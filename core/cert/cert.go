@@ -1,6 +1,7 @@
 package cert
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -17,8 +18,39 @@ var (
 	scopeOID = []int{1, 2, 3, 4}
 )
 
-// NewCA returns a new x509 certificate for digital signature and cert sign purposes with given parameters
-func NewCA(primaryPrivateKey, primaryPublicKey any, serialNumber int64, commonName, org string, expiration time.Duration) ([]byte, error) {
+// IssuanceLog is an append-only log of the issued certificates, e.g. a Merkle tree log
+// the issuer appends a certificate to the log before it's returned
+type IssuanceLog interface {
+	Append(certDER []byte) error
+}
+
+// NewCA returns a new self-signed x509 certificate for digital signature and cert sign purposes with given parameters
+// the primary signer can be a private key or any signer backend, e.g. a signing agent or a PKCS#11 token
+func NewCA(primarySigner crypto.Signer, serialNumber int64, commonName, org string, expiration time.Duration) ([]byte, error) {
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject: pkix.Name{
+			Organization: []string{org},
+			CommonName:   commonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(expiration),
+		IsCA:                  true,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, cert, primarySigner.Public(), primarySigner)
+	if err != nil {
+		return nil, err
+	}
+	return certBytes, nil
+}
+
+// NewIntermediateCA returns a new x509 CA certificate for the public key signed by the parent CA signer
+// the intermediate CA can not issue other CA certificates
+func NewIntermediateCA(parentCert *x509.Certificate, publicKey any, parentSigner crypto.Signer, serialNumber int64, commonName, org string, expiration time.Duration) ([]byte, error) {
 	cert := &x509.Certificate{
 		SerialNumber: big.NewInt(serialNumber),
 		Subject: pkix.Name{
@@ -28,42 +60,58 @@ func NewCA(primaryPrivateKey, primaryPublicKey any, serialNumber int64, commonNa
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().Add(expiration),
 		IsCA:                  true,
+		MaxPathLenZero:        true,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, cert, primaryPublicKey, primaryPrivateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, parentCert, publicKey, parentSigner)
 	if err != nil {
 		return nil, err
 	}
 	return certBytes, nil
 }
 
-// NewCert a new x509 certificate for the client
-func NewCert(caCert *x509.Certificate, clientPublicKey, caPrivateKey any, serialNumber int64, clientName, org, scopes string, dnsNames []string, expirationTime time.Duration) ([]byte, error) {
+// NewCert a new x509 certificate for the client signed by the CA signer
+func NewCert(caCert *x509.Certificate, clientPublicKey any, caSigner crypto.Signer, serialNumber int64, clientName, org, scopes string, dnsNames []string, expirationTime time.Duration) ([]byte, error) {
+	return newLeafCert(caCert, clientPublicKey, caSigner, serialNumber, clientName, org, scopes, dnsNames, expirationTime,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+}
+
+// NewServerCert a new x509 server certificate without scopes signed by the CA signer, it can not authenticate as a client
+func NewServerCert(caCert *x509.Certificate, publicKey any, caSigner crypto.Signer, serialNumber int64, commonName, org string, dnsNames []string, expirationTime time.Duration) ([]byte, error) {
+	return newLeafCert(caCert, publicKey, caSigner, serialNumber, commonName, org, "", dnsNames, expirationTime,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+}
+
+// newLeafCert a new x509 leaf certificate of the extended key usages signed by the CA signer
+func newLeafCert(caCert *x509.Certificate, publicKey any, caSigner crypto.Signer, serialNumber int64, commonName, org, scopes string, dnsNames []string, expirationTime time.Duration, extKeyUsage []x509.ExtKeyUsage) ([]byte, error) {
 	cert := &x509.Certificate{
 		SerialNumber: big.NewInt(serialNumber),
 		Subject: pkix.Name{
 			Organization:       []string{org},
 			OrganizationalUnit: []string{"Client"},
-			CommonName:         clientName,
+			CommonName:         commonName,
 		},
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().Add(expirationTime),
-		SubjectKeyId: []byte(fmt.Sprintf("%s-key-1", clientName)),
+		SubjectKeyId: []byte(fmt.Sprintf("%s-key-1", commonName)),
 		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		DNSNames:     dnsNames,
-		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtKeyUsage:  extKeyUsage,
 	}
 
-	cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
-		Id: scopeOID,
-		// Critical: true, // TODO: it can not be critical because this extension verification is not supported by the default implementation
-		Value: []byte(scopes),
-	})
+	// a certificate without scopes (e.g. a server certificate) has no scope extension
+	if scopes != "" {
+		cert.ExtraExtensions = append(cert.ExtraExtensions, pkix.Extension{
+			Id: scopeOID,
+			// Critical: true, // TODO: it can not be critical because this extension verification is not supported by the default implementation
+			Value: []byte(scopes),
+		})
+	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, caCert, clientPublicKey, caPrivateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, caCert, publicKey, caSigner)
 	if err != nil {
 		return nil, err
 	}
@@ -84,3 +132,13 @@ func ScopesFromCertificate(cert *x509.Certificate) common.Scopes {
 	}
 	return scopes
 }
+
+// RawScopesFromCertificate returns the client scopes in the certificate as stored in the extension, separated by space
+func RawScopesFromCertificate(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(scopeOID) {
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
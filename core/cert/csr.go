@@ -0,0 +1,66 @@
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+)
+
+// NewCSR returns a new certificate signing request in DER format signed by the client private key
+// the organization is optional, it's not set in the subject if it's empty
+func NewCSR(clientPrivateKey any, clientName, org string, dnsNames []string) ([]byte, error) {
+	csr := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			OrganizationalUnit: []string{"Client"},
+			CommonName:         clientName,
+		},
+		DNSNames: dnsNames,
+	}
+	if org != "" {
+		csr.Subject.Organization = []string{org}
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, csr, clientPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return csrBytes, nil
+}
+
+// DecodeCSRFromDERBytes decodes the certificate signing request bytes in DER format and checks its signature
+func DecodeCSRFromDERBytes(csrBytes []byte) (*x509.CertificateRequest, error) {
+	csr, err := x509.ParseCertificateRequest(csrBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// the signature proves the requester owns the private key of the public key in the request
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, err
+	}
+
+	return csr, nil
+}
+
+// DecodeCSR decodes the certificate signing request in PEM or DER format and checks its signature
+func DecodeCSR(b []byte) (*x509.CertificateRequest, error) {
+	if !IsPEM(b) {
+		return DecodeCSRFromDERBytes(b)
+	}
+
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, errors.New("no certificate request is found")
+		}
+
+		if block.Type == pemCertificateRequest || block.Type == pemNewCertificateRequest {
+			return DecodeCSRFromDERBytes(block.Bytes)
+		}
+	}
+}
@@ -1,8 +1,31 @@
 package cert
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"strings"
+)
+
+var (
+	ErrNoCertificate        = errors.New("no certificate is found")
+	ErrMultipleCertificates = errors.New("CA certificate file holds more than one certificate")
+)
+
+// PEMCertificate is the PEM block type of a certificate
+const PEMCertificate = "CERTIFICATE"
+
+// the PEM block types of the certificate bundles and the certificate requests
+const (
+	pemPKCS7                 = "PKCS7"
+	pemCertificateRequest    = "CERTIFICATE REQUEST"
+	pemNewCertificateRequest = "NEW CERTIFICATE REQUEST"
 )
 
 // DecodeFromDERBytes decodes the certificate bytes in DER format to x509.Certificate
@@ -15,17 +38,143 @@ func DecodeFromDERBytes(certBytes []byte) (*x509.Certificate, error) {
 	return cert, nil
 }
 
-// ReadFromDERFile reads the file in DER format and returns x509.Certificate
-func ReadFromDERFile(path string) (*x509.Certificate, error) {
+// DecodeCertificates decodes the certificates in PEM format, the CERTIFICATE and PKCS7 blocks, or in DER format,
+// a certificate, the concatenated certificates or a PKCS#7 certs-only bundle, the leaf is the first of a chain
+func DecodeCertificates(b []byte) ([]*x509.Certificate, error) {
+	if !IsPEM(b) {
+		certs, err := x509.ParseCertificates(b)
+		if err != nil {
+			// the CA /v1/cacerts endpoint responds a PKCS#7 certs-only bundle
+			if p7Certs, p7Err := DecodePKCS7CertsOnly(b); p7Err == nil {
+				certs, err = p7Certs, nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(certs) == 0 {
+			return nil, ErrNoCertificate
+		}
+		return certs, nil
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+
+		// the other blocks are skipped, e.g. the private key of the certificate in the same file
+		switch block.Type {
+		case PEMCertificate:
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, c)
+		case pemPKCS7:
+			p7Certs, err := DecodePKCS7CertsOnly(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, p7Certs...)
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, ErrNoCertificate
+	}
+	return certs, nil
+}
+
+// DecodeCertificate decodes the certificate in PEM or DER format like DecodeCertificates, the first certificate of a chain is returned
+func DecodeCertificate(b []byte) (*x509.Certificate, error) {
+	certs, err := DecodeCertificates(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return certs[0], nil
+}
+
+// ReadFromFile reads the certificate file in PEM or DER format and returns x509.Certificate, the first certificate of a chain is returned
+func ReadFromFile(path string) (*x509.Certificate, error) {
 	certBytes, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	cert, err := DecodeFromDERBytes(certBytes)
+	cert, err := DecodeCertificate(certBytes)
 	if err != nil {
 		return nil, err
 	}
 
 	return cert, nil
 }
+
+// ReadCAFromFile reads the CA certificate file in PEM or DER format, a file of more than one certificate is rejected,
+// since the CRL and the issuance log of a trust bundle are of a single CA and the other certificates of a bundle would be ignored silently
+func ReadCAFromFile(path string) (*x509.Certificate, error) {
+	certs, err := ReadChainFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) > 1 {
+		return nil, fmt.Errorf("%w: %s has %d certificates", ErrMultipleCertificates, path, len(certs))
+	}
+
+	return certs[0], nil
+}
+
+// ReadFromDERFile reads the file in DER format and returns x509.Certificate
+//
+// Deprecated: use ReadFromFile, which reads the certificate in PEM or DER format
+func ReadFromDERFile(path string) (*x509.Certificate, error) {
+	return ReadFromFile(path)
+}
+
+// ReadChainFromFile reads the certificates of the file in PEM or DER format, e.g. a chain from the leaf to the root
+func ReadChainFromFile(path string) ([]*x509.Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeCertificates(b)
+}
+
+// EncodeToPEM writes the certificates as CERTIFICATE PEM blocks in order, e.g. a chain from the leaf to the root
+func EncodeToPEM(w io.Writer, certs ...*x509.Certificate) error {
+	for _, c := range certs {
+		err := pem.Encode(w, &pem.Block{Type: PEMCertificate, Bytes: c.Raw})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Thumbprint returns the base64url-encoded SHA-256 of the certificate bytes in DER format, as the x5t#S256 JWK parameter (RFC 7517)
+func Thumbprint(certBytes []byte) string {
+	digest := sha256.Sum256(certBytes)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// Fingerprint returns the colon-separated hex SHA-256 of the DER bytes, e.g. AB:CD:..., as printed by openssl x509 -fingerprint -sha256
+func Fingerprint(der []byte) string {
+	digest := sha256.Sum256(der)
+
+	parts := make([]string, len(digest))
+	for i, b := range digest {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// IsPEM reports whether the bytes are in PEM format, the leading white spaces are ignored
+func IsPEM(b []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN "))
+}
@@ -0,0 +1,79 @@
+package cert
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+)
+
+var (
+	// signedDataOID is the PKCS#7 signed data content type
+	signedDataOID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	// dataOID is the PKCS#7 data content type
+	dataOID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+
+	// emptySet is an empty ASN.1 SET, e.g. no digest algorithms and signer infos in a certs-only message
+	emptySet = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      asn1.RawValue
+}
+
+// EncodePKCS7CertsOnly encodes the certificates as a degenerate PKCS#7 signed data (certs-only) in DER format, e.g. for EST responses
+func EncodePKCS7CertsOnly(certs ...*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs7ContentInfo{ContentType: dataOID},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: signedDataOID,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+}
+
+// DecodePKCS7CertsOnly decodes the certificates of a PKCS#7 signed data in DER format, the signatures are not verified
+func DecodePKCS7CertsOnly(b []byte) ([]*x509.Certificate, error) {
+	var ci pkcs7ContentInfo
+	rest, err := asn1.Unmarshal(b, &ci)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after PKCS#7 content")
+	}
+
+	if !ci.ContentType.Equal(signedDataOID) {
+		return nil, errors.New("PKCS#7 content is not signed data")
+	}
+
+	var sd pkcs7SignedData
+	_, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificates(sd.Certificates.Bytes)
+}
@@ -0,0 +1,124 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRevoked  = errors.New("certificate is revoked")
+	ErrStaleCRL = errors.New("certificate revocation list is expired")
+)
+
+// CRLChecker checks the certificates against a certificate revocation list file signed by the issuer
+// the list is read again on reload, e.g. when it's refreshed from the CA /v1/crl endpoint
+type CRLChecker struct {
+	path string
+
+	mu         sync.RWMutex
+	issuer     *x509.Certificate
+	digest     [sha256.Size]byte
+	revoked    map[string]struct{}
+	nextUpdate time.Time
+}
+
+// NewCRLChecker reads the DER-encoded revocation list signed by the issuer and returns a new instance of CRLChecker
+func NewCRLChecker(path string, issuer *x509.Certificate) (*CRLChecker, error) {
+	c := &CRLChecker{
+		path:   path,
+		issuer: issuer,
+	}
+
+	_, err := c.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads the revocation list from the disk and reports whether it's changed, the current list is kept on failure
+func (c *CRLChecker) Reload() (bool, error) {
+	b, err := os.ReadFile(c.path)
+	if err != nil {
+		return false, err
+	}
+
+	digest := sha256.Sum256(b)
+
+	c.mu.RLock()
+	unchanged := bytes.Equal(digest[:], c.digest[:])
+	issuer := c.issuer
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return false, err
+	}
+
+	err = crl.CheckSignatureFrom(issuer)
+	if err != nil {
+		return false, fmt.Errorf("invalid revocation list signature: %w", err)
+	}
+
+	revoked := make(map[string]struct{}, len(crl.RevokedCertificates))
+	for _, r := range crl.RevokedCertificates {
+		revoked[r.SerialNumber.String()] = struct{}{}
+	}
+
+	c.mu.Lock()
+	c.digest = digest
+	c.revoked = revoked
+	c.nextUpdate = crl.NextUpdate
+	c.mu.Unlock()
+
+	return true, nil
+}
+
+// SetIssuer replaces the issuer of the revocation list, e.g. when the CA certificate is rotated
+// the list is verified by the new issuer on the next reload even if it's not changed, the current list is kept until then
+func (c *CRLChecker) SetIssuer(issuer *x509.Certificate) {
+	c.mu.Lock()
+	c.issuer = issuer
+	c.digest = [sha256.Size]byte{}
+	c.mu.Unlock()
+}
+
+// CheckRevocation returns ErrRevoked if the certificate is in the revocation list
+// a list after its next update is not trusted, the certificates are rejected until it's refreshed
+func (c *CRLChecker) CheckRevocation(cert *x509.Certificate) error {
+	return c.CheckRevocationAt(cert, time.Now())
+}
+
+// CheckRevocationAt checks the certificate like CheckRevocation does, the list is stale if the time is after its next update
+// e.g. a list which was fresh at the time of a captured request is trusted to verify it
+func (c *CRLChecker) CheckRevocationAt(cert *x509.Certificate, now time.Time) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if !c.nextUpdate.IsZero() && now.After(c.nextUpdate) {
+		return ErrStaleCRL
+	}
+
+	if _, ok := c.revoked[cert.SerialNumber.String()]; ok {
+		return ErrRevoked
+	}
+
+	return nil
+}
+
+// NextUpdate returns the time the revocation list is expected to be refreshed
+func (c *CRLChecker) NextUpdate() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nextUpdate
+}
@@ -0,0 +1,104 @@
+package cert
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCRLCheckerAtClock(t *testing.T) {
+	caPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caBytes, err := NewCA(caPrivateKey, 1, "Test CA", "Test Org", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	certBytes, err := NewCert(caCert, &caPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("expected cert, got err: %s", err)
+	}
+
+	clientCert, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		t.Fatalf("expected cert, got err: %s", err)
+	}
+
+	// the list was fresh an hour ago, it's stale now
+	thisUpdate := time.Now().Add(-2 * time.Hour)
+	crlBytes, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: thisUpdate,
+		NextUpdate: thisUpdate.Add(time.Hour + 30*time.Minute),
+	}, caCert, caPrivateKey)
+	if err != nil {
+		t.Fatalf("expected crl, got err: %s", err)
+	}
+
+	crlPath := filepath.Join(t.TempDir(), "ca.crl")
+	err = os.WriteFile(crlPath, crlBytes, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := NewCRLChecker(crlPath, caCert)
+	if err != nil {
+		t.Fatalf("expected crl checker, got err: %s", err)
+	}
+
+	err = crl.CheckRevocation(clientCert)
+	if !errors.Is(err, ErrStaleCRL) {
+		t.Errorf("expected %v, got %v", ErrStaleCRL, err)
+	}
+
+	hourAgo := time.Now().Add(-time.Hour)
+	err = crl.CheckRevocationAt(clientCert, hourAgo)
+	if err != nil {
+		t.Errorf("expected the list to be fresh an hour ago, got err: %s", err)
+	}
+
+	// the validator checks the list at the time of its clock, e.g. of `cli verify --at`, the certificate is issued now
+	freshCRLBytes, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(2),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, caCert, caPrivateKey)
+	if err != nil {
+		t.Fatalf("expected crl, got err: %s", err)
+	}
+
+	err = os.WriteFile(crlPath, freshCRLBytes, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = crl.Reload()
+	if err != nil {
+		t.Fatalf("expected reloaded crl, got err: %s", err)
+	}
+
+	err = NewValidator(caCert, WithRevocationChecker(crl)).Validate(clientCert)
+	if err != nil {
+		t.Errorf("expected valid certificate now, got err: %s", err)
+	}
+
+	inTwoHours := time.Now().Add(2 * time.Hour)
+	err = NewValidator(caCert, WithRevocationChecker(crl), WithClock(func() time.Time { return inTwoHours })).Validate(clientCert)
+	if !errors.Is(err, ErrStaleCRL) {
+		t.Errorf("expected %v at the clock, got %v", ErrStaleCRL, err)
+	}
+}
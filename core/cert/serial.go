@@ -0,0 +1,17 @@
+package cert
+
+import (
+	"crypto/rand"
+	"math"
+	"math/big"
+)
+
+// NewSerialNumber returns a random positive serial number for a new certificate
+func NewSerialNumber() (int64, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+	if err != nil {
+		return 0, err
+	}
+
+	return n.Int64() + 1, nil
+}
@@ -1,27 +1,165 @@
 package cert
 
-import "crypto/x509"
+import (
+	"crypto/x509"
+	"time"
+)
+
+// InclusionVerifier verifies the certificate is included in the issuance log, e.g. by a Merkle tree inclusion proof
+type InclusionVerifier interface {
+	VerifyInclusion(cert *x509.Certificate) error
+}
+
+// RevocationChecker checks the certificate is not revoked, e.g. by a certificate revocation list
+// the result is valid until the next update, when the revocation information is refreshed
+type RevocationChecker interface {
+	CheckRevocation(cert *x509.Certificate) error
+	NextUpdate() time.Time
+}
+
+// RevocationCheckerAt checks the revocation at a time like RevocationChecker does at the current time,
+// e.g. by a revocation list which was fresh at the time of a captured request
+type RevocationCheckerAt interface {
+	CheckRevocationAt(cert *x509.Certificate, now time.Time) error
+}
+
+// CheckRevocationAt checks the certificate is not revoked at the time, the checker is called at the current time if it's not a RevocationCheckerAt
+func CheckRevocationAt(c RevocationChecker, cert *x509.Certificate, now time.Time) error {
+	if at, ok := c.(RevocationCheckerAt); ok {
+		return at.CheckRevocationAt(cert, now)
+	}
+	return c.CheckRevocation(cert)
+}
+
+// ValidatorOption configures the validator
+type ValidatorOption func(*Validator)
+
+// WithInclusionVerifier requires the certificate to be included in the issuance log, so a certificate issued silently is rejected
+func WithInclusionVerifier(v InclusionVerifier) ValidatorOption {
+	return func(m *Validator) {
+		m.inclusion = v
+	}
+}
+
+// WithRevocationChecker rejects the revoked certificates
+func WithRevocationChecker(c RevocationChecker) ValidatorOption {
+	return func(m *Validator) {
+		m.revocation = c
+	}
+}
+
+// WithClock validates the certificates at the time of the clock, e.g. of a captured request, instead of the current time
+func WithClock(now func() time.Time) ValidatorOption {
+	return func(m *Validator) {
+		m.clock = now
+	}
+}
 
 type Validator struct {
-	rootCA *x509.Certificate
-	opts   x509.VerifyOptions
+	rootCA     *x509.Certificate
+	opts       x509.VerifyOptions
+	inclusion  InclusionVerifier
+	revocation RevocationChecker
+	clock      func() time.Time
 }
 
 // NewValidator returns a new instance of Validator
-func NewValidator(rootCA *x509.Certificate) *Validator {
+func NewValidator(rootCA *x509.Certificate, opts ...ValidatorOption) *Validator {
 	roots := x509.NewCertPool()
 	roots.AddCert(rootCA)
 
-	return &Validator{
+	v := &Validator{
 		rootCA: rootCA,
 		opts: x509.VerifyOptions{
 			Roots: roots,
 		},
 	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
 }
 
 // Validate validates x509.Certificate by CA cerificate
 func (m Validator) Validate(cert *x509.Certificate) error {
-	_, err := cert.Verify(m.opts)
+	return m.ValidateWithIntermediates(cert, nil)
+}
+
+// ValidateWithIntermediates validates x509.Certificate by CA cerificate through the intermediate certificates, e.g. the chain forwarded by a proxy
+func (m Validator) ValidateWithIntermediates(cert *x509.Certificate, intermediates []*x509.Certificate) error {
+	_, err := m.validate(cert, intermediates)
 	return err
 }
+
+// validate validates the certificate and returns the time the result is valid until
+func (m Validator) validate(cert *x509.Certificate, intermediates []*x509.Certificate) (time.Time, error) {
+	opts := m.opts
+	if m.clock != nil {
+		opts.CurrentTime = m.clock()
+	}
+	if len(intermediates) > 0 {
+		opts.Intermediates = x509.NewCertPool()
+		for _, c := range intermediates {
+			opts.Intermediates.AddCert(c)
+		}
+	}
+
+	chains, err := cert.Verify(opts)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if m.revocation != nil {
+		now := opts.CurrentTime
+		if now.IsZero() {
+			now = time.Now()
+		}
+
+		// the revocation list is checked at the time of the clock as the certificate is
+		err = CheckRevocationAt(m.revocation, cert, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	if m.inclusion != nil {
+		err = m.inclusion.VerifyInclusion(cert)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return m.ValidUntil(chains), nil
+}
+
+// ValidUntil returns the time the validation result of the verified chains is valid until,
+// the expiry of the first certificate of the chain expiring first, e.g. the CA, or the next update of the revocation information, whichever is earlier
+// the certificate is valid while any of its chains is valid
+func (m Validator) ValidUntil(chains [][]*x509.Certificate) time.Time {
+	var until time.Time
+	for _, chain := range chains {
+		var chainUntil time.Time
+		for _, c := range chain {
+			if chainUntil.IsZero() || c.NotAfter.Before(chainUntil) {
+				chainUntil = c.NotAfter
+			}
+		}
+
+		if chainUntil.After(until) {
+			until = chainUntil
+		}
+	}
+
+	if m.revocation == nil {
+		return until
+	}
+
+	nextUpdate := m.revocation.NextUpdate()
+	if nextUpdate.IsZero() || until.Before(nextUpdate) {
+		return until
+	}
+
+	return nextUpdate
+}
@@ -144,3 +144,20 @@ func BenchmarkValidatorValidate4096KeySize(b *testing.B) {
 		validator.Validate(clientCert)
 	}
 }
+
+func BenchmarkVerifiedCacheValidate2048KeySizeWithDecodeBase64(b *testing.B) {
+	caCert, err := DecodeFromDERBytes(caCert2048Bytes)
+	if err != nil {
+		b.Errorf("expected ca cert, got err: %s", err)
+		b.FailNow()
+	}
+
+	validator := NewValidator(caCert)
+	cache := NewVerifiedCache(100, nil)
+
+	for i := 0; i < b.N; i++ {
+		clientCert2048Bytes, _ = base64.StdEncoding.DecodeString(clientCert2048Str)
+
+		cache.Validate(validator, clientCert2048Bytes)
+	}
+}
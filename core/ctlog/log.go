@@ -0,0 +1,366 @@
+package ctlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/signer"
+)
+
+const (
+	leavesFileName   = "leaves"
+	treeHeadFileName = "sth.json"
+)
+
+var (
+	ErrNoTreeHead   = errors.New("the log has no signed tree head")
+	ErrNotIncluded  = errors.New("the entry is not included in the log")
+	ErrReadOnly     = errors.New("the log is opened without a signer")
+	ErrInvalidRange = errors.New("invalid tree size range")
+)
+
+// SignedTreeHead is the Merkle tree root hash of the first tree size entries of the log, signed by the log signer
+type SignedTreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	Timestamp int64  `json:"timestamp"` // milliseconds since the epoch
+	RootHash  []byte `json:"root_hash"`
+	Signature []byte `json:"signature"`
+}
+
+// InclusionProof proves the entry of the leaf index is included in the tree of the signed tree head
+type InclusionProof struct {
+	LeafIndex uint64         `json:"leaf_index"`
+	TreeHead  SignedTreeHead `json:"tree_head"`
+	AuditPath [][]byte       `json:"audit_path"`
+}
+
+// Log is an append-only Merkle tree log of the issued certificates (RFC 6962)
+// the entries are stored as base64-encoded lines in the log directory and the latest signed tree head is stored next to them
+// the leaves file is locked while an entry is appended, so several processes (e.g. the CA server and the CLI) can share the log
+type Log struct {
+	mu     sync.Mutex
+	dir    string
+	signer crypto.Signer
+	f      *os.File
+
+	size    int64 // bytes of the leaves file read
+	entries [][]byte
+	hashes  [][]byte
+	index   map[string]uint64
+
+	// proofs are the inclusion proofs of the leaf hashes in the tree of proofsHead, they're dropped when a new tree head is signed
+	proofs     map[string]*InclusionProof
+	proofsHead *SignedTreeHead
+}
+
+// Open opens the log directory and returns a new instance of Log, the signer is required to append entries only
+func Open(dir string, s crypto.Signer) (*Log, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, leavesFileName), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLog(dir, s, f)
+}
+
+// OpenReadOnly opens the existing log directory read-only and returns a new instance of Log, e.g. for a verifier
+// the entries can not be appended to it
+func OpenReadOnly(dir string) (*Log, error) {
+	f, err := os.Open(filepath.Join(dir, leavesFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	return newLog(dir, nil, f)
+}
+
+func newLog(dir string, s crypto.Signer, f *os.File) (*Log, error) {
+	l := &Log{
+		dir:    dir,
+		signer: s,
+		f:      f,
+		index:  make(map[string]uint64),
+		proofs: make(map[string]*InclusionProof),
+	}
+
+	err := l.refresh()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Append appends the entry (a certificate in DER format) to the log and signs a new tree head
+// it implements cert.IssuanceLog
+func (l *Log) Append(entry []byte) error {
+	if l.signer == nil {
+		return ErrReadOnly
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("failed to lock the log: %w", err)
+	}
+	defer syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+
+	err = l.refresh()
+	if err != nil {
+		return err
+	}
+
+	// the entry is written straight after the read lines, a partial line would merge with it
+	err = l.truncatePartial()
+	if err != nil {
+		return err
+	}
+
+	line := base64.StdEncoding.EncodeToString(entry) + "\n"
+	n, err := l.f.WriteString(line)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	err = l.f.Sync()
+	if err != nil {
+		return err
+	}
+	l.add(entry)
+
+	sth, err := l.signTreeHead()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(sth)
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(filepath.Join(l.dir, treeHeadFileName), b, 0644)
+}
+
+// SignedTreeHead returns the latest signed tree head
+func (l *Log) SignedTreeHead() (*SignedTreeHead, error) {
+	b, err := os.ReadFile(filepath.Join(l.dir, treeHeadFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoTreeHead
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sth SignedTreeHead
+	err = json.Unmarshal(b, &sth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed tree head: %w", err)
+	}
+
+	return &sth, nil
+}
+
+// InclusionProof returns the inclusion proof of the leaf hash in the tree of the latest signed tree head
+// the proofs are cached until a new tree head is signed
+func (l *Log) InclusionProof(leafHash []byte) (*InclusionProof, error) {
+	sth, err := l.SignedTreeHead()
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.proofsHead == nil || l.proofsHead.TreeSize != sth.TreeSize || !bytes.Equal(l.proofsHead.RootHash, sth.RootHash) {
+		l.proofs = make(map[string]*InclusionProof)
+		l.proofsHead = sth
+	}
+
+	if proof, ok := l.proofs[string(leafHash)]; ok {
+		return proof, nil
+	}
+
+	// the entries are appended before the tree head is signed, so the log includes the tree of the signed tree head after the refresh
+	err = l.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	index, ok := l.index[string(leafHash)]
+	if !ok || index >= sth.TreeSize {
+		return nil, ErrNotIncluded
+	}
+
+	proof := &InclusionProof{
+		LeafIndex: index,
+		TreeHead:  *l.proofsHead,
+		AuditPath: inclusionPath(int(index), l.hashes[:sth.TreeSize]),
+	}
+	l.proofs[string(leafHash)] = proof
+
+	return proof, nil
+}
+
+// ConsistencyProof returns the proof that the tree of the first size is a prefix of the tree of the second size
+func (l *Log) ConsistencyProof(first, second uint64) ([][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	if first > second || second > uint64(len(l.hashes)) {
+		return nil, ErrInvalidRange
+	}
+
+	if first == 0 || first == second {
+		return [][]byte{}, nil
+	}
+
+	return consistencyPath(int(first), l.hashes[:second], true), nil
+}
+
+// Entries returns the entries from the start index to the end index (exclusive), e.g. for a monitor to check the issued certificates
+func (l *Log) Entries(start, end uint64) ([][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	if start > end || end > uint64(len(l.entries)) {
+		return nil, ErrInvalidRange
+	}
+
+	return l.entries[start:end], nil
+}
+
+// Close closes the leaves file
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// signTreeHead signs the tree head of all the entries, the log must be locked
+func (l *Log) signTreeHead() (*SignedTreeHead, error) {
+	sth := &SignedTreeHead{
+		TreeSize:  uint64(len(l.hashes)),
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		RootHash:  rootHash(l.hashes),
+	}
+
+	digest := sth.digest()
+	signature, err := l.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the tree head: %w", err)
+	}
+	sth.Signature = signature
+
+	return sth, nil
+}
+
+// refresh reads the entries appended since the last read, e.g. by another process, the log must be locked
+// a partial line (an entry being written) is not read
+func (l *Log) refresh() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == l.size {
+		return nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(l.f, l.size, info.Size()-l.size))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entry, err := base64.StdEncoding.DecodeString(string(bytes.TrimSuffix(line, []byte("\n"))))
+		if err != nil {
+			return fmt.Errorf("invalid log entry %d: %w", len(l.entries), err)
+		}
+
+		l.size += int64(len(line))
+		l.add(entry)
+	}
+}
+
+// truncatePartial removes a partial last line, e.g. of a crash while an entry is written, the log must be locked and refreshed
+// the tree head is signed after the line is written, so the partial entry is never included in a signed tree head
+func (l *Log) truncatePartial() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == l.size {
+		return nil
+	}
+
+	err = l.f.Truncate(l.size)
+	if err != nil {
+		return fmt.Errorf("failed to remove the partial log entry: %w", err)
+	}
+
+	log.Printf("removed a partial log entry of %d bytes at the end of %s", info.Size()-l.size, l.f.Name())
+	return nil
+}
+
+// add adds the entry to the in-memory tree
+func (l *Log) add(entry []byte) {
+	hash := LeafHash(entry)
+	l.index[string(hash)] = uint64(len(l.hashes))
+	l.entries = append(l.entries, entry)
+	l.hashes = append(l.hashes, hash)
+}
+
+// Verify verifies the signature of the tree head by the log public key
+func (sth *SignedTreeHead) Verify(pub crypto.PublicKey) error {
+	digest := sth.digest()
+	return signer.Verify(pub, digest[:], sth.Signature)
+}
+
+// digest returns the SHA-256 digest of the tree head signature input, the TreeHeadSignature structure of RFC 6962 section 3.5
+func (sth *SignedTreeHead) digest() [sha256.Size]byte {
+	var b bytes.Buffer
+	b.WriteByte(0) // version v1
+	b.WriteByte(1) // signature type tree_hash
+	binary.Write(&b, binary.BigEndian, uint64(sth.Timestamp))
+	binary.Write(&b, binary.BigEndian, sth.TreeSize)
+	b.Write(sth.RootHash)
+	return sha256.Sum256(b.Bytes())
+}
@@ -0,0 +1,225 @@
+package ctlog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+func TestMerkleProofs(t *testing.T) {
+	var leaves [][]byte
+	for i := 0; i < 20; i++ {
+		leaves = append(leaves, LeafHash([]byte(fmt.Sprintf("entry-%d", i))))
+	}
+
+	for n := 1; n <= len(leaves); n++ {
+		root := rootHash(leaves[:n])
+
+		for i := 0; i < n; i++ {
+			path := inclusionPath(i, leaves[:n])
+			if !VerifyInclusion(leaves[i], uint64(i), uint64(n), path, root) {
+				t.Errorf("expected valid inclusion proof of %d in %d", i, n)
+			}
+			if VerifyInclusion(leaves[(i+1)%n], uint64(i), uint64(n), path, root) && n > 1 {
+				t.Errorf("expected invalid inclusion proof of another leaf at %d in %d", i, n)
+			}
+		}
+
+		for m := 1; m < n; m++ {
+			proof := consistencyPath(m, leaves[:n], true)
+			if !VerifyConsistency(uint64(m), uint64(n), rootHash(leaves[:m]), root, proof) {
+				t.Errorf("expected valid consistency proof of %d and %d", m, n)
+			}
+			if VerifyConsistency(uint64(m), uint64(n), rootHash(leaves[1:m+1]), root, proof) {
+				t.Errorf("expected invalid consistency proof of another tree %d and %d", m, n)
+			}
+		}
+	}
+}
+
+func TestVerifierRequiresInclusion(t *testing.T) {
+	caPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected ca private key, got err: %s", err)
+	}
+
+	caBytes, err := cert.NewCA(caPrivateKey, 1, "Test CA", "Test Org", time.Hour)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	caCert, err := cert.DecodeFromDERBytes(caBytes)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	dir := t.TempDir()
+	l, err := Open(dir, caPrivateKey)
+	if err != nil {
+		t.Fatalf("expected log, got err: %s", err)
+	}
+	defer l.Close()
+
+	newCert := func(serialNumber int64) []byte {
+		b, err := cert.NewCert(caCert, &caPrivateKey.PublicKey, caPrivateKey, serialNumber, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+		if err != nil {
+			t.Fatalf("expected cert, got err: %s", err)
+		}
+		return b
+	}
+
+	// a certificate which is not appended to the log is not included
+	silent, err := cert.DecodeFromDERBytes(newCert(2))
+	if err != nil {
+		t.Fatalf("expected cert, got err: %s", err)
+	}
+
+	var logged []byte
+	for i := int64(3); i < 10; i++ {
+		logged = newCert(i)
+		err = l.Append(logged)
+		if err != nil {
+			t.Fatalf("expected appended cert, got err: %s", err)
+		}
+	}
+
+	// the log is read by another process, e.g. the server
+	reader, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatalf("expected log, got err: %s", err)
+	}
+	defer reader.Close()
+
+	validator := cert.NewValidator(caCert, cert.WithInclusionVerifier(NewVerifier(&caPrivateKey.PublicKey, reader)))
+
+	loggedCert, err := cert.DecodeFromDERBytes(logged)
+	if err != nil {
+		t.Fatalf("expected cert, got err: %s", err)
+	}
+
+	err = validator.Validate(loggedCert)
+	if err != nil {
+		t.Errorf("expected logged certificate to be valid, got err: %s", err)
+	}
+
+	err = validator.Validate(silent)
+	if !errors.Is(err, ErrNotLogged) {
+		t.Errorf("expected %v, got %v", ErrNotLogged, err)
+	}
+
+	// the proof is cached for the tree head
+	leafHash := LeafHash(loggedCert.Raw)
+	proof, err := reader.InclusionProof(leafHash)
+	if err != nil {
+		t.Fatalf("expected proof, got err: %s", err)
+	}
+
+	cached, err := reader.InclusionProof(leafHash)
+	if err != nil || cached != proof {
+		t.Errorf("expected the cached proof, got err: %v", err)
+	}
+
+	err = reader.Append(logged)
+	if !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected %v, got %v", ErrReadOnly, err)
+	}
+
+	// the tree grows, the new tree head must be consistent with the previous one
+	grown := newCert(10)
+	err = l.Append(grown)
+	if err != nil {
+		t.Fatalf("expected appended cert, got err: %s", err)
+	}
+
+	// the cached proof of the previous tree head is dropped
+	proof, err = reader.InclusionProof(leafHash)
+	if err != nil {
+		t.Fatalf("expected proof, got err: %s", err)
+	}
+	if proof == cached || proof.TreeHead.TreeSize != 8 {
+		t.Errorf("expected the proof of the new tree head, got tree size %d", proof.TreeHead.TreeSize)
+	}
+
+	loggedCert, err = cert.DecodeFromDERBytes(grown)
+	if err != nil {
+		t.Fatalf("expected cert, got err: %s", err)
+	}
+
+	err = validator.Validate(loggedCert)
+	if err != nil {
+		t.Errorf("expected logged certificate to be valid, got err: %s", err)
+	}
+}
+
+func TestAppendAfterPartialEntry(t *testing.T) {
+	privateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected private key, got err: %s", err)
+	}
+
+	dir := t.TempDir()
+	l, err := Open(dir, privateKey)
+	if err != nil {
+		t.Fatalf("expected log, got err: %s", err)
+	}
+
+	err = l.Append([]byte("entry-1"))
+	if err != nil {
+		t.Fatalf("expected appended entry, got err: %s", err)
+	}
+	l.Close()
+
+	// a crash while an entry is written leaves a partial line without a new line
+	f, err := os.OpenFile(filepath.Join(dir, leavesFileName), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("expected leaves file, got err: %s", err)
+	}
+	_, err = f.WriteString("ZW50cnkt")
+	if err != nil {
+		t.Fatalf("expected partial entry, got err: %s", err)
+	}
+	f.Close()
+
+	l, err = Open(dir, privateKey)
+	if err != nil {
+		t.Fatalf("expected log with a partial entry, got err: %s", err)
+	}
+	defer l.Close()
+
+	err = l.Append([]byte("entry-2"))
+	if err != nil {
+		t.Fatalf("expected appended entry, got err: %s", err)
+	}
+
+	// the log is opened again by another process
+	reader, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatalf("expected log, got err: %s", err)
+	}
+	defer reader.Close()
+
+	entries, err := reader.Entries(0, 2)
+	if err != nil {
+		t.Fatalf("expected entries, got err: %s", err)
+	}
+	if string(entries[0]) != "entry-1" || string(entries[1]) != "entry-2" {
+		t.Errorf("expected entry-1 and entry-2, got %q and %q", entries[0], entries[1])
+	}
+
+	sth, err := reader.SignedTreeHead()
+	if err != nil {
+		t.Fatalf("expected signed tree head, got err: %s", err)
+	}
+
+	root := rootHash([][]byte{LeafHash([]byte("entry-1")), LeafHash([]byte("entry-2"))})
+	if sth.TreeSize != 2 || !bytes.Equal(sth.RootHash, root) {
+		t.Errorf("expected the signed tree head of the 2 entries, got size %d", sth.TreeSize)
+	}
+}
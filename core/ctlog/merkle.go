@@ -0,0 +1,156 @@
+package ctlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// LeafHash returns the Merkle tree leaf hash of the entry (RFC 6962 section 2.1)
+func LeafHash(entry []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(entry)
+	return h.Sum(nil)
+}
+
+// nodeHash returns the Merkle tree hash of an interior node by its children
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// rootHash returns the Merkle tree hash of the leaf hashes
+func rootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+
+	k := splitPoint(len(leaves))
+	return nodeHash(rootHash(leaves[:k]), rootHash(leaves[k:]))
+}
+
+// inclusionPath returns the audit path of the leaf index in the tree of the leaf hashes
+func inclusionPath(index int, leaves [][]byte) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+
+	k := splitPoint(len(leaves))
+	if index < k {
+		return append(inclusionPath(index, leaves[:k]), rootHash(leaves[k:]))
+	}
+	return append(inclusionPath(index-k, leaves[k:]), rootHash(leaves[:k]))
+}
+
+// consistencyPath returns the consistency proof of the tree of the first m leaves and the tree of the leaf hashes
+func consistencyPath(m int, leaves [][]byte, complete bool) [][]byte {
+	if m == len(leaves) {
+		if complete {
+			return nil
+		}
+		return [][]byte{rootHash(leaves)}
+	}
+
+	k := splitPoint(len(leaves))
+	if m <= k {
+		return append(consistencyPath(m, leaves[:k], complete), rootHash(leaves[k:]))
+	}
+	return append(consistencyPath(m-k, leaves[k:], false), rootHash(leaves[:k]))
+}
+
+// splitPoint returns the largest power of two smaller than n
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// VerifyInclusion verifies the audit path proves the leaf hash at the index is included in the tree of the size and the root hash (RFC 9162 section 2.1.3.2)
+func VerifyInclusion(leafHash []byte, index, size uint64, path [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+
+	fn, sn := index, size-1
+	r := leafHash
+	for _, p := range path {
+		if sn == 0 {
+			return false
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// VerifyConsistency verifies the proof that the tree of the first size and root is a prefix of the tree of the second size and root (RFC 9162 section 2.1.4.2)
+func VerifyConsistency(firstSize, secondSize uint64, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	switch {
+	case firstSize > secondSize:
+		return false
+	case firstSize == secondSize:
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	case firstSize == 0:
+		// the empty tree is a prefix of any tree
+		return len(proof) == 0
+	}
+
+	// the first tree is a complete subtree, its root is the first node of the path
+	if firstSize&(firstSize-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+
+	if len(proof) == 0 {
+		return false
+	}
+
+	fn, sn := firstSize-1, secondSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
@@ -0,0 +1,98 @@
+package ctlog
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrNotLogged            = errors.New("certificate is not included in the issuance log")
+	ErrInvalidProof         = errors.New("invalid inclusion proof")
+	ErrInvalidTreeHead      = errors.New("invalid signed tree head")
+	ErrInconsistentTreeHead = errors.New("signed tree head is not consistent with the previous one")
+)
+
+// Source returns the proofs of a log, e.g. a local log or a log server
+type Source interface {
+	InclusionProof(leafHash []byte) (*InclusionProof, error)
+	ConsistencyProof(first, second uint64) ([][]byte, error)
+}
+
+// Verifier verifies the certificates are included in the log by the inclusion proofs of the source
+// every signed tree head is verified to be consistent with the largest one seen, so the log can not be forked or rewritten
+type Verifier struct {
+	pub    crypto.PublicKey
+	source Source
+
+	mu   sync.Mutex
+	last *SignedTreeHead
+}
+
+// NewVerifier returns a new instance of Verifier by the log public key, it implements cert.InclusionVerifier
+func NewVerifier(pub crypto.PublicKey, source Source) *Verifier {
+	return &Verifier{
+		pub:    pub,
+		source: source,
+	}
+}
+
+// VerifyInclusion verifies the certificate is included in the log
+func (v *Verifier) VerifyInclusion(c *x509.Certificate) error {
+	leafHash := LeafHash(c.Raw)
+
+	proof, err := v.source.InclusionProof(leafHash)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNotLogged, err)
+	}
+
+	err = v.VerifyTreeHead(&proof.TreeHead)
+	if err != nil {
+		return err
+	}
+
+	if !VerifyInclusion(leafHash, proof.LeafIndex, proof.TreeHead.TreeSize, proof.AuditPath, proof.TreeHead.RootHash) {
+		return ErrInvalidProof
+	}
+
+	return nil
+}
+
+// VerifyTreeHead verifies the tree head signature and its consistency with the largest tree head seen
+func (v *Verifier) VerifyTreeHead(sth *SignedTreeHead) error {
+	err := sth.Verify(v.pub)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidTreeHead, err)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.last == nil {
+		v.last = sth
+		return nil
+	}
+
+	first, second := v.last, sth
+	if first.TreeSize > second.TreeSize {
+		first, second = second, first
+	}
+
+	if first.TreeSize != second.TreeSize {
+		proof, err := v.source.ConsistencyProof(first.TreeSize, second.TreeSize)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInconsistentTreeHead, err)
+		}
+
+		if !VerifyConsistency(first.TreeSize, second.TreeSize, first.RootHash, second.RootHash, proof) {
+			return ErrInconsistentTreeHead
+		}
+	} else if !VerifyConsistency(first.TreeSize, second.TreeSize, first.RootHash, second.RootHash, nil) {
+		return ErrInconsistentTreeHead
+	}
+
+	v.last = second
+	return nil
+}
@@ -0,0 +1,192 @@
+package est
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+const (
+	// PathPrefix is the well-known EST path prefix (RFC 7030)
+	PathPrefix = "/.well-known/est"
+
+	CACertsPath        = PathPrefix + "/cacerts"
+	SimpleEnrollPath   = PathPrefix + "/simpleenroll"
+	SimpleReenrollPath = PathPrefix + "/simplereenroll"
+
+	// CSRContentType is the media type of the base64-encoded PKCS#10 enrollment request
+	CSRContentType = "application/pkcs10"
+
+	// CertsOnlyContentType is the media type of the base64-encoded PKCS#7 certs-only response
+	CertsOnlyContentType = "application/pkcs7-mime; smime-type=certs-only"
+
+	// maxResponseSize limits the EST response body
+	maxResponseSize = 256 << 10
+)
+
+// Credentials sets the bootstrap credentials of the enrollment request
+type Credentials func(r *http.Request)
+
+// BasicAuth returns the HTTP basic bootstrap credentials
+func BasicAuth(username, password string) Credentials {
+	return func(r *http.Request) {
+		r.SetBasicAuth(username, password)
+	}
+}
+
+// OneTimeToken returns the one-time token bootstrap credentials, sent as a bearer token
+func OneTimeToken(token string) Credentials {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// Client is an EST client
+// the re-enrollment is authenticated by the client certificate in the tls config
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// NewClient returns a new instance of Client for the EST server base URL, e.g. https://localhost:8686
+func NewClient(baseURL string, tlsConfig *tls.Config) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// CACerts returns the CA certificates of the EST server
+func (c *Client) CACerts(ctx context.Context) ([]*x509.Certificate, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+CACertsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.do(r)
+}
+
+// PinnedCACert fetches the CA certificates from the EST server without verifying it and returns the one matching the
+// hex-encoded SHA-256 fingerprint, the colons of the fingerprint are ignored
+func PinnedCACert(ctx context.Context, baseURL, fingerprint string) (*x509.Certificate, error) {
+	expected, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+	if err != nil || len(expected) != sha256.Size {
+		return nil, errors.New("invalid CA fingerprint")
+	}
+
+	// the server is not verified, the CA certificate is trusted only if it matches the pinned fingerprint
+	client := NewClient(baseURL, &tls.Config{
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS12,
+	})
+
+	caCerts, err := client.CACerts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range caCerts {
+		sum := sha256.Sum256(c.Raw)
+		if bytes.Equal(sum[:], expected) {
+			return c, nil
+		}
+	}
+
+	return nil, errors.New("no CA certificate matching the fingerprint")
+}
+
+// SimpleEnroll sends the CSR in DER format authenticated by the bootstrap credentials and returns the issued certificate
+func (c *Client) SimpleEnroll(ctx context.Context, csr []byte, credentials Credentials) (*x509.Certificate, error) {
+	r, err := c.newEnrollRequest(ctx, SimpleEnrollPath, csr)
+	if err != nil {
+		return nil, err
+	}
+
+	if credentials != nil {
+		credentials(r)
+	}
+
+	return c.doEnroll(r)
+}
+
+// SimpleReenroll sends the CSR in DER format authenticated by the current client certificate and returns the renewed certificate
+func (c *Client) SimpleReenroll(ctx context.Context, csr []byte) (*x509.Certificate, error) {
+	r, err := c.newEnrollRequest(ctx, SimpleReenrollPath, csr)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.doEnroll(r)
+}
+
+func (c *Client) newEnrollRequest(ctx context.Context, path string, csr []byte) (*http.Request, error) {
+	body := base64.StdEncoding.EncodeToString(csr)
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Header.Set("Content-Type", CSRContentType)
+	r.Header.Set("Content-Transfer-Encoding", "base64")
+	return r, nil
+}
+
+func (c *Client) doEnroll(r *http.Request) (*x509.Certificate, error) {
+	certs, err := c.do(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate in the enrollment response")
+	}
+
+	return certs[0], nil
+}
+
+// do sends the request and decodes the base64-encoded PKCS#7 certs-only response
+func (c *Client) do(r *http.Request) ([]*x509.Certificate, error) {
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("EST request failed with status %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	der, err := DecodeBase64(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode EST response: %w", err)
+	}
+
+	return cert.DecodePKCS7CertsOnly(der)
+}
+
+// DecodeBase64 decodes the base64-encoded EST message body, the line breaks are ignored
+func DecodeBase64(b []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(b)), ""))
+}
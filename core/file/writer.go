@@ -1,6 +1,10 @@
 package file
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
 
 func Write(path string, b []byte) error {
 	f, err := os.Create(path)
@@ -16,3 +20,38 @@ func Write(path string, b []byte) error {
 
 	return nil
 }
+
+// WriteAtomic writes the bytes to a temporary file next to the path and renames it to the path,
+// so readers of the path either see the previous content or the new one, never a partial write
+func WriteAtomic(path string, b []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf(".%s.*.tmp", filepath.Base(path)))
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath) // no-op after a successful rename
+
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmpPath, perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,49 @@
+package hmac
+
+import (
+	cryptoHMAC "crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidMAC = errors.New("invalid message authentication code")
+)
+
+// SignMAC signs the params by the symmetric key (HMAC-SHA256), e.g. by a session key
+func SignMAC(key []byte, params Params) string {
+	return SignMACMessage(key, params.String())
+}
+
+// ValidateMAC validates the HMAC-SHA256 signature of the params by the symmetric key in constant time
+func ValidateMAC(key []byte, signature string, params Params) error {
+	return ValidateMACMessage(key, signature, params.String())
+}
+
+// SignMACMessage signs the message by the symmetric key (HMAC-SHA256) and returns the base64-encoded signature
+func SignMACMessage(key []byte, message string) string {
+	return base64.StdEncoding.EncodeToString(mac(key, message))
+}
+
+// ValidateMACMessage validates the HMAC-SHA256 signature of the message by the symmetric key in constant time
+func ValidateMACMessage(key []byte, signature, message string) error {
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %s", err)
+	}
+
+	if !cryptoHMAC.Equal(signatureBytes, mac(key, message)) {
+		return ErrInvalidMAC
+	}
+
+	return nil
+}
+
+// mac returns the HMAC-SHA256 of the message
+func mac(key []byte, message string) []byte {
+	h := cryptoHMAC.New(sha256.New, key)
+	h.Write([]byte(message))
+	return h.Sum(nil)
+}
@@ -46,9 +46,10 @@ func ValidateSignature(cert *x509.Certificate, signature string, params Params)
 	return nil
 }
 
-func Sign(privateKey *rsa.PrivateKey, params Params) (string, error) {
+// Sign signs the params by the signer (RSA PKCS #1 v1.5 with SHA-256), the signer can be a private key or any signer backend
+func Sign(signer crypto.Signer, params Params) (string, error) {
 	strToSignHash := sha256.Sum256([]byte(params.String()))
-	sinatureBytes, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, strToSignHash[:])
+	sinatureBytes, err := signer.Sign(rand.Reader, strToSignHash[:], crypto.SHA256)
 	if err != nil {
 		return "", fmt.Errorf("error while signing: %s", err)
 
@@ -0,0 +1,52 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// SigningMethodRS256Signer signs RS256 tokens by a crypto.Signer instead of *rsa.PrivateKey,
+	// e.g. a signing agent or a PKCS#11 token, the tokens are verified the same as RS256
+	SigningMethodRS256Signer = &signingMethodSigner{}
+
+	ErrInvalidSigner = errors.New("key is not a crypto.Signer")
+)
+
+type signingMethodSigner struct{}
+
+// Alg implements jwt.SigningMethod
+func (m *signingMethodSigner) Alg() string {
+	return jwt.SigningMethodRS256.Alg()
+}
+
+// Verify implements jwt.SigningMethod
+func (m *signingMethodSigner) Verify(signingString, signature string, key interface{}) error {
+	return jwt.SigningMethodRS256.Verify(signingString, signature, key)
+}
+
+// Sign implements jwt.SigningMethod, the key must be a crypto.Signer of an RSA key
+func (m *signingMethodSigner) Sign(signingString string, key interface{}) (string, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return "", ErrInvalidSigner
+	}
+
+	hash := sha256.Sum256([]byte(signingString))
+	signature, err := signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Sign returns a new RS256 token of the claims signed by the signer
+func Sign(signer crypto.Signer, claims jwt.Claims) (string, error) {
+	return jwt.NewWithClaims(SigningMethodRS256Signer, claims).SignedString(signer)
+}
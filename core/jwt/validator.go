@@ -3,6 +3,7 @@ package jwt
 import (
 	"crypto/rsa"
 	"errors"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 )
@@ -20,7 +21,13 @@ func NewValidator(pubKey *rsa.PublicKey) Validator {
 }
 
 func (v Validator) Validate(tokenString string) (*jwt.Token, error) {
-	t, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+	return v.ValidateAt(tokenString, jwt.TimeFunc())
+}
+
+// ValidateAt validates the token like Validate, its exp, iat and nbf claims are checked at the time, e.g. of a captured request
+func (v Validator) ValidateAt(tokenString string, now time.Time) (*jwt.Token, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	t, err := parser.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		return v.publicKey, nil
 	})
 
@@ -33,5 +40,41 @@ func (v Validator) Validate(tokenString string) (*jwt.Token, error) {
 		return nil, ErrInvalidToken
 	}
 
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	err = validClaimsAt(claims, now)
+	if err != nil {
+		return nil, err
+	}
+
 	return t, nil
 }
+
+// validClaimsAt checks the time claims as jwt.MapClaims.Valid does at the current time, the error is a *jwt.ValidationError
+func validClaimsAt(claims jwt.MapClaims, now time.Time) error {
+	vErr := new(jwt.ValidationError)
+	unix := now.Unix()
+
+	if !claims.VerifyExpiresAt(unix, false) {
+		vErr.Inner = errors.New("Token is expired")
+		vErr.Errors |= jwt.ValidationErrorExpired
+	}
+
+	if !claims.VerifyIssuedAt(unix, false) {
+		vErr.Inner = errors.New("Token used before issued")
+		vErr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+
+	if !claims.VerifyNotBefore(unix, false) {
+		vErr.Inner = errors.New("Token is not valid yet")
+		vErr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+
+	if vErr.Errors != 0 {
+		return vErr
+	}
+	return nil
+}
@@ -2,8 +2,11 @@ package jwt
 
 import (
 	"encoding/base64"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt"
 	"github.com/theredrad/certauthz/core/key"
 )
 
@@ -46,3 +49,40 @@ func BenchmarkValidatorValidate4096KeySize(b *testing.B) {
 		validator.Validate(clientToken4096)
 	}
 }
+
+func TestValidatorValidateAt(t *testing.T) {
+	pubKey, err := key.DecodePublicKeyFromDER(publicKey2048Bytes)
+	if err != nil {
+		t.Fatalf("expected pub key, got err: %s", err)
+	}
+
+	validator := NewValidator(pubKey)
+
+	// the token is issued at 1707173830 and expires at 4860773830
+	tests := []struct {
+		name   string
+		at     time.Time
+		errors uint32
+	}{
+		{name: "valid", at: time.Unix(1707173830+3600, 0)},
+		{name: "expired", at: time.Unix(4860773830+1, 0), errors: jwt.ValidationErrorExpired},
+		{name: "before issued", at: time.Unix(1707173830-3600, 0), errors: jwt.ValidationErrorIssuedAt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.ValidateAt(clientToken2048, tt.at)
+			if tt.errors == 0 {
+				if err != nil {
+					t.Fatalf("expected valid token, got err: %s", err)
+				}
+				return
+			}
+
+			var validationErr *jwt.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Errors&tt.errors == 0 {
+				t.Fatalf("expected validation error %d, got %v", tt.errors, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package key
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+var (
+	ErrUnknownKeyFormat   = errors.New("key is not in PKCS#8, PKCS#1, SEC1 or PKIX format")
+	ErrLegacyPEMEncrypted = errors.New("legacy PEM encryption is not supported, convert the private key to an encrypted PKCS#8 key")
+)
+
+// the PEM block types of the private and the public keys
+const (
+	pemPrivateKey          = "PRIVATE KEY"
+	pemEncryptedPrivateKey = "ENCRYPTED PRIVATE KEY"
+	pemRSAPrivateKey       = "RSA PRIVATE KEY"
+	pemECPrivateKey        = "EC PRIVATE KEY"
+	pemPublicKey           = "PUBLIC KEY"
+	pemRSAPublicKey        = "RSA PUBLIC KEY"
+)
+
+// findPEMBlock returns the first PEM block of the types, the other blocks are skipped, e.g. the EC PARAMETERS block before an EC private key
+func findPEMBlock(b []byte, types ...string) (*pem.Block, error) {
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM block of %v is found", types)
+		}
+
+		for _, t := range types {
+			if block.Type == t {
+				return block, nil
+			}
+		}
+	}
+}
+
+// privateKeyDER returns the DER bytes of the private key in PEM or DER format and reports whether it's an encrypted PKCS#8 key
+func privateKeyDER(b []byte) ([]byte, bool, error) {
+	if !cert.IsPEM(b) {
+		return b, IsEncryptedPKCS8(b), nil
+	}
+
+	block, err := findPEMBlock(b, pemPrivateKey, pemEncryptedPrivateKey, pemRSAPrivateKey, pemECPrivateKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if _, ok := block.Headers["DEK-Info"]; ok {
+		return nil, false, ErrLegacyPEMEncrypted
+	}
+
+	return block.Bytes, block.Type == pemEncryptedPrivateKey, nil
+}
+
+// IsEncryptedPrivateKey reports whether the private key in PEM or DER format is an encrypted PKCS#8 key
+func IsEncryptedPrivateKey(b []byte) bool {
+	_, encrypted, err := privateKeyDER(b)
+	return err == nil && encrypted
+}
+
+// DecodePrivateKey decodes the private key in PEM or DER format by its PKCS#8, PKCS#1 (RSA) or SEC1 (EC) encoding,
+// an encrypted PKCS#8 key is decrypted by the passphrase, ErrPassphraseRequired is returned if the passphrase is empty
+func DecodePrivateKey(b, passphrase []byte) (crypto.Signer, error) {
+	der, encrypted, err := privateKeyDER(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypted {
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+
+		der, err = DecryptPKCS8(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parsePrivateKeyDER(der)
+}
+
+// parsePrivateKeyDER parses the DER bytes of the private key by the PKCS#8, PKCS#1 or SEC1 encoding
+func parsePrivateKeyDER(der []byte) (crypto.Signer, error) {
+	if pk, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		signer, ok := pk.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", pk)
+		}
+		return signer, nil
+	}
+
+	if pk, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return pk, nil
+	}
+
+	if pk, err := x509.ParseECPrivateKey(der); err == nil {
+		return pk, nil
+	}
+
+	return nil, ErrUnknownKeyFormat
+}
+
+// ReadPrivateKeyFromFile reads the private key file in PEM or DER format, the encrypted private key is decrypted by the passphrase function
+func ReadPrivateKeyFromFile(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var passphrase []byte
+	if IsEncryptedPrivateKey(b) {
+		var ok bool
+		passphrase, ok, err = passphraseFor(path)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrPassphraseRequired, path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pk, err := DecodePrivateKey(b, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private key %s: %w", path, err)
+	}
+
+	return pk, nil
+}
+
+// ReadRSAPrivateKeyFromFile reads the RSA private key file in PEM or DER format, the encrypted private key is decrypted by the passphrase function
+func ReadRSAPrivateKeyFromFile(path string) (*rsa.PrivateKey, error) {
+	pk, err := ReadPrivateKeyFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaPK, ok := pk.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid RSA private key, got %s", path, KeyType(pk.Public()))
+	}
+
+	return rsaPK, nil
+}
+
+// ReadRSAPrivateKeyFromDERFile reads the PKCS#8 private key file, the encrypted private key is decrypted by the passphrase function
+//
+// Deprecated: use ReadRSAPrivateKeyFromFile, which reads the private key in PEM or DER format
+func ReadRSAPrivateKeyFromDERFile(path string) (*rsa.PrivateKey, error) {
+	return ReadRSAPrivateKeyFromFile(path)
+}
+
+// DecodePublicKey decodes the public key in PEM or DER format by its PKIX or PKCS#1 (RSA) encoding, or the public key of a certificate
+func DecodePublicKey(b []byte) (crypto.PublicKey, error) {
+	der := b
+	if cert.IsPEM(b) {
+		block, err := findPEMBlock(b, pemPublicKey, pemRSAPublicKey, cert.PEMCertificate)
+		if err != nil {
+			return nil, err
+		}
+		der = block.Bytes
+	}
+
+	if pk, err := x509.ParsePKIXPublicKey(der); err == nil {
+		return pk, nil
+	}
+
+	if pk, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return pk, nil
+	}
+
+	if c, err := x509.ParseCertificate(der); err == nil {
+		return c.PublicKey, nil
+	}
+
+	return nil, ErrUnknownKeyFormat
+}
+
+// ReadPublicKeyFromFile reads the public key file in PEM or DER format
+func ReadPublicKeyFromFile(path string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pk, err := DecodePublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key %s: %w", path, err)
+	}
+
+	return pk, nil
+}
+
+// ReadRSAPublicKeyFromFile reads the RSA public key file in PEM or DER format
+func ReadRSAPublicKeyFromFile(path string) (*rsa.PublicKey, error) {
+	pk, err := ReadPublicKeyFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaPK, ok := pk.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: invalid RSA public key, got %s", path, KeyType(pk))
+	}
+
+	return rsaPK, nil
+}
+
+// ReadRSAPublicKeyFromDERFile reads the RSA public key file in DER format
+//
+// Deprecated: use ReadRSAPublicKeyFromFile, which reads the public key in PEM or DER format
+func ReadRSAPublicKeyFromDERFile(path string) (*rsa.PublicKey, error) {
+	return ReadRSAPublicKeyFromFile(path)
+}
+
+// KeyType returns the type and the size of the public key, e.g. RSA 2048 or EC P-256
+func KeyType(pk crypto.PublicKey) string {
+	switch k := pk.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("EC %s", k.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", pk)
+	}
+}
@@ -0,0 +1,167 @@
+package key
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+func TestDecodePrivateKeyFormats(t *testing.T) {
+	rsaKey, err := GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8 := func(k interface{}) []byte {
+		b, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		b    []byte
+		want string
+	}{
+		{"PKCS#8 DER", pkcs8(rsaKey), "RSA 2048"},
+		{"PKCS#8 PEM", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(ecKey)}), "EC P-256"},
+		{"PKCS#8 Ed25519", pkcs8(edKey), "Ed25519"},
+		{"PKCS#1 DER", x509.MarshalPKCS1PrivateKey(rsaKey), "RSA 2048"},
+		{"PKCS#1 PEM", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), "RSA 2048"},
+		{"SEC1 DER", ecDER, "EC P-256"},
+		// openssl ecparam -genkey writes the EC PARAMETERS block before the private key
+		{"SEC1 PEM", append(pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{0x06, 0x08, 0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER})...), "EC P-256"},
+	}
+
+	for _, tt := range tests {
+		pk, err := DecodePrivateKey(tt.b, nil)
+		if err != nil {
+			t.Errorf("%s: expected private key, got err: %s", tt.name, err)
+			continue
+		}
+
+		if got := KeyType(pk.Public()); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+
+	_, err = DecodePrivateKey([]byte("not a key"), nil)
+	if !errors.Is(err, ErrUnknownKeyFormat) {
+		t.Errorf("expected ErrUnknownKeyFormat, got %v", err)
+	}
+
+	legacy := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": "AES-256-CBC,00"}, Bytes: []byte{0}})
+	_, err = DecodePrivateKey(legacy, []byte("secret"))
+	if !errors.Is(err, ErrLegacyPEMEncrypted) {
+		t.Errorf("expected ErrLegacyPEMEncrypted, got %v", err)
+	}
+}
+
+func TestEncodePrivateKeyToPEMRoundTrip(t *testing.T) {
+	pk, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plain bytes.Buffer
+	err = EncodePrivateKeyToPEM(&plain, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if IsEncryptedPrivateKey(plain.Bytes()) {
+		t.Fatal("expected an unencrypted private key")
+	}
+
+	decodedPlain, err := DecodePrivateKey(plain.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pk.Equal(decodedPlain) {
+		t.Fatal("decoded private key does not match")
+	}
+
+	var buf bytes.Buffer
+	err = EncodeEncryptedPrivateKeyToPEM(&buf, pk, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsEncryptedPrivateKey(buf.Bytes()) {
+		t.Fatal("expected an encrypted private key")
+	}
+
+	_, err = DecodePrivateKey(buf.Bytes(), nil)
+	if !errors.Is(err, ErrPassphraseRequired) {
+		t.Fatalf("expected ErrPassphraseRequired, got %v", err)
+	}
+
+	decoded, err := DecodePrivateKey(buf.Bytes(), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pk.Equal(decoded) {
+		t.Fatal("decoded private key does not match")
+	}
+}
+
+func TestDecodePublicKeyFormats(t *testing.T) {
+	pk, err := GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pkix bytes.Buffer
+	err = EncodePublicKeyToPEM(&pkix, &pk.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var der bytes.Buffer
+	err = EncodePublicKeyToDER(&der, &pk.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, b := range map[string][]byte{
+		"PKIX PEM":   pkix.Bytes(),
+		"PKIX DER":   der.Bytes(),
+		"PKCS#1 DER": x509.MarshalPKCS1PublicKey(&pk.PublicKey),
+		"PKCS#1 PEM": pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&pk.PublicKey)}),
+	} {
+		decoded, err := DecodePublicKey(b)
+		if err != nil {
+			t.Errorf("%s: expected public key, got err: %s", name, err)
+			continue
+		}
+
+		if !pk.PublicKey.Equal(decoded) {
+			t.Errorf("%s: decoded public key does not match", name)
+		}
+	}
+}
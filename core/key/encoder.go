@@ -1,33 +1,54 @@
 package key
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"errors"
 	"io"
-	"io/ioutil"
 )
 
-func EncodePublicKeyToPEM(w io.Writer, pk *rsa.PublicKey) error {
+// EncodePublicKeyToPEM writes the public key in PKIX PEM format
+func EncodePublicKeyToPEM(w io.Writer, pk crypto.PublicKey) error {
 	pkBytes, err := x509.MarshalPKIXPublicKey(pk)
 	if err != nil {
 		return err
 	}
 
 	pemBlock := &pem.Block{
-		Type:  "PUBLIC KEY",
+		Type:  pemPublicKey,
 		Bytes: pkBytes,
 	}
 
 	return pem.Encode(w, pemBlock)
 }
 
-func EncodePrivateKeyToPEM(w io.Writer, pk *rsa.PrivateKey) error {
+// EncodePrivateKeyToPEM writes the private key in unencrypted PKCS#8 PEM format
+func EncodePrivateKeyToPEM(w io.Writer, pk crypto.Signer) error {
+	return EncodeEncryptedPrivateKeyToPEM(w, pk, nil)
+}
+
+// EncodeEncryptedPrivateKeyToPEM writes the private key in PKCS#8 PEM format, the private key is encrypted if the passphrase is not empty
+func EncodeEncryptedPrivateKeyToPEM(w io.Writer, pk crypto.Signer, passphrase []byte) error {
+	pkBytes, err := x509.MarshalPKCS8PrivateKey(pk)
+	if err != nil {
+		return err
+	}
+
 	pkBlock := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(pk),
+		Type:  pemPrivateKey,
+		Bytes: pkBytes,
 	}
+
+	if len(passphrase) > 0 {
+		pkBlock.Type = pemEncryptedPrivateKey
+		pkBlock.Bytes, err = EncryptPKCS8(pkBytes, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
 	return pem.Encode(w, pkBlock)
 }
 
@@ -85,31 +106,3 @@ func DecodePublicKeyFromDER(pkBytes []byte) (*rsa.PublicKey, error) {
 
 	return rsaPK, nil
 }
-
-func ReadRSAPrivateKeyFromDERFile(path string) (*rsa.PrivateKey, error) {
-	pkBytes, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	pk, err := DecodePrivateKeyFromDER(pkBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	return pk, nil
-}
-
-func ReadRSAPublicKeyFromDERFile(path string) (*rsa.PublicKey, error) {
-	pkBytes, err := ioutil.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	pk, err := DecodePublicKeyFromDER(pkBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	return pk, nil
-}
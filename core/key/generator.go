@@ -1,10 +1,13 @@
 package key
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 	"os"
+
+	"github.com/theredrad/certauthz/core/file"
 )
 
 func GeneratePrivateKey(size int) (*rsa.PrivateKey, error) {
@@ -22,32 +25,61 @@ func GenerateKeyPair(path string, size int) (*rsa.PrivateKey, error) {
 		return nil, err
 	}
 
-	err = os.MkdirAll(path, 0755)
+	err = WriteKeyPair(path, privateKey)
 	if err != nil {
 		return nil, err
 	}
 
-	privateKeyFile, err := os.Create(fmt.Sprintf("%s/%s", path, "private.key"))
+	return privateKey, nil
+}
+
+// WriteKeyPair writes the private key and its public key in DER format to the path directory
+func WriteKeyPair(path string, privateKey *rsa.PrivateKey) error {
+	err := os.MkdirAll(path, 0755)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer privateKeyFile.Close()
 
-	err = EncodePrivateKeyToDER(privateKeyFile, privateKey)
+	err = WritePrivateKey(fmt.Sprintf("%s/%s", path, "private.key"), privateKey)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	publicKeyFile, err := os.Create(fmt.Sprintf("%s/%s", path, "public.pub"))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer publicKeyFile.Close()
 
 	err = EncodePublicKeyToDER(publicKeyFile, &privateKey.PublicKey)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return privateKey, nil
+	return nil
+}
+
+// WritePrivateKey writes the private key in PKCS#8 DER format to the file with 0600 permission
+// the private key is encrypted if the passphrase function is set
+func WritePrivateKey(path string, privateKey *rsa.PrivateKey) error {
+	var buf bytes.Buffer
+	err := EncodePrivateKeyToDER(&buf, privateKey)
+	if err != nil {
+		return err
+	}
+
+	pkBytes := buf.Bytes()
+
+	passphrase, ok, err := passphraseFor(path)
+	if err != nil {
+		return err
+	}
+	if ok {
+		pkBytes, err = EncryptPKCS8(pkBytes, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+
+	return file.WriteAtomic(path, pkBytes, 0600)
 }
@@ -0,0 +1,114 @@
+package key
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a JSON web key of a public key (RFC 7517), the private parameters are never exported
+type JWK struct {
+	Kty     string   `json:"kty"`
+	Kid     string   `json:"kid,omitempty"`
+	Use     string   `json:"use,omitempty"`
+	Alg     string   `json:"alg,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+}
+
+// JWKS is a JSON web key set
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// NewJWK returns the JWK of the RSA, EC or Ed25519 public key, the signature use and the default algorithm of the key are set
+// and the key ID is the JWK SHA-256 thumbprint (RFC 7638)
+func NewJWK(pk crypto.PublicKey) (*JWK, error) {
+	var k *JWK
+
+	switch pub := pk.(type) {
+	case *rsa.PublicKey:
+		k = &JWK{
+			Kty: "RSA",
+			Alg: "RS256",
+			N:   encodeJWKInt(pub.N.Bytes()),
+			E:   encodeJWKInt(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		alg, ok := map[string]string{"P-256": "ES256", "P-384": "ES384", "P-521": "ES512"}[pub.Curve.Params().Name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", pub.Curve.Params().Name)
+		}
+
+		// the coordinates are padded to the curve size (RFC 7518 section 6.2.1.2)
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		k = &JWK{
+			Kty: "EC",
+			Alg: alg,
+			Crv: pub.Curve.Params().Name,
+			X:   encodeJWKInt(pub.X.FillBytes(make([]byte, size))),
+			Y:   encodeJWKInt(pub.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		k = &JWK{
+			Kty: "OKP",
+			Alg: "EdDSA",
+			Crv: "Ed25519",
+			X:   encodeJWKInt(pub),
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pk)
+	}
+
+	k.Use = "sig"
+	k.Kid = k.Thumbprint()
+
+	return k, nil
+}
+
+// SetCertificates sets the certificate chain of the key, the leaf is the first, and the leaf SHA-256 thumbprint
+func (k *JWK) SetCertificates(certs ...*x509.Certificate) {
+	k.X5c = nil
+	k.X5tS256 = ""
+	for _, c := range certs {
+		// the x5c values are standard base64, not base64url (RFC 7517 section 4.7)
+		k.X5c = append(k.X5c, base64.StdEncoding.EncodeToString(c.Raw))
+	}
+
+	if len(certs) > 0 {
+		digest := sha256.Sum256(certs[0].Raw)
+		k.X5tS256 = base64.RawURLEncoding.EncodeToString(digest[:])
+	}
+}
+
+// Thumbprint returns the base64url-encoded JWK SHA-256 thumbprint of the required members in lexicographic order (RFC 7638)
+func (k *JWK) Thumbprint() string {
+	var canonical string
+	switch k.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	default:
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, k.Crv, k.Kty, k.X, k.Y)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// encodeJWKInt encodes the big-endian bytes in base64url without padding
+func encodeJWKInt(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
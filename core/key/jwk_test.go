@@ -0,0 +1,64 @@
+package key
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+func TestJWKThumbprint(t *testing.T) {
+	// the RSA key example of RFC 7638 section 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := NewJWK(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.Kid != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("unexpected thumbprint %s", k.Kid)
+	}
+
+	if k.Alg != "RS256" || k.E != "AQAB" {
+		t.Errorf("unexpected alg %s or e %s", k.Alg, k.E)
+	}
+}
+
+func TestNewJWK(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := NewJWK(&ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, _ := base64.RawURLEncoding.DecodeString(k.X)
+	if k.Kty != "EC" || k.Crv != "P-521" || k.Alg != "ES512" || len(x) != 66 {
+		t.Errorf("unexpected EC JWK %+v", k)
+	}
+
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err = NewJWK(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if k.Kty != "OKP" || k.Crv != "Ed25519" || k.Alg != "EdDSA" || k.Y != "" {
+		t.Errorf("unexpected Ed25519 JWK %+v", k)
+	}
+}
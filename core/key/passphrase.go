@@ -0,0 +1,124 @@
+package key
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+var (
+	ErrPassphraseRequired = errors.New("private key is encrypted, a passphrase is required")
+
+	passphraseMu   sync.RWMutex
+	passphraseFunc PassphraseFunc
+)
+
+// PassphraseFunc returns the passphrase of the private key file
+type PassphraseFunc func(path string) ([]byte, error)
+
+// SetPassphraseFunc sets the passphrase function of the private key files
+// the written private keys are encrypted by the passphrase and the encrypted private keys are decrypted transparently on read
+// the private keys are written unencrypted if it's nil
+func SetPassphraseFunc(f PassphraseFunc) {
+	passphraseMu.Lock()
+	defer passphraseMu.Unlock()
+	passphraseFunc = f
+}
+
+// NewPassphraseFunc returns a passphrase function by the first configured source: the file, the environment variable or the terminal prompt
+// it returns nil if no source is configured
+func NewPassphraseFunc(filePath, envName string, prompt bool) PassphraseFunc {
+	switch {
+	case filePath != "":
+		return PassphraseFromFile(filePath)
+	case envName != "":
+		return PassphraseFromEnv(envName)
+	case prompt:
+		return PassphraseFromPrompt()
+	default:
+		return nil
+	}
+}
+
+// PassphraseFromEnv returns a passphrase function reading the passphrase from the environment variable
+func PassphraseFromEnv(name string) PassphraseFunc {
+	return func(string) ([]byte, error) {
+		passphrase := os.Getenv(name)
+		if passphrase == "" {
+			return nil, fmt.Errorf("%w: environment variable %s is empty", ErrPassphraseRequired, name)
+		}
+		return []byte(passphrase), nil
+	}
+}
+
+// PassphraseFromFile returns a passphrase function reading the passphrase from the file, the trailing new line is trimmed
+func PassphraseFromFile(filePath string) PassphraseFunc {
+	return func(string) ([]byte, error) {
+		b, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		passphrase := bytes.TrimRight(b, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("%w: passphrase file %s is empty", ErrPassphraseRequired, filePath)
+		}
+		return passphrase, nil
+	}
+}
+
+// PassphraseFromPrompt returns a passphrase function prompting for the passphrase on the terminal
+// the passphrase is asked once per private key file, e.g. the server certificate reload does not prompt again
+func PassphraseFromPrompt() PassphraseFunc {
+	var (
+		mu          sync.Mutex
+		passphrases = make(map[string][]byte)
+	)
+
+	return func(path string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if passphrase, ok := passphrases[path]; ok {
+			return passphrase, nil
+		}
+
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to open the terminal: %s", ErrPassphraseRequired, err)
+		}
+		defer tty.Close()
+
+		fmt.Fprintf(tty, "Enter passphrase for %s: ", path)
+		passphrase, err := term.ReadPassword(int(tty.Fd()))
+		fmt.Fprintln(tty)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+
+		passphrases[path] = passphrase
+		return passphrase, nil
+	}
+}
+
+// passphraseFor returns the passphrase of the private key file, ok is false if the passphrase function is not set
+func passphraseFor(path string) (p []byte, ok bool, err error) {
+	passphraseMu.RLock()
+	f := passphraseFunc
+	passphraseMu.RUnlock()
+
+	if f == nil {
+		return nil, false, nil
+	}
+
+	p, err = f(path)
+	return p, true, err
+}
@@ -0,0 +1,344 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// ContentType is the content type of the text exposition format
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+var (
+	// DefaultBuckets are the histogram buckets in seconds, suitable for the signature and certificate verification latency
+	DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+)
+
+// Registry holds the metrics and writes them in the Prometheus text exposition format
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+}
+
+// family is a metric with its label values
+type family interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns a new instance of Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounterVec registers a new counter with the label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, typeCounter, labels)}
+	r.register(c)
+	return c
+}
+
+// NewGaugeVec registers a new gauge with the label names
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, typeGauge, labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogramVec registers a new histogram with the upper bounds of the buckets and the label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+
+	h := &HistogramVec{vec: newVec(name, help, typeHistogram, labels), buckets: b}
+	r.register(h)
+	return h
+}
+
+// register adds the family to the registry, a duplicated name is a programming error
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.families {
+		if existing.name() == f.name() {
+			panic(fmt.Sprintf("metrics: duplicated metric %q", f.name()))
+		}
+	}
+
+	r.families = append(r.families, f)
+}
+
+// Write writes all the metrics in the text exposition format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP implements http.Handler to serve the metrics, e.g. on /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	r.Write(w)
+}
+
+// vec is the label values of a metric family
+type vec struct {
+	metricName string
+	help       string
+	typ        string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a metric of the label values
+type series struct {
+	labelValues []string
+
+	value float64
+	fn    func() float64
+
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+// newVec returns a new instance of vec
+func newVec(name, help, typ string, labels []string) vec {
+	return vec{
+		metricName: name,
+		help:       help,
+		typ:        typ,
+		labels:     labels,
+		series:     make(map[string]*series),
+	}
+}
+
+func (v *vec) name() string {
+	return v.metricName
+}
+
+// with returns the series of the label values, it's created if it doesn't exist, the vec must be locked
+func (v *vec) with(labelValues []string) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %q expects %d label values, got %d", v.metricName, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+// sorted returns the series sorted by the label values, the vec must be locked
+func (v *vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	s := make([]*series, 0, len(keys))
+	for _, key := range keys {
+		s = append(s, v.series[key])
+	}
+	return s
+}
+
+// writeHeader writes the help and the type lines
+func (v *vec) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", v.metricName, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.metricName, v.typ)
+}
+
+// labelPairs returns the labels of the series in the exposition format, e.g. {method="jwt",code="expired_token"}
+func (v *vec) labelPairs(labelValues []string, extra ...string) string {
+	pairs := make([]string, 0, len(labelValues)+len(extra)/2)
+	for i, value := range labelValues {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", v.labels[i], escapeLabelValue(value)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extra[i], escapeLabelValue(extra[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a counter partitioned by the label values
+type CounterVec struct {
+	vec
+}
+
+// Inc increments the counter of the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the delta to the counter of the label values, a negative delta is ignored
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if c == nil || delta < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.with(labelValues).value += delta
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w)
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, c.labelPairs(s.labelValues), formatFloat(s.value))
+	}
+}
+
+// GaugeVec is a gauge partitioned by the label values
+type GaugeVec struct {
+	vec
+}
+
+// Set sets the gauge of the label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s := g.with(labelValues)
+	s.value, s.fn = value, nil
+}
+
+// SetFunc sets the function that the gauge of the label values is read from on every write, e.g. the days to a certificate expiry
+func (g *GaugeVec) SetFunc(fn func() float64, labelValues ...string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.with(labelValues).fn = fn
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.writeHeader(w)
+	for _, s := range g.sorted() {
+		value := s.value
+		if s.fn != nil {
+			value = s.fn()
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, g.labelPairs(s.labelValues), formatFloat(value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by the label values
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// Observe adds the value to the histogram of the label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.with(labelValues)
+	if s.bucketCounts == nil {
+		s.bucketCounts = make([]uint64, len(h.buckets))
+	}
+
+	// the buckets are written cumulatively, the value is counted in the first bucket it fits only
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.buckets) {
+		s.bucketCounts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w)
+	for _, s := range h.sorted() {
+		var cumulative uint64
+		for i, upperBound := range h.buckets {
+			cumulative += s.bucketCounts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(s.labelValues, "le", formatFloat(upperBound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labelPairs(s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labelPairs(s.labelValues), s.count)
+	}
+}
+
+// formatFloat formats the value in the exposition format
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// escapeLabelValue escapes the backslash, the double-quote and the line feed of a label value
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// escapeHelp escapes the backslash and the line feed of a help text
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
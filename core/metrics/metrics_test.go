@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+
+	counter := r.NewCounterVec("auth_requests_total", "Authentication decisions.", "method", "outcome")
+	counter.Inc("jwt", "deny")
+	counter.Inc("certificate", "allow")
+	counter.Add(2, "certificate", "allow")
+
+	histogram := r.NewHistogramVec("verify_duration_seconds", "Verification latency.", []float64{0.01, 0.1}, "method")
+	histogram.Observe(0.005, "jwt")
+	histogram.Observe(0.05, "jwt")
+	histogram.Observe(2, "jwt")
+
+	gauge := r.NewGaugeVec("certificate_expiry_days", "Days to \"expiry\".", "certificate")
+	gauge.SetFunc(func() float64 { return 12.5 }, "ca")
+
+	var b bytes.Buffer
+	err := r.Write(&b)
+	if err != nil {
+		t.Fatalf("expected metrics, got err: %s", err)
+	}
+
+	expected := `# HELP auth_requests_total Authentication decisions.
+# TYPE auth_requests_total counter
+auth_requests_total{method="certificate",outcome="allow"} 3
+auth_requests_total{method="jwt",outcome="deny"} 1
+# HELP verify_duration_seconds Verification latency.
+# TYPE verify_duration_seconds histogram
+verify_duration_seconds_bucket{method="jwt",le="0.01"} 1
+verify_duration_seconds_bucket{method="jwt",le="0.1"} 2
+verify_duration_seconds_bucket{method="jwt",le="+Inf"} 3
+verify_duration_seconds_sum{method="jwt"} 2.055
+verify_duration_seconds_count{method="jwt"} 3
+# HELP certificate_expiry_days Days to "expiry".
+# TYPE certificate_expiry_days gauge
+certificate_expiry_days{certificate="ca"} 12.5
+`
+
+	if b.String() != expected {
+		t.Errorf("unexpected exposition:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+}
@@ -0,0 +1,177 @@
+package renewal
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+)
+
+const (
+	// DefaultFraction renews the certificate when two thirds of its lifetime is passed
+	DefaultFraction = 2.0 / 3.0
+
+	// DefaultCheckInterval is the maximum time between two checks of the certificate file
+	DefaultCheckInterval = time.Minute
+
+	// certificateFilePerm is the permission of the renewed certificate file
+	certificateFilePerm = 0644
+)
+
+// Config is the renewal agent configuration
+type Config struct {
+	// CertPath is the path of the watched certificate in DER format
+	CertPath string
+
+	// Fraction of the certificate lifetime after which the renewal is requested, e.g. 0.5 renews a 24h certificate after 12h
+	Fraction float64
+
+	// CheckInterval is the maximum time between two checks, the certificate file is read again on every check
+	CheckInterval time.Duration
+
+	// RetryInterval is the wait time after a failed renewal, CheckInterval is used if it is zero
+	RetryInterval time.Duration
+
+	Issuer    Issuer
+	Notifiers []Notifier
+
+	// OnError is called with the errors of the run loop, the loop continues after the error
+	OnError func(err error)
+}
+
+// Agent watches a certificate file and renews it when the configured fraction of its lifetime is passed
+// the renewed certificate replaces the file atomically (file swap) and the notifiers are called afterward
+type Agent struct {
+	cfg Config
+	now func() time.Time
+}
+
+// NewAgent validates the configuration and returns a new instance of Agent
+func NewAgent(cfg Config) (*Agent, error) {
+	if cfg.CertPath == "" {
+		return nil, errors.New("certificate path is required")
+	}
+
+	if cfg.Issuer == nil {
+		return nil, errors.New("issuer is required")
+	}
+
+	if cfg.Fraction == 0 {
+		cfg.Fraction = DefaultFraction
+	}
+	if cfg.Fraction <= 0 || cfg.Fraction >= 1 {
+		return nil, fmt.Errorf("renewal fraction must be between 0 and 1, got %v", cfg.Fraction)
+	}
+
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = DefaultCheckInterval
+	}
+
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = cfg.CheckInterval
+	}
+
+	return &Agent{
+		cfg: cfg,
+		now: time.Now,
+	}, nil
+}
+
+// RenewalTime returns the time that the certificate must be renewed at based on the lifetime fraction
+func RenewalTime(c *x509.Certificate, fraction float64) time.Time {
+	lifetime := c.NotAfter.Sub(c.NotBefore)
+	return c.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+}
+
+// Run checks the certificate until the context is done and renews it when it is due
+func (a *Agent) Run(ctx context.Context) error {
+	for {
+		wait := a.cfg.CheckInterval
+
+		next, err := a.RenewIfDue(ctx)
+		if err != nil {
+			if a.cfg.OnError != nil {
+				a.cfg.OnError(err)
+			}
+			wait = a.cfg.RetryInterval
+		} else if untilNext := next.Sub(a.now()); untilNext < wait {
+			wait = untilNext
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// RenewIfDue reads the certificate and renews it if its renewal time is passed
+// it returns the renewal time of the current certificate (the renewed one if it's renewed)
+func (a *Agent) RenewIfDue(ctx context.Context) (time.Time, error) {
+	current, err := cert.ReadFromFile(a.cfg.CertPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	renewAt := RenewalTime(current, a.cfg.Fraction)
+	if a.now().Before(renewAt) {
+		return renewAt, nil
+	}
+
+	renewed, err := a.Renew(ctx, current)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return RenewalTime(renewed, a.cfg.Fraction), nil
+}
+
+// Renew requests a renewed certificate from the issuer, replaces the certificate file and notifies the consumers
+func (a *Agent) Renew(ctx context.Context, current *x509.Certificate) (*x509.Certificate, error) {
+	certBytes, err := a.cfg.Issuer.Renew(ctx, current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew certificate: %w", err)
+	}
+
+	renewed, err := cert.DecodeFromDERBytes(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode renewed certificate: %w", err)
+	}
+
+	// a renewed certificate for another key would not match the private key of the consumers
+	if !publicKeyEqual(current, renewed) {
+		return nil, errors.New("renewed certificate public key does not match the current certificate")
+	}
+
+	err = file.WriteAtomic(a.cfg.CertPath, certBytes, certificateFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write renewed certificate: %w", err)
+	}
+
+	// all the notifiers are called even if one of them fails, the first error is returned
+	var notifyErr error
+	for _, n := range a.cfg.Notifiers {
+		if err := n.Notify(a.cfg.CertPath, renewed); err != nil && notifyErr == nil {
+			notifyErr = err
+		}
+	}
+	if notifyErr != nil {
+		return renewed, fmt.Errorf("certificate is renewed but notification failed: %w", notifyErr)
+	}
+
+	return renewed, nil
+}
+
+// publicKeyEqual reports whether both certificates have the same public key
+func publicKeyEqual(a, b *x509.Certificate) bool {
+	key, ok := a.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b.PublicKey)
+}
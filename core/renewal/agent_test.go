@@ -0,0 +1,100 @@
+package renewal
+
+import (
+	"context"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+func TestAgentRenewIfDue(t *testing.T) {
+	caPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected ca private key, got err: %s", err)
+	}
+
+	caBytes, err := cert.NewCA(caPrivateKey, 1, "Test CA", "Test Org", time.Hour)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	caCert, err := cert.DecodeFromDERBytes(caBytes)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientBytes, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read bob.user.write", []string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	certPath := filepath.Join(t.TempDir(), "certificate.crt")
+	err = file.Write(certPath, clientBytes)
+	if err != nil {
+		t.Fatalf("expected certificate file, got err: %s", err)
+	}
+
+	var notified *x509.Certificate
+	agent, err := NewAgent(Config{
+		CertPath: certPath,
+		Fraction: 0.5,
+		Issuer: &DirectIssuer{
+			CACert:     caCert,
+			CASigner:   caPrivateKey,
+			Expiration: 24 * time.Hour,
+		},
+		Notifiers: []Notifier{NotifierFunc(func(_ string, renewed *x509.Certificate) error {
+			notified = renewed
+			return nil
+		})},
+	})
+	if err != nil {
+		t.Fatalf("expected agent, got err: %s", err)
+	}
+
+	next, err := agent.RenewIfDue(context.Background())
+	if err != nil {
+		t.Fatalf("expected no renewal, got err: %s", err)
+	}
+	if notified != nil {
+		t.Fatalf("expected no renewal before half of the lifetime")
+	}
+
+	// move the clock to the renewal time of the current certificate
+	agent.now = func() time.Time { return next }
+
+	_, err = agent.RenewIfDue(context.Background())
+	if err != nil {
+		t.Fatalf("expected renewal, got err: %s", err)
+	}
+	if notified == nil {
+		t.Fatalf("expected the notifier to be called")
+	}
+
+	renewed, err := cert.ReadFromDERFile(certPath)
+	if err != nil {
+		t.Fatalf("expected renewed certificate, got err: %s", err)
+	}
+
+	if renewed.NotAfter.Sub(renewed.NotBefore) != 24*time.Hour {
+		t.Errorf("expected 24h lifetime, got %s", renewed.NotAfter.Sub(renewed.NotBefore))
+	}
+
+	if got := cert.RawScopesFromCertificate(renewed); got != "bob.user.read bob.user.write" {
+		t.Errorf("expected scopes to be kept, got %q", got)
+	}
+
+	if err := cert.NewValidator(caCert).Validate(renewed); err != nil {
+		t.Errorf("expected valid renewed certificate, got err: %s", err)
+	}
+}
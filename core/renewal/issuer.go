@@ -0,0 +1,146 @@
+package renewal
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+const (
+	// csrContentType is the media type of a DER-encoded certificate signing request
+	csrContentType = "application/pkcs10"
+
+	// certContentType is the media type of a DER-encoded certificate
+	certContentType = "application/pkix-cert"
+
+	// maxCertificateSize limits the renewed certificate response body
+	maxCertificateSize = 64 << 10
+)
+
+// Issuer issues a renewed certificate in DER format for the current certificate
+type Issuer interface {
+	Renew(ctx context.Context, current *x509.Certificate) ([]byte, error)
+}
+
+// DirectIssuer renews certificates by signing them with the CA signer (e.g. the CA private key) directly
+// it keeps the client public key, common name, organization, scopes and DNS names of the current certificate
+// the renewed certificate is appended to the issuance log if it's set
+type DirectIssuer struct {
+	CACert      *x509.Certificate
+	CASigner    crypto.Signer
+	Expiration  time.Duration
+	IssuanceLog cert.IssuanceLog
+}
+
+// Renew implements Issuer
+func (i *DirectIssuer) Renew(_ context.Context, current *x509.Certificate) ([]byte, error) {
+	serialNumber, err := cert.NewSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	var org string
+	if len(current.Subject.Organization) > 0 {
+		org = current.Subject.Organization[0]
+	}
+
+	certBytes, err := cert.NewCert(
+		i.CACert,
+		current.PublicKey,
+		i.CASigner,
+		serialNumber,
+		current.Subject.CommonName,
+		org,
+		cert.RawScopesFromCertificate(current),
+		current.DNSNames,
+		i.Expiration,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.IssuanceLog != nil {
+		err = i.IssuanceLog.Append(certBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to append the certificate to the issuance log: %w", err)
+		}
+	}
+
+	return certBytes, nil
+}
+
+// CSRIssuer renews certificates by sending a certificate signing request to a CA endpoint
+// the request is authenticated over mTLS with the current certificate, so the CA knows which certificate is renewed
+type CSRIssuer struct {
+	URL              string
+	CACert           *x509.Certificate
+	ClientPrivateKey any
+	Timeout          time.Duration
+}
+
+// Renew implements Issuer
+func (i *CSRIssuer) Renew(ctx context.Context, current *x509.Certificate) ([]byte, error) {
+	var org string
+	if len(current.Subject.Organization) > 0 {
+		org = current.Subject.Organization[0]
+	}
+
+	csr, err := cert.NewCSR(i.ClientPrivateKey, current.Subject.CommonName, org, current.DNSNames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate signing request: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(i.CACert)
+
+	client := &http.Client{
+		Timeout: i.Timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{{
+					Certificate: [][]byte{current.Raw},
+					PrivateKey:  i.ClientPrivateKey,
+				}},
+				RootCAs:    roots,
+				MinVersion: tls.VersionTLS12,
+			},
+		},
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, i.URL, bytes.NewReader(csr))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", csrContentType)
+	r.Header.Set("Accept", certContentType)
+
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCertificateSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("renewal request failed with status %d: %s", resp.StatusCode, body)
+	}
+
+	if len(body) == 0 {
+		return nil, errors.New("renewal response is empty")
+	}
+
+	return body, nil
+}
@@ -0,0 +1,58 @@
+package renewal
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Notifier notifies a consumer of the certificate that it's renewed
+type Notifier interface {
+	Notify(certPath string, renewed *x509.Certificate) error
+}
+
+// NotifierFunc is an adapter to use a callback function as Notifier
+type NotifierFunc func(certPath string, renewed *x509.Certificate) error
+
+// Notify implements Notifier
+func (f NotifierFunc) Notify(certPath string, renewed *x509.Certificate) error {
+	return f(certPath, renewed)
+}
+
+// SignalNotifier sends SIGHUP to a process, so it reloads the certificate from the disk
+// the process is identified by the PID or the PID file, the PID file is read on every notification
+type SignalNotifier struct {
+	PID     int
+	PIDFile string
+}
+
+// Notify implements Notifier
+func (n *SignalNotifier) Notify(_ string, _ *x509.Certificate) error {
+	pid := n.PID
+	if n.PIDFile != "" {
+		b, err := os.ReadFile(n.PIDFile)
+		if err != nil {
+			return fmt.Errorf("failed to read pid file: %w", err)
+		}
+
+		pid, err = strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return fmt.Errorf("invalid pid file content: %w", err)
+		}
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	err = p.Signal(syscall.SIGHUP)
+	if err != nil {
+		return fmt.Errorf("failed to send SIGHUP to process %d: %w", pid, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package shamir
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+var (
+	ErrInvalidParams  = errors.New("threshold must be at least 2 and not more than the parts, parts must not be more than 255")
+	ErrInvalidShares  = errors.New("at least 2 shares of the same length are required")
+	ErrDuplicateShare = errors.New("duplicate share index")
+)
+
+// Split splits the secret into the parts by Shamir's secret sharing over GF(256), any threshold of the parts reconstructs the secret
+// a share is the x coordinate (1 to 255) followed by the polynomial values of the secret bytes
+func Split(secret []byte, parts, threshold int) ([][]byte, error) {
+	if threshold < 2 || threshold > parts || parts > 255 {
+		return nil, ErrInvalidParams
+	}
+
+	if len(secret) == 0 {
+		return nil, errors.New("secret is empty")
+	}
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for j, b := range secret {
+		// a random polynomial of threshold-1 degree whose constant is the secret byte
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		for i := range shares {
+			shares[i][j+1] = evaluate(coefficients, shares[i][0])
+		}
+	}
+
+	zero(coefficients)
+	return shares, nil
+}
+
+// Combine reconstructs the secret from the shares by Lagrange interpolation
+// the result is not the secret if the shares are less than the threshold, the caller must verify it
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, ErrInvalidShares
+	}
+
+	length := len(shares[0])
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, s := range shares {
+		if len(s) != length || length < 2 {
+			return nil, ErrInvalidShares
+		}
+		if s[0] == 0 || seen[s[0]] {
+			return nil, ErrDuplicateShare
+		}
+		seen[s[0]] = true
+		xs[i] = s[0]
+	}
+
+	secret := make([]byte, length-1)
+	for j := range secret {
+		var value byte
+		for i, s := range shares {
+			// the Lagrange basis polynomial of the share at x = 0
+			basis := byte(1)
+			for k, x := range xs {
+				if k == i {
+					continue
+				}
+				basis = mul(basis, div(x, x^xs[i]))
+			}
+			value ^= mul(s[j+1], basis)
+		}
+		secret[j] = value
+	}
+
+	return secret, nil
+}
+
+// evaluate returns the polynomial value at x by Horner's method
+func evaluate(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coefficients[i]
+	}
+	return y
+}
+
+var (
+	expTable [510]byte
+	logTable [256]byte
+)
+
+func init() {
+	// the exp and log tables of GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1 and the generator 3
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		expTable[i+255] = x
+		logTable[x] = byte(i)
+
+		// x *= 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func div(a, b byte) byte {
+	if b == 0 {
+		panic("shamir: division by zero")
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
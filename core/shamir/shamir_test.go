@@ -0,0 +1,51 @@
+package shamir
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombine(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every subset of the threshold reconstructs the secret
+	for a := 0; a < len(shares); a++ {
+		for b := a + 1; b < len(shares); b++ {
+			for c := b + 1; c < len(shares); c++ {
+				combined, err := Combine([][]byte{shares[a], shares[b], shares[c]})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(combined, secret) {
+					t.Fatalf("shares %d, %d, %d do not reconstruct the secret", a, b, c)
+				}
+			}
+		}
+	}
+
+	combined, err := Combine(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(combined, secret) {
+		t.Fatal("less than the threshold shares must not reconstruct the secret")
+	}
+
+	_, err = Combine([][]byte{shares[0], shares[0]})
+	if err != ErrDuplicateShare {
+		t.Fatalf("expected ErrDuplicateShare, got %v", err)
+	}
+}
+
+func TestSplitInvalidParams(t *testing.T) {
+	for _, tc := range []struct{ parts, threshold int }{{3, 1}, {2, 3}, {256, 2}} {
+		if _, err := Split([]byte("secret"), tc.parts, tc.threshold); err != ErrInvalidParams {
+			t.Fatalf("expected ErrInvalidParams for %d of %d, got %v", tc.threshold, tc.parts, err)
+		}
+	}
+}
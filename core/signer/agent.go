@@ -0,0 +1,263 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	agentOpPublicKey = "public_key"
+	agentOpSign      = "sign"
+
+	// agentTimeout limits a signing agent request
+	agentTimeout = 30 * time.Second
+)
+
+// agentRequest is a signing agent request, the requests and responses are JSON-encoded on the connection
+type agentRequest struct {
+	Op  string `json:"op"`
+	Key string `json:"key"`
+
+	// Digest is the message digest to sign
+	Digest []byte `json:"digest,omitempty"`
+
+	// Hash is the hash function of the digest
+	Hash crypto.Hash `json:"hash,omitempty"`
+
+	// PSS is set if the RSA-PSS signature is requested
+	PSS           bool `json:"pss,omitempty"`
+	PSSSaltLength int  `json:"pss_salt_length,omitempty"`
+}
+
+// agentResponse is a signing agent response
+type agentResponse struct {
+	// PublicKey is the public key in PKIX DER format
+	PublicKey []byte `json:"public_key,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Agent serves signing requests for its signers over a listener (e.g. a unix socket), like ssh-agent
+// the private keys are loaded into the agent process only, the clients get the public keys and the signatures
+type Agent struct {
+	signers map[string]crypto.Signer
+}
+
+// NewAgent returns a new instance of Agent serving the signers by their names
+func NewAgent(signers map[string]crypto.Signer) *Agent {
+	return &Agent{
+		signers: signers,
+	}
+}
+
+// ListenUnix listens on the unix socket path which is accessible by the current user only, a stale socket file is removed
+// the socket is created in a private directory and moved to the path after its mode is set, so no other user can connect to it in between
+func ListenUnix(path string) (net.Listener, error) {
+	err := os.Remove(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".agent-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the socket file is removed by the listener on close by its final path
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	err = os.Chmod(tmpPath, 0600)
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &unixListener{Listener: l, path: path}, nil
+}
+
+// unixListener removes the socket file on close
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+// Close implements net.Listener
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// Serve accepts the connections of the listener and serves their requests, it returns when the listener is closed
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go a.serveConn(conn)
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	decoder := json.NewDecoder(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		var req agentRequest
+		err := decoder.Decode(&req)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("signing agent: failed to decode request: %s", err)
+			}
+			return
+		}
+
+		res := a.handle(req)
+		err = encoder.Encode(res)
+		if err != nil {
+			log.Printf("signing agent: failed to encode response: %s", err)
+			return
+		}
+	}
+}
+
+func (a *Agent) handle(req agentRequest) agentResponse {
+	s, ok := a.signers[req.Key]
+	if !ok {
+		return agentResponse{Error: fmt.Sprintf("key %q not found", req.Key)}
+	}
+
+	switch req.Op {
+	case agentOpPublicKey:
+		publicKey, err := x509.MarshalPKIXPublicKey(s.Public())
+		if err != nil {
+			return agentResponse{Error: err.Error()}
+		}
+		return agentResponse{PublicKey: publicKey}
+	case agentOpSign:
+		var opts crypto.SignerOpts = req.Hash
+		if req.PSS {
+			opts = &rsa.PSSOptions{SaltLength: req.PSSSaltLength, Hash: req.Hash}
+		}
+
+		signature, err := s.Sign(rand.Reader, req.Digest, opts)
+		if err != nil {
+			return agentResponse{Error: err.Error()}
+		}
+		return agentResponse{Signature: signature}
+	default:
+		return agentResponse{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// AgentSigner is a signer of a key held by the signing agent
+type AgentSigner struct {
+	socketPath string
+	key        string
+	publicKey  crypto.PublicKey
+}
+
+// NewAgentSigner returns a new instance of AgentSigner, the public key is fetched from the agent
+func NewAgentSigner(socketPath, key string) (*AgentSigner, error) {
+	s := &AgentSigner{
+		socketPath: socketPath,
+		key:        key,
+	}
+
+	res, err := s.do(agentRequest{Op: agentOpPublicKey, Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	s.publicKey, err = x509.ParsePKIXPublicKey(res.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key from signing agent: %w", err)
+	}
+
+	return s, nil
+}
+
+// Public implements crypto.Signer
+func (s *AgentSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign implements crypto.Signer, the digest is signed by the agent
+func (s *AgentSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	req := agentRequest{
+		Op:     agentOpSign,
+		Key:    s.key,
+		Digest: digest,
+		Hash:   opts.HashFunc(),
+	}
+
+	if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+		req.PSS = true
+		req.PSSSaltLength = pssOpts.SaltLength
+	}
+
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Signature, nil
+}
+
+// do sends the request to the agent on a new connection
+func (s *AgentSigner) do(req agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", s.socketPath, agentTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to signing agent: %w", err)
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(agentTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var res agentResponse
+	err = json.NewDecoder(conn).Decode(&res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signing agent response: %w", err)
+	}
+
+	if res.Error != "" {
+		return nil, fmt.Errorf("signing agent: %s", res.Error)
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,258 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Mechanism is a PKCS#11 signing mechanism
+type Mechanism uint
+
+const (
+	// MechanismRSAPKCS is CKM_RSA_PKCS, the input is the DER-encoded DigestInfo
+	MechanismRSAPKCS Mechanism = 0x0001
+
+	// MechanismRSAPKCSPSS is CKM_RSA_PKCS_PSS, the input is the digest
+	MechanismRSAPKCSPSS Mechanism = 0x000d
+
+	// MechanismECDSA is CKM_ECDSA, the input is the digest and the output is r || s
+	MechanismECDSA Mechanism = 0x1041
+)
+
+// SoftModuleName is the module-name of the pkcs11 URI opening the module-path as a software token directory
+const SoftModuleName = "soft"
+
+var (
+	ErrModuleNotFound = errors.New("PKCS#11 module is not registered")
+	ErrTokenNotFound  = errors.New("token not found")
+	ErrObjectNotFound = errors.New("key object not found")
+	ErrPINIncorrect   = errors.New("PIN is incorrect")
+
+	modulesMu sync.RWMutex
+	modules   = make(map[string]Module)
+)
+
+// Module is a PKCS#11 module, e.g. an HSM library binding or the software token
+type Module interface {
+	// OpenSession opens a session on the token by its label
+	OpenSession(tokenLabel string) (Session, error)
+}
+
+// Session is a PKCS#11 session on a token, it's not safe for concurrent use
+type Session interface {
+	Login(pin string) error
+
+	// FindKey returns the private key object handle by its label
+	FindKey(label string) (ObjectHandle, error)
+
+	PublicKey(h ObjectHandle) (crypto.PublicKey, error)
+
+	// Sign signs the data by the mechanism, the hash is the PSS hash function for MechanismRSAPKCSPSS
+	Sign(h ObjectHandle, m Mechanism, hash crypto.Hash, data []byte) ([]byte, error)
+
+	Close() error
+}
+
+// ObjectHandle is a PKCS#11 object handle
+type ObjectHandle uint
+
+// RegisterModule registers the PKCS#11 module by its path, it's used by the pkcs11 URIs with the module-path
+func RegisterModule(path string, m Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	modules[path] = m
+}
+
+// PKCS11Signer is a signer of a private key object of a PKCS#11 token, the private key never leaves the token
+type PKCS11Signer struct {
+	mu        sync.Mutex
+	session   Session
+	handle    ObjectHandle
+	publicKey crypto.PublicKey
+}
+
+// NewPKCS11Signer logs in the token and returns a new instance of PKCS11Signer for the key object
+func NewPKCS11Signer(m Module, tokenLabel, objectLabel, pin string) (*PKCS11Signer, error) {
+	session, err := m.OpenSession(tokenLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	err = session.Login(pin)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	handle, err := session.FindKey(objectLabel)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	publicKey, err := session.PublicKey(handle)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return &PKCS11Signer{
+		session:   session,
+		handle:    handle,
+		publicKey: publicKey,
+	}, nil
+}
+
+// OpenPKCS11 returns the PKCS#11 signer by the pkcs11 URI (RFC 7512), the token, object and module-path attributes are required
+// the module-path must be a registered module, it's opened as a software token directory only if the module-name is soft,
+// so a missing HSM module never falls back to a key on disk
+// the PIN is read from the pin-source file or the pin-value attribute
+func OpenPKCS11(uri string) (*PKCS11Signer, error) {
+	attrs, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenLabel, objectLabel, modulePath := attrs["token"], attrs["object"], attrs["module-path"]
+	if tokenLabel == "" || objectLabel == "" || modulePath == "" {
+		return nil, errors.New("token, object and module-path are required in the pkcs11 URI")
+	}
+
+	pin := attrs["pin-value"]
+	if source := attrs["pin-source"]; source != "" {
+		b, err := os.ReadFile(strings.TrimPrefix(source, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pin-source: %w", err)
+		}
+		pin = strings.TrimRight(string(b), "\r\n")
+	}
+
+	var m Module
+	if attrs["module-name"] == SoftModuleName {
+		m = NewSoftModule(modulePath)
+	} else {
+		modulesMu.RLock()
+		registered, ok := modules[modulePath]
+		modulesMu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrModuleNotFound, modulePath)
+		}
+		m = registered
+	}
+
+	return NewPKCS11Signer(m, tokenLabel, objectLabel, pin)
+}
+
+// Public implements crypto.Signer
+func (s *PKCS11Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign implements crypto.Signer, the mechanism is selected by the public key type and the options
+func (s *PKCS11Signer) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.publicKey.(type) {
+	case *rsa.PublicKey:
+		if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+			if pssOpts.SaltLength != rsa.PSSSaltLengthAuto && pssOpts.SaltLength != rsa.PSSSaltLengthEqualsHash && pssOpts.SaltLength != opts.HashFunc().Size() {
+				return nil, errors.New("only the hash length PSS salt is supported")
+			}
+			return s.session.Sign(s.handle, MechanismRSAPKCSPSS, opts.HashFunc(), digest)
+		}
+
+		digestInfo, err := encodeDigestInfo(opts.HashFunc(), digest)
+		if err != nil {
+			return nil, err
+		}
+		return s.session.Sign(s.handle, MechanismRSAPKCS, 0, digestInfo)
+	case *ecdsa.PublicKey:
+		signature, err := s.session.Sign(s.handle, MechanismECDSA, 0, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// the PKCS#11 ECDSA signature is r || s, crypto.Signer returns the ASN.1 signature
+		half := len(signature) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(signature[:half]),
+			S: new(big.Int).SetBytes(signature[half:]),
+		})
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
+// Close closes the token session
+func (s *PKCS11Signer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.session.Close()
+}
+
+var hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+	crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+}
+
+// encodeDigestInfo returns the DER-encoded DigestInfo (RFC 8017 section 9.2) which is signed by CKM_RSA_PKCS
+func encodeDigestInfo(hash crypto.Hash, digest []byte) ([]byte, error) {
+	oid, ok := hashOIDs[hash]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash function %s", hash)
+	}
+
+	if len(digest) != hash.Size() {
+		return nil, errors.New("invalid digest length")
+	}
+
+	return asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		Digest    []byte
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+		Digest:    digest,
+	})
+}
+
+// parsePKCS11URI returns the path and query attributes of the pkcs11 URI
+func parsePKCS11URI(uri string) (map[string]string, error) {
+	if !strings.HasPrefix(uri, SchemePKCS11+":") {
+		return nil, errors.New("invalid pkcs11 URI")
+	}
+	rest := strings.TrimPrefix(uri, SchemePKCS11+":")
+
+	attrs := make(map[string]string)
+	path, query, _ := strings.Cut(rest, "?")
+	for _, part := range append(strings.Split(path, ";"), strings.Split(query, "&")...) {
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid pkcs11 URI attribute %q", part)
+		}
+
+		value, err := url.PathUnescape(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pkcs11 URI attribute %q: %w", part, err)
+		}
+		attrs[name] = value
+	}
+
+	return attrs, nil
+}
@@ -0,0 +1,52 @@
+package signer
+
+import (
+	"crypto"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/theredrad/certauthz/core/key"
+)
+
+const (
+	SchemeFile   = "file"
+	SchemeAgent  = "agent"
+	SchemePKCS11 = "pkcs11"
+)
+
+// Open returns the signer by the URI, the signer backend is selected by the URI scheme:
+//   - a file path or file:///path/to/private.key reads the private key file, it's decrypted by the key passphrase function if it's encrypted
+//   - agent:///path/to/agent.sock?key=primary signs by the key of the signing agent over the unix socket, the key is never loaded into the process
+//   - pkcs11:token=primary;object=ca?module-path=/path/to/module&pin-source=/path/to/pin signs by the key object of the PKCS#11 token (RFC 7512)
+func Open(uri string) (crypto.Signer, error) {
+	scheme, _, ok := strings.Cut(uri, ":")
+	if !ok {
+		return OpenFile(uri)
+	}
+
+	switch scheme {
+	case SchemeFile:
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signer URI: %w", err)
+		}
+		return OpenFile(u.Path)
+	case SchemeAgent:
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signer URI: %w", err)
+		}
+		return NewAgentSigner(u.Path, u.Query().Get("key"))
+	case SchemePKCS11:
+		return OpenPKCS11(uri)
+	default:
+		// not a URI, e.g. a relative path including a colon
+		return OpenFile(uri)
+	}
+}
+
+// OpenFile returns the private key of the file as the signer
+func OpenFile(path string) (crypto.Signer, error) {
+	return key.ReadRSAPrivateKeyFromFile(path)
+}
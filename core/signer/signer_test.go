@@ -0,0 +1,221 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+
+	"github.com/theredrad/certauthz/core/cert"
+	jwtCore "github.com/theredrad/certauthz/core/jwt"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+// openTestSigners returns the signers of the same RSA key by every backend
+func openTestSigners(t *testing.T, privateKey *rsa.PrivateKey) map[string]crypto.Signer {
+	dir := t.TempDir()
+
+	err := key.WriteKeyPair(filepath.Join(dir, "primary"), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileSigner, err := Open(filepath.Join(dir, "primary", "private.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	socketPath := filepath.Join(dir, "agent.sock")
+	l, err := ListenUnix(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewAgent(map[string]crypto.Signer{"primary": privateKey}).Serve(l)
+
+	agentSigner, err := Open(fmt.Sprintf("agent://%s?key=primary", socketPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokensDir := filepath.Join(dir, "tokens")
+	module := NewSoftModule(tokensDir)
+	err = module.InitToken("primary", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = module.ImportKey("primary", "1234", "ca", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pinPath := filepath.Join(dir, "pin")
+	err = os.WriteFile(pinPath, []byte("1234\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs11Signer, err := Open(fmt.Sprintf("pkcs11:token=primary;object=ca?module-name=soft&module-path=%s&pin-source=%s", tokensDir, pinPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]crypto.Signer{
+		"file":   fileSigner,
+		"agent":  agentSigner,
+		"pkcs11": pkcs11Signer,
+	}
+}
+
+func TestSigners(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, s := range openTestSigners(t, privateKey) {
+		t.Run(name, func(t *testing.T) {
+			if !privateKey.PublicKey.Equal(s.Public()) {
+				t.Fatal("public key does not match")
+			}
+
+			caBytes, err := cert.NewCA(s, 1, "Test CA", "Test Org", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			caCert, err := cert.DecodeFromDERBytes(caBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = caCert.CheckSignatureFrom(caCert)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := jwtCore.Sign(s, jwt.MapClaims{"sub": "alice", "scopes": []string{"bob.user.read"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = jwtCore.NewValidator(&privateKey.PublicKey).Validate(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			digest := sha256.Sum256([]byte("message"))
+			signature, err := s.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = rsa.VerifyPSS(&privateKey.PublicKey, crypto.SHA256, digest[:], signature, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSoftTokenECDSA(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	module := NewSoftModule(t.TempDir())
+	err = module.InitToken("token", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = module.ImportKey("token", "1234", "key", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewPKCS11Signer(module, "token", "key", "4321")
+	if err != ErrPINIncorrect {
+		t.Fatalf("expected ErrPINIncorrect, got %v", err)
+	}
+
+	s, err := NewPKCS11Signer(module, "token", "key", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	digest := sha256.Sum256([]byte("message"))
+	signature, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecdsa.VerifyASN1(&privateKey.PublicKey, digest[:], signature) {
+		t.Fatal("invalid signature")
+	}
+}
+
+func TestPKCS11UnregisteredModule(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokensDir := t.TempDir()
+	module := NewSoftModule(tokensDir)
+	err = module.InitToken("primary", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = module.ImportKey("primary", "1234", "ca", privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the software token is not opened unless the module-name is soft
+	_, err = Open(fmt.Sprintf("pkcs11:token=primary;object=ca?module-path=%s&pin-value=1234", tokensDir))
+	if !errors.Is(err, ErrModuleNotFound) {
+		t.Fatalf("expected ErrModuleNotFound, got %v", err)
+	}
+}
+
+func TestListenUnixMode(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := ListenUnix(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected socket mode 0600, got %s", info.Mode().Perm())
+	}
+
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(socketPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the socket file to be removed on close, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+var (
+	// labelRegexp limits the token and object labels, they are used as file names
+	labelRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// softToken is the token file of the software token
+type softToken struct {
+	Label   string `json:"label"`
+	PINSalt []byte `json:"pin_salt"`
+	PINHash []byte `json:"pin_hash"`
+}
+
+// SoftModule is a software PKCS#11 module storing the tokens in a directory, a local stand-in for an HSM like SoftHSM
+// a token is stored in [dir]/[token label], including token.json and the private key objects as [object label].key
+// encrypted by the token PIN, so the keys are readable only after the login
+type SoftModule struct {
+	dir string
+}
+
+// NewSoftModule returns a new instance of SoftModule
+func NewSoftModule(dir string) *SoftModule {
+	return &SoftModule{
+		dir: dir,
+	}
+}
+
+// InitToken initializes a new token by the label and the user PIN
+func (m *SoftModule) InitToken(label, pin string) error {
+	if !labelRegexp.MatchString(label) {
+		return fmt.Errorf("invalid token label %q", label)
+	}
+
+	if pin == "" {
+		return errors.New("PIN is required")
+	}
+
+	tokenDir := filepath.Join(m.dir, label)
+	if _, err := os.Stat(filepath.Join(tokenDir, "token.json")); err == nil {
+		return fmt.Errorf("token %q is already initialized", label)
+	}
+
+	err := os.MkdirAll(tokenDir, 0700)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	pinHash, err := hashPIN(pin, salt)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(softToken{
+		Label:   label,
+		PINSalt: salt,
+		PINHash: pinHash,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(filepath.Join(tokenDir, "token.json"), b, 0600)
+}
+
+// ImportKey imports the private key to the token as the object label
+func (m *SoftModule) ImportKey(tokenLabel, pin, objectLabel string, privateKey crypto.Signer) error {
+	if !labelRegexp.MatchString(objectLabel) {
+		return fmt.Errorf("invalid object label %q", objectLabel)
+	}
+
+	session, err := m.OpenSession(tokenLabel)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	err = session.Login(pin)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := key.EncryptPKCS8(der, []byte(pin))
+	if err != nil {
+		return err
+	}
+
+	return file.WriteAtomic(filepath.Join(m.dir, tokenLabel, objectLabel+".key"), encrypted, 0600)
+}
+
+// OpenSession implements Module
+func (m *SoftModule) OpenSession(tokenLabel string) (Session, error) {
+	if !labelRegexp.MatchString(tokenLabel) {
+		return nil, ErrTokenNotFound
+	}
+
+	tokenDir := filepath.Join(m.dir, tokenLabel)
+	b, err := os.ReadFile(filepath.Join(tokenDir, "token.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var token softToken
+	err = json.Unmarshal(b, &token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token file: %w", err)
+	}
+
+	return &softSession{
+		dir:   tokenDir,
+		token: token,
+	}, nil
+}
+
+// softSession is a session on a software token
+type softSession struct {
+	dir   string
+	token softToken
+	pin   string
+	keys  []crypto.Signer
+}
+
+// Login implements Session
+func (s *softSession) Login(pin string) error {
+	pinHash, err := hashPIN(pin, s.token.PINSalt)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare(pinHash, s.token.PINHash) != 1 {
+		return ErrPINIncorrect
+	}
+
+	s.pin = pin
+	return nil
+}
+
+// FindKey implements Session
+func (s *softSession) FindKey(label string) (ObjectHandle, error) {
+	if s.pin == "" {
+		return 0, errors.New("user is not logged in")
+	}
+
+	if !labelRegexp.MatchString(label) {
+		return 0, ErrObjectNotFound
+	}
+
+	encrypted, err := os.ReadFile(filepath.Join(s.dir, label+".key"))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrObjectNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	der, err := key.DecryptPKCS8(encrypted, []byte(s.pin))
+	if err != nil {
+		return 0, err
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return 0, err
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return 0, errors.New("unsupported key object")
+	}
+
+	s.keys = append(s.keys, signer)
+	return ObjectHandle(len(s.keys)), nil
+}
+
+// PublicKey implements Session
+func (s *softSession) PublicKey(h ObjectHandle) (crypto.PublicKey, error) {
+	k, err := s.object(h)
+	if err != nil {
+		return nil, err
+	}
+	return k.Public(), nil
+}
+
+// Sign implements Session
+func (s *softSession) Sign(h ObjectHandle, m Mechanism, hash crypto.Hash, data []byte) ([]byte, error) {
+	k, err := s.object(h)
+	if err != nil {
+		return nil, err
+	}
+
+	switch privateKey := k.(type) {
+	case *rsa.PrivateKey:
+		switch m {
+		case MechanismRSAPKCS:
+			// the data is the DigestInfo, it's signed as is
+			return rsa.SignPKCS1v15(rand.Reader, privateKey, 0, data)
+		case MechanismRSAPKCSPSS:
+			return rsa.SignPSS(rand.Reader, privateKey, hash, data, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PrivateKey:
+		if m == MechanismECDSA {
+			r, sig, err := ecdsa.Sign(rand.Reader, privateKey, data)
+			if err != nil {
+				return nil, err
+			}
+
+			size := (privateKey.Curve.Params().BitSize + 7) / 8
+			signature := make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			sig.FillBytes(signature[size:])
+			return signature, nil
+		}
+	}
+
+	return nil, fmt.Errorf("mechanism %#x is not supported by the key object", m)
+}
+
+// Close implements Session
+func (s *softSession) Close() error {
+	s.pin = ""
+	s.keys = nil
+	return nil
+}
+
+func (s *softSession) object(h ObjectHandle) (crypto.Signer, error) {
+	if h == 0 || int(h) > len(s.keys) {
+		return nil, errors.New("invalid object handle")
+	}
+	return s.keys[h-1], nil
+}
+
+// hashPIN returns the PIN verifier of the token
+func hashPIN(pin string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(pin), salt, 1<<14, 8, 1, 32)
+}
@@ -14,53 +14,108 @@ import (
 var (
 	// custom OID (object identifier) for the client scope in the certificate
 	scopeOID = []int{1, 2, 3, 4}
+
+	errUnauthorizedPeer = errors.New("the peer is not authorized for the communication")
 )
 
 // NewServerConfig returns an instance of tls config based on server configuration to enforce mtls
 // the certificate scope extension is validated if requiredScopePrefix is passed
 // the client certificate must have the requiredScopePrefix in at least of of the scopes e.g. bob.read (bob.*)
 func NewServerConfig(caPath, serverCertPath, serverPrivateKeyPath, requiredScopePrefix string) (*tls.Config, error) {
-	caCert, err := cert.ReadFromDERFile(caPath)
+	keyPair, err := NewKeyPairReloader(serverCertPath, serverPrivateKeyPath)
 	if err != nil {
 		return nil, err
 	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AddCert(caCert)
+	return NewReloadableServerConfig(caPath, keyPair, requiredScopePrefix)
+}
 
-	serverCert, err := cert.ReadFromDERFile(serverCertPath)
+// NewReloadableServerConfig returns an instance of tls config like NewServerConfig, the server certificate is read from the key pair reloader on every handshake
+func NewReloadableServerConfig(caPath string, keyPair *KeyPairReloader, requiredScopePrefix string) (*tls.Config, error) {
+	caCert, err := cert.ReadCAFromFile(caPath)
 	if err != nil {
 		return nil, err
 	}
 
-	var serverTLSCert tls.Certificate
-	serverTLSCert.Certificate = append(serverTLSCert.Certificate, serverCert.Raw)
+	return NewReloadableTrustServerConfig(func() *x509.Certificate { return caCert }, keyPair, requiredScopePrefix), nil
+}
 
-	serverPrivateKey, err := key.ReadRSAPrivateKeyFromDERFile(serverPrivateKeyPath)
-	if err != nil {
-		return nil, err
+// NewReloadableTrustServerConfig returns an instance of tls config like NewReloadableServerConfig, the client certificate is verified
+// by the current CA certificate of caCert on every handshake, e.g. the CA certificate of a trust bundle which is read again on SIGHUP
+// the client certificate is verified by VerifyPeerCertificate, the ClientCAs pool would keep trusting the CA certificate it's built by
+func NewReloadableTrustServerConfig(caCert func() *x509.Certificate, keyPair *KeyPairReloader, requiredScopePrefix string) *tls.Config {
+	var scopeVerifierFunc func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+	if requiredScopePrefix != "" {
+		scopeVerifierFunc = NewPeerCertVerifierFuncWithScopePrefix(requiredScopePrefix)
 	}
 
-	serverTLSCert.PrivateKey = serverPrivateKey
+	return &tls.Config{
+		GetCertificate: keyPair.GetCertificate,
+		ClientAuth:     tls.RequireAnyClientCert,
+		MinVersion:     tls.VersionTLS12,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			verifiedChains, err := verifyClientCert(caCert(), rawCerts)
+			if err != nil {
+				return err
+			}
 
-	var peerCertVerifierFunc func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
-	if requiredScopePrefix != "" {
-		peerCertVerifierFunc = NewPeerCertVerifierFuncWithScopePrefix(requiredScopePrefix)
+			if scopeVerifierFunc != nil {
+				return scopeVerifierFunc(rawCerts, verifiedChains)
+			}
+			return nil
+		},
+	}
+}
+
+// verifyClientCert verifies the client certificate chain by the CA certificate for the client authentication,
+// like the tls.RequireAndVerifyClientCert client authentication does by the ClientCAs pool
+func verifyClientCert(caCert *x509.Certificate, rawCerts [][]byte) ([][]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("no peer certificate")
 	}
 
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+		certs = append(certs, c)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	verifiedChains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify certificate: %w", err)
+	}
+
+	return verifiedChains, nil
+}
+
+// NewReloadableTLSServerConfig returns an instance of tls config of the server certificate without the client authentication,
+// the server certificate is read from the key pair reloader on every handshake
+func NewReloadableTLSServerConfig(keyPair *KeyPairReloader) *tls.Config {
 	return &tls.Config{
-		Certificates:          []tls.Certificate{serverTLSCert},
-		ClientCAs:             caCertPool,
-		ClientAuth:            tls.RequireAndVerifyClientCert,
-		MinVersion:            tls.VersionTLS12,
-		VerifyPeerCertificate: peerCertVerifierFunc,
-	}, nil
+		GetCertificate: keyPair.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
 }
 
 // NewClientConfig returns an instance of tls config based on client configuration to enforce mtls
 // no prefix scope is required on the client side in our case, however it might be required since it's a mutual tls
 func NewClientConfig(caPath, clientCertPath, clientPrivateKeyPath string) (*tls.Config, error) {
-	caCert, err := cert.ReadFromDERFile(caPath)
+	caCert, err := cert.ReadCAFromFile(caPath)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +123,7 @@ func NewClientConfig(caPath, clientCertPath, clientPrivateKeyPath string) (*tls.
 	caCertPool := x509.NewCertPool()
 	caCertPool.AddCert(caCert)
 
-	clientCert, err := cert.ReadFromDERFile(clientCertPath)
+	clientCert, err := cert.ReadFromFile(clientCertPath)
 	if err != nil {
 		return nil, err
 	}
@@ -76,7 +131,7 @@ func NewClientConfig(caPath, clientCertPath, clientPrivateKeyPath string) (*tls.
 	var clientTLSCert tls.Certificate
 	clientTLSCert.Certificate = append(clientTLSCert.Certificate, clientCert.Raw)
 
-	clientPrivateKey, err := key.ReadRSAPrivateKeyFromDERFile(clientPrivateKeyPath)
+	clientPrivateKey, err := key.ReadRSAPrivateKeyFromFile(clientPrivateKeyPath)
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +168,6 @@ func NewPeerCertVerifierFuncWithScopePrefix(scopePrefix string) func(rawCerts []
 			}
 		}
 
-		return errors.New("the peer is not authorized for the communication")
+		return errUnauthorizedPeer
 	}
 }
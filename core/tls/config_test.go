@@ -0,0 +1,179 @@
+package tls
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+// testCA is a CA certificate and its private key
+type testCA struct {
+	cert       *x509.Certificate
+	privateKey *rsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, commonName string) testCA {
+	privateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected ca private key, got err: %s", err)
+	}
+
+	caBytes, err := cert.NewCA(privateKey, 1, commonName, "Test Org", time.Hour)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	caCert, err := x509.ParseCertificate(caBytes)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	return testCA{cert: caCert, privateKey: privateKey}
+}
+
+// newClientCert returns a client certificate of the scopes signed by the CA
+func (ca testCA) newClientCert(t *testing.T, name, scopes string) tls.Certificate {
+	privateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	certBytes, err := cert.NewCert(ca.cert, &privateKey.PublicKey, ca.privateKey, 2, name, "Test Org", scopes, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{certBytes}, PrivateKey: privateKey}
+}
+
+// newKeyPairReloader writes a server certificate signed by the CA and returns its key pair reloader
+func (ca testCA) newKeyPairReloader(t *testing.T) *KeyPairReloader {
+	privateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected server private key, got err: %s", err)
+	}
+
+	certBytes, err := cert.NewServerCert(ca.cert, &privateKey.PublicKey, ca.privateKey, 3, "server", "Test Org", []string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected server cert, got err: %s", err)
+	}
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "certificate.crt")
+	privateKeyPath := filepath.Join(dir, "private_key.der")
+
+	err = file.Write(certPath, certBytes)
+	if err != nil {
+		t.Fatalf("expected server cert file, got err: %s", err)
+	}
+
+	err = key.WritePrivateKey(privateKeyPath, privateKey)
+	if err != nil {
+		t.Fatalf("expected server private key file, got err: %s", err)
+	}
+
+	keyPair, err := NewKeyPairReloader(certPath, privateKeyPath)
+	if err != nil {
+		t.Fatalf("expected key pair reloader, got err: %s", err)
+	}
+
+	return keyPair
+}
+
+// handshake runs a handshake of the client certificate with the server config and returns the server error
+func handshake(t *testing.T, serverConfig *tls.Config, clientCert tls.Certificate) error {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client := tls.Client(clientConn, &tls.Config{
+			Certificates:       []tls.Certificate{clientCert},
+			InsecureSkipVerify: true,
+		})
+		// the client fails once the server rejects its certificate
+		_ = client.Handshake()
+		clientConn.Close()
+	}()
+
+	err := tls.Server(serverConn, serverConfig).Handshake()
+	serverConn.Close()
+	wg.Wait()
+
+	return err
+}
+
+func TestReloadableTrustServerConfigRotatedCA(t *testing.T) {
+	oldCA := newTestCA(t, "Old CA")
+	newCA := newTestCA(t, "New CA")
+
+	var (
+		mu     sync.RWMutex
+		caCert = oldCA.cert
+	)
+	currentCA := func() *x509.Certificate {
+		mu.RLock()
+		defer mu.RUnlock()
+		return caCert
+	}
+
+	serverConfig := NewReloadableTrustServerConfig(currentCA, oldCA.newKeyPairReloader(t), "")
+
+	oldClient := oldCA.newClientCert(t, "alice", "bob.read")
+	newClient := newCA.newClientCert(t, "alice", "bob.read")
+
+	err := handshake(t, serverConfig, oldClient)
+	if err != nil {
+		t.Fatalf("expected handshake of the old CA client, got err: %s", err)
+	}
+
+	err = handshake(t, serverConfig, newClient)
+	if err == nil {
+		t.Fatal("expected the new CA client to be rejected before the rotation")
+	}
+
+	// the CA certificate is rotated, e.g. read again on SIGHUP
+	mu.Lock()
+	caCert = newCA.cert
+	mu.Unlock()
+
+	err = handshake(t, serverConfig, oldClient)
+	if err == nil {
+		t.Fatal("expected the old CA client to be rejected after the rotation")
+	}
+	if reason := HandshakeFailureReason(err.Error()); reason != HandshakeFailureUnknownCA {
+		t.Errorf("expected %s failure reason, got %s: %s", HandshakeFailureUnknownCA, reason, err)
+	}
+
+	err = handshake(t, serverConfig, newClient)
+	if err != nil {
+		t.Fatalf("expected handshake of the new CA client, got err: %s", err)
+	}
+}
+
+func TestReloadableTrustServerConfigScopePrefix(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	serverConfig := NewReloadableTrustServerConfig(func() *x509.Certificate { return ca.cert }, ca.newKeyPairReloader(t), "bob.")
+
+	err := handshake(t, serverConfig, ca.newClientCert(t, "alice", "bob.read"))
+	if err != nil {
+		t.Fatalf("expected handshake of the client with the scope prefix, got err: %s", err)
+	}
+
+	err = handshake(t, serverConfig, ca.newClientCert(t, "carol", "alice.read"))
+	if err != errUnauthorizedPeer {
+		t.Fatalf("expected %s, got err: %v", errUnauthorizedPeer, err)
+	}
+}
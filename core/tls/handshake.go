@@ -0,0 +1,72 @@
+package tls
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+)
+
+const (
+	// handshakeErrorPrefix is the prefix of the handshake errors in the http.Server error log
+	handshakeErrorPrefix = "http: TLS handshake error"
+)
+
+// the reasons of the handshake failures
+const (
+	HandshakeFailureUnknownCA          = "unknown_ca"
+	HandshakeFailureExpiredCertificate = "expired_certificate"
+	HandshakeFailureBadCertificate     = "bad_certificate"
+	HandshakeFailureNoCertificate      = "no_certificate"
+	HandshakeFailureUnauthorizedScope  = "unauthorized_scope"
+	HandshakeFailureProtocol           = "protocol"
+	HandshakeFailureRemote             = "remote_error"
+	HandshakeFailureEOF                = "eof"
+	HandshakeFailureOther              = "other"
+)
+
+// NewHandshakeErrorLog returns a logger for the http.Server ErrorLog which calls onFailure by the reason of every TLS handshake error
+// the log lines are written to the output as well
+func NewHandshakeErrorLog(out io.Writer, onFailure func(reason string)) *log.Logger {
+	return log.New(&handshakeErrorWriter{out: out, onFailure: onFailure}, "", log.LstdFlags)
+}
+
+// handshakeErrorWriter classifies the handshake error lines of the log
+type handshakeErrorWriter struct {
+	out       io.Writer
+	onFailure func(reason string)
+}
+
+// Write implements io.Writer, a log line is written at once
+func (w *handshakeErrorWriter) Write(p []byte) (int, error) {
+	if i := bytes.Index(p, []byte(handshakeErrorPrefix)); i >= 0 {
+		w.onFailure(HandshakeFailureReason(string(p[i:])))
+	}
+
+	return w.out.Write(p)
+}
+
+// HandshakeFailureReason returns the reason of the handshake error message
+func HandshakeFailureReason(msg string) string {
+	switch {
+	case strings.Contains(msg, "unknown authority"), strings.Contains(msg, "unknown certificate authority"):
+		return HandshakeFailureUnknownCA
+	case strings.Contains(msg, "expired"):
+		return HandshakeFailureExpiredCertificate
+	case strings.Contains(msg, "didn't provide a certificate"):
+		return HandshakeFailureNoCertificate
+	case strings.Contains(msg, errUnauthorizedPeer.Error()):
+		return HandshakeFailureUnauthorizedScope
+	case strings.Contains(msg, "remote error"):
+		return HandshakeFailureRemote
+	case strings.Contains(msg, "x509:"), strings.Contains(msg, "bad certificate"), strings.Contains(msg, "failed to verify certificate"):
+		return HandshakeFailureBadCertificate
+	case strings.Contains(msg, "unsupported versions"), strings.Contains(msg, "no cipher suite"),
+		strings.Contains(msg, "does not look like a TLS handshake"), strings.Contains(msg, "protocol version"):
+		return HandshakeFailureProtocol
+	case strings.HasSuffix(strings.TrimSpace(msg), "EOF"):
+		return HandshakeFailureEOF
+	default:
+		return HandshakeFailureOther
+	}
+}
@@ -0,0 +1,96 @@
+package tls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+// KeyPairReloader holds a certificate and private key pair loaded from the disk and reloads it on demand,
+// so a renewed certificate is used for the new handshakes without restarting the process
+type KeyPairReloader struct {
+	certPath       string
+	privateKeyPath string
+
+	mu      sync.RWMutex
+	keyPair *tls.Certificate
+}
+
+// NewKeyPairReloader loads the certificate and private key pair and returns a new instance of KeyPairReloader
+func NewKeyPairReloader(certPath, privateKeyPath string) (*KeyPairReloader, error) {
+	r := &KeyPairReloader{
+		certPath:       certPath,
+		privateKeyPath: privateKeyPath,
+	}
+
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the certificate and private key pair from the disk, the current pair is kept on failure
+func (r *KeyPairReloader) Reload() error {
+	keyPair, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.keyPair = keyPair
+	r.mu.Unlock()
+
+	return nil
+}
+
+// Check reads the certificate and private key pair from the disk without using it, it returns an error if the pair
+// can't be loaded on the next reload, e.g. to check the readiness
+func (r *KeyPairReloader) Check() error {
+	_, err := r.read()
+	return err
+}
+
+// read reads the certificate and private key pair from the disk
+func (r *KeyPairReloader) read() (*tls.Certificate, error) {
+	c, err := cert.ReadFromFile(r.certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := key.ReadRSAPrivateKeyFromFile(r.privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{c.Raw},
+		PrivateKey:  privateKey,
+		Leaf:        c,
+	}, nil
+}
+
+// GetCertificate implements tls.Config GetCertificate signature
+func (r *KeyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keyPair, nil
+}
+
+// GetClientCertificate implements tls.Config GetClientCertificate signature
+func (r *KeyPairReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keyPair, nil
+}
+
+// Leaf returns the current certificate
+func (r *KeyPairReloader) Leaf() *x509.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.keyPair.Leaf
+}
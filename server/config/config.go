@@ -0,0 +1,269 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theredrad/certauthz/server/web"
+	"gopkg.in/yaml.v3"
+)
+
+// the modes of the listeners
+const (
+	ModePlain = "plain"
+	ModeTLS   = "tls"
+	ModeMTLS  = "mtls"
+)
+
+// the authentication methods of the listeners
+const (
+	AuthToken       = "token"
+	AuthCertificate = "certificate"
+	AuthSession     = "session"
+	AuthXFCC        = "xfcc"
+	AuthTLS         = "tls"
+)
+
+// the defaults of the listener HTTP servers, the headers are read in a short time but their size fits the client certificate
+// chains in the X-Client-Cert and X-Forwarded-Client-Cert headers, a base64 certificate of a 4096-bit key is about 2 KiB
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = time.Minute
+	DefaultWriteTimeout      = time.Minute
+	DefaultIdleTimeout       = 2 * time.Minute
+	DefaultMaxHeaderBytes    = 64 << 10
+)
+
+// Config is the configuration of the server listeners and the trust bundles they authenticate the clients by
+type Config struct {
+	TrustBundles map[string]TrustBundle `json:"trust_bundles"`
+	Listeners    []Listener             `json:"listeners"`
+}
+
+// TrustBundle is the credentials the clients are authenticated by, the CA certificate of the client certificates
+// and the public key of the tokens, the format of the files (PEM or DER) is detected, the CRL is in DER format
+type TrustBundle struct {
+	CACertificate  string `json:"ca_certificate"`
+	TokenPublicKey string `json:"token_public_key"`
+
+	// CRL is the certificate revocation list signed by the CA, the revocation is not checked if it's empty
+	CRL string `json:"crl"`
+
+	// IssuanceLog is the issuance log directory of the CA, the client certificates must be included in the log if it's set
+	IssuanceLog string `json:"issuance_log"`
+}
+
+// Listener is a server listener, its mode, the authentication methods of its clients and its routes
+type Listener struct {
+	Name        string   `json:"name"`
+	Address     string   `json:"address"`
+	Mode        string   `json:"mode"`
+	TLS         *TLS     `json:"tls"`
+	TrustBundle string   `json:"trust_bundle"`
+	AuthMethods []string `json:"auth_methods"`
+	XFCC        *XFCC    `json:"xfcc"`
+
+	// RequiredScopes are required for every request, the Routes or the ScopePolicy file are the scopes by the request path and method
+	RequiredScopes []string        `json:"required_scopes"`
+	Routes         []web.ScopeRule `json:"routes"`
+	ScopePolicy    string          `json:"scope_policy"`
+
+	// DebugScope is required to read the credential status on /debug/credentials, the endpoint is disabled if it's empty
+	DebugScope string `json:"debug_scope"`
+
+	AuthzPrefix string      `json:"authz_prefix"`
+	Upstream    *Upstream   `json:"upstream"`
+	RateLimits  *RateLimits `json:"rate_limits"`
+	Timeouts    Timeouts    `json:"timeouts"`
+
+	// MaxHeaderBytes is the limit of the request headers size, DefaultMaxHeaderBytes if it's zero
+	MaxHeaderBytes int `json:"max_header_bytes"`
+}
+
+// TLS is the server certificate of a TLS or an mTLS listener
+type TLS struct {
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"private_key"`
+
+	// ClientScopePrefix is required in a scope of the client certificate to handshake in the mTLS mode, e.g. bob.
+	ClientScopePrefix string `json:"client_scope_prefix"`
+}
+
+// XFCC is the proxies trusted to forward the client certificate in the X-Forwarded-Client-Cert header
+type XFCC struct {
+	Proxies         []string `json:"proxies"`
+	ProxyIdentities []string `json:"proxy_identities"`
+}
+
+// Upstream is the service the authorized requests are proxied to
+type Upstream struct {
+	URL        string `json:"url"`
+	SigningKey string `json:"signing_key"`
+}
+
+// RateLimits are the request limits of the listener, the clients are limited by their name, then by their scopes, then by the default limit
+// the source limit is of every source IP before the requests are authenticated
+// the bucket of a client is keyed by its name only, not by the certificate fingerprint: a bucket per certificate would multiply
+// the limit of a client by its certificates, e.g. a renewed certificate or a token, and the certificates of a name are issued to one client
+type RateLimits struct {
+	Source  *RateLimit           `json:"source"`
+	Default *RateLimit           `json:"default"`
+	Clients map[string]RateLimit `json:"clients"`
+	Scopes  map[string]RateLimit `json:"scopes"`
+}
+
+// RateLimit is a token bucket of Burst requests refilled by Requests per Period, Burst is Requests if it's zero
+type RateLimit struct {
+	Requests int      `json:"requests"`
+	Period   Duration `json:"period"`
+	Burst    int      `json:"burst"`
+}
+
+// ParseRateLimit parses the limit of the requests per period, e.g. 100/1m, the burst is the requests
+func ParseRateLimit(s string) (RateLimit, error) {
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("rate limit %q must be the requests per period, e.g. 100/1m", s)
+	}
+
+	var (
+		l   RateLimit
+		err error
+	)
+	l.Requests, err = strconv.Atoi(requests)
+	if err != nil {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid requests: %w", s, err)
+	}
+
+	l.Period.Duration, err = time.ParseDuration(period)
+	if err != nil {
+		return RateLimit{}, fmt.Errorf("rate limit %q: invalid period: %w", s, err)
+	}
+
+	return l, nil
+}
+
+// Timeouts are the timeouts of the listener HTTP server, the default timeout is applied if it's zero
+type Timeouts struct {
+	ReadHeader Duration `json:"read_header"`
+	Read       Duration `json:"read"`
+	Write      Duration `json:"write"`
+	Idle       Duration `json:"idle"`
+}
+
+// Duration is a time.Duration decoded from a string, e.g. 30s
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON decodes the duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"30s\": %w", err)
+	}
+
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// MarshalJSON encodes the duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Read reads the configuration from the JSON file, or from the YAML file if its extension is .yaml or .yml
+// the unknown fields are rejected, so a typo is not ignored silently
+func Read(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		b, err = yamlToJSON(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode config %s: %w", path, err)
+		}
+	}
+
+	var c Config
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&c)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode config %s: %w", path, err)
+	}
+
+	c.SetDefaults()
+	return &c, nil
+}
+
+// yamlToJSON converts the YAML document to JSON, so the configuration is decoded by its JSON tags either way
+func yamlToJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	err := yaml.Unmarshal(b, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// SetDefaults sets the listener names by their index, the plain mode of the listeners without a mode
+// and the default timeouts and max header bytes of the listeners
+func (c *Config) SetDefaults() {
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if l.Name == "" {
+			l.Name = fmt.Sprintf("listeners[%d]", i)
+		}
+		if l.Mode == "" {
+			l.Mode = ModePlain
+		}
+		if l.MaxHeaderBytes == 0 {
+			l.MaxHeaderBytes = DefaultMaxHeaderBytes
+		}
+		l.Timeouts.setDefaults(l.Upstream != nil)
+	}
+}
+
+// setDefaults sets the default of the zero timeouts, the write timeout of a proxy is left zero
+// so the long proxied or streamed upstream responses are not cut off
+func (t *Timeouts) setDefaults(proxy bool) {
+	defaults := []struct {
+		d *Duration
+		v time.Duration
+	}{
+		{&t.ReadHeader, DefaultReadHeaderTimeout},
+		{&t.Read, DefaultReadTimeout},
+		{&t.Idle, DefaultIdleTimeout},
+	}
+	for _, d := range defaults {
+		if d.d.Duration == 0 {
+			d.d.Duration = d.v
+		}
+	}
+
+	if t.Write.Duration == 0 && !proxy {
+		t.Write.Duration = DefaultWriteTimeout
+	}
+}
+
+// HasAuthMethod reports whether the listener authenticates the clients by the method
+func (l Listener) HasAuthMethod(method string) bool {
+	for _, m := range l.AuthMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
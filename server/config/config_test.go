@@ -0,0 +1,239 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theredrad/certauthz/server/web"
+)
+
+func TestReadYAMLAndJSON(t *testing.T) {
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca_certificate.crt")
+	writeTestFile(t, caPath, "ca")
+
+	yamlPath := filepath.Join(dir, "server.yaml")
+	writeTestFile(t, yamlPath, `
+trust_bundles:
+  primary:
+    ca_certificate: `+caPath+`
+listeners:
+  - address: 127.0.0.1:8585
+    trust_bundle: primary
+    auth_methods: [certificate]
+    routes:
+      - path_prefix: /users
+        methods: [POST]
+        scopes: [bob.user.write]
+    rate_limits:
+      source: {requests: 20, period: 1s, burst: 40}
+      scopes:
+        bob.user.write: {requests: 100, period: 1m}
+    timeouts:
+      read_header: 5s
+`)
+
+	jsonPath := filepath.Join(dir, "server.json")
+	writeTestFile(t, jsonPath, `{
+  "trust_bundles": {"primary": {"ca_certificate": "`+caPath+`"}},
+  "listeners": [{
+    "address": "127.0.0.1:8585",
+    "trust_bundle": "primary",
+    "auth_methods": ["certificate"],
+    "routes": [{"path_prefix": "/users", "methods": ["POST"], "scopes": ["bob.user.write"]}],
+    "rate_limits": {"source": {"requests": 20, "period": "1s", "burst": 40}, "scopes": {"bob.user.write": {"requests": 100, "period": "1m"}}},
+    "timeouts": {"read_header": "5s"}
+  }]
+}`)
+
+	for _, path := range []string{yamlPath, jsonPath} {
+		t.Run(filepath.Ext(path), func(t *testing.T) {
+			c, err := Read(path)
+			if err != nil {
+				t.Fatalf("expected config, got err: %s", err)
+			}
+
+			err = c.Validate()
+			if err != nil {
+				t.Fatalf("expected valid config, got err: %s", err)
+			}
+
+			l := c.Listeners[0]
+			if l.Name != "listeners[0]" || l.Mode != ModePlain {
+				t.Errorf("expected default name and mode, got %q %q", l.Name, l.Mode)
+			}
+			if l.Timeouts.ReadHeader.Duration != 5*time.Second {
+				t.Errorf("expected read header timeout 5s, got %s", l.Timeouts.ReadHeader)
+			}
+			if l.Timeouts.Idle.Duration != DefaultIdleTimeout || l.MaxHeaderBytes != DefaultMaxHeaderBytes {
+				t.Errorf("expected default idle timeout and max header bytes, got %s %d", l.Timeouts.Idle, l.MaxHeaderBytes)
+			}
+			if l.RateLimits == nil || l.RateLimits.Source.Burst != 40 || l.RateLimits.Scopes["bob.user.write"].Period.Duration != time.Minute {
+				t.Errorf("expected rate limits, got %+v", l.RateLimits)
+			}
+			if len(l.Routes) != 1 || l.Routes[0].PathPrefix != "/users" || l.Routes[0].Scopes[0] != "bob.user.write" {
+				t.Errorf("expected route, got %+v", l.Routes)
+			}
+		})
+	}
+}
+
+func TestSetDefaultsWriteTimeout(t *testing.T) {
+	c := &Config{Listeners: []Listener{
+		{Address: "127.0.0.1:8585"},
+		{Address: "127.0.0.1:8586", Upstream: &Upstream{URL: "http://127.0.0.1:8080"}},
+		{Address: "127.0.0.1:8587", Upstream: &Upstream{URL: "http://127.0.0.1:8080"}, Timeouts: Timeouts{Write: Duration{time.Hour}}},
+	}}
+	c.SetDefaults()
+
+	for i, expected := range []time.Duration{DefaultWriteTimeout, 0, time.Hour} {
+		if write := c.Listeners[i].Timeouts.Write.Duration; write != expected {
+			t.Errorf("listener %d: expected write timeout %s, got %s", i, expected, write)
+		}
+	}
+}
+
+func TestReadUnknownField(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	writeTestFile(t, path, "listeners:\n  - address: 127.0.0.1:8585\n    tsl: {}\n")
+
+	_, err := Read(path)
+	if err == nil || !strings.Contains(err.Error(), `unknown field "tsl"`) {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca_certificate.crt")
+	writeTestFile(t, caPath, "ca")
+
+	c := &Config{
+		TrustBundles: map[string]TrustBundle{
+			"primary": {CACertificate: caPath},
+		},
+		Listeners: []Listener{
+			{Name: "a", Address: "127.0.0.1:8585", Mode: ModeMTLS, TrustBundle: "primary", AuthMethods: []string{AuthToken, AuthSession}},
+			{Name: "a", Address: "127.0.0.1:8585", Mode: ModePlain, TrustBundle: "secondary", AuthMethods: []string{AuthTLS}, AuthzPrefix: "/",
+				RateLimits: &RateLimits{Clients: map[string]RateLimit{"alice": {Requests: 10}}}},
+			{Name: "b", Address: "127.0.0.1:8586", Mode: ModePlain, TrustBundle: "primary", AuthMethods: []string{AuthCertificate, AuthSession}},
+			{Name: "c", Address: "127.0.0.1:8587", Mode: ModePlain, TrustBundle: "primary", AuthMethods: []string{AuthXFCC},
+				XFCC: &XFCC{ProxyIdentities: []string{"envoy"}}},
+		},
+	}
+
+	err := c.Validate()
+	errs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	expected := []string{
+		`listener "a": tls: mode mtls requires tls.certificate and tls.private_key`,
+		`listener "a": auth_methods: token requires the token_public_key of trust bundle "primary"`,
+		`listener "a": auth_methods: session requires certificate`,
+		`listener "a": the name is already used`,
+		`listener "a": address: 127.0.0.1:8585 is already used by another listener`,
+		`listener "a": trust_bundle: unknown trust bundle "secondary"`,
+		`listener "a": auth_methods: tls requires the mtls mode`,
+		`listener "a": authz_prefix: "/" must be a path under /`,
+		`listener "a": authz_prefix: the authorization service requires token, certificate or xfcc`,
+		`listener "a": rate_limits.clients.alice: requests and period must be positive`,
+		`listener "b": auth_methods: session requires the tls or mtls mode`,
+		`listener "c": xfcc.proxy_identities: "envoy" must be a URI SAN`,
+		`listener "c": xfcc.proxy_identities: the proxy identities are verified in the mtls mode only`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %d:\n%s", len(expected), len(errs), err)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(errs[i], e) {
+			t.Errorf("expected error %q, got %q", e, errs[i])
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	l, err := ParseRateLimit("100/1m")
+	if err != nil || l.Requests != 100 || l.Period.Duration != time.Minute {
+		t.Errorf("expected 100 requests per minute, got %+v, err: %v", l, err)
+	}
+
+	for _, s := range []string{"100", "x/1m", "100/x"} {
+		if _, err := ParseRateLimit(s); err == nil {
+			t.Errorf("expected error of %q", s)
+		}
+	}
+}
+
+// writeTestFile writes the content to the file of the path
+func writeTestFile(t *testing.T, path, content string) {
+	err := os.WriteFile(path, []byte(content), 0600)
+	if err != nil {
+		t.Fatalf("expected file, got err: %s", err)
+	}
+}
+
+func TestTrustBundleOptions(t *testing.T) {
+	opts, crl, err := TrustBundle{CACertificate: "ca.crt"}.Options(nil)
+	if err != nil || len(opts) != 0 || crl != nil {
+		t.Errorf("expected no options without the CRL and the issuance log, got %d options, err: %v", len(opts), err)
+	}
+
+	_, _, err = TrustBundle{CRL: filepath.Join(t.TempDir(), "missing.crl")}.Options(nil)
+	if err == nil {
+		t.Error("expected error of the missing CRL, got nil")
+	}
+}
+
+func TestListenerScopeMiddlewares(t *testing.T) {
+	scope, route, err := Listener{}.ScopeMiddlewares()
+	if err != nil || scope != nil || route != nil {
+		t.Errorf("expected no middlewares without the scopes, got err: %v", err)
+	}
+
+	scope, route, err = Listener{RequiredScopes: []string{"bob.user.read"}}.ScopeMiddlewares()
+	if err != nil || scope == nil || route == nil {
+		t.Errorf("expected the required scopes middleware of the routes, got err: %v", err)
+	}
+
+	_, _, err = Listener{ScopePolicy: filepath.Join(t.TempDir(), "missing.json")}.ScopeMiddlewares()
+	if err == nil {
+		t.Error("expected error of the missing scope policy, got nil")
+	}
+}
+
+func TestListenerScopeMiddlewareOf(t *testing.T) {
+	// the demo routes require the required scopes only, there're none, the other paths require the scopes of the routes
+	routes := []web.ScopeRule{{PathPrefix: "/", Scopes: []string{"bob.admin"}}}
+	l := Listener{AuthMethods: []string{AuthCertificate}, Routes: routes}
+	proxied := Listener{AuthMethods: []string{AuthCertificate}, Routes: routes, Upstream: &Upstream{URL: "http://127.0.0.1:8080"}}
+
+	tests := []struct {
+		name      string
+		listener  Listener
+		path      string
+		wantRoute bool
+	}{
+		{name: "demo route", listener: l, path: "/cert"},
+		{name: "demo route of another method", listener: l, path: "/token", wantRoute: true},
+		{name: "authorization service path", listener: l, path: "/authz/users", wantRoute: true},
+		{name: "proxied demo route", listener: proxied, path: "/cert", wantRoute: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.listener.ScopeMiddlewareOf(tt.path)
+			if err != nil {
+				t.Fatalf("expected scope middleware, got err: %s", err)
+			}
+
+			if tt.wantRoute != (m != nil) {
+				t.Errorf("expected the scopes of the routes required %t, got %t", tt.wantRoute, m != nil)
+			}
+		})
+	}
+}
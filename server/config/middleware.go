@@ -0,0 +1,87 @@
+package config
+
+import (
+	"crypto/x509"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/ctlog"
+	"github.com/theredrad/certauthz/server/web"
+)
+
+// Options returns the middleware options of the listeners of the trust bundle by its CA certificate, the inclusion verifier
+// of the issuance log and the revocation checker of the CRL if they're set, the server and `cli verify` validate the clients by them
+// the revocation checker is returned to read the CRL again, it's nil if the CRL is not set
+func (b TrustBundle) Options(caCert *x509.Certificate) ([]web.Option, *cert.CRLChecker, error) {
+	var opts []web.Option
+
+	if b.IssuanceLog != "" {
+		l, err := ctlog.OpenReadOnly(b.IssuanceLog)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, web.WithInclusionVerifier(ctlog.NewVerifier(caCert.PublicKey, l)))
+	}
+
+	var crl *cert.CRLChecker
+	if b.CRL != "" {
+		var err error
+		crl, err = cert.NewCRLChecker(b.CRL, caCert)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, web.WithRevocationChecker(crl))
+	}
+
+	return opts, crl, nil
+}
+
+// ScopeMiddlewares returns the middleware of the required scopes of every request and the middleware of the routes,
+// the routes require the scopes of the Routes rules or the ScopePolicy file in addition to the required scopes
+// a middleware is nil if no scope is required
+func (l Listener) ScopeMiddlewares(opts ...web.Option) (web.Middlware, web.Middlware, error) {
+	var scopeMiddleware web.Middlware
+	if len(l.RequiredScopes) > 0 {
+		scopeMiddleware = web.NewScopeMiddleware(l.RequiredScopes, opts...).Handle
+	}
+
+	switch {
+	case l.ScopePolicy != "":
+		policy, err := web.ReadScopePolicy(l.ScopePolicy)
+		if err != nil {
+			return nil, nil, err
+		}
+		return scopeMiddleware, web.Chain(scopeMiddleware, web.NewScopePolicyMiddleware(policy, opts...).Handle), nil
+	case len(l.Routes) > 0:
+		return scopeMiddleware, web.Chain(scopeMiddleware, web.NewScopePolicyMiddleware(&web.ScopePolicy{Rules: l.Routes}, opts...).Handle), nil
+	default:
+		return scopeMiddleware, scopeMiddleware, nil
+	}
+}
+
+// demoRoutes are the routes of the demo handler by the authentication method the clients of the route are authenticated by
+var demoRoutes = map[string]string{
+	"/cert":  AuthCertificate,
+	"/token": AuthToken,
+	"/hmac":  AuthSession,
+}
+
+// ServesDemoRoute reports whether the listener serves the path by the demo handler, the route of an authentication method
+// of the listener is served if the requests aren't proxied to the upstream, it requires the required scopes only
+func (l Listener) ServesDemoRoute(path string) bool {
+	method, ok := demoRoutes[path]
+	return ok && l.Upstream == nil && l.HasAuthMethod(method)
+}
+
+// ScopeMiddlewareOf returns the scope middleware of the request path as the server mounts it, the middleware of the required scopes
+// for the demo routes and the middleware of the routes for the other paths, e.g. of the authorization service and the upstream
+func (l Listener) ScopeMiddlewareOf(path string, opts ...web.Option) (web.Middlware, error) {
+	scopeMiddleware, routeScopeMiddleware, err := l.ScopeMiddlewares(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.ServesDemoRoute(path) {
+		return scopeMiddleware, nil
+	}
+	return routeScopeMiddleware, nil
+}
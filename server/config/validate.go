@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ValidationErrors are the errors of an invalid configuration, all the errors are reported at once
+type ValidationErrors []string
+
+// Error implements error, an error per line
+func (e ValidationErrors) Error() string {
+	return "invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks the configuration and the credential files are readable, it returns ValidationErrors if it's not valid
+func (c *Config) Validate() error {
+	var errs ValidationErrors
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	checkFile := func(field, path string) {
+		if path == "" {
+			addErr("%s is required", field)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			addErr("%s: %s", field, err)
+			return
+		}
+		f.Close()
+	}
+
+	bundleNames := make([]string, 0, len(c.TrustBundles))
+	for name := range c.TrustBundles {
+		bundleNames = append(bundleNames, name)
+	}
+	sort.Strings(bundleNames)
+
+	for _, name := range bundleNames {
+		b := c.TrustBundles[name]
+		field := fmt.Sprintf("trust_bundles.%s", name)
+		checkFile(field+".ca_certificate", b.CACertificate)
+		if b.TokenPublicKey != "" {
+			checkFile(field+".token_public_key", b.TokenPublicKey)
+		}
+		if b.CRL != "" {
+			checkFile(field+".crl", b.CRL)
+		}
+		if b.IssuanceLog != "" {
+			checkFile(field+".issuance_log", b.IssuanceLog)
+		}
+	}
+
+	if len(c.Listeners) == 0 {
+		addErr("listeners: at least one listener is required")
+	}
+
+	names, addresses := make(map[string]bool), make(map[string]bool)
+	for _, l := range c.Listeners {
+		field := func(name string) string {
+			return fmt.Sprintf("listener %q: %s", l.Name, name)
+		}
+
+		if names[l.Name] {
+			addErr("listener %q: the name is already used", l.Name)
+		}
+		names[l.Name] = true
+
+		if _, _, err := net.SplitHostPort(l.Address); err != nil {
+			addErr("%s: %s", field("address"), err)
+		} else if addresses[l.Address] {
+			addErr("%s: %s is already used by another listener", field("address"), l.Address)
+		}
+		addresses[l.Address] = true
+
+		switch l.Mode {
+		case ModePlain:
+			if l.TLS != nil {
+				addErr("%s: tls is set but the mode is plain", field("tls"))
+			}
+		case ModeTLS, ModeMTLS:
+			if l.TLS == nil {
+				addErr("%s: mode %s requires tls.certificate and tls.private_key", field("tls"), l.Mode)
+				break
+			}
+			checkFile(field("tls.certificate"), l.TLS.Certificate)
+			checkFile(field("tls.private_key"), l.TLS.PrivateKey)
+		default:
+			addErr("%s: unknown mode %q, expected plain, tls or mtls", field("mode"), l.Mode)
+		}
+
+		bundle, ok := c.TrustBundles[l.TrustBundle]
+		if !ok {
+			addErr("%s: unknown trust bundle %q", field("trust_bundle"), l.TrustBundle)
+		}
+
+		if len(l.AuthMethods) == 0 {
+			addErr("%s: at least one method is required", field("auth_methods"))
+		}
+		for _, m := range l.AuthMethods {
+			switch m {
+			case AuthToken:
+				if ok && bundle.TokenPublicKey == "" {
+					addErr("%s: token requires the token_public_key of trust bundle %q", field("auth_methods"), l.TrustBundle)
+				}
+			case AuthCertificate:
+			case AuthSession:
+				if !l.HasAuthMethod(AuthCertificate) {
+					addErr("%s: session requires certificate, the sessions are created by the certificate signed requests", field("auth_methods"))
+				}
+				if l.Mode == ModePlain {
+					addErr("%s: session requires the tls or mtls mode, the session key is a bearer credential", field("auth_methods"))
+				}
+			case AuthXFCC:
+				if l.XFCC == nil || len(l.XFCC.Proxies)+len(l.XFCC.ProxyIdentities) == 0 {
+					addErr("%s: xfcc requires xfcc.proxies or xfcc.proxy_identities", field("auth_methods"))
+				}
+			case AuthTLS:
+				if l.Mode != ModeMTLS {
+					addErr("%s: tls requires the mtls mode", field("auth_methods"))
+				}
+			default:
+				addErr("%s: unknown method %q, expected token, certificate, session, xfcc or tls", field("auth_methods"), m)
+			}
+		}
+
+		if l.XFCC != nil {
+			for _, cidr := range l.XFCC.Proxies {
+				if _, _, err := net.ParseCIDR(cidr); err != nil {
+					addErr("%s: %s", field("xfcc.proxies"), err)
+				}
+			}
+			for _, identity := range l.XFCC.ProxyIdentities {
+				if u, err := url.Parse(identity); err != nil || u.Scheme == "" {
+					addErr("%s: %q must be a URI SAN, the common names are not trusted", field("xfcc.proxy_identities"), identity)
+				}
+			}
+			if len(l.XFCC.ProxyIdentities) > 0 && l.Mode != ModeMTLS {
+				addErr("%s: the proxy identities are verified in the mtls mode only", field("xfcc.proxy_identities"))
+			}
+		}
+
+		if len(l.Routes) > 0 && l.ScopePolicy != "" {
+			addErr("%s: routes and scope_policy are exclusive", field("routes"))
+		}
+		for i, rule := range l.Routes {
+			if !strings.HasPrefix(rule.PathPrefix, "/") {
+				addErr("%s: path_prefix %q must start with /", field(fmt.Sprintf("routes[%d]", i)), rule.PathPrefix)
+			}
+		}
+		if l.ScopePolicy != "" {
+			checkFile(field("scope_policy"), l.ScopePolicy)
+		}
+
+		if l.AuthzPrefix != "" {
+			if !strings.HasPrefix(l.AuthzPrefix, "/") || l.AuthzPrefix == "/" {
+				addErr("%s: %q must be a path under /, e.g. /authz", field("authz_prefix"), l.AuthzPrefix)
+			}
+			if !l.HasAuthMethod(AuthToken) && !l.HasAuthMethod(AuthCertificate) && !l.HasAuthMethod(AuthXFCC) {
+				addErr("%s: the authorization service requires token, certificate or xfcc", field("authz_prefix"))
+			}
+		}
+
+		if l.Upstream != nil {
+			u, err := url.Parse(l.Upstream.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				addErr("%s: %q must be an http or https URL", field("upstream.url"), l.Upstream.URL)
+			}
+			if l.Upstream.SigningKey != "" {
+				checkFile(field("upstream.signing_key"), l.Upstream.SigningKey)
+			}
+		}
+
+		if l.RateLimits != nil {
+			checkLimit := func(name string, rl RateLimit) {
+				if rl.Requests <= 0 || rl.Period.Duration <= 0 {
+					addErr("%s: requests and period must be positive", field("rate_limits."+name))
+				}
+				if rl.Burst < 0 {
+					addErr("%s: burst must not be negative", field("rate_limits."+name))
+				}
+			}
+
+			if l.RateLimits.Source != nil {
+				checkLimit("source", *l.RateLimits.Source)
+			}
+			if l.RateLimits.Default != nil {
+				checkLimit("default", *l.RateLimits.Default)
+			}
+			for _, name := range sortedKeys(l.RateLimits.Clients) {
+				checkLimit("clients."+name, l.RateLimits.Clients[name])
+			}
+			for _, scope := range sortedKeys(l.RateLimits.Scopes) {
+				checkLimit("scopes."+scope, l.RateLimits.Scopes[scope])
+			}
+		}
+
+		timeouts := []struct {
+			name string
+			d    Duration
+		}{
+			{"read_header", l.Timeouts.ReadHeader},
+			{"read", l.Timeouts.Read},
+			{"write", l.Timeouts.Write},
+			{"idle", l.Timeouts.Idle},
+		}
+		for _, t := range timeouts {
+			if t.d.Duration < 0 {
+				addErr("%s: must not be negative", field("timeouts."+t.name))
+			}
+		}
+		if l.MaxHeaderBytes < 0 {
+			addErr("%s: must not be negative", field("max_header_bytes"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// sortedKeys returns the sorted keys of the limits, so the errors are reported in the same order
+func sortedKeys(limits map[string]RateLimit) []string {
+	keys := make([]string, 0, len(limits))
+	for k := range limits {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+// CredentialStatus is the status of the credentials the server is loaded by, it's reported on /debug/credentials
+type CredentialStatus struct {
+	TrustBundles     map[string]TrustBundleStatus `json:"trust_bundles"`
+	Listeners        []ListenerStatus             `json:"listeners"`
+	CertificateStore *int                         `json:"certificate_store,omitempty"`
+	LoadErrors       map[string]string            `json:"load_errors,omitempty"`
+}
+
+// TrustBundleStatus is the status of a trust bundle, its CA certificate, its revocation list, its sessions and its certificate cache
+type TrustBundleStatus struct {
+	CACertificate    CertificateStatus `json:"ca_certificate"`
+	CRL              *CRLStatus        `json:"crl,omitempty"`
+	Sessions         *int              `json:"sessions,omitempty"`
+	CertificateCache *cert.CacheStats  `json:"certificate_cache,omitempty"`
+}
+
+// ListenerStatus is the status of a listener and its server certificate, the certificate is nil in the plain mode
+type ListenerStatus struct {
+	Name              string             `json:"name"`
+	Address           string             `json:"address"`
+	Mode              string             `json:"mode"`
+	ServerCertificate *CertificateStatus `json:"server_certificate,omitempty"`
+}
+
+// CertificateStatus is the identity and the validity of a certificate, the fingerprint is the SHA-256 of the DER bytes like openssl prints it
+type CertificateStatus struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	SHA256Fingerprint string    `json:"sha256_fingerprint"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	Expired           bool      `json:"expired"`
+}
+
+// CRLStatus is the freshness of a revocation list, it's stale after its next update until it's refreshed
+type CRLStatus struct {
+	Path       string    `json:"path"`
+	NextUpdate time.Time `json:"next_update"`
+	Stale      bool      `json:"stale"`
+}
+
+// NewCertificateStatus returns the status of the certificate
+func NewCertificateStatus(c *x509.Certificate) CertificateStatus {
+	return CertificateStatus{
+		Subject:           c.Subject.String(),
+		Issuer:            c.Issuer.String(),
+		SerialNumber:      c.SerialNumber.String(),
+		SHA256Fingerprint: Fingerprint(c),
+		NotBefore:         c.NotBefore,
+		NotAfter:          c.NotAfter,
+		Expired:           time.Now().After(c.NotAfter),
+	}
+}
+
+// NewCRLStatus returns the status of the revocation list of the path
+func NewCRLStatus(path string, crl *cert.CRLChecker) *CRLStatus {
+	nextUpdate := crl.NextUpdate()
+	return &CRLStatus{
+		Path:       path,
+		NextUpdate: nextUpdate,
+		Stale:      !nextUpdate.IsZero() && time.Now().After(nextUpdate),
+	}
+}
+
+// Fingerprint returns the colon-separated hex SHA-256 of the certificate DER bytes, e.g. AB:CD:...
+func Fingerprint(c *x509.Certificate) string {
+	return cert.Fingerprint(c.Raw)
+}
+
+// Credentials returns the handler of the credential status in JSON, the status is read on every request
+// the handler must be wrapped by the authentication and the scope middlewares, the status is not public
+func Credentials(status func() CredentialStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(status())
+	}
+}
+
+// LoadErrors holds the error of the last load of every credential by its name, e.g. a server certificate which is not
+// reloaded on SIGHUP, the server keeps the credentials loaded before but it's not ready until they're loaded again
+type LoadErrors struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// Set sets the error of the last load of the credential, a nil error clears it
+func (e *LoadErrors) Set(name string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err == nil {
+		delete(e.errs, name)
+		return
+	}
+
+	if e.errs == nil {
+		e.errs = make(map[string]error)
+	}
+	e.errs[name] = err
+}
+
+// Map returns the errors by the credential names
+func (e *LoadErrors) Map() map[string]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	m := make(map[string]string, len(e.errs))
+	for name, err := range e.errs {
+		m[name] = err.Error()
+	}
+	return m
+}
+
+// Check returns an error of the credentials which are not loaded, it's a check of the readiness
+func (e *LoadErrors) Check() error {
+	m := e.Map()
+	if len(m) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failures := make([]string, len(names))
+	for i, name := range names {
+		failures[i] = fmt.Sprintf("%s: %s", name, m[name])
+	}
+	return errors.New(strings.Join(failures, "; "))
+}
@@ -15,3 +15,9 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("Welcome %s, you are authorized to %s", client.Name, client.Scopes.String())))
 }
+
+// Register responds to a registration request, the client certificate is registered by the certificate middleware
+// and its thumbprint is returned in the X-Client-Cert-ID response header
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Readiness reports whether the server is ready to serve the requests, e.g. to the load balancer probes,
+// it's not ready if a check fails or once the server is draining, so no new requests are routed to it before it shuts down
+type Readiness struct {
+	draining int32
+
+	mu     sync.Mutex
+	checks []readinessCheck
+}
+
+// readinessCheck is a named check of the readiness, it returns an error if the server is not ready
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// AddCheck adds a check of the readiness, it's called on every probe
+func (r *Readiness) AddCheck(name string, check func() error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, readinessCheck{name: name, check: check})
+}
+
+// Drain flips the readiness to not ready
+func (r *Readiness) Drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// Draining reports whether the server is draining
+func (r *Readiness) Draining() bool {
+	return atomic.LoadInt32(&r.draining) == 1
+}
+
+// Check runs the checks and returns the failures by the check names, it's empty if the server is ready
+func (r *Readiness) Check() []string {
+	if r.Draining() {
+		return []string{"draining"}
+	}
+
+	r.mu.Lock()
+	checks := r.checks
+	r.mu.Unlock()
+
+	var failures []string
+	for _, c := range checks {
+		err := c.check()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", c.name, err))
+		}
+	}
+	return failures
+}
+
+// Handle responds 200 if the server is ready, or 503 with a failure per line if it's not
+func (r *Readiness) Handle(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	failures := r.Check()
+	if len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Join(failures, "\n") + "\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready\n"))
+}
+
+// Health responds 200 while the process serves the requests, e.g. to the liveness probes, it doesn't check the credentials
+func Health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
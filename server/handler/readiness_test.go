@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadiness(t *testing.T) {
+	loadErrors := &LoadErrors{}
+	readiness := &Readiness{}
+	readiness.AddCheck("credentials", loadErrors.Check)
+
+	probe := func() (int, string) {
+		w := httptest.NewRecorder()
+		readiness.Handle(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code, w.Body.String()
+	}
+
+	if code, body := probe(); code != http.StatusOK {
+		t.Errorf("expected ready, got %d: %s", code, body)
+	}
+
+	loadErrors.Set("listener a: server certificate", errors.New("no such file"))
+	if code, body := probe(); code != http.StatusServiceUnavailable || !strings.Contains(body, "credentials: listener a: server certificate: no such file") {
+		t.Errorf("expected not ready by the load error, got %d: %s", code, body)
+	}
+
+	// the error is cleared once the credential is loaded again
+	loadErrors.Set("listener a: server certificate", nil)
+	if code, body := probe(); code != http.StatusOK {
+		t.Errorf("expected ready, got %d: %s", code, body)
+	}
+
+	readiness.Drain()
+	if code, body := probe(); code != http.StatusServiceUnavailable || body != "draining\n" {
+		t.Errorf("expected draining, got %d: %s", code, body)
+	}
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/ca"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/key"
+	"github.com/theredrad/certauthz/core/metrics"
+	"github.com/theredrad/certauthz/core/signer"
 	coreTLS "github.com/theredrad/certauthz/core/tls"
+	"github.com/theredrad/certauthz/server/config"
 	"github.com/theredrad/certauthz/server/handler"
 	"github.com/theredrad/certauthz/server/web"
 )
@@ -22,6 +40,49 @@ var (
 	port             = 8585
 	path             = "../credentials"
 	mtls             = false
+	pidFile          = ""
+	configPath       = ""
+
+	auditPath       = ""
+	auditSignerURI  = ""
+	auditCheckpoint = 100
+
+	ctlogDir = ""
+
+	crlPath       = ""
+	crlRefresh    = 5 * time.Minute
+	certCacheSize = 10000
+
+	certStoreSize = 10000
+	issuanceDBDir = ""
+
+	sessionTTL       = 15 * time.Minute
+	sessionStoreSize = 10000
+
+	authzPrefix = ""
+	scopePolicy = ""
+
+	upstreamURL        = ""
+	upstreamSigningKey = ""
+
+	xfccProxies         = ""
+	xfccProxyIdentities = ""
+
+	redactErrors   = false
+	requiredScopes = ""
+	debugScope     = ""
+	metricsAddr    = "127.0.0.1:9585"
+	probeAddr      = ""
+
+	rateLimit       *config.RateLimit
+	sourceRateLimit *config.RateLimit
+
+	shutdownGrace = 30 * time.Second
+	drainDelay    = time.Duration(0)
+
+	passphraseFile   = ""
+	passphraseEnv    = ""
+	passphrasePrompt = false
 )
 
 func init() {
@@ -31,88 +92,978 @@ func init() {
 	flag.StringVar(&path, "path", "../credentials", "credentials path")
 	flag.IntVar(&port, "port", 8585, "server port")
 	flag.BoolVar(&mtls, "mtls", false, "enable mtls, custom authentication is disabled")
+	flag.StringVar(&configPath, "config", "", "configuration file of the listeners in JSON or YAML format, the listener flags are ignored if it's set, e.g. -host, -port and -mtls")
+	flag.StringVar(&pidFile, "pid-file", "", "write the process id to the file, e.g. for the renewal agent to send SIGHUP")
+	flag.StringVar(&auditPath, "audit-log", "", "audit log file of the allow/deny decisions, the decisions are not logged if it's empty")
+	flag.StringVar(&auditSignerURI, "audit-signer", "", "signer URI of the audit log checkpoints, e.g. agent:///path/to/agent.sock?key=primary, the checkpoints are not signed if it's empty")
+	flag.IntVar(&auditCheckpoint, "audit-checkpoint", 100, "number of the audit records between the signed checkpoints")
+	flag.StringVar(&ctlogDir, "ctlog", "", "issuance log directory, the client certificates must be included in the log if it's set")
+	flag.StringVar(&crlPath, "crl", "", "certificate revocation list file in DER format signed by the CA, the revocation is not checked if it's empty")
+	flag.DurationVar(&crlRefresh, "crl-refresh", 5*time.Minute, "interval of reading the certificate revocation list file again")
+	flag.IntVar(&certCacheSize, "cert-cache-size", 10000, "number of the verified client certificates to cache per trust bundle, the cache is disabled if it's zero")
+	flag.IntVar(&certStoreSize, "cert-store-size", 10000, "number of the registered client certificates the clients refer to by thumbprint, the registration is disabled if it's zero")
+	flag.StringVar(&issuanceDBDir, "issuance-db", "", "issuance database directory of the CA, the unregistered thumbprints are resolved from it if it's set")
+	flag.DurationVar(&sessionTTL, "session-ttl", 15*time.Minute, "lifetime of the session keys created on /session, the sessions are disabled if it's zero")
+	flag.IntVar(&sessionStoreSize, "session-store-size", 10000, "number of the sessions held per trust bundle, the oldest sessions are evicted over it and the sessions are disabled if it's zero")
+	flag.StringVar(&authzPrefix, "authz-prefix", "", "path prefix of the authorization service for Envoy ext_authz and nginx auth_request, e.g. /authz, it's disabled if it's empty")
+	flag.StringVar(&scopePolicy, "scope-policy", "", "scope policy file of the routes in JSON format for the authorization service and the upstream, the -required-scopes are required if it's empty")
+	flag.StringVar(&upstreamURL, "upstream", "", "upstream URL the authorized requests are proxied to with the client identity headers, e.g. http://127.0.0.1:8080")
+	flag.StringVar(&upstreamSigningKey, "upstream-signing-key", "", "file including the HMAC-SHA256 key the identity headers are signed by for the upstream, the headers are not signed if it's empty")
+	flag.StringVar(&xfccProxies, "xfcc-proxies", "", "CIDRs of the proxies trusted to forward the client certificate in the X-Forwarded-Client-Cert header, separated by space, e.g. 127.0.0.1/32")
+	flag.StringVar(&xfccProxyIdentities, "xfcc-proxy-identities", "", "URI SANs (e.g. SPIFFE IDs) of the proxies client certificates trusted to forward the client certificate in mTLS mode, separated by space")
+	flag.BoolVar(&redactErrors, "redact-errors", false, "omit the error details from the error responses, e.g. in production")
+	flag.StringVar(&requiredScopes, "required-scopes", "", "scopes required for every request, separated by space")
+	flag.StringVar(&debugScope, "debug-scope", "", "scope required to read the credential status on /debug/credentials, e.g. bob.debug.read, it's disabled if it's empty")
+	flag.Func("rate-limit", "requests per period of every authenticated client, e.g. 100/1m, the clients are not limited if it's not set", parseRateLimitFlag(&rateLimit))
+	flag.Func("source-rate-limit", "requests per period of every source IP before the authentication, e.g. 20/1s, the sources are not limited if it's not set", parseRateLimitFlag(&sourceRateLimit))
+	flag.StringVar(&metricsAddr, "metrics-addr", "127.0.0.1:9585", "address of the separate plain HTTP listener for /metrics, the metrics are not served if it's empty")
+	flag.StringVar(&probeAddr, "probe-addr", "", "address of the separate plain HTTP listener for /healthz and /readyz, e.g. :9586 for the kubelet probes, they're served on -metrics-addr if it's empty")
+	flag.DurationVar(&shutdownGrace, "shutdown-grace", 30*time.Second, "grace period of the active requests on SIGINT or SIGTERM, the connections left are closed after it")
+	flag.DurationVar(&drainDelay, "drain-delay", 0, "period the server keeps serving as not ready on /readyz before it shuts down, e.g. for the load balancers to stop routing to it")
+	flag.StringVar(&passphraseFile, "passphrase-file", "", "file including the private keys passphrase")
+	flag.StringVar(&passphraseEnv, "passphrase-env", "", "environment variable including the private keys passphrase")
+	flag.BoolVar(&passphrasePrompt, "passphrase-prompt", false, "prompt for the private keys passphrase")
 	flag.Parse()
+
+	key.SetPassphraseFunc(key.NewPassphraseFunc(passphraseFile, passphraseEnv, passphrasePrompt))
 }
 
 func main() {
-	h := handler.Handler{}
+	if pidFile != "" {
+		err := file.Write(pidFile, []byte(strconv.Itoa(os.Getpid())))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	mux := http.NewServeMux()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	var tlsConfig *tls.Config
-	if !mtls {
-		certMiddleware, err := web.NewCertificateMiddleware(fmt.Sprintf("%s/%s/ca_certificate.crt", path, primaryName))
+	auditLogger, err := openAuditLogger()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer auditLogger.Close()
+
+	registry := metrics.NewRegistry()
+	handshakeFailures := registry.NewCounterVec("certauthz_tls_handshake_failures_total", "TLS handshake failures by reason.", "reason")
+	certificateExpiry := registry.NewGaugeVec("certauthz_certificate_expiry_days", "Days to the expiry of the server and the CA certificates.", "certificate")
+
+	middlewareOpts := []web.Option{web.WithAuditLogger(auditLogger), web.WithMetrics(web.NewMetrics(registry))}
+	if redactErrors {
+		middlewareOpts = append(middlewareOpts, web.WithRedactedErrors())
+	}
+
+	// every trust bundle caches the certificates verified by its CA certificate, so a reload of a bundle purges its cache only
+	var cacheEvents func(event string)
+	if certCacheSize > 0 {
+		cacheEventsCounter := registry.NewCounterVec("certauthz_certificate_cache_events_total", "Verified client certificate cache hits, misses, evictions and invalidations.", "event")
+		cacheEvents = func(event string) {
+			cacheEventsCounter.Inc(event)
+		}
+	}
+
+	var certStore *web.CertificateStore
+	if certStoreSize > 0 {
+		resolver, err := newIssuanceResolver()
 		if err != nil {
 			log.Fatal(err)
 		}
+		certStore = web.NewCertificateStore(certStoreSize, resolver)
+		middlewareOpts = append(middlewareOpts, web.WithCertificateStore(certStore))
+	}
 
-		jwtMiddleware, err := web.NewJWTokenMiddleware(fmt.Sprintf("%s/%s/public.pub", path, primaryName))
+	// a trust bundle is loaded once, it's shared by its listeners
+	bundles := make(map[string]*trustBundle)
+	for name, b := range cfg.TrustBundles {
+		bundles[name], err = newTrustBundle(b, middlewareOpts, cacheEvents)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("trust bundle %q: %s", name, err)
+		}
+	}
+
+	if certCacheSize > 0 {
+		registry.NewGaugeVec("certauthz_certificate_cache_entries", "Verified client certificates in the cache.").SetFunc(func() float64 {
+			var n int
+			for _, b := range bundles {
+				n += b.certCache.Len()
+			}
+			return float64(n)
+		})
+	}
+
+	// the expiry of the certificate expiring first is reported
+	certificateExpiry.SetFunc(func() float64 {
+		var caCerts []*x509.Certificate
+		for _, b := range bundles {
+			caCerts = append(caCerts, b.CACert())
+		}
+		return daysUntil(earliestExpiry(caCerts))
+	}, "ca")
+
+	h := handler.Handler{}
+
+	// the readiness flips to not ready once the server is draining, the connections are tracked to report the ones left
+	readiness := &handler.Readiness{}
+	connTracker := web.NewConnTracker()
+	connections := registry.NewGaugeVec("certauthz_http_connections", "Open HTTP connections by state.", "state")
+	for _, state := range []http.ConnState{http.StateNew, http.StateActive, http.StateIdle} {
+		state := state
+		connections.SetFunc(func() float64 { return float64(connTracker.Count(state)) }, state.String())
+	}
+
+	// the credentials which are not reloaded are kept, but the server is not ready until they're loaded again
+	loadErrors := &handler.LoadErrors{}
+
+	var listeners []*listener
+	credentials := handler.Credentials(func() handler.CredentialStatus {
+		return credentialStatus(bundles, listeners, certStore, loadErrors)
+	})
+
+	for _, l := range cfg.Listeners {
+		ln, err := newListener(l, bundles[l.TrustBundle], h, credentials, handshakeFailures)
+		if err != nil {
+			log.Fatalf("listener %q: %s", l.Name, err)
+		}
+
+		ln.server.ConnState = connTracker.ConnState
+		listeners = append(listeners, ln)
+	}
+
+	// the server certificates are read on every scrape, they may be reloaded
+	serverCerts := func() []*x509.Certificate {
+		var certs []*x509.Certificate
+		for _, ln := range listeners {
+			if ln.keyPair != nil {
+				certs = append(certs, ln.keyPair.Leaf())
+			}
+		}
+		return certs
+	}
+	if len(serverCerts()) > 0 {
+		certificateExpiry.SetFunc(func() float64 { return daysUntil(earliestExpiry(serverCerts())) }, "server")
+	}
+
+	readiness.AddCheck("credentials", loadErrors.Check)
+	readiness.AddCheck("credential files", func() error { return checkCredentialFiles(bundles, listeners) })
+	readiness.AddCheck("crl", func() error { return checkCRLs(bundles) })
+	readiness.AddCheck("certificates", func() error { return checkExpiry(bundles, listeners) })
+
+	serverErr := make(chan error, len(listeners)+2)
+
+	var servers []*http.Server
+	for _, ln := range listeners {
+		servers = append(servers, ln.server)
+	}
+
+	// the probes are served on a plain listener, a probe can't complete the handshake of an mtls listener
+	probeMux := http.NewServeMux()
+	probeMux.HandleFunc("/healthz", handler.Health)
+	probeMux.HandleFunc("/readyz", readiness.Handle)
+
+	if metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", registry)
+		if probeAddr == "" {
+			metricsMux.Handle("/", probeMux)
 		}
+		servers = append(servers, servePlain("metrics", metricsAddr, metricsMux, serverErr))
+	}
+
+	if probeAddr != "" {
+		servers = append(servers, servePlain("probes", probeAddr, probeMux, serverErr))
+	}
+
+	for _, ln := range listeners {
+		go func(ln *listener) {
+			fmt.Printf("listener %s listening on %s (%s)\n", ln.config.Name, ln.config.Address, ln.config.Mode)
+
+			if ln.config.Mode == config.ModePlain {
+				serverErr <- ln.server.ListenAndServe()
+				return
+			}
+
+			serverErr <- ln.server.ListenAndServeTLS("", "")
+		}(ln)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// a nil channel blocks, the revocation lists are not read again if they're not set
+	var crlTick <-chan time.Time
+	for _, b := range bundles {
+		if b.crl != nil {
+			ticker := time.NewTicker(crlRefresh)
+			defer ticker.Stop()
+			crlTick = ticker.C
+			break
+		}
+	}
+
+	for {
+		select {
+		case <-crlTick:
+			for name, b := range bundles {
+				reloadCRL(name, b.crl, b.certCache, loadErrors)
+			}
+			continue
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				reloadKeyPairs(listeners, loadErrors)
+				for name, b := range bundles {
+					loadErrors.Set(fmt.Sprintf("trust bundle %s: ca certificate", name), b.reloadCACert())
+				}
+				for _, ln := range listeners {
+					reloadTrust(ln.certMiddleware, ln.xfccMiddleware)
+				}
+				for name, b := range bundles {
+					reloadCRL(name, b.crl, b.certCache, loadErrors)
+				}
+				continue
+			}
+			log.Printf("received os signal: %s", s)
+			shutdown(servers, readiness, connTracker, auditLogger, sig)
+		case err := <-serverErr:
+			// the other listeners are drained and the audit log is closed by the final checkpoint before exiting with the error
+			log.Printf("server error: %s", err)
+			shutdown(servers, readiness, connTracker, auditLogger, sig)
+			if err := auditLogger.Close(); err != nil {
+				log.Printf("failed to close the audit log: %s", err)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+}
+
+// loadConfig reads the configuration file, or returns the configuration of the flags if it's not set
+func loadConfig() (*config.Config, error) {
+	var (
+		cfg = configFromFlags()
+		err error
+	)
+	if configPath != "" {
+		cfg, err = config.Read(configPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
 
-		// wrap the handler with JWT middleware
-		clientWithTokenHandler := web.WrapMiddlewares([]web.Middlware{
-			jwtMiddleware.Handle,
-		}, h.Handle)
+	return cfg, nil
+}
 
-		// wrap the handler with certificate middleware
-		clientWithCertHandler := web.WrapMiddlewares([]web.Middlware{
-			certMiddleware.Handle,
-		}, h.Handle)
+// configFromFlags returns the configuration of a listener by the flags, the credentials are read by the path conventions
+// of the CLI, e.g. [path]/[primary-name]/ca_certificate.crt
+func configFromFlags() *config.Config {
+	l := config.Listener{
+		Name:           "default",
+		Address:        net.JoinHostPort(host, strconv.Itoa(port)),
+		Mode:           config.ModePlain,
+		TrustBundle:    primaryName,
+		RequiredScopes: strings.Fields(requiredScopes),
+		ScopePolicy:    scopePolicy,
+		AuthzPrefix:    authzPrefix,
+		DebugScope:     debugScope,
+	}
 
-		mux.HandleFunc("/token", clientWithTokenHandler)
-		mux.HandleFunc("/cert", clientWithCertHandler)
+	bundle := config.TrustBundle{
+		CACertificate: fmt.Sprintf("%s/%s/ca_certificate.crt", path, primaryName),
+		CRL:           crlPath,
+		IssuanceLog:   ctlogDir,
+	}
 
-		fmt.Println("TLS is disabled")
+	if mtls {
+		l.Mode = config.ModeMTLS
+		l.TLS = &config.TLS{
+			Certificate: fmt.Sprintf("%s/%s/certificate.crt", path, serverClientName),
+			PrivateKey:  fmt.Sprintf("%s/%s/private.key", path, serverClientName),
+			// the client certificate must have at least one scope with a "[ServerClientName]." prefix to handshake, e.g. bob.*
+			ClientScopePrefix: fmt.Sprintf("%s.", serverClientName),
+		}
+		l.AuthMethods = []string{config.AuthTLS}
 	} else {
-		var err error
-		tlsConfig, err = coreTLS.NewServerConfig(
-			fmt.Sprintf("%s/%s/ca_certificate.crt", path, primaryName),
-			fmt.Sprintf("%s/%s/certificate.crt", path, serverClientName),
-			fmt.Sprintf("%s/%s/private.key", path, serverClientName),
-			fmt.Sprintf("%s.", serverClientName), // the client certificate must have at least one scope with a "[ServerClientName]." prefix to handshake, e.g. bob.*
-		)
+		bundle.TokenPublicKey = fmt.Sprintf("%s/%s/public.pub", path, primaryName)
+		// the sessions are not created on the plain listener, the session key would be sent in clear
+		l.AuthMethods = []string{config.AuthToken, config.AuthCertificate}
+	}
+
+	if xfccProxies != "" || xfccProxyIdentities != "" {
+		l.XFCC = &config.XFCC{
+			Proxies:         strings.Fields(xfccProxies),
+			ProxyIdentities: strings.Fields(xfccProxyIdentities),
+		}
+		l.AuthMethods = append(l.AuthMethods, config.AuthXFCC)
+	}
+
+	if rateLimit != nil || sourceRateLimit != nil {
+		l.RateLimits = &config.RateLimits{
+			Source:  sourceRateLimit,
+			Default: rateLimit,
+		}
+	}
+
+	if upstreamURL != "" {
+		l.Upstream = &config.Upstream{
+			URL:        upstreamURL,
+			SigningKey: upstreamSigningKey,
+		}
+	}
+
+	cfg := &config.Config{
+		TrustBundles: map[string]config.TrustBundle{primaryName: bundle},
+		Listeners:    []config.Listener{l},
+	}
+	cfg.SetDefaults()
+
+	return cfg
+}
+
+// parseRateLimitFlag returns the parser of a rate limit flag, e.g. 100/1m
+func parseRateLimitFlag(limit **config.RateLimit) func(string) error {
+	return func(s string) error {
+		l, err := config.ParseRateLimit(s)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		*limit = &l
+		return nil
+	}
+}
+
+// trustBundle is a loaded trust bundle, the middlewares of its listeners check the revocation by its revocation list
+// and the inclusion by its issuance log
+type trustBundle struct {
+	config.TrustBundle
+
+	crl       *cert.CRLChecker
+	certCache *cert.VerifiedCache
+	sessions  *web.SessionStore
+	opts      []web.Option
+
+	mu     sync.RWMutex
+	caCert *x509.Certificate
+}
+
+// newTrustBundle reads the CA certificate of the trust bundle and returns a new instance of trustBundle with the middleware options of its listeners
+// the certificates are cached by the bundle if the cache events observer is set
+func newTrustBundle(b config.TrustBundle, opts []web.Option, cacheEvents func(event string)) (*trustBundle, error) {
+	caCert, err := cert.ReadCAFromFile(b.CACertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	// the inclusion and the revocation are checked by the same options as `cli verify` does
+	bundleOpts, crl, err := b.Options(caCert)
+	if err != nil {
+		return nil, err
+	}
+
+	// the nonces are shared by the listeners of the trust bundle, so a signed request is accepted once by all of them
+	tb := &trustBundle{
+		TrustBundle: b,
+		crl:         crl,
+		caCert:      caCert,
+		opts:        append(append([]web.Option{web.WithNonceCache(web.NewNonceCache())}, opts...), bundleOpts...),
+	}
+
+	if cacheEvents != nil {
+		tb.certCache = cert.NewVerifiedCache(certCacheSize, cacheEvents)
+		tb.opts = append(tb.opts, web.WithCertificateCache(tb.certCache))
+	}
+
+	// a session is valid on the listeners of the trust bundle its certificate is validated by
+	if sessionTTL > 0 && sessionStoreSize > 0 {
+		tb.sessions = web.NewSessionStore(sessionTTL, sessionStoreSize)
+	}
+
+	return tb, nil
+}
+
+// CACert returns the CA certificate of the trust bundle, it's read again on SIGHUP
+func (b *trustBundle) CACert() *x509.Certificate {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.caCert
+}
+
+// reloadCACert reads the CA certificate again, the current certificate is kept on failure
+func (b *trustBundle) reloadCACert() error {
+	caCert, err := cert.ReadCAFromFile(b.CACertificate)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.caCert = caCert
+	b.mu.Unlock()
+
+	// the revocation list is verified by the new CA certificate when it's read again
+	if b.crl != nil {
+		b.crl.SetIssuer(caCert)
+	}
+
+	return nil
+}
+
+// listener is the server of a listener configuration and its reloadable credentials
+type listener struct {
+	config config.Listener
+	server *http.Server
+	mux    *http.ServeMux
+
+	keyPair        *coreTLS.KeyPairReloader
+	certMiddleware *web.CertificateMiddleware
+	xfccMiddleware *web.XFCCMiddleware
+}
+
+// newListener returns the server of the listener configuration, the routes are mounted by its authentication methods
+// the credential status is served on /debug/credentials to the clients of the debug scope if it's set
+func newListener(l config.Listener, bundle *trustBundle, h handler.Handler, credentials http.HandlerFunc, handshakeFailures *metrics.CounterVec) (*listener, error) {
+	var (
+		ln   = &listener{config: l, mux: http.NewServeMux()}
+		opts = bundle.opts
+		err  error
+	)
+
+	// the scopes are checked after the client is authenticated, a nil middleware is skipped
+	// the scopes of the routes are required by the scope policy if it's set, e.g. for the authorization service and the upstream,
+	// in addition to the required scopes of every request
+	scopeMiddleware, routeScopeMiddleware, err := l.ScopeMiddlewares(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the authorized requests are served by the demo handler, or proxied to the upstream if it's set
+	final := h.Handle
+	upstream, err := newUpstreamProxy(l.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	if upstream != nil {
+		final = upstream.Handle
+	}
+
+	// the proxies which forward the client certificates, their forwarded requests aren't limited by the proxy address
+	var proxies web.ProxyTrust
+	if l.XFCC != nil {
+		proxies, err = web.ParseProxyTrust(strings.Join(l.XFCC.Proxies, " "), strings.Join(l.XFCC.ProxyIdentities, " "))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the sources are limited before the authentication, so the credentials of a limited request are not verified,
+	// and the clients are limited after it
+	sourceLimit, clientLimit := newRateLimits(l.RateLimits, proxies, opts)
+	authenticated := func(m web.Middlware) web.Middlware {
+		return web.Chain(sourceLimit, m, clientLimit)
+	}
+
+	// the authentication middleware of the authorization service and the upstream is selected by the credentials of the request
+	var authenticators web.Authenticators
+
+	// the client certificate is forwarded by a proxy which terminated the mTLS, e.g. a sidecar
+	var clientWithXFCCHandler http.HandlerFunc
+	if l.HasAuthMethod(config.AuthXFCC) {
+		ln.xfccMiddleware, err = web.NewXFCCMiddleware(bundle.CACertificate, proxies, opts...)
+		if err != nil {
+			return nil, err
+		}
+		authenticators.Forwarded = authenticated(ln.xfccMiddleware.Handle)
+
+		clientWithXFCCHandler = web.WrapMiddlewares([]web.Middlware{
+			authenticators.Forwarded,
+			routeScopeMiddleware,
+		}, final)
+	}
+
+	if l.HasAuthMethod(config.AuthToken) {
+		jwtMiddleware, err := web.NewJWTokenMiddleware(bundle.TokenPublicKey, opts...)
+		if err != nil {
+			return nil, err
+		}
+		authenticators.Token = authenticated(jwtMiddleware.Handle)
+
+		// the demo routes are verified by `cli verify request` as they're mounted
+		if l.ServesDemoRoute("/token") {
+			// wrap the handler with JWT middleware
+			ln.mux.HandleFunc("/token", web.WrapMiddlewares([]web.Middlware{
+				authenticators.Token,
+				scopeMiddleware,
+			}, h.Handle))
+		}
+	}
+
+	if l.HasAuthMethod(config.AuthCertificate) {
+		ln.certMiddleware, err = web.NewCertificateMiddleware(bundle.CACertificate, opts...)
+		if err != nil {
+			return nil, err
 		}
+		authenticators.Certificate = authenticated(ln.certMiddleware.Handle)
 
-		tlsMiddleware := web.NewTLSCertificateMiddleware()
+		ln.mux.HandleFunc("/register", authenticators.Certificate(h.Register))
+
+		if l.ServesDemoRoute("/cert") {
+			// wrap the handler with certificate middleware
+			ln.mux.HandleFunc("/cert", web.WrapMiddlewares([]web.Middlware{
+				authenticators.Certificate,
+				scopeMiddleware,
+			}, h.Handle))
+		}
+	}
+
+	if l.HasAuthMethod(config.AuthSession) {
+		if bundle.sessions == nil {
+			return nil, errors.New("the session method requires a positive -session-ttl and -session-store-size")
+		}
+
+		// a session is created by a certificate signed request, the next requests are signed by the session key
+		authenticators.Session = authenticated(web.NewSessionMiddleware(bundle.sessions, opts...).Handle)
+		ln.mux.HandleFunc("/session", authenticators.Certificate(bundle.sessions.Handle))
+
+		if l.ServesDemoRoute("/hmac") {
+			ln.mux.HandleFunc("/hmac", web.WrapMiddlewares([]web.Middlware{
+				authenticators.Session,
+				scopeMiddleware,
+			}, h.Handle))
+		}
+	}
+
+	// the clients of the debug endpoint are authenticated like the other requests of the listener
+	authenticate := authenticators.Handle
+
+	switch {
+	case l.HasAuthMethod(config.AuthTLS):
+		tlsMiddleware := web.NewTLSCertificateMiddleware(opts...)
+		authenticate = authenticated(tlsMiddleware.Handle)
 
 		clientWithTLSHandler := web.WrapMiddlewares([]web.Middlware{
-			tlsMiddleware.Handle,
-		}, h.Handle)
+			authenticate,
+			routeScopeMiddleware,
+		}, final)
+
+		// a trusted proxy connects by its own certificate and forwards the client certificate in the header
+		ln.mux.HandleFunc("/", web.ForwardedOr(clientWithXFCCHandler, clientWithTLSHandler))
+	case upstream != nil:
+		// every request is authenticated by its credentials and proxied to the upstream
+		ln.mux.HandleFunc("/", web.WrapMiddlewares([]web.Middlware{
+			authenticators.Handle,
+			routeScopeMiddleware,
+		}, upstream.Handle))
+	case clientWithXFCCHandler != nil:
+		ln.mux.HandleFunc("/", clientWithXFCCHandler)
+	}
+
+	if l.AuthzPrefix != "" {
+		// Envoy sends the original path under the prefix, nginx sends the original URI in a header to the prefix
+		prefix := strings.TrimSuffix(l.AuthzPrefix, "/")
+		authzHandler := web.NewAuthzHandler(prefix, authenticators, routeScopeMiddleware)
+		ln.mux.HandleFunc(prefix, authzHandler)
+		ln.mux.HandleFunc(prefix+"/", authzHandler)
+	}
 
-		mux.HandleFunc("/", clientWithTLSHandler)
+	if l.DebugScope != "" {
+		ln.mux.HandleFunc("/debug/credentials", web.WrapMiddlewares([]web.Middlware{
+			authenticate,
+			web.NewScopeMiddleware([]string{l.DebugScope}, opts...).Handle,
+		}, credentials))
+	}
+
+	// the server certificate is reloaded on SIGHUP, e.g. when it's renewed by the renewal agent
+	var tlsConfig *tls.Config
+	if l.Mode != config.ModePlain {
+		ln.keyPair, err = coreTLS.NewKeyPairReloader(l.TLS.Certificate, l.TLS.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig = coreTLS.NewReloadableTLSServerConfig(ln.keyPair)
+		if l.Mode == config.ModeMTLS {
+			// the client certificates are verified by the CA certificate of the trust bundle, it's read again on SIGHUP
+			tlsConfig = coreTLS.NewReloadableTrustServerConfig(bundle.CACert, ln.keyPair, l.TLS.ClientScopePrefix)
+		}
+	}
+
+	ln.server = &http.Server{
+		Addr:              l.Address,
+		Handler:           ln.mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: l.Timeouts.ReadHeader.Duration,
+		ReadTimeout:       l.Timeouts.Read.Duration,
+		WriteTimeout:      l.Timeouts.Write.Duration,
+		IdleTimeout:       l.Timeouts.Idle.Duration,
+		MaxHeaderBytes:    l.MaxHeaderBytes,
+		ErrorLog: coreTLS.NewHandshakeErrorLog(os.Stderr, func(reason string) {
+			handshakeFailures.Inc(reason)
+		}),
+	}
+
+	return ln, nil
+}
+
+// newRateLimits returns the source and the client rate limit middlewares of the listener limits, a middleware is nil if it's not limited
+func newRateLimits(limits *config.RateLimits, proxies web.ProxyTrust, opts []web.Option) (web.Middlware, web.Middlware) {
+	if limits == nil {
+		return nil, nil
+	}
+
+	var sourceLimit, clientLimit web.Middlware
+	if limits.Source != nil {
+		sourceLimit = web.NewSourceRateLimitMiddleware(newRateLimit(*limits.Source), proxies, opts...).Handle
+	}
+
+	policy := web.RateLimitPolicy{
+		Clients: make(map[string]web.RateLimit, len(limits.Clients)),
+		Scopes:  make(map[string]web.RateLimit, len(limits.Scopes)),
+	}
+	if limits.Default != nil {
+		policy.Default = newRateLimit(*limits.Default)
+	}
+	for name, l := range limits.Clients {
+		policy.Clients[name] = newRateLimit(l)
+	}
+	for scope, l := range limits.Scopes {
+		policy.Scopes[scope] = newRateLimit(l)
+	}
+	if limits.Default != nil || len(limits.Clients)+len(limits.Scopes) > 0 {
+		clientLimit = web.NewRateLimitMiddleware(policy, opts...).Handle
+	}
+
+	return sourceLimit, clientLimit
+}
 
-		fmt.Println("TLS is enabled")
+// newRateLimit returns the middleware limit of the configured limit
+func newRateLimit(l config.RateLimit) web.RateLimit {
+	return web.RateLimit{
+		Requests: l.Requests,
+		Period:   l.Period.Duration,
+		Burst:    l.Burst,
 	}
+}
 
-	server := http.Server{
-		Addr:      fmt.Sprintf("%s:%d", host, port),
-		Handler:   mux,
-		TLSConfig: tlsConfig,
+// servePlain serves the handler on a plain HTTP listener of the address and returns its server, the error is sent to the channel
+func servePlain(name, addr string, handler http.Handler, serverErr chan<- error) *http.Server {
+	s := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.DefaultReadHeaderTimeout,
 	}
 
-	serverErr := make(chan error, 1)
 	go func() {
-		fmt.Printf("listening on %s:%d\n", host, port)
+		fmt.Printf("%s listening on %s\n", name, addr)
+		serverErr <- s.ListenAndServe()
+	}()
+
+	return s
+}
+
+// shutdown drains the servers: the readiness flips to not ready and the servers keep serving for the drain delay,
+// then they stop accepting the connections and wait for the active requests up to the grace period, the connections
+// left are closed after it. another SIGINT or SIGTERM exits without waiting, the audit log is closed before
+func shutdown(servers []*http.Server, readiness *handler.Readiness, connTracker *web.ConnTracker, auditLogger *audit.Logger, sig <-chan os.Signal) {
+	go func() {
+		for s := range sig {
+			if s != syscall.SIGHUP {
+				log.Printf("received os signal: %s while draining, exiting", s)
+				if err := auditLogger.Close(); err != nil {
+					log.Printf("failed to close the audit log: %s", err)
+				}
+				os.Exit(1)
+			}
+		}
+	}()
+
+	readiness.Drain()
+	if drainDelay > 0 {
+		log.Printf("draining, not ready for %s", drainDelay)
+		time.Sleep(drainDelay)
+	}
+
+	log.Printf("shutting down, waiting up to %s for %d connections", shutdownGrace, connTracker.Len())
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *http.Server) {
+			defer wg.Done()
+			s.Shutdown(ctx)
+		}(s)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Printf("the grace period is over, closing %d connections", connTracker.Len())
+		for _, s := range servers {
+			s.Close()
+		}
+		return
+	}
+
+	log.Printf("all the connections are drained")
+}
+
+// openAuditLogger opens the audit log of the allow/deny decisions, it returns nil if the audit log is disabled
+func openAuditLogger() (*audit.Logger, error) {
+	if auditPath == "" {
+		return nil, nil
+	}
 
-		if !mtls {
-			serverErr <- server.ListenAndServe()
+	var opts []audit.Option
+	if auditSignerURI != "" {
+		s, err := signer.Open(auditSignerURI)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, audit.WithSigner(s, auditCheckpoint))
+	}
+
+	return audit.Open(auditPath, opts...)
+}
+
+// newUpstreamProxy returns the reverse proxy of the upstream, it returns nil if the upstream is not set
+func newUpstreamProxy(upstream *config.Upstream) (*web.UpstreamProxy, error) {
+	if upstream == nil {
+		return nil, nil
+	}
+
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	var signingKey []byte
+	if upstream.SigningKey != "" {
+		signingKey, err = os.ReadFile(upstream.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return web.NewUpstreamProxy(u, signingKey), nil
+}
+
+// newIssuanceResolver opens the issuance database and returns a resolver of the issued certificates by thumbprint, it returns nil if it's not set
+func newIssuanceResolver() (web.CertificateResolver, error) {
+	if issuanceDBDir == "" {
+		return nil, nil
+	}
+
+	store, err := ca.OpenStore(issuanceDBDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return web.CertificateResolverFunc(func(id string) ([]byte, error) {
+		c, err := store.GetByThumbprint(id)
+		if errors.Is(err, ca.ErrNotFound) {
+			return nil, web.ErrUnknownCertificateID
+		}
+		if err != nil {
+			return nil, err
+		}
+		return c.Raw, nil
+	}), nil
+}
+
+// daysUntil returns the days from now to the time, negative if it's passed
+func daysUntil(t time.Time) float64 {
+	return time.Until(t).Hours() / 24
+}
+
+// reloadKeyPairs reloads the server certificates and private keys of the listeners from the disk
+func reloadKeyPairs(listeners []*listener, loadErrors *handler.LoadErrors) {
+	reloaded := false
+	for _, ln := range listeners {
+		if ln.keyPair == nil {
+			continue
+		}
+		reloaded = true
+
+		err := ln.keyPair.Reload()
+		loadErrors.Set(fmt.Sprintf("listener %s: server certificate", ln.config.Name), err)
+		if err != nil {
+			log.Printf("listener %s: failed to reload server certificate: %s", ln.config.Name, err)
+			continue
+		}
+
+		log.Printf("listener %s: server certificate is reloaded", ln.config.Name)
+	}
+
+	if !reloaded {
+		log.Printf("received SIGHUP, TLS is disabled, nothing to reload")
+	}
+}
+
+// earliestExpiry returns the earliest expiry of the certificates
+func earliestExpiry(certs []*x509.Certificate) time.Time {
+	var earliest time.Time
+	for _, c := range certs {
+		if earliest.IsZero() || c.NotAfter.Before(earliest) {
+			earliest = c.NotAfter
+		}
+	}
+	return earliest
+}
+
+// reloadTrust reads the CA certificate of the certificate middlewares again, the cached client certificates are dropped
+func reloadTrust(certMiddleware *web.CertificateMiddleware, xfccMiddleware *web.XFCCMiddleware) {
+	if certMiddleware != nil {
+		err := certMiddleware.Reload()
+		if err != nil {
+			log.Printf("failed to reload CA certificate: %s", err)
 			return
 		}
+	}
 
-		serverErr <- server.ListenAndServeTLS("", "")
-	}()
+	if xfccMiddleware != nil {
+		err := xfccMiddleware.Reload()
+		if err != nil {
+			log.Printf("failed to reload CA certificate: %s", err)
+			return
+		}
+	}
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	if certMiddleware != nil || xfccMiddleware != nil {
+		log.Printf("CA certificate is reloaded")
+	}
+}
+
+// reloadCRL reads the certificate revocation list again, the cached client certificates of its trust bundle are dropped if it's changed
+func reloadCRL(name string, crl *cert.CRLChecker, certCache *cert.VerifiedCache, loadErrors *handler.LoadErrors) {
+	if crl == nil {
+		return
+	}
+
+	changed, err := crl.Reload()
+	loadErrors.Set(fmt.Sprintf("trust bundle %s: crl", name), err)
+	if err != nil {
+		log.Printf("trust bundle %s: failed to reload certificate revocation list: %s", name, err)
+		return
+	}
+
+	if changed && certCache != nil {
+		certCache.Purge()
+		log.Printf("trust bundle %s: certificate revocation list is changed, the certificate cache is purged", name)
+	}
+}
+
+// checkCRLs returns an error if a revocation list is stale, the certificates of its trust bundle are rejected until it's refreshed
+func checkCRLs(bundles map[string]*trustBundle) error {
+	for _, name := range bundleNames(bundles) {
+		b := bundles[name]
+		if b.crl == nil {
+			continue
+		}
+
+		if status := handler.NewCRLStatus(b.CRL, b.crl); status.Stale {
+			return fmt.Errorf("trust bundle %s: %w since %s", name, cert.ErrStaleCRL, status.NextUpdate.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// checkCredentialFiles returns an error if a CA certificate or a server certificate and private key pair can't be read
+// from the disk, so a credential which would fail to reload is detected before the next SIGHUP
+func checkCredentialFiles(bundles map[string]*trustBundle, listeners []*listener) error {
+	for _, name := range bundleNames(bundles) {
+		if _, err := cert.ReadCAFromFile(bundles[name].CACertificate); err != nil {
+			return fmt.Errorf("trust bundle %s: ca certificate: %w", name, err)
+		}
+	}
+
+	for _, ln := range listeners {
+		if ln.keyPair == nil {
+			continue
+		}
+		if err := ln.keyPair.Check(); err != nil {
+			return fmt.Errorf("listener %s: server certificate: %w", ln.config.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkExpiry returns an error if a CA certificate or a server certificate is expired
+func checkExpiry(bundles map[string]*trustBundle, listeners []*listener) error {
+	now := time.Now()
+	for _, name := range bundleNames(bundles) {
+		if caCert := bundles[name].CACert(); now.After(caCert.NotAfter) {
+			return fmt.Errorf("trust bundle %s: ca certificate is expired since %s", name, caCert.NotAfter.Format(time.RFC3339))
+		}
+	}
+
+	for _, ln := range listeners {
+		if ln.keyPair == nil {
+			continue
+		}
+		if leaf := ln.keyPair.Leaf(); now.After(leaf.NotAfter) {
+			return fmt.Errorf("listener %s: server certificate is expired since %s", ln.config.Name, leaf.NotAfter.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// credentialStatus returns the status of the loaded credentials, the caches and the load errors
+func credentialStatus(bundles map[string]*trustBundle, listeners []*listener, certStore *web.CertificateStore, loadErrors *handler.LoadErrors) handler.CredentialStatus {
+	status := handler.CredentialStatus{
+		TrustBundles: make(map[string]handler.TrustBundleStatus, len(bundles)),
+		LoadErrors:   loadErrors.Map(),
+	}
+
+	for name, b := range bundles {
+		bs := handler.TrustBundleStatus{
+			CACertificate: handler.NewCertificateStatus(b.CACert()),
+		}
+		if b.crl != nil {
+			bs.CRL = handler.NewCRLStatus(b.CRL, b.crl)
+		}
+		if b.sessions != nil {
+			n := b.sessions.Len()
+			bs.Sessions = &n
+		}
+		if b.certCache != nil {
+			stats := b.certCache.Stats()
+			bs.CertificateCache = &stats
+		}
+		status.TrustBundles[name] = bs
+	}
+
+	for _, ln := range listeners {
+		ls := handler.ListenerStatus{
+			Name:    ln.config.Name,
+			Address: ln.config.Address,
+			Mode:    ln.config.Mode,
+		}
+		if ln.keyPair != nil {
+			cs := handler.NewCertificateStatus(ln.keyPair.Leaf())
+			ls.ServerCertificate = &cs
+		}
+		status.Listeners = append(status.Listeners, ls)
+	}
+
+	if certStore != nil {
+		n := certStore.Len()
+		status.CertificateStore = &n
+	}
+
+	return status
+}
 
-	select {
-	case s := <-sig:
-		log.Printf("received os signal: %s", s)
-	case err := <-serverErr:
-		log.Fatalf("server error: %s", err)
+// bundleNames returns the sorted names of the trust bundles, so the checks are reported in the same order
+func bundleNames(bundles map[string]*trustBundle) []string {
+	names := make([]string, 0, len(bundles))
+	for name := range bundles {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// originalURIHeader and originalMethodHeader are the original request of an nginx auth_request subrequest,
+	// e.g. proxy_set_header X-Original-URI $request_uri
+	originalURIHeader    = "X-Original-URI"
+	originalMethodHeader = "X-Original-Method"
+
+	// authClientHeader and authScopesHeader are the identity of the authorized client returned to the proxy for the upstream
+	authClientHeader = "X-Auth-Client"
+	authScopesHeader = "X-Auth-Scopes"
+)
+
+// Authenticators are the authentication middlewares of the authorization service, the middleware is selected by the credentials of the request
+// a nil middleware is not supported, its credentials are denied
+type Authenticators struct {
+	Token       Middlware
+	Certificate Middlware
+	Session     Middlware
+	Forwarded   Middlware
+}
+
+// selectMiddleware returns the middleware of the request credentials, the token middleware challenges a request without credentials
+func (a Authenticators) selectMiddleware(r *http.Request) Middlware {
+	switch {
+	case r.Header.Get(sessionIDHeader) != "":
+		return a.Session
+	case r.Header.Get(forwardedClientCertHeader) != "":
+		return a.Forwarded
+	case r.Header.Get(clientCertHeader) != "" || r.Header.Get(clientCertIDHeader) != "":
+		return a.Certificate
+	default:
+		return a.Token
+	}
+}
+
+// Handle implements Middleware signature to authenticate the request by the middleware of its credentials
+func (a Authenticators) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		middleware := a.selectMiddleware(r)
+		if middleware == nil {
+			writeProblem(w, newProblem(CodeMissingCredentials, "the credentials are not supported"))
+			return
+		}
+
+		middleware(next)(w, r)
+	}
+}
+
+// NewAuthzHandler returns the handler of the authorization service for the external authorization of the proxies,
+// compatible with the Envoy HTTP ext_authz and the nginx auth_request
+// Envoy sends the original request with the path under the prefix, nginx sends the original URI and method in the X-Original-URI and X-Original-Method headers
+// the request is authenticated by the middleware of its credentials and its scopes are checked, an allowed request is responded by 200
+// with the client identity in the X-Auth-Client and X-Auth-Scopes headers for the upstream, a denied request by the problem of the middleware
+func NewAuthzHandler(prefix string, authenticators Authenticators, scopeMiddleware Middlware) http.HandlerFunc {
+	allow := func(w http.ResponseWriter, r *http.Request) {
+		client := ClientFromContext(r.Context())
+
+		w.Header().Set(authClientHeader, client.Name)
+		w.Header().Set(authScopesHeader, scopesString(client.Scopes))
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		// nginx treats a status of the auth_request other than 2xx, 401 and 403 as an error of the authorization service
+		if r.Header.Get(originalURIHeader) != "" {
+			w = &authRequestWriter{ResponseWriter: w}
+		}
+
+		original, err := originalRequest(r, prefix)
+		if err != nil {
+			writeProblem(w, newProblem(CodeInvalidRequest, err.Error()))
+			return
+		}
+
+		WrapMiddlewares([]Middlware{authenticators.Handle, scopeMiddleware}, allow)(w, original)
+	}
+}
+
+// authRequestWriter maps the denial statuses for the nginx auth_request, an invalid request is 401 and any other denial is 403
+// the problem body keeps its code
+type authRequestWriter struct {
+	http.ResponseWriter
+}
+
+// WriteHeader writes the mapped status
+func (w *authRequestWriter) WriteHeader(status int) {
+	switch {
+	case status < http.StatusBadRequest, status == http.StatusUnauthorized, status == http.StatusForbidden:
+	case status == http.StatusBadRequest:
+		status = http.StatusUnauthorized
+	default:
+		status = http.StatusForbidden
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// originalRequest returns the original request of the authorization request, the signature is validated by its method and URI
+func originalRequest(r *http.Request, prefix string) (*http.Request, error) {
+	original := r.Clone(r.Context())
+
+	if method := r.Header.Get(originalMethodHeader); method != "" {
+		original.Method = method
+	}
+
+	requestURI := r.Header.Get(originalURIHeader)
+	if requestURI == "" {
+		// the path prefix of the Envoy ext_authz is prepended to the original path
+		requestURI = strings.TrimPrefix(r.RequestURI, prefix)
+		if !strings.HasPrefix(requestURI, "/") {
+			requestURI = "/" + requestURI
+		}
+	}
+
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return nil, err
+	}
+
+	original.URL = u
+	original.RequestURI = requestURI
+
+	// the body of the original request is not forwarded by default, its hash is of the forwarded body
+	return original, nil
+}
@@ -3,9 +3,10 @@ package web
 import (
 	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
+	"sync"
 	"time"
 
 	"github.com/theredrad/certauthz/core/cert"
@@ -16,116 +17,188 @@ import (
 const (
 	// clientCertHeader base64-encoded client certificate header key
 	clientCertHeader = "X-Client-Cert"
+)
 
-	// allowedTimeWindowSec hmac signature expiration time in second since X-Timestamp header
-	allowedTimeWindowSec = 600
+var (
+	errMissingClientCertificate = errors.New("client certificate header is missing")
 )
 
 // CertificateMiddleware is a middleware to validate the client ceritificate by the CA certificate
 type CertificateMiddleware struct {
+	caPath  string
+	nonces  *NonceCache
+	options options
+
+	mu            sync.RWMutex
 	certValidator *cert.Validator
 }
 
 // NewCertificateMiddleware accepts CA certificate path and returns a new instance of CertificateMiddleware
-func NewCertificateMiddleware(caPath string) (*CertificateMiddleware, error) {
-	caCert, err := cert.ReadFromDERFile(caPath)
+func NewCertificateMiddleware(caPath string, opts ...Option) (*CertificateMiddleware, error) {
+	o := newOptions(methodCertificate, opts)
+	m := &CertificateMiddleware{
+		caPath:  caPath,
+		nonces:  o.nonceCache(),
+		options: o,
+	}
+
+	err := m.Reload()
 	if err != nil {
 		return nil, err
 	}
 
-	return &CertificateMiddleware{
-		certValidator: cert.NewValidator(caCert),
-	}, nil
+	return m, nil
 }
 
-// Handle implements Middleware signature to validate the request client certificate
-func (m *CertificateMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// read client base64-encoded certificate
-		clientCertStr := r.Header.Get(clientCertHeader)
+// Reload reads the CA certificate from the disk, the cached validation results of the previous CA certificate are dropped
+func (m *CertificateMiddleware) Reload() error {
+	caCert, err := cert.ReadCAFromFile(m.caPath)
+	if err != nil {
+		return err
+	}
 
-		// validates the client certificate by CA certificate
-		clientCert, err := m.validateClientCertificate(clientCertStr)
-		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(err.Error()))
-			return
-		}
+	m.mu.Lock()
+	m.certValidator = cert.NewValidator(caCert, m.options.validatorOptions()...)
+	m.mu.Unlock()
 
-		// read hmac signature from the header
-		signature := r.Header.Get("X-Signature")
+	if m.options.cache != nil {
+		m.options.cache.Purge()
+	}
 
-		timestampStr := r.Header.Get("X-Timestamp")
+	return nil
+}
 
-		if timestampStr == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("timestamp header is missing"))
+// Handle implements Middleware signature to validate the request client certificate
+func (m *CertificateMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// read client base64-encoded certificate, or the registered certificate by its thumbprint
+		certBytes, byReference, err := m.clientCertificateBytes(r)
+		if err != nil {
+			code := CodeInvalidCertificate
+			switch {
+			case errors.Is(err, errMissingClientCertificate):
+				code = CodeMissingCredentials
+			case errors.Is(err, ErrUnknownCertificateID):
+				code = CodeUnknownCertificateID
+			case byReference:
+				code = CodeInternalError
+			}
+			m.options.trace(CheckClientCertificate, code, err)
+			m.options.deny(w, r, schemeSignature, code, err.Error())
 			return
 		}
+		m.options.trace(CheckClientCertificate, "", nil)
 
-		requestTimestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+		// validates the client certificate by CA certificate
+		start := time.Now()
+		clientCert, code, err := m.VerifyCertificate(certBytes)
+		m.options.metrics.observeCertificateVerify(methodCertificate, start)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			m.options.deny(w, r, schemeSignature, code, err.Error())
 			return
 		}
 
-		// validates if signature is not expired by allowed time window config
-		timestampNow := time.Now().Unix()
-		different := timestampNow - requestTimestamp
-		if different < -allowedTimeWindowSec || different > allowedTimeWindowSec {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("timestamp is expired"))
+		params, signature, code, err := readSignedRequest(r, m.options.now())
+		m.options.trace(CheckSignedRequest, code, err)
+		if err != nil {
+			m.options.deny(w, r, schemeSignature, code, err.Error())
 			return
 		}
 
-		// TODO: check nonce duplication
-
-		// calculate md5 hash of body content
-		var bodyHash string
-		if r.Body != nil && r.Body != http.NoBody {
-			bodyHash, err = hmac.CalculateMD5Hash(r.Body)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
-				return
-			}
-		}
-
-		// validates the request hmac signature by client public key (from client certificate)
-		// a valid hmac signature proves client is who it is
-		err = hmac.ValidateSignature(clientCert, signature, hmac.Params{
-			Method:    r.Method,
-			BodyMD5:   bodyHash,
-			URI:       fmt.Sprintf("%s://%s%s", "http", r.Host, r.RequestURI), // TODO: support tls
-			Nonce:     r.Header.Get("X-Nonce"),
-			Timestamp: timestampStr,
-		})
+		code, err = m.verifySignature(clientCert, signature, params)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(err.Error()))
+			m.options.deny(w, r, schemeSignature, code, err.Error())
 			return
 		}
 
 		// read scopes from the client cerificate
 		scopes := cert.ScopesFromCertificate(clientCert)
 
-		// set the client in the context, so the handler has access to the authorized client
-		ctx := setClient(r.Context(), common.Client{
+		client := common.Client{
 			Name:   clientCert.Subject.CommonName,
 			Scopes: scopes,
-		})
+		}
+		m.options.allow(r, client)
+
+		// the client sends the thumbprint instead of the certificate in the next requests
+		if m.options.store != nil && !byReference {
+			w.Header().Set(clientCertIDHeader, m.options.store.Register(certBytes))
+		}
+
+		// set the client in the context, so the handler has access to the authorized client
+		ctx := setClient(r.Context(), client)
+		ctx = setClientCertificate(ctx, clientCert)
 
 		r = r.WithContext(ctx)
 		next(w, r)
 	}
 }
 
-// validateClientCertificate accepts base64-encoded client certificate and validates it
-func (m *CertificateMiddleware) validateClientCertificate(clientCertStr string) (*x509.Certificate, error) {
-	certBytes, err := base64.StdEncoding.DecodeString(clientCertStr)
+// verifySignature validates the signature of the params by the client certificate public key and uses the nonce
+// the error code of the problem is returned on failure
+func (m *CertificateMiddleware) verifySignature(clientCert *x509.Certificate, signature string, params hmac.Params) (string, error) {
+	// validates the request hmac signature by client public key (from client certificate)
+	// a valid hmac signature proves client is who it is
+	start := time.Now()
+	err := hmac.ValidateSignature(clientCert, signature, params)
+	m.options.metrics.observeSignatureVerify(start)
+	m.options.trace(CheckSignature, CodeBadSignature, err)
 	if err != nil {
-		return nil, err
+		return CodeBadSignature, err
+	}
+
+	// the nonce is recorded after the signature is validated, so it can't be used up by an unauthenticated request
+	// the nonces are scoped by the client certificate, nonces of different clients don't collide
+	if !m.nonces.use(fmt.Sprintf("%s/%s", clientCert.SerialNumber, params.Nonce), time.Now()) {
+		err = errors.New("nonce is already used")
+		m.options.trace(CheckNonce, CodeReplayedNonce, err)
+		return CodeReplayedNonce, err
+	}
+	m.options.trace(CheckNonce, "", nil)
+
+	return "", nil
+}
+
+// VerifyCertificate validates the DER-encoded client certificate as the certificates of the requests are,
+// by the CA certificate and the revocation and the inclusion options, e.g. to reproduce a decision offline
+// the error code of the problem is returned on failure
+func (m *CertificateMiddleware) VerifyCertificate(certBytes []byte) (*x509.Certificate, string, error) {
+	clientCert, err := m.validateClientCertificate(certBytes)
+	if err != nil {
+		code := certificateErrorCode(err)
+		m.options.trace(CheckCertificate, code, err)
+		return nil, code, err
+	}
+	m.options.trace(CheckCertificate, "", nil)
+
+	return clientCert, "", nil
+}
+
+// clientCertificateBytes returns the DER-encoded client certificate of the request and whether it's resolved by the thumbprint
+// the certificate header is preferred, the thumbprint header is used if the certificate store is set
+func (m *CertificateMiddleware) clientCertificateBytes(r *http.Request) ([]byte, bool, error) {
+	if clientCertStr := r.Header.Get(clientCertHeader); clientCertStr != "" {
+		certBytes, err := base64.StdEncoding.DecodeString(clientCertStr)
+		return certBytes, false, err
+	}
+
+	id := r.Header.Get(clientCertIDHeader)
+	if id == "" || m.options.store == nil {
+		return nil, false, errMissingClientCertificate
+	}
+
+	certBytes, err := m.options.store.Resolve(id)
+	return certBytes, true, err
+}
+
+// validateClientCertificate accepts DER-encoded client certificate and validates it
+func (m *CertificateMiddleware) validateClientCertificate(certBytes []byte) (*x509.Certificate, error) {
+	m.mu.RLock()
+	certValidator := m.certValidator
+	m.mu.RUnlock()
+
+	if m.options.cache != nil {
+		return m.options.cache.Validate(certValidator, certBytes)
 	}
 
 	clientCert, err := cert.DecodeFromDERBytes(certBytes)
@@ -133,7 +206,7 @@ func (m *CertificateMiddleware) validateClientCertificate(clientCertStr string)
 		return nil, err
 	}
 
-	err = m.certValidator.Validate(clientCert)
+	err = certValidator.Validate(clientCert)
 	if err != nil {
 		return nil, err
 	}
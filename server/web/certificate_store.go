@@ -0,0 +1,118 @@
+package web
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/theredrad/certauthz/core/cert"
+)
+
+const (
+	// clientCertIDHeader is the thumbprint of a registered client certificate, it's sent instead of the client certificate header
+	clientCertIDHeader = "X-Client-Cert-ID"
+)
+
+var (
+	ErrUnknownCertificateID = errors.New("certificate id is unknown")
+)
+
+// CertificateResolver returns the DER-encoded certificate of the thumbprint, e.g. from the issuance database
+type CertificateResolver interface {
+	Resolve(id string) ([]byte, error)
+}
+
+// CertificateResolverFunc is an adapter to use a function as CertificateResolver
+type CertificateResolverFunc func(id string) ([]byte, error)
+
+// Resolve calls the function
+func (f CertificateResolverFunc) Resolve(id string) ([]byte, error) {
+	return f(id)
+}
+
+// CertificateStore holds the registered client certificates by their thumbprint, so the clients send the thumbprint
+// in the X-Client-Cert-ID header instead of the whole certificate on every request
+// the least recently used certificates are evicted over the size, the client falls back to the whole certificate
+type CertificateStore struct {
+	size     int
+	fallback CertificateResolver
+
+	mu      sync.Mutex
+	entries *list.List
+	index   map[string]*list.Element
+}
+
+// storedCertificate is a registered certificate
+type storedCertificate struct {
+	id  string
+	der []byte
+}
+
+// NewCertificateStore returns a new instance of CertificateStore holding up to size certificates
+// the unknown thumbprints are resolved by the fallback if it's not nil
+func NewCertificateStore(size int, fallback CertificateResolver) *CertificateStore {
+	return &CertificateStore{
+		size:     size,
+		fallback: fallback,
+		entries:  list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// Register stores the DER-encoded certificate and returns its thumbprint
+// the certificate must be validated before, the stored certificates are validated on every use anyway
+func (s *CertificateStore) Register(der []byte) string {
+	id := cert.Thumbprint(der)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.index[id]; ok {
+		s.entries.MoveToFront(e)
+		return id
+	}
+
+	s.index[id] = s.entries.PushFront(&storedCertificate{id: id, der: der})
+	for s.entries.Len() > s.size {
+		e := s.entries.Back()
+		s.entries.Remove(e)
+		delete(s.index, e.Value.(*storedCertificate).id)
+	}
+
+	return id
+}
+
+// Resolve returns the DER-encoded certificate of the thumbprint, ErrUnknownCertificateID if it's not registered and not resolved by the fallback
+func (s *CertificateStore) Resolve(id string) ([]byte, error) {
+	s.mu.Lock()
+	if e, ok := s.index[id]; ok {
+		s.entries.MoveToFront(e)
+		s.mu.Unlock()
+		return e.Value.(*storedCertificate).der, nil
+	}
+	s.mu.Unlock()
+
+	if s.fallback == nil {
+		return nil, ErrUnknownCertificateID
+	}
+
+	der, err := s.fallback.Resolve(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the thumbprint is checked, the fallback must not return another certificate
+	if cert.Thumbprint(der) != id {
+		return nil, ErrUnknownCertificateID
+	}
+
+	s.Register(der)
+	return der, nil
+}
+
+// Len returns the number of the registered certificates
+func (s *CertificateStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries.Len()
+}
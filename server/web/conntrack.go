@@ -0,0 +1,56 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ConnTracker tracks the connections of the HTTP servers by their state, e.g. to report the connections left while draining
+// the hijacked and the closed connections are not tracked anymore
+type ConnTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+// NewConnTracker returns a new instance of ConnTracker, its ConnState is set as the ConnState hook of the servers
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{
+		conns: make(map[net.Conn]http.ConnState),
+	}
+}
+
+// ConnState records the state of the connection, it implements the http.Server ConnState hook
+func (t *ConnTracker) ConnState(c net.Conn, state http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch state {
+	case http.StateHijacked, http.StateClosed:
+		delete(t.conns, c)
+	default:
+		t.conns[c] = state
+	}
+}
+
+// Count returns the number of the connections in the state
+func (t *ConnTracker) Count(state http.ConnState) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var n int
+	for _, s := range t.conns {
+		if s == state {
+			n++
+		}
+	}
+	return n
+}
+
+// Len returns the number of the open connections
+func (t *ConnTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.conns)
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"context"
+	"crypto/x509"
 
 	"github.com/theredrad/certauthz/core/common"
 )
@@ -10,6 +11,7 @@ type contextKey int
 
 const (
 	clientKey contextKey = iota
+	clientCertificateKey
 )
 
 // setClient sets the client in the context
@@ -23,3 +25,14 @@ func ClientFromContext(ctx context.Context) common.Client {
 	client, _ := val.(common.Client)
 	return client
 }
+
+// setClientCertificate sets the validated client certificate in the context
+func setClientCertificate(ctx context.Context, clientCert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, clientCertificateKey, clientCert)
+}
+
+// ClientCertificateFromContext reads the validated client certificate from the context, it's nil if the client is not authenticated by a certificate
+func ClientCertificateFromContext(ctx context.Context) *x509.Certificate {
+	clientCert, _ := ctx.Value(clientCertificateKey).(*x509.Certificate)
+	return clientCert
+}
@@ -0,0 +1,321 @@
+package web
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/common"
+	"github.com/theredrad/certauthz/core/hmac"
+	jwtCore "github.com/theredrad/certauthz/core/jwt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// rpcMethod is the HTTP method of the gRPC calls, the signatures and the scope policy rules of the calls are matched by it
+	rpcMethod = http.MethodPost
+
+	// rpcErrorDomain is the domain of the error info of the gRPC status, its reason is the stable error code
+	rpcErrorDomain = "certauthz"
+)
+
+// RPCAuthenticator authenticates a gRPC call by its metadata or its peer, it returns the context with the authorized client
+// the error is the gRPC status of the problem
+type RPCAuthenticator interface {
+	AuthenticateRPC(ctx context.Context, fullMethod string) (context.Context, error)
+}
+
+// RPCAuthenticators are the authenticators of the gRPC calls, the authenticator is selected by the credentials metadata of the call
+// the TLS authenticator authenticates the calls without credentials metadata if it's set, e.g. on an mTLS listener
+type RPCAuthenticators struct {
+	Token       RPCAuthenticator
+	Certificate RPCAuthenticator
+	TLS         RPCAuthenticator
+}
+
+// selectAuthenticator returns the authenticator of the call credentials, the token authenticator denies a call without credentials
+func (a RPCAuthenticators) selectAuthenticator(md metadata.MD) RPCAuthenticator {
+	switch {
+	case metadataValue(md, authorizationHeader) != "":
+		return a.Token
+	case metadataValue(md, clientCertHeader) != "":
+		return a.Certificate
+	case a.TLS != nil:
+		return a.TLS
+	default:
+		return a.Token
+	}
+}
+
+// GRPCInterceptor authenticates and authorizes the gRPC calls by the schemes of the HTTP middlewares, the credentials are sent in the metadata
+// the client is set in the context of the handler, it's read by ClientFromContext
+type GRPCInterceptor struct {
+	authenticators RPCAuthenticators
+	scopes         *ScopeMiddleware
+}
+
+// NewGRPCInterceptor accepts the authenticators and the scope middleware and returns a new instance of GRPCInterceptor
+// the scopes are not checked if the scope middleware is nil
+func NewGRPCInterceptor(authenticators RPCAuthenticators, scopes *ScopeMiddleware) *GRPCInterceptor {
+	return &GRPCInterceptor{
+		authenticators: authenticators,
+		scopes:         scopes,
+	}
+}
+
+// Unary returns the unary server interceptor, e.g. grpc.ChainUnaryInterceptor(i.Unary())
+func (i *GRPCInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := i.authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the stream server interceptor, the stream is authorized once when it's opened
+func (i *GRPCInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorize authenticates the call by the authenticator of its credentials and checks its scopes
+func (i *GRPCInterceptor) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	authenticator := i.authenticators.selectAuthenticator(md)
+	if authenticator == nil {
+		return nil, rpcError(newProblem(CodeMissingCredentials, "the credentials are not supported"))
+	}
+
+	ctx, err := authenticator.AuthenticateRPC(ctx, fullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	if i.scopes != nil {
+		err = i.scopes.AuthorizeRPC(ctx, fullMethod)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ctx, nil
+}
+
+// authorizedStream is the server stream with the context of the authorized client
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the authorized client
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// AuthenticateRPC implements RPCAuthenticator to validate the JWT of the authorization metadata
+func (m *JWTokenMiddleware) AuthenticateRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	tokenHeader := metadataValue(md, authorizationHeader)
+	if tokenHeader == "" {
+		return nil, m.options.denyRPC(fullMethod, CodeMissingCredentials, "authorization metadata is missing")
+	}
+
+	parsedHeader := strings.Split(tokenHeader, " ")
+	if len(parsedHeader) != 2 || parsedHeader[0] != tokenType {
+		return nil, m.options.denyRPC(fullMethod, CodeInvalidRequest, "invalid authorization metadata")
+	}
+
+	start := time.Now()
+	token, err := m.validateClientToken(parsedHeader[1])
+	m.options.metrics.observeJWTVerify(start)
+	if err != nil {
+		return nil, m.options.denyRPC(fullMethod, tokenErrorCode(err), err.Error())
+	}
+
+	client := jwtCore.ClientFromToken(token)
+	m.options.recordAllow(rpcSubject(fullMethod), client)
+
+	return setClient(ctx, client), nil
+}
+
+// AuthenticateRPC implements RPCAuthenticator to validate the client certificate of the metadata and the call signature
+// the signature is of the POST method and the full method name as the URI, the messages are not signed
+func (m *CertificateMiddleware) AuthenticateRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		return metadataValue(md, key)
+	}
+
+	clientCertStr := get(clientCertHeader)
+	if clientCertStr == "" {
+		return nil, m.options.denyRPC(fullMethod, CodeMissingCredentials, "client certificate metadata is missing")
+	}
+
+	certBytes, err := base64.StdEncoding.DecodeString(clientCertStr)
+	if err != nil {
+		return nil, m.options.denyRPC(fullMethod, CodeInvalidCertificate, err.Error())
+	}
+
+	start := time.Now()
+	clientCert, code, err := m.VerifyCertificate(certBytes)
+	m.options.metrics.observeCertificateVerify(methodCertificate, start)
+	if err != nil {
+		return nil, m.options.denyRPC(fullMethod, code, err.Error())
+	}
+
+	signature, timestamp, nonce, code, err := readSignatureHeaders(get, m.options.now())
+	if err != nil {
+		return nil, m.options.denyRPC(fullMethod, code, err.Error())
+	}
+
+	code, err = m.verifySignature(clientCert, signature, hmac.Params{
+		Method:    rpcMethod,
+		URI:       fullMethod,
+		Nonce:     nonce,
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		return nil, m.options.denyRPC(fullMethod, code, err.Error())
+	}
+
+	client := common.Client{
+		Name:   clientCert.Subject.CommonName,
+		Scopes: cert.ScopesFromCertificate(clientCert),
+	}
+	m.options.recordAllow(rpcSubject(fullMethod), client)
+
+	ctx = setClient(ctx, client)
+	return setClientCertificate(ctx, clientCert), nil
+}
+
+// AuthenticateRPC implements RPCAuthenticator to read the client certificate verified by the TLS handshake of the peer
+func (m *TLSCertificateMiddleware) AuthenticateRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, m.options.denyRPC(fullMethod, CodeMissingCredentials, "peer not found")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, m.options.denyRPC(fullMethod, CodeMissingCredentials, "client certificate not found")
+	}
+	clientCert := tlsInfo.State.PeerCertificates[0]
+
+	err := m.checkPeerCertificate(clientCert)
+	if err != nil {
+		return nil, m.options.denyRPC(fullMethod, certificateErrorCode(err), err.Error())
+	}
+
+	client := common.Client{
+		Name:   clientCert.Subject.CommonName,
+		Scopes: cert.ScopesFromCertificate(clientCert),
+	}
+	m.options.recordAllow(rpcSubject(fullMethod), client)
+
+	ctx = setClient(ctx, client)
+	return setClientCertificate(ctx, clientCert), nil
+}
+
+// AuthorizeRPC checks the scopes of the authorized client of the call, the scope policy rules match the full method name as the path
+func (m *ScopeMiddleware) AuthorizeRPC(ctx context.Context, fullMethod string) error {
+	client := ClientFromContext(ctx)
+
+	scopes := m.scopes
+	if m.policy != nil {
+		var ok bool
+		scopes, ok = m.policy.RequiredScopes(rpcMethod, fullMethod)
+		if !ok {
+			return m.options.denyRPC(fullMethod, CodeInsufficientScope, fmt.Sprintf("no scope policy rule matches %s", fullMethod))
+		}
+	}
+
+	if !client.Scopes.HasAll(scopes) {
+		return m.options.denyRPC(fullMethod, CodeInsufficientScope, fmt.Sprintf("client %q has not the required scopes: %s", client.Name, strings.Join(scopes, " ")))
+	}
+
+	m.options.metrics.observeDecision(methodScope, audit.OutcomeAllow, "")
+	return nil
+}
+
+// denyRPC records the deny decision of the call and returns the gRPC status of the problem
+func (o *options) denyRPC(fullMethod, code, detail string) error {
+	p := newProblem(code, detail)
+	if o.redact {
+		p.Detail = ""
+	}
+
+	o.recordDeny(rpcSubject(fullMethod), p.Code, detail)
+
+	return rpcError(p)
+}
+
+// rpcError returns the gRPC status of the problem, the error code is the reason of its error info
+func rpcError(p *Problem) error {
+	message := p.Title
+	if p.Detail != "" {
+		message = p.Detail
+	}
+
+	s := status.New(rpcCode(p.Status), fmt.Sprintf("%s: %s", p.Code, message))
+	withDetails, err := s.WithDetails(&errdetails.ErrorInfo{
+		Reason: p.Code,
+		Domain: rpcErrorDomain,
+	})
+	if err != nil {
+		return s.Err()
+	}
+
+	return withDetails.Err()
+}
+
+// rpcCode returns the gRPC status code of the HTTP status of a problem
+func rpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusBadGateway:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// metadataValue returns the first value of the metadata key, the key is case-insensitive like a header
+func metadataValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// rpcSubject returns the subject of the call in the audit log, e.g. gRPC /grpc.health.v1.Health/Check
+func rpcSubject(fullMethod string) string {
+	return fmt.Sprintf("gRPC %s", fullMethod)
+}
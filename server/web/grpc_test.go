@@ -0,0 +1,222 @@
+package web
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	clientRPC "github.com/theredrad/certauthz/client/rpc"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/file"
+	jwtCore "github.com/theredrad/certauthz/core/jwt"
+	"github.com/theredrad/certauthz/core/key"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCInterceptor(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+	dir := filepath.Dir(caPath)
+
+	// the tokens are signed by the CA key pair in the test
+	err = key.WriteKeyPair(dir, caPrivateKey)
+	if err != nil {
+		t.Fatalf("expected key pair, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	clientCertPath, clientKeyPath := filepath.Join(dir, "certificate.crt"), filepath.Join(dir, "client.key")
+	err = file.Write(clientCertPath, clientCert)
+	if err != nil {
+		t.Fatalf("expected client cert file, got err: %s", err)
+	}
+	err = key.WritePrivateKey(clientKeyPath, clientPrivateKey)
+	if err != nil {
+		t.Fatalf("expected client private key file, got err: %s", err)
+	}
+
+	tokenMiddleware, err := NewJWTokenMiddleware(filepath.Join(dir, "public.pub"))
+	if err != nil {
+		t.Fatalf("expected token middleware, got err: %s", err)
+	}
+
+	certMiddleware, err := NewCertificateMiddleware(caPath)
+	if err != nil {
+		t.Fatalf("expected certificate middleware, got err: %s", err)
+	}
+
+	policy := &ScopePolicy{Rules: []ScopeRule{
+		{PathPrefix: "/grpc.health.v1.Health/Check", Scopes: []string{"bob.user.read"}},
+		{PathPrefix: "/grpc.health.v1.Health/Watch", Scopes: []string{"bob.user.write"}},
+	}}
+	interceptor := NewGRPCInterceptor(RPCAuthenticators{
+		Token:       tokenMiddleware,
+		Certificate: certMiddleware,
+		TLS:         NewTLSCertificateMiddleware(),
+	}, NewScopePolicyMiddleware(policy))
+
+	// the handler reads the authorized client from the context
+	var authorized string
+	capture := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		authorized = ClientFromContext(ctx).Name
+		return handler(ctx, req)
+	}
+
+	serverTLS, clientTLS := newTestGRPCTLSConfigs(t, caCert, caPrivateKey)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(serverTLS)),
+		grpc.ChainUnaryInterceptor(interceptor.Unary(), capture),
+		grpc.ChainStreamInterceptor(interceptor.Stream()),
+	)
+	healthpb.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	defer s.Stop()
+
+	dial := func(creds credentials.TransportCredentials, perRPC credentials.PerRPCCredentials) healthpb.HealthClient {
+		opts := []grpc.DialOption{
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return lis.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(creds),
+		}
+		if perRPC != nil {
+			opts = append(opts, grpc.WithPerRPCCredentials(perRPC))
+		}
+
+		conn, err := grpc.Dial("bufnet", opts...)
+		if err != nil {
+			t.Fatalf("expected connection, got err: %s", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		return healthpb.NewHealthClient(conn)
+	}
+
+	token, err := jwtCore.Sign(caPrivateKey, jwt.MapClaims{
+		"sub":    "alice",
+		"scopes": []string{"bob.user.read"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatalf("expected token, got err: %s", err)
+	}
+
+	mtlsCreds, err := clientRPC.NewTLSCredentials(caPath, clientCertPath, clientKeyPath, "bufnet")
+	if err != nil {
+		t.Fatalf("expected mtls credentials, got err: %s", err)
+	}
+
+	tlsCreds := credentials.NewTLS(clientTLS)
+	tests := []struct {
+		name   string
+		client healthpb.HealthClient
+		code   codes.Code
+		reason string
+	}{
+		{name: "token", client: dial(tlsCreds, clientRPC.TokenCredentials{Token: token}), code: codes.OK},
+		{name: "certificate", client: dial(tlsCreds, &clientRPC.CertificateCredentials{Certificate: clientCert, Signer: clientPrivateKey}), code: codes.OK},
+		{name: "mtls", client: dial(mtlsCreds, nil), code: codes.OK},
+		{name: "invalid token", client: dial(tlsCreds, clientRPC.TokenCredentials{Token: "invalid"}), code: codes.Unauthenticated, reason: CodeInvalidToken},
+		{name: "no credentials", client: dial(tlsCreds, nil), code: codes.Unauthenticated, reason: CodeMissingCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorized = ""
+			_, err := tt.client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			assertRPCError(t, err, tt.code, tt.reason)
+
+			if tt.code == codes.OK && authorized != "alice" {
+				t.Errorf("expected alice in the handler context, got %q", authorized)
+			}
+		})
+	}
+
+	t.Run("stream insufficient scope", func(t *testing.T) {
+		stream, err := dial(mtlsCreds, nil).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatalf("expected stream, got err: %s", err)
+		}
+
+		_, err = stream.Recv()
+		assertRPCError(t, err, codes.PermissionDenied, CodeInsufficientScope)
+	})
+}
+
+// newTestGRPCTLSConfigs returns the TLS configs of the server and the client of the bufnet server name,
+// the client certificate is verified if it's given, so the calls are authenticated by the metadata or by the mTLS
+func newTestGRPCTLSConfigs(t *testing.T, caCert *x509.Certificate, caPrivateKey *rsa.PrivateKey) (*tls.Config, *tls.Config) {
+	serverPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected server private key, got err: %s", err)
+	}
+
+	serverCert, err := cert.NewCert(caCert, &serverPrivateKey.PublicKey, caPrivateKey, 3, "bob", "Test Org", "", []string{"bufnet"}, time.Hour)
+	if err != nil {
+		t.Fatalf("expected server cert, got err: %s", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	serverTLS := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert}, PrivateKey: serverPrivateKey}},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+
+	clientTLS := &tls.Config{
+		RootCAs:    pool,
+		ServerName: "bufnet",
+	}
+
+	return serverTLS, clientTLS
+}
+
+// assertRPCError checks the status code of the call error and the error code in its error info
+func assertRPCError(t *testing.T, err error, code codes.Code, reason string) {
+	t.Helper()
+
+	s := status.Convert(err)
+	if s.Code() != code {
+		t.Fatalf("expected code %s, got %s: %s", code, s.Code(), s.Message())
+	}
+
+	if reason == "" {
+		return
+	}
+
+	for _, d := range s.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Reason == reason {
+			return
+		}
+	}
+	t.Errorf("expected reason %s, got %v", reason, s.Details())
+}
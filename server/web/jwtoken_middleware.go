@@ -1,10 +1,13 @@
 package web
 
 import (
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
+	"github.com/theredrad/certauthz/core/common"
 	jwtCore "github.com/theredrad/certauthz/core/jwt"
 	"github.com/theredrad/certauthz/core/key"
 )
@@ -17,17 +20,19 @@ const (
 // JWTokenMiddleware is a middleware to validate the client JWT
 type JWTokenMiddleware struct {
 	validator jwtCore.Validator
+	options   options
 }
 
 // NewJWTokenMiddleware accepts the authority public key and returns a new instance of JWTokenMiddleware
-func NewJWTokenMiddleware(publicKeyPath string) (*JWTokenMiddleware, error) {
-	pubKey, err := key.ReadRSAPublicKeyFromDERFile(publicKeyPath)
+func NewJWTokenMiddleware(publicKeyPath string, opts ...Option) (*JWTokenMiddleware, error) {
+	pubKey, err := key.ReadRSAPublicKeyFromFile(publicKeyPath)
 	if err != nil {
 		return nil, err
 	}
 
 	return &JWTokenMiddleware{
 		validator: jwtCore.NewValidator(pubKey),
+		options:   newOptions(methodJWT, opts),
 	}, nil
 }
 
@@ -35,21 +40,29 @@ func NewJWTokenMiddleware(publicKeyPath string) (*JWTokenMiddleware, error) {
 func (m *JWTokenMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenHeader := r.Header.Get(authorizationHeader)
+		if tokenHeader == "" {
+			m.options.trace(CheckAuthorization, CodeMissingCredentials, errors.New("authorization header is missing"))
+			m.options.deny(w, r, schemeBearer, CodeMissingCredentials, "authorization header is missing")
+			return
+		}
+
 		parsedHeader := strings.Split(tokenHeader, " ")
 		if len(parsedHeader) != 2 || parsedHeader[0] != tokenType {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte("invalid token"))
+			m.options.trace(CheckAuthorization, CodeInvalidRequest, errors.New("invalid authorization header"))
+			m.options.deny(w, r, schemeBearer, CodeInvalidRequest, "invalid authorization header")
 			return
 		}
+		m.options.trace(CheckAuthorization, "", nil)
 
-		token, err := m.validateClientToken(parsedHeader[1])
+		start := time.Now()
+		client, code, err := m.VerifyToken(parsedHeader[1])
+		m.options.metrics.observeJWTVerify(start)
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(err.Error()))
+			m.options.deny(w, r, schemeBearer, code, err.Error())
 			return
 		}
 
-		client := jwtCore.ClientFromToken(token)
+		m.options.allow(r, client)
 
 		ctx := setClient(r.Context(), client)
 		r = r.WithContext(ctx)
@@ -57,9 +70,23 @@ func (m *JWTokenMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// VerifyToken validates the token as the bearer tokens of the requests are and returns its client, e.g. to reproduce a decision offline
+// the error code of the problem is returned on failure
+func (m *JWTokenMiddleware) VerifyToken(tokenString string) (common.Client, string, error) {
+	token, err := m.validateClientToken(tokenString)
+	if err != nil {
+		code := tokenErrorCode(err)
+		m.options.trace(CheckToken, code, err)
+		return common.Client{}, code, err
+	}
+	m.options.trace(CheckToken, "", nil)
+
+	return jwtCore.ClientFromToken(token), "", nil
+}
+
 // validateClientToken validates JWT
 func (m *JWTokenMiddleware) validateClientToken(tokenString string) (*jwt.Token, error) {
-	token, err := m.validator.Validate(tokenString)
+	token, err := m.validator.ValidateAt(tokenString, m.options.now())
 	if err != nil {
 		return nil, err
 	}
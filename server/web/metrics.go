@@ -0,0 +1,79 @@
+package web
+
+import (
+	"time"
+
+	"github.com/theredrad/certauthz/core/metrics"
+)
+
+// the authentication methods of the middlewares, used as the method label of the metrics
+const (
+	methodCertificate = "certificate"
+	methodJWT         = "jwt"
+	methodTLS         = "tls"
+	methodSession     = "session"
+	methodXFCC        = "xfcc"
+	methodScope       = "scope"
+	methodRateLimit   = "rate_limit"
+)
+
+// Metrics is the authentication and validation metrics of the middlewares
+type Metrics struct {
+	authRequests      *metrics.CounterVec
+	certificateVerify *metrics.HistogramVec
+	signatureVerify   *metrics.HistogramVec
+	jwtVerify         *metrics.HistogramVec
+}
+
+// NewMetrics registers the middleware metrics in the registry and returns a new instance of Metrics
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		authRequests: r.NewCounterVec("certauthz_auth_requests_total",
+			"Authentication decisions of the middlewares by method, outcome and error code.", "method", "outcome", "code"),
+		certificateVerify: r.NewHistogramVec("certauthz_certificate_verify_duration_seconds",
+			"Client certificate verification latency by method.", metrics.DefaultBuckets, "method"),
+		signatureVerify: r.NewHistogramVec("certauthz_signature_verify_duration_seconds",
+			"Request signature verification latency.", metrics.DefaultBuckets),
+		jwtVerify: r.NewHistogramVec("certauthz_jwt_verify_duration_seconds",
+			"JWT verification latency.", metrics.DefaultBuckets),
+	}
+}
+
+// WithMetrics records the authentication decisions and the verification latency of the middleware
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// observeDecision counts the decision of the method, the metrics are nil-safe
+func (m *Metrics) observeDecision(method, outcome, code string) {
+	if m == nil {
+		return
+	}
+	m.authRequests.Inc(method, outcome, code)
+}
+
+// observeCertificateVerify records the certificate verification latency of the method since the start
+func (m *Metrics) observeCertificateVerify(method string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.certificateVerify.Observe(time.Since(start).Seconds(), method)
+}
+
+// observeSignatureVerify records the request signature verification latency since the start
+func (m *Metrics) observeSignatureVerify(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.signatureVerify.Observe(time.Since(start).Seconds())
+}
+
+// observeJWTVerify records the JWT verification latency since the start
+func (m *Metrics) observeJWTVerify(start time.Time) {
+	if m == nil {
+		return
+	}
+	m.jwtVerify.Observe(time.Since(start).Seconds())
+}
@@ -16,3 +16,26 @@ func WrapMiddlewares(middlewares []Middlware, handler http.HandlerFunc) http.Han
 	}
 	return handler
 }
+
+// Chain returns a middleware of the middlewares in order, the nil middlewares are skipped
+func Chain(middlewares ...Middlware) Middlware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return WrapMiddlewares(middlewares, next)
+	}
+}
+
+// ForwardedOr serves the requests with the X-Forwarded-Client-Cert header by the forwarded handler and the others by the direct handler
+// the direct handler serves all the requests if the forwarded handler is nil
+func ForwardedOr(forwarded, direct http.HandlerFunc) http.HandlerFunc {
+	if forwarded == nil {
+		return direct
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(forwardedClientCertHeader) != "" {
+			forwarded(w, r)
+			return
+		}
+		direct(w, r)
+	}
+}
@@ -0,0 +1,1090 @@
+package web
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/common"
+	"github.com/theredrad/certauthz/core/file"
+	"github.com/theredrad/certauthz/core/hmac"
+	"github.com/theredrad/certauthz/core/key"
+)
+
+func TestCertificateMiddlewareProblems(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	// a certificate of another CA
+	_, otherCAPath := newTestCA(t)
+	otherMiddleware, err := NewCertificateMiddleware(otherCAPath, WithRedactedErrors())
+	if err != nil {
+		t.Fatalf("expected middleware, got err: %s", err)
+	}
+
+	m, err := NewCertificateMiddleware(caPath)
+	if err != nil {
+		t.Fatalf("expected middleware, got err: %s", err)
+	}
+
+	handler := WrapMiddlewares([]Middlware{
+		m.Handle,
+		NewScopeMiddleware([]string{"bob.user.read"}).Handle,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	writeHandler := WrapMiddlewares([]Middlware{
+		m.Handle,
+		NewScopeMiddleware([]string{"bob.user.write"}).Handle,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req := newSignedRequest(t, clientPrivateKey, clientCert, "1")
+	replayed := req.Clone(req.Context())
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		req       *http.Request
+		status    int
+		code      string
+		challenge string
+		redacted  bool
+	}{
+		{name: "valid", handler: handler, req: req, status: http.StatusOK},
+		{name: "replayed nonce", handler: handler, req: replayed, status: http.StatusUnauthorized, code: CodeReplayedNonce, challenge: `Signature realm="certauthz", error="replayed_nonce"`},
+		{name: "unknown ca", handler: otherMiddleware.Handle(handler), req: newSignedRequest(t, clientPrivateKey, clientCert, "2"), status: http.StatusUnauthorized, code: CodeUnknownCA, challenge: `Signature realm="certauthz", error="unknown_ca"`, redacted: true},
+		{name: "bad signature", handler: handler, req: newSignedRequest(t, caPrivateKey, clientCert, "3"), status: http.StatusUnauthorized, code: CodeBadSignature},
+		{name: "insufficient scope", handler: writeHandler, req: newSignedRequest(t, clientPrivateKey, clientCert, "4"), status: http.StatusForbidden, code: CodeInsufficientScope, challenge: `Signature realm="certauthz", error="insufficient_scope", scope="bob.user.write"`},
+		{name: "missing credentials", handler: handler, req: httptest.NewRequest(http.MethodGet, "/cert", nil), status: http.StatusUnauthorized, code: CodeMissingCredentials, challenge: `Signature realm="certauthz"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.code == "" {
+				return
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("expected content type %s, got %s", problemContentType, ct)
+			}
+
+			var p Problem
+			err := json.NewDecoder(w.Body).Decode(&p)
+			if err != nil {
+				t.Fatalf("expected problem, got err: %s", err)
+			}
+
+			if p.Code != tt.code || p.Status != tt.status {
+				t.Errorf("expected code %s, got %+v", tt.code, p)
+			}
+
+			if tt.redacted && p.Detail != "" {
+				t.Errorf("expected redacted detail, got %q", p.Detail)
+			}
+
+			if got := w.Header().Get(wwwAuthenticateHeader); tt.challenge != "" && !strings.HasPrefix(got, tt.challenge) {
+				t.Errorf("expected challenge %q, got %q", tt.challenge, got)
+			}
+		})
+	}
+}
+
+// newTestCA returns a new CA private key and the CA certificate file path
+func newTestCA(t *testing.T) (*rsa.PrivateKey, string) {
+	caPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected ca private key, got err: %s", err)
+	}
+
+	caBytes, err := cert.NewCA(caPrivateKey, 1, "Test CA", "Test Org", time.Hour)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	caPath := filepath.Join(t.TempDir(), "ca_certificate.crt")
+	err = file.Write(caPath, caBytes)
+	if err != nil {
+		t.Fatalf("expected ca cert file, got err: %s", err)
+	}
+
+	return caPrivateKey, caPath
+}
+
+// newSignedRequest returns a new request signed by the private key with the client certificate
+func newSignedRequest(t *testing.T, privateKey *rsa.PrivateKey, clientCert []byte, nonce string) *http.Request {
+	return newSignedRequestURL(t, privateKey, clientCert, "http://example.com/cert", nonce)
+}
+
+// newSignedRequestURL returns a request to the URL signed by the URL, the request of an https URL is received over TLS
+func newSignedRequestURL(t *testing.T, privateKey *rsa.PrivateKey, clientCert []byte, signedURL, nonce string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, signedURL, nil)
+	r.RequestURI = r.URL.RequestURI()
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signature, err := hmac.Sign(privateKey, hmac.Params{
+		Method:    r.Method,
+		URI:       signedURL,
+		Nonce:     nonce,
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		t.Fatalf("expected signature, got err: %s", err)
+	}
+
+	r.Header.Set(clientCertHeader, base64.StdEncoding.EncodeToString(clientCert))
+	r.Header.Set(nonceHeader, nonce)
+	r.Header.Set("X-Timestamp", timestamp)
+	r.Header.Set("X-Signature", signature)
+	return r
+}
+
+func TestCertificateMiddlewareTLS(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	// the listeners of a trust bundle share the nonces, a request accepted by a listener is replayed on another
+	nonces := NewNonceCache()
+	newHandler := func() http.HandlerFunc {
+		m, err := NewCertificateMiddleware(caPath, WithNonceCache(nonces))
+		if err != nil {
+			t.Fatalf("expected middleware, got err: %s", err)
+		}
+
+		return m.Handle(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+	plain, tlsListener := newHandler(), newHandler()
+
+	signed := newSignedRequestURL(t, clientPrivateKey, clientCert, "https://example.com/cert", "1")
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+		status  int
+		code    string
+	}{
+		{name: "signed https over TLS", handler: tlsListener, req: signed, status: http.StatusOK},
+		{name: "replayed on another listener", handler: plain, req: signed.Clone(signed.Context()), status: http.StatusUnauthorized, code: CodeReplayedNonce},
+		{name: "signed http over TLS", handler: tlsListener, req: func() *http.Request {
+			r := newSignedRequestURL(t, clientPrivateKey, clientCert, "http://example.com/cert", "2")
+			r.TLS = &tls.ConnectionState{}
+			return r
+		}(), status: http.StatusUnauthorized, code: CodeBadSignature},
+		{name: "signed http over plain HTTP", handler: plain, req: newSignedRequestURL(t, clientPrivateKey, clientCert, "http://example.com/cert", "3"), status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.code != "" && !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected code %s, got %s", tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCertificateMiddlewareClock(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	// the timestamp and the certificate expiry are checked at the time of the clock, e.g. of a captured request
+	tests := []struct {
+		name   string
+		after  time.Duration
+		status int
+		code   string
+	}{
+		{name: "at the signing time", status: http.StatusOK},
+		{name: "out of the timestamp window", after: 20 * time.Minute, status: http.StatusUnauthorized, code: CodeStaleTimestamp},
+		{name: "after the certificate expiry", after: 2 * time.Hour, status: http.StatusUnauthorized, code: CodeExpiredCertificate},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := time.Now().Add(tt.after)
+			m, err := NewCertificateMiddleware(caPath, WithClock(func() time.Time { return at }))
+			if err != nil {
+				t.Fatalf("expected middleware, got err: %s", err)
+			}
+
+			w := httptest.NewRecorder()
+			m.Handle(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(w, newSignedRequest(t, clientPrivateKey, clientCert, strconv.Itoa(i)))
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if tt.code != "" && !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected code %s, got %s", tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCertificateMiddlewareByReference(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	// the issued certificates are resolved by the fallback, e.g. from the issuance database
+	issuedCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 3, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected issued cert, got err: %s", err)
+	}
+	fallback := CertificateResolverFunc(func(id string) ([]byte, error) {
+		if id == cert.Thumbprint(issuedCert) {
+			return issuedCert, nil
+		}
+		return nil, ErrUnknownCertificateID
+	})
+
+	m, err := NewCertificateMiddleware(caPath, WithCertificateStore(NewCertificateStore(10, fallback)))
+	if err != nil {
+		t.Fatalf("expected middleware, got err: %s", err)
+	}
+
+	handler := m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	byReference := func(certBytes []byte, nonce string) *http.Request {
+		r := newSignedRequest(t, clientPrivateKey, certBytes, nonce)
+		r.Header.Del(clientCertHeader)
+		r.Header.Set(clientCertIDHeader, cert.Thumbprint(certBytes))
+		return r
+	}
+
+	// the certificate is not registered yet
+	w := httptest.NewRecorder()
+	handler(w, byReference(clientCert, "1"))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), CodeUnknownCertificateID) {
+		t.Fatalf("expected unknown certificate id, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler(w, newSignedRequest(t, clientPrivateKey, clientCert, "2"))
+	if w.Code != http.StatusOK || w.Header().Get(clientCertIDHeader) != cert.Thumbprint(clientCert) {
+		t.Fatalf("expected registered certificate, got %d: %s", w.Code, w.Header().Get(clientCertIDHeader))
+	}
+
+	for i, certBytes := range [][]byte{clientCert, issuedCert} {
+		w = httptest.NewRecorder()
+		handler(w, byReference(certBytes, strconv.Itoa(i+3)))
+		if w.Code != http.StatusOK {
+			t.Errorf("expected authorized request by reference, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+}
+
+// revokedSerials is a revocation checker of the revoked serial numbers
+type revokedSerials map[int64]bool
+
+func (r revokedSerials) CheckRevocation(c *x509.Certificate) error {
+	if r[c.SerialNumber.Int64()] {
+		return cert.ErrRevoked
+	}
+	return nil
+}
+
+func (r revokedSerials) NextUpdate() time.Time {
+	return time.Time{}
+}
+
+func TestSessionMiddlewareProblems(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	sessions := NewSessionStore(time.Hour, 10)
+	newSession := func(serialNumber int64) *Session {
+		certBytes, err := cert.NewCert(caCert, &caPrivateKey.PublicKey, caPrivateKey, serialNumber, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+		if err != nil {
+			t.Fatalf("expected client cert, got err: %s", err)
+		}
+
+		clientCert, err := cert.DecodeFromDERBytes(certBytes)
+		if err != nil {
+			t.Fatalf("expected client cert, got err: %s", err)
+		}
+
+		session, err := sessions.Create(clientCert, common.Client{Name: "alice"})
+		if err != nil {
+			t.Fatalf("expected session, got err: %s", err)
+		}
+		return session
+	}
+
+	session := newSession(2)
+	revokedSession := newSession(3)
+
+	handler := NewSessionMiddleware(sessions, WithRevocationChecker(revokedSerials{3: true})).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	newRequest := func(sessionID string, key []byte, nonce string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/hmac", nil)
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+		r.Header.Set(sessionIDHeader, sessionID)
+		r.Header.Set(nonceHeader, nonce)
+		r.Header.Set("X-Timestamp", timestamp)
+		r.Header.Set("X-Signature", hmac.SignMAC(key, hmac.Params{
+			Method:    r.Method,
+			URI:       "http://" + r.Host + r.RequestURI,
+			Nonce:     nonce,
+			Timestamp: timestamp,
+		}))
+		return r
+	}
+
+	req := newRequest(session.ID, session.Key, "1")
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{name: "valid", req: req, status: http.StatusOK},
+		{name: "replayed nonce", req: req.Clone(req.Context()), status: http.StatusUnauthorized, code: CodeReplayedNonce},
+		{name: "bad signature", req: newRequest(session.ID, revokedSession.Key, "2"), status: http.StatusUnauthorized, code: CodeBadSignature},
+		{name: "unknown session", req: newRequest("unknown", session.Key, "3"), status: http.StatusUnauthorized, code: CodeInvalidSession},
+		{name: "revoked certificate", req: newRequest(revokedSession.ID, revokedSession.Key, "4"), status: http.StatusUnauthorized, code: CodeRevokedCertificate},
+		{name: "deleted revoked session", req: newRequest(revokedSession.ID, revokedSession.Key, "5"), status: http.StatusUnauthorized, code: CodeInvalidSession},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, tt.req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.code != "" && !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected code %s, got %s", tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	certBytes, err := cert.NewCert(caCert, &caPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	clientCert, err := cert.DecodeFromDERBytes(certBytes)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	// the oldest sessions are evicted over the size
+	sessions := NewSessionStore(time.Hour, 2)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		session, err := sessions.Create(clientCert, common.Client{Name: "alice"})
+		if err != nil {
+			t.Fatalf("expected session, got err: %s", err)
+		}
+		ids = append(ids, session.ID)
+	}
+
+	if n := sessions.Len(); n != 2 {
+		t.Fatalf("expected 2 sessions, got %d", n)
+	}
+	if _, ok := sessions.Get(ids[0]); ok {
+		t.Fatal("expected the oldest session to be evicted")
+	}
+	if _, ok := sessions.Get(ids[2]); !ok {
+		t.Fatal("expected the newest session")
+	}
+
+	// the expired sessions are removed on creation
+	sessions = NewSessionStore(time.Nanosecond, 10)
+	for i := 0; i < 3; i++ {
+		_, err = sessions.Create(clientCert, common.Client{Name: "alice"})
+		if err != nil {
+			t.Fatalf("expected session, got err: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := sessions.Len(); n != 1 {
+		t.Fatalf("expected the expired sessions to be removed, got %d sessions", n)
+	}
+
+	// the session key is not returned over plain HTTP
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientCertificateKey, clientCert)
+		sessions.Handle(w, r.WithContext(ctx))
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodPost, "/session", nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), CodeInvalidRequest) {
+		t.Fatalf("expected invalid request over plain HTTP, got %d: %s", w.Code, w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/session", nil)
+	r.TLS = &tls.ConnectionState{}
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "session_id") {
+		t.Fatalf("expected session over TLS, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestXFCCMiddlewareProblems(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &caPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	clientPEM := url.PathEscape(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert})))
+	digest := sha256.Sum256(clientCert)
+	clientHash := hex.EncodeToString(digest[:])
+
+	// httptest requests are sent from 192.0.2.1
+	proxies, err := ParseProxyTrust("192.0.2.0/24", "")
+	if err != nil {
+		t.Fatalf("expected proxy trust, got err: %s", err)
+	}
+
+	store := NewCertificateStore(10, nil)
+	store.Register(clientCert)
+
+	m, err := NewXFCCMiddleware(caPath, proxies, WithCertificateStore(store))
+	if err != nil {
+		t.Fatalf("expected middleware, got err: %s", err)
+	}
+
+	handler := m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		if name := ClientFromContext(r.Context()).Name; name != "alice" {
+			t.Errorf("expected client alice, got %q", name)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		xfcc       string
+		remoteAddr string
+		status     int
+		code       string
+	}{
+		{name: "valid", xfcc: fmt.Sprintf(`By=spiffe://cluster.local/ns/bob;Hash=%s;Cert="%s";Subject="CN=alice,O=Test Org";URI=`, clientHash, clientPEM), status: http.StatusOK},
+		{name: "nearest proxy element", xfcc: fmt.Sprintf(`Hash=00;By=spiffe://edge,Cert="%s"`, clientPEM), status: http.StatusOK},
+		{name: "hash only", xfcc: "Hash=" + clientHash, status: http.StatusOK},
+		{name: "untrusted proxy", xfcc: fmt.Sprintf(`Cert="%s"`, clientPEM), remoteAddr: "198.51.100.1:1234", status: http.StatusForbidden, code: CodeUntrustedProxy},
+		{name: "hash mismatch", xfcc: fmt.Sprintf(`Hash=00;Cert="%s"`, clientPEM), status: http.StatusUnauthorized, code: CodeInvalidCertificate},
+		{name: "unterminated quote", xfcc: `Cert="abc`, status: http.StatusBadRequest, code: CodeInvalidRequest},
+		{name: "missing header", status: http.StatusUnauthorized, code: CodeMissingCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.xfcc != "" {
+				r.Header.Set(forwardedClientCertHeader, tt.xfcc)
+			}
+			if tt.remoteAddr != "" {
+				r.RemoteAddr = tt.remoteAddr
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.code != "" && !strings.Contains(w.Body.String(), tt.code) {
+				t.Errorf("expected code %s, got %s", tt.code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestProxyTrust(t *testing.T) {
+	proxies, err := ParseProxyTrust("", "spiffe://cluster.local/ns/default/sa/envoy")
+	if err != nil {
+		t.Fatalf("expected proxy trust, got err: %s", err)
+	}
+
+	_, err = ParseProxyTrust("", "envoy")
+	if !errors.Is(err, ErrInvalidProxyIdentity) {
+		t.Fatalf("expected invalid proxy identity error for a common name, got %v", err)
+	}
+
+	proxyURI, err := url.Parse("spiffe://cluster.local/ns/default/sa/envoy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		trusted bool
+	}{
+		{name: "URI SAN", cert: &x509.Certificate{URIs: []*url.URL{proxyURI}}, trusted: true},
+		{name: "common name", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "spiffe://cluster.local/ns/default/sa/envoy"}}},
+		{name: "other URI SAN", cert: &x509.Certificate{URIs: []*url.URL{{Scheme: "spiffe", Host: "cluster.local", Path: "/ns/default/sa/alice"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+
+			if trusted := proxies.trusts(r); trusted != tt.trusted {
+				t.Fatalf("expected trusted %t, got %t", tt.trusted, trusted)
+			}
+		})
+	}
+}
+
+func TestAuthzHandler(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	m, err := NewCertificateMiddleware(caPath)
+	if err != nil {
+		t.Fatalf("expected middleware, got err: %s", err)
+	}
+
+	policy := &ScopePolicy{Rules: []ScopeRule{
+		{PathPrefix: "/cert", Scopes: []string{"bob.user.read"}},
+		{PathPrefix: "/cert", Methods: []string{http.MethodPost}, Scopes: []string{"bob.user.write"}},
+	}}
+	handler := NewAuthzHandler("/authz", Authenticators{Certificate: m.Handle}, NewScopePolicyMiddleware(policy).Handle)
+
+	// the request to the service is signed by the client, the proxy sends its metadata to the authorization service
+	envoyRequest := func(nonce string) *http.Request {
+		r := newSignedRequest(t, clientPrivateKey, clientCert, nonce)
+		r.URL.Path, r.RequestURI = "/authz/cert", "/authz/cert"
+		return r
+	}
+	nginxRequest := func(nonce, method string) *http.Request {
+		r := newSignedRequest(t, clientPrivateKey, clientCert, nonce)
+		r.URL.Path, r.RequestURI = "/authz", "/authz"
+		r.Header.Set(originalURIHeader, "/cert")
+		if method != "" {
+			r.Method = http.MethodGet
+			r.Header.Set(originalMethodHeader, method)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{name: "envoy", req: envoyRequest("1"), status: http.StatusOK},
+		{name: "nginx", req: nginxRequest("2", ""), status: http.StatusOK},
+		{name: "signed for another method", req: nginxRequest("3", http.MethodPost), status: http.StatusUnauthorized},
+		{name: "no credentials", req: httptest.NewRequest(http.MethodGet, "/authz/cert", nil), status: http.StatusUnauthorized},
+	}
+
+	// nginx treats the statuses other than 401 and 403 as an error, the invalid request is 401
+	invalidURI := nginxRequest("4", "")
+	invalidURI.Header.Set(originalURIHeader, "%zz")
+	tests = append(tests, struct {
+		name   string
+		req    *http.Request
+		status int
+	}{name: "nginx invalid request", req: invalidURI, status: http.StatusUnauthorized})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler(w, tt.req)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+
+			if tt.status == http.StatusOK && (w.Header().Get(authClientHeader) != "alice" || w.Header().Get(authScopesHeader) != "bob.user.read") {
+				t.Errorf("expected identity headers, got %v", w.Header())
+			}
+		})
+	}
+}
+
+func TestScopePolicyRequiredScopes(t *testing.T) {
+	policy := &ScopePolicy{Rules: []ScopeRule{
+		{PathPrefix: "/", Scopes: []string{"bob.read"}},
+		{PathPrefix: "/public", Scopes: []string{}},
+		{PathPrefix: "/users", Scopes: []string{"bob.user.read"}},
+		{PathPrefix: "/admin/", Scopes: []string{"bob.admin"}},
+	}}
+
+	tests := []struct {
+		name    string
+		path    string
+		rawPath string
+		scopes  []string
+		ok      bool
+	}{
+		{name: "prefix", path: "/users", scopes: []string{"bob.user.read"}, ok: true},
+		{name: "under prefix", path: "/users/1", scopes: []string{"bob.user.read"}, ok: true},
+		{name: "partial segment", path: "/usersX", scopes: []string{"bob.read"}, ok: true},
+		{name: "prefix with trailing slash", path: "/admin", scopes: []string{"bob.admin"}, ok: true},
+		{name: "dot-dot segment", path: "/public/../admin", scopes: []string{"bob.admin"}, ok: true},
+		{name: "dot segment and duplicate slashes", path: "/public/.//users/1", scopes: []string{}, ok: true},
+		{name: "encoded slash", path: "/public/a/b", rawPath: "/public/a%2Fb"},
+		{name: "encoded backslash", path: "/public/%5C..%5Cadmin"},
+		{name: "backslash", path: "/public/..\\admin"},
+		{name: "relative", path: "public"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.URL.Path, r.URL.RawPath = tt.path, tt.rawPath
+
+			scopes, ok := policy.requiredScopes(r)
+			if ok != tt.ok {
+				t.Fatalf("expected matched %t, got %t", tt.ok, ok)
+			}
+			if strings.Join(scopes, " ") != strings.Join(tt.scopes, " ") {
+				t.Fatalf("expected scopes %v, got %v", tt.scopes, scopes)
+			}
+		})
+	}
+}
+
+func TestUpstreamProxy(t *testing.T) {
+	signingKey := []byte("upstream-signing-key")
+
+	type received struct {
+		client, scopes string
+		credentials    string
+		body           string
+		err            error
+	}
+	upstreamReceived := make(chan received, 1)
+	replayed := make(chan *http.Request, 1)
+
+	verifier := NewIdentityVerifier(signingKey)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		replayed <- r.Clone(context.Background())
+		client, scopes, err := verifier.Verify(r)
+		body, _ := io.ReadAll(r.Body)
+		upstreamReceived <- received{
+			client:      client,
+			scopes:      scopes,
+			credentials: r.Header.Get(clientCertHeader) + r.Header.Get("X-Signature"),
+			body:        string(body),
+			err:         err,
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	u, err := url.Parse(upstream.URL + "/api")
+	if err != nil {
+		t.Fatalf("expected upstream url, got err: %s", err)
+	}
+	p := NewUpstreamProxy(u, signingKey)
+
+	r := httptest.NewRequest(http.MethodPost, "/users?id=1", strings.NewReader("payload"))
+	r.Header.Set(clientCertHeader, "certificate")
+	r.Header.Set("X-Signature", "signature")
+	r.Header.Set(authClientHeader, "mallory")
+	r = r.WithContext(setClient(r.Context(), common.Client{Name: "alice", Scopes: common.Scopes{"bob.user.read": {}}}))
+
+	w := httptest.NewRecorder()
+	p.Handle(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, w.Code, w.Body.String())
+	}
+
+	got := <-upstreamReceived
+	if got.err != nil {
+		t.Fatalf("expected valid identity signature, got err: %s", got.err)
+	}
+	if got.client != "alice" || got.scopes != "bob.user.read" {
+		t.Errorf("expected alice identity, got %q %q", got.client, got.scopes)
+	}
+	if got.credentials != "" {
+		t.Errorf("expected credential headers to be stripped, got %q", got.credentials)
+	}
+	if got.body != "payload" {
+		t.Errorf("expected body to be forwarded, got %q", got.body)
+	}
+
+	// the identity headers of a forwarded request can be used once
+	_, _, err = verifier.Verify(<-replayed)
+	if !errors.Is(err, ErrInvalidIdentitySignature) || !strings.Contains(err.Error(), "nonce is already used") {
+		t.Errorf("expected replayed nonce error, got %v", err)
+	}
+
+	// the upstream is unreachable once it's closed
+	upstream.Close()
+	w = httptest.NewRecorder()
+	p.Handle(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+}
+
+func TestConnTracker(t *testing.T) {
+	tracker := NewConnTracker()
+
+	a, _ := net.Pipe()
+	b, _ := net.Pipe()
+	c, _ := net.Pipe()
+
+	tracker.ConnState(a, http.StateNew)
+	tracker.ConnState(a, http.StateActive)
+	tracker.ConnState(b, http.StateNew)
+	tracker.ConnState(b, http.StateActive)
+	tracker.ConnState(b, http.StateIdle)
+	tracker.ConnState(c, http.StateNew)
+	tracker.ConnState(c, http.StateHijacked)
+
+	if n := tracker.Count(http.StateActive); n != 1 {
+		t.Errorf("expected 1 active connection, got %d", n)
+	}
+	if n := tracker.Count(http.StateIdle); n != 1 {
+		t.Errorf("expected 1 idle connection, got %d", n)
+	}
+
+	tracker.ConnState(a, http.StateClosed)
+	tracker.ConnState(b, http.StateClosed)
+	if n := tracker.Len(); n != 0 {
+		t.Errorf("expected no open connections, got %d", n)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	policy := RateLimitPolicy{
+		Default: RateLimit{Requests: 1, Period: time.Minute},
+		Clients: map[string]RateLimit{"alice": {Requests: 2, Period: time.Minute}},
+		Scopes: map[string]RateLimit{
+			"bob.user.read":  {Requests: 3, Period: time.Minute},
+			"bob.user.batch": {Requests: 60, Period: time.Minute, Burst: 4},
+		},
+	}
+	handler := NewRateLimitMiddleware(policy).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(client common.Client) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/token", nil)
+		r = r.WithContext(setClient(r.Context(), client))
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	tests := []struct {
+		name    string
+		client  common.Client
+		allowed int
+		policy  string
+	}{
+		{name: "client limit", client: common.Client{Name: "alice", Scopes: common.Scopes{"bob.user.batch": {}}}, allowed: 2, policy: "2;w=60"},
+		{name: "most generous scope limit", client: common.Client{Name: "carol", Scopes: common.Scopes{"bob.user.read": {}, "bob.user.batch": {}}}, allowed: 4, policy: "60;w=60;burst=4"},
+		{name: "default limit", client: common.Client{Name: "dave"}, allowed: 1, policy: "1;w=60"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.allowed; i++ {
+				w := request(tt.client)
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected request %d allowed, got %d", i+1, w.Code)
+				}
+				if remaining := w.Header().Get(rateLimitRemainingHeader); remaining != strconv.Itoa(tt.allowed-i-1) {
+					t.Errorf("expected %d remaining, got %s", tt.allowed-i-1, remaining)
+				}
+				if policy := w.Header().Get(rateLimitPolicyHeader); policy != tt.policy {
+					t.Errorf("expected policy %s, got %s", tt.policy, policy)
+				}
+			}
+
+			w := request(tt.client)
+			if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), CodeRateLimited) {
+				t.Fatalf("expected rate limited, got %d: %s", w.Code, w.Body.String())
+			}
+			if w.Header().Get(retryAfterHeader) == "" {
+				t.Errorf("expected Retry-After header")
+			}
+		})
+	}
+
+	t.Run("another certificate", func(t *testing.T) {
+		// the certificates of the client share its bucket
+		client := common.Client{Name: "dave"}
+		r := httptest.NewRequest(http.MethodGet, "/cert", nil)
+		r = r.WithContext(setClientCertificate(setClient(r.Context(), client), &x509.Certificate{Raw: []byte("dave certificate")}))
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("expected the client bucket limited, got %d", w.Code)
+		}
+	})
+
+	t.Run("switched credentials", func(t *testing.T) {
+		// the tokens are kept if the limit is changed by the scopes of another credential of the client
+		reader := common.Client{Name: "erin", Scopes: common.Scopes{"bob.user.read": {}}}
+		batch := common.Client{Name: "erin", Scopes: common.Scopes{"bob.user.batch": {}}}
+		for i := 0; i < 3; i++ {
+			if w := request(reader); w.Code != http.StatusOK {
+				t.Fatalf("expected request %d allowed, got %d", i+1, w.Code)
+			}
+		}
+
+		for _, client := range []common.Client{batch, reader} {
+			if w := request(client); w.Code != http.StatusTooManyRequests {
+				t.Fatalf("expected rate limited after switching the credential, got %d", w.Code)
+			}
+		}
+	})
+}
+
+func TestSourceRateLimitMiddleware(t *testing.T) {
+	proxies, err := ParseProxyTrust("192.0.2.0/24", "")
+	if err != nil {
+		t.Fatalf("expected proxy trust, got err: %s", err)
+	}
+
+	handler := NewSourceRateLimitMiddleware(RateLimit{Requests: 1, Period: time.Minute}, proxies).Handle(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/cert", nil)
+		r.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	if w := request("10.0.0.1:1234"); w.Code != http.StatusOK || w.Header().Get(rateLimitLimitHeader) != "" {
+		t.Errorf("expected allowed without the limit headers, got %d %v", w.Code, w.Header())
+	}
+
+	// the source is limited by the IP, not by the port
+	w := request("10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), CodeRateLimited) {
+		t.Fatalf("expected rate limited, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get(retryAfterHeader) != "60" {
+		t.Errorf("expected Retry-After 60, got %q", w.Header().Get(retryAfterHeader))
+	}
+
+	if w := request("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("expected another source allowed, got %d", w.Code)
+	}
+
+	// the clients forwarded by a trusted proxy are limited after the authentication, not by the proxy address
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set(forwardedClientCertHeader, "Hash=abc")
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected forwarded request %d allowed, got %d", i+1, w.Code)
+		}
+	}
+
+	// the requests of the proxy without a forwarded certificate are limited by its address
+	request("192.0.2.1:1234")
+	if w := request("192.0.2.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the proxy address limited, got %d", w.Code)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	caPrivateKey, caPath := newTestCA(t)
+	caCert, err := cert.ReadFromDERFile(caPath)
+	if err != nil {
+		t.Fatalf("expected ca cert, got err: %s", err)
+	}
+
+	clientPrivateKey, err := key.GeneratePrivateKey(2048)
+	if err != nil {
+		t.Fatalf("expected client private key, got err: %s", err)
+	}
+
+	clientCert, err := cert.NewCert(caCert, &clientPrivateKey.PublicKey, caPrivateKey, 2, "alice", "Test Org", "bob.user.read", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("expected client cert, got err: %s", err)
+	}
+
+	var checks []string
+	tracer := WithTracer(func(c Check) {
+		checks = append(checks, fmt.Sprintf("%s/%s:%s", c.Method, c.Name, c.Code))
+	})
+
+	m, err := NewCertificateMiddleware(caPath, tracer)
+	if err != nil {
+		t.Fatalf("expected middleware, got err: %s", err)
+	}
+
+	handler := WrapMiddlewares([]Middlware{
+		m.Handle,
+		NewScopeMiddleware([]string{"bob.user.write"}, tracer).Handle,
+	}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		checks string
+	}{
+		{
+			name:   "insufficient scope",
+			req:    newSignedRequest(t, clientPrivateKey, clientCert, "1"),
+			checks: "certificate/client certificate: certificate/certificate validation: certificate/signed request: certificate/signature: certificate/nonce: scope/scopes:insufficient_scope",
+		},
+		{
+			name:   "bad signature",
+			req:    newSignedRequest(t, caPrivateKey, clientCert, "2"),
+			checks: "certificate/client certificate: certificate/certificate validation: certificate/signed request: certificate/signature:bad_signature",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks = nil
+			handler(httptest.NewRecorder(), tt.req)
+
+			if got := strings.Join(checks, " "); got != tt.checks {
+				t.Errorf("expected checks %q, got %q", tt.checks, got)
+			}
+		})
+	}
+
+	_, code, err := m.VerifyCertificate([]byte("invalid"))
+	if err == nil || code != CodeInvalidCertificate {
+		t.Errorf("expected %s, got %s: %v", CodeInvalidCertificate, code, err)
+	}
+}
@@ -0,0 +1,47 @@
+package web
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceCache remembers the request nonces for the ttl, so a signed request can not be replayed within the timestamp window
+// the middlewares of the listeners sharing a trust bundle share the cache, so a request can't be replayed on another listener
+type NonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewNonceCache returns a new instance of NonceCache, a timestamp is accepted in the window before and after now
+// so a nonce is remembered for both
+func NewNonceCache() *NonceCache {
+	return &NonceCache{
+		ttl:  2 * allowedTimeWindowSec * time.Second,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// use records the nonce and returns false if it's already used within the ttl
+func (c *NonceCache) use(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the expired nonces are removed periodically, not on every request
+	if now.Sub(c.lastSweep) > c.ttl/2 {
+		for n, expiresAt := range c.seen {
+			if now.After(expiresAt) {
+				delete(c.seen, n)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if expiresAt, ok := c.seen[nonce]; ok && !now.After(expiresAt) {
+		return false
+	}
+
+	c.seen[nonce] = now.Add(c.ttl)
+	return true
+}
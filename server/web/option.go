@@ -0,0 +1,199 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theredrad/certauthz/core/audit"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/common"
+)
+
+// Option configures the middlewares
+type Option func(*options)
+
+// options is the shared configuration of the middlewares
+type options struct {
+	method     string
+	audit      *audit.Logger
+	metrics    *Metrics
+	inclusion  cert.InclusionVerifier
+	revocation cert.RevocationChecker
+	cache      *cert.VerifiedCache
+	store      *CertificateStore
+	nonces     *NonceCache
+	redact     bool
+	tracer     Tracer
+	clock      func() time.Time
+}
+
+// WithAuditLogger records every allow/deny decision of the middleware in the audit log
+func WithAuditLogger(l *audit.Logger) Option {
+	return func(o *options) {
+		o.audit = l
+	}
+}
+
+// WithInclusionVerifier requires the client certificate to be included in the issuance log
+func WithInclusionVerifier(v cert.InclusionVerifier) Option {
+	return func(o *options) {
+		o.inclusion = v
+	}
+}
+
+// WithRevocationChecker rejects the revoked client certificates
+func WithRevocationChecker(c cert.RevocationChecker) Option {
+	return func(o *options) {
+		o.revocation = c
+	}
+}
+
+// WithCertificateCache caches the parsed and validated client certificates, so they're verified once until the result is valid
+func WithCertificateCache(c *cert.VerifiedCache) Option {
+	return func(o *options) {
+		o.cache = c
+	}
+}
+
+// WithCertificateStore registers the validated client certificates in the store, so the clients can send the certificate thumbprint
+// in the X-Client-Cert-ID header instead of the whole certificate, the thumbprint is returned in the response header
+func WithCertificateStore(s *CertificateStore) Option {
+	return func(o *options) {
+		o.store = s
+	}
+}
+
+// WithNonceCache remembers the nonces of the signed requests in the shared cache, e.g. of the listeners sharing a trust bundle,
+// so a signed request accepted by a listener can't be replayed on another
+func WithNonceCache(c *NonceCache) Option {
+	return func(o *options) {
+		o.nonces = c
+	}
+}
+
+// WithRedactedErrors omits the error details from the problem responses, e.g. the x509 verification errors in production
+// the details are still recorded in the audit log
+func WithRedactedErrors() Option {
+	return func(o *options) {
+		o.redact = true
+	}
+}
+
+// WithClock checks the timestamps of the signed requests and the expiry of the certificates and the tokens at the time of the clock,
+// e.g. to reproduce the decision of a captured request, the current time is used by default
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.clock = now
+	}
+}
+
+// newOptions applies the options of the middleware of the authentication method
+func newOptions(method string, opts []Option) options {
+	o := options{method: method}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// nonceCache returns the shared nonce cache, or a new cache of the middleware if it's not set
+func (o *options) nonceCache() *NonceCache {
+	if o.nonces != nil {
+		return o.nonces
+	}
+	return NewNonceCache()
+}
+
+// now returns the time of the clock, or the current time if it's not set
+func (o *options) now() time.Time {
+	if o.clock != nil {
+		return o.clock()
+	}
+	return time.Now()
+}
+
+// validatorOptions returns the certificate validator options of the middleware options
+func (o *options) validatorOptions() []cert.ValidatorOption {
+	var opts []cert.ValidatorOption
+	if o.revocation != nil {
+		opts = append(opts, cert.WithRevocationChecker(o.revocation))
+	}
+	if o.inclusion != nil {
+		opts = append(opts, cert.WithInclusionVerifier(o.inclusion))
+	}
+	if o.clock != nil {
+		opts = append(opts, cert.WithClock(o.clock))
+	}
+	return opts
+}
+
+// allow records the allow decision for the client
+func (o *options) allow(r *http.Request, client common.Client) {
+	o.recordAllow(requestSubject(r), client)
+}
+
+// recordAllow records the allow decision for the client of the subject, e.g. a request or a gRPC method
+func (o *options) recordAllow(subject string, client common.Client) {
+	o.metrics.observeDecision(o.method, audit.OutcomeAllow, "")
+
+	_ = o.audit.Log(audit.Record{ // the audit logger is nil-safe
+		Operation: audit.OperationAuthorize,
+		Identity:  client.Name,
+		Subject:   subject,
+		Scopes:    scopesString(client.Scopes),
+		Outcome:   audit.OutcomeAllow,
+	})
+}
+
+// deny writes the problem of the error code and records the deny decision
+// the challenge of the scheme is set in the WWW-Authenticate header if the scheme is given and the status is unauthorized
+func (o *options) deny(w http.ResponseWriter, r *http.Request, scheme, code, detail string) {
+	p := newProblem(code, detail)
+
+	description := detail
+	if o.redact {
+		p.Detail, description = "", p.Title
+	}
+
+	if scheme != "" && p.Status == http.StatusUnauthorized {
+		if code == CodeMissingCredentials {
+			// the client is not told about an error, it has not tried to authenticate
+			code, description = "", ""
+		}
+		w.Header().Set(wwwAuthenticateHeader, challenge(scheme, code, description))
+	}
+
+	writeProblem(w, p)
+
+	o.recordDeny(requestSubject(r), p.Code, detail)
+}
+
+// recordDeny records the deny decision of the subject with the error code
+func (o *options) recordDeny(subject, code, detail string) {
+	o.metrics.observeDecision(o.method, audit.OutcomeDeny, code)
+
+	_ = o.audit.Log(audit.Record{
+		Operation: audit.OperationAuthorize,
+		Subject:   subject,
+		Outcome:   audit.OutcomeDeny,
+		Error:     fmt.Sprintf("%s: %s", code, detail),
+	})
+}
+
+// scopesString returns the sorted scopes separated by space, as they're stored in the certificate
+func scopesString(scopes common.Scopes) string {
+	s := make([]string, 0, len(scopes))
+	for scope := range scopes {
+		s = append(s, scope)
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+// requestSubject returns the method and the path of the request, e.g. GET /token
+func requestSubject(r *http.Request) string {
+	return fmt.Sprintf("%s %s", r.Method, r.URL.Path)
+}
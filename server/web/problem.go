@@ -0,0 +1,166 @@
+package web
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/ctlog"
+)
+
+const (
+	problemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:certauthz:problem:"
+
+	wwwAuthenticateHeader = "WWW-Authenticate"
+	realm                 = "certauthz"
+
+	// schemeBearer is the authentication scheme of the JWT middleware (RFC 6750)
+	schemeBearer = "Bearer"
+
+	// schemeSignature is the authentication scheme of the certificate middleware, the request is signed by the client certificate private key
+	schemeSignature = "Signature"
+
+	// schemeSession is the authentication scheme of the session middleware, the request is signed by the session key
+	schemeSession = "Session"
+)
+
+// the stable error codes of the problem responses, clients can rely on them
+const (
+	CodeMissingCredentials   = "missing_credentials"
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidCertificate   = "invalid_certificate"
+	CodeUnknownCertificateID = "unknown_certificate_id"
+	CodeExpiredCertificate   = "expired_certificate"
+	CodeUnknownCA            = "unknown_ca"
+	CodeRevokedCertificate   = "revoked_certificate"
+	CodeUnloggedCertificate  = "unlogged_certificate"
+	CodeBadSignature         = "bad_signature"
+	CodeStaleTimestamp       = "stale_timestamp"
+	CodeReplayedNonce        = "replayed_nonce"
+	CodeUntrustedProxy       = "untrusted_proxy"
+	CodeInvalidSession       = "invalid_session"
+	CodeInvalidToken         = "invalid_token"
+	CodeExpiredToken         = "expired_token"
+	CodeInsufficientScope    = "insufficient_scope"
+	CodeRateLimited          = "rate_limited"
+	CodeUpstreamUnavailable  = "upstream_unavailable"
+	CodeInternalError        = "internal_error"
+)
+
+// problemDefinition is the status and the title of an error code
+type problemDefinition struct {
+	status int
+	title  string
+}
+
+var problemDefinitions = map[string]problemDefinition{
+	CodeMissingCredentials:   {http.StatusUnauthorized, "Credentials are missing"},
+	CodeInvalidRequest:       {http.StatusBadRequest, "The request is malformed"},
+	CodeInvalidCertificate:   {http.StatusUnauthorized, "The client certificate is not valid"},
+	CodeUnknownCertificateID: {http.StatusUnauthorized, "The client certificate id is not registered, the client certificate must be sent"},
+	CodeExpiredCertificate:   {http.StatusUnauthorized, "The client certificate is expired"},
+	CodeUnknownCA:            {http.StatusUnauthorized, "The client certificate is issued by an unknown CA"},
+	CodeRevokedCertificate:   {http.StatusUnauthorized, "The client certificate is revoked"},
+	CodeUnloggedCertificate:  {http.StatusUnauthorized, "The client certificate is not included in the issuance log"},
+	CodeBadSignature:         {http.StatusUnauthorized, "The request signature is not valid"},
+	CodeStaleTimestamp:       {http.StatusUnauthorized, "The request timestamp is out of the allowed window"},
+	CodeReplayedNonce:        {http.StatusUnauthorized, "The request nonce is already used"},
+	CodeUntrustedProxy:       {http.StatusForbidden, "The forwarded client certificate is not sent by a trusted proxy"},
+	CodeInvalidSession:       {http.StatusUnauthorized, "The session is not found or expired"},
+	CodeInvalidToken:         {http.StatusUnauthorized, "The token is not valid"},
+	CodeExpiredToken:         {http.StatusUnauthorized, "The token is expired"},
+	CodeInsufficientScope:    {http.StatusForbidden, "The client has not the required scopes"},
+	CodeRateLimited:          {http.StatusTooManyRequests, "The client has sent too many requests"},
+	CodeUpstreamUnavailable:  {http.StatusBadGateway, "The upstream is not available"},
+	CodeInternalError:        {http.StatusInternalServerError, "Internal error"},
+}
+
+// Problem is the body of an error response (RFC 9457)
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+}
+
+// newProblem returns the problem of the error code with the detail
+func newProblem(code, detail string) *Problem {
+	def, ok := problemDefinitions[code]
+	if !ok {
+		code, def = CodeInternalError, problemDefinitions[CodeInternalError]
+	}
+
+	return &Problem{
+		Type:   problemTypePrefix + code,
+		Title:  def.title,
+		Status: def.status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// writeProblem writes the problem in JSON format with its status code
+func writeProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// challenge returns the WWW-Authenticate header value of the scheme with the auth-params pairs
+// the error and the description are omitted if they're empty, e.g. if the request has no credentials (RFC 6750 section 3)
+func challenge(scheme, code, description string, params ...string) string {
+	attrs := []string{fmt.Sprintf("realm=%q", realm)}
+	if code != "" {
+		attrs = append(attrs, fmt.Sprintf("error=%q", code))
+	}
+	if code != "" && description != "" {
+		attrs = append(attrs, fmt.Sprintf("error_description=%q", description))
+	}
+
+	for i := 0; i+1 < len(params); i += 2 {
+		attrs = append(attrs, fmt.Sprintf("%s=%q", params[i], params[i+1]))
+	}
+
+	return fmt.Sprintf("%s %s", scheme, strings.Join(attrs, ", "))
+}
+
+// certificateErrorCode returns the error code of a client certificate validation error
+func certificateErrorCode(err error) string {
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired {
+		return CodeExpiredCertificate
+	}
+
+	var unknownErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownErr) {
+		return CodeUnknownCA
+	}
+
+	if errors.Is(err, cert.ErrRevoked) {
+		return CodeRevokedCertificate
+	}
+
+	if errors.Is(err, ctlog.ErrNotLogged) || errors.Is(err, ctlog.ErrInvalidProof) ||
+		errors.Is(err, ctlog.ErrInvalidTreeHead) || errors.Is(err, ctlog.ErrInconsistentTreeHead) {
+		return CodeUnloggedCertificate
+	}
+
+	return CodeInvalidCertificate
+}
+
+// tokenErrorCode returns the error code of a token validation error
+func tokenErrorCode(err error) string {
+	var validationErr *jwt.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return CodeExpiredToken
+	}
+
+	return CodeInvalidToken
+}
@@ -0,0 +1,171 @@
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/theredrad/certauthz/core/hmac"
+)
+
+const (
+	// authTimestampHeader, authNonceHeader and authSignatureHeader are the signature of the identity headers for the upstream,
+	// the HMAC-SHA256 by the key shared with the upstream
+	authTimestampHeader = "X-Auth-Timestamp"
+	authNonceHeader     = "X-Auth-Nonce"
+	authSignatureHeader = "X-Auth-Signature"
+)
+
+var (
+	ErrInvalidIdentitySignature = errors.New("invalid identity headers signature")
+
+	// credentialHeaders are the authentication headers of the client, they're not sent to the upstream
+	credentialHeaders = []string{
+		authorizationHeader,
+		clientCertHeader,
+		clientCertIDHeader,
+		sessionIDHeader,
+		forwardedClientCertHeader,
+		nonceHeader,
+		"X-Timestamp",
+		"X-Signature",
+	}
+
+	// identityHeaders are set by the proxy only, the headers of the client are dropped so it can't claim an identity
+	identityHeaders = []string{
+		authClientHeader,
+		authScopesHeader,
+		authTimestampHeader,
+		authNonceHeader,
+		authSignatureHeader,
+	}
+)
+
+// UpstreamProxy proxies the authorized requests to the upstream with the client identity headers,
+// it must be wrapped by an authentication middleware which sets the client in the context
+type UpstreamProxy struct {
+	proxy      *httputil.ReverseProxy
+	signingKey []byte
+}
+
+// NewUpstreamProxy accepts the upstream URL and returns a new instance of UpstreamProxy
+// the identity headers are signed by the signing key if it's not empty, so the upstream can trust them even if it's reachable by others
+func NewUpstreamProxy(upstream *url.URL, signingKey []byte) *UpstreamProxy {
+	p := &UpstreamProxy{
+		proxy:      httputil.NewSingleHostReverseProxy(upstream),
+		signingKey: signingKey,
+	}
+
+	// the identity headers are set after the URL is rewritten, the signature is of the request URI the upstream receives
+	director := p.proxy.Director
+	p.proxy.Director = func(r *http.Request) {
+		director(r)
+		p.setIdentityHeaders(r)
+	}
+	p.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		writeProblem(w, newProblem(CodeUpstreamUnavailable, ""))
+	}
+
+	return p
+}
+
+// Handle proxies the request to the upstream, the credential headers are replaced by the identity headers of the authorized client
+func (p *UpstreamProxy) Handle(w http.ResponseWriter, r *http.Request) {
+	p.proxy.ServeHTTP(w, r)
+}
+
+// setIdentityHeaders replaces the credential headers of the outgoing request by the identity headers of the client in its context
+func (p *UpstreamProxy) setIdentityHeaders(r *http.Request) {
+	client := ClientFromContext(r.Context())
+
+	for _, header := range credentialHeaders {
+		r.Header.Del(header)
+	}
+	for _, header := range identityHeaders {
+		r.Header.Del(header)
+	}
+
+	scopes := scopesString(client.Scopes)
+	r.Header.Set(authClientHeader, client.Name)
+	r.Header.Set(authScopesHeader, scopes)
+
+	if len(p.signingKey) > 0 {
+		// the nonce is unique per request, so the upstream rejects a replayed request within the timestamp window
+		nonce := make([]byte, 16)
+		_, err := rand.Read(nonce)
+		if err != nil {
+			return
+		}
+
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		nonceStr := base64.RawURLEncoding.EncodeToString(nonce)
+		r.Header.Set(authTimestampHeader, timestamp)
+		r.Header.Set(authNonceHeader, nonceStr)
+		r.Header.Set(authSignatureHeader, hmac.SignMACMessage(p.signingKey, identityMessage(r.Method, r.URL.RequestURI(), client.Name, scopes, timestamp, nonceStr)))
+	}
+}
+
+// IdentityVerifier validates the signature of the identity headers by the signing key shared with the proxy, for the upstreams in Go
+// the nonces are remembered for the timestamp window, so a signed request can be used once
+type IdentityVerifier struct {
+	signingKey []byte
+	nonces     *NonceCache
+}
+
+// NewIdentityVerifier accepts the signing key shared with the proxy and returns a new instance of IdentityVerifier
+func NewIdentityVerifier(signingKey []byte) *IdentityVerifier {
+	return &IdentityVerifier{
+		signingKey: signingKey,
+		nonces:     NewNonceCache(),
+	}
+}
+
+// Verify validates the identity headers of the request, the timestamp must be in the allowed time window and the nonce must not be used
+// it returns the client name and the scopes separated by space
+func (v *IdentityVerifier) Verify(r *http.Request) (string, string, error) {
+	timestamp := r.Header.Get(authTimestampHeader)
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidIdentitySignature, err)
+	}
+
+	now := time.Now()
+	if different := now.Unix() - signedAt; different < -allowedTimeWindowSec || different > allowedTimeWindowSec {
+		return "", "", fmt.Errorf("%w: timestamp is expired", ErrInvalidIdentitySignature)
+	}
+
+	nonce := r.Header.Get(authNonceHeader)
+	if nonce == "" {
+		return "", "", fmt.Errorf("%w: nonce is missing", ErrInvalidIdentitySignature)
+	}
+
+	client, scopes := r.Header.Get(authClientHeader), r.Header.Get(authScopesHeader)
+	err = hmac.ValidateMACMessage(v.signingKey, r.Header.Get(authSignatureHeader), identityMessage(r.Method, r.URL.RequestURI(), client, scopes, timestamp, nonce))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidIdentitySignature, err)
+	}
+
+	// the nonce is recorded after the signature is validated, so a forged request can't burn the nonce of a valid one
+	if !v.nonces.use(nonce, now) {
+		return "", "", fmt.Errorf("%w: nonce is already used", ErrInvalidIdentitySignature)
+	}
+
+	return client, scopes, nil
+}
+
+// identityMessage returns the signed string of the identity headers, the lines of the method, the request URI, the client, the scopes,
+// the timestamp and the nonce
+func identityMessage(method, requestURI, client, scopes, timestamp, nonce string) string {
+	return method + "\n" +
+		requestURI + "\n" +
+		client + "\n" +
+		scopes + "\n" +
+		timestamp + "\n" +
+		nonce
+}
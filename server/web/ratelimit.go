@@ -0,0 +1,293 @@
+package web
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/theredrad/certauthz/core/common"
+)
+
+// the rate limit headers of the responses (draft-ietf-httpapi-ratelimit-headers)
+const (
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+	rateLimitResetHeader     = "RateLimit-Reset"
+	rateLimitPolicyHeader    = "RateLimit-Policy"
+	retryAfterHeader         = "Retry-After"
+)
+
+// RateLimit is a token bucket of Burst requests refilled by Requests per Period, Burst is Requests if it's zero
+// a zero limit is unlimited
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+// unlimited reports whether the limit is not set
+func (l RateLimit) unlimited() bool {
+	return l.Requests <= 0 || l.Period <= 0
+}
+
+// rate returns the tokens refilled per second
+func (l RateLimit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// burst returns the size of the bucket
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// policy returns the RateLimit-Policy header value of the limit, e.g. 100;w=60
+func (l RateLimit) policy() string {
+	p := fmt.Sprintf("%d;w=%d", l.Requests, int64(math.Ceil(l.Period.Seconds())))
+	if l.burst() != l.Requests {
+		p += fmt.Sprintf(";burst=%d", l.burst())
+	}
+	return p
+}
+
+// RateLimitPolicy is the limits of the authenticated clients, the limit of the client name wins over the limits of its scopes,
+// the most generous limit of its scopes wins over the default limit
+type RateLimitPolicy struct {
+	Default RateLimit
+	Clients map[string]RateLimit
+	Scopes  map[string]RateLimit
+}
+
+// limit returns the limit of the client
+func (p RateLimitPolicy) limit(client common.Client) RateLimit {
+	if l, ok := p.Clients[client.Name]; ok {
+		return l
+	}
+
+	var (
+		limit RateLimit
+		found bool
+	)
+	for scope, l := range p.Scopes {
+		if _, ok := client.Scopes[scope]; !ok {
+			continue
+		}
+		if !found || l.unlimited() || (!limit.unlimited() && moreGenerous(l, limit)) {
+			limit, found = l, true
+		}
+	}
+	if found {
+		return limit
+	}
+
+	return p.Default
+}
+
+// moreGenerous reports whether the limit a refills faster than b, or has a larger burst by the same rate
+func moreGenerous(a, b RateLimit) bool {
+	if a.rate() != b.rate() {
+		return a.rate() > b.rate()
+	}
+	return a.burst() > b.burst()
+}
+
+// RateLimitMiddleware limits the requests of every authenticated client by a token bucket, the client is identified by its name,
+// so the certificates and the tokens of a client share its bucket, the certificate fingerprint is not a part of the key,
+// otherwise a client would get a new bucket by every certificate it renews or enrolls
+// it must be wrapped by an authentication middleware which sets the client in the context
+type RateLimitMiddleware struct {
+	policy  RateLimitPolicy
+	limiter *rateLimiter
+	options options
+}
+
+// NewRateLimitMiddleware accepts the limits of the clients and returns a new instance of RateLimitMiddleware
+func NewRateLimitMiddleware(policy RateLimitPolicy, opts ...Option) *RateLimitMiddleware {
+	return &RateLimitMiddleware{
+		policy:  policy,
+		limiter: newRateLimiter(),
+		options: newOptions(methodRateLimit, opts),
+	}
+}
+
+// Handle implements Middleware signature to limit the requests of the client, the limit is reported in the RateLimit headers
+// and a limited request is responded by 429 with the Retry-After header
+func (m *RateLimitMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := ClientFromContext(r.Context())
+
+		limit := m.policy.limit(client)
+		if limit.unlimited() {
+			next(w, r)
+			return
+		}
+
+		result := m.limiter.take("client:"+client.Name, limit, time.Now())
+		setRateLimitHeaders(w, limit, result)
+		if !result.allowed {
+			m.options.deny(w, r, "", CodeRateLimited, fmt.Sprintf("client %q exceeded %d requests per %s", client.Name, limit.Requests, limit.Period))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// SourceRateLimitMiddleware limits the requests of every source IP by a token bucket before they're authenticated,
+// so a client can't exhaust the server by the signature and the certificate chain verification of invalid credentials
+// the limit headers are set on the limited responses only, the authenticated responses report the limit of the client
+// the requests forwarded by the trusted proxies are not limited by the source, all their clients share the proxy address,
+// they're limited by the client after the authentication
+type SourceRateLimitMiddleware struct {
+	limit   RateLimit
+	proxies ProxyTrust
+	limiter *rateLimiter
+	options options
+}
+
+// NewSourceRateLimitMiddleware accepts the limit of every source IP and the trusted proxies of the forwarded client certificates,
+// and returns a new instance of SourceRateLimitMiddleware
+func NewSourceRateLimitMiddleware(limit RateLimit, proxies ProxyTrust, opts ...Option) *SourceRateLimitMiddleware {
+	return &SourceRateLimitMiddleware{
+		limit:   limit,
+		proxies: proxies,
+		limiter: newRateLimiter(),
+		options: newOptions(methodRateLimit, opts),
+	}
+}
+
+// Handle implements Middleware signature to limit the requests of the source IP
+func (m *SourceRateLimitMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.limit.unlimited() || r.Header.Get(forwardedClientCertHeader) != "" && m.proxies.trusts(r) {
+			next(w, r)
+			return
+		}
+
+		source := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			source = host
+		}
+
+		result := m.limiter.take("source:"+source, m.limit, time.Now())
+		if !result.allowed {
+			setRateLimitHeaders(w, m.limit, result)
+			m.options.deny(w, r, "", CodeRateLimited, fmt.Sprintf("source %s exceeded %d requests per %s", source, m.limit.Requests, m.limit.Period))
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// setRateLimitHeaders sets the RateLimit headers of the result, and the Retry-After header if the request is limited
+func setRateLimitHeaders(w http.ResponseWriter, limit RateLimit, result rateLimitResult) {
+	w.Header().Set(rateLimitLimitHeader, strconv.Itoa(limit.burst()))
+	w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(result.remaining))
+	w.Header().Set(rateLimitResetHeader, strconv.FormatInt(ceilSeconds(result.reset), 10))
+	w.Header().Set(rateLimitPolicyHeader, limit.policy())
+
+	if !result.allowed {
+		w.Header().Set(retryAfterHeader, strconv.FormatInt(ceilSeconds(result.retryAfter), 10))
+	}
+}
+
+// ceilSeconds returns the duration in seconds rounded up
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// rateLimitResult is the result of taking a token from a bucket
+type rateLimitResult struct {
+	allowed   bool
+	remaining int
+
+	// reset is the time until the bucket is full, retryAfter is the time until a token is available
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// bucket is the tokens of a key at the time they're updated
+type bucket struct {
+	limit   RateLimit
+	tokens  float64
+	updated time.Time
+}
+
+// refill adds the tokens refilled since the last update up to the burst
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.burst()), b.tokens+elapsed*b.limit.rate())
+	}
+	b.updated = now
+}
+
+// rateLimiter holds the token buckets by their keys, the full buckets are removed periodically
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// newRateLimiter returns a new instance of rateLimiter
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// sweepInterval is the interval of removing the full buckets, a full bucket is the same as a missing bucket
+const sweepInterval = time.Minute
+
+// take takes a token from the bucket of the key, a new bucket is full
+// the bucket keeps its tokens if the limit of the key is changed, e.g. by the scopes of another credential of the client,
+// only its capacity and refill rate are changed, so switching the credentials doesn't fill the bucket
+func (l *rateLimiter) take(key string, limit RateLimit, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		for k, b := range l.buckets {
+			b.refill(now)
+			if b.tokens >= float64(b.limit.burst()) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limit: limit, tokens: float64(limit.burst()), updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.limit != limit {
+		b.limit = limit
+		b.tokens = math.Min(b.tokens, float64(limit.burst()))
+	}
+
+	result := rateLimitResult{allowed: b.tokens >= 1}
+	if result.allowed {
+		b.tokens--
+	} else {
+		result.retryAfter = secondsDuration((1 - b.tokens) / limit.rate())
+	}
+
+	result.remaining = int(b.tokens)
+	result.reset = secondsDuration((float64(limit.burst()) - b.tokens) / limit.rate())
+
+	return result
+}
+
+// secondsDuration returns the duration of the seconds
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
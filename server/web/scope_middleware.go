@@ -0,0 +1,86 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/theredrad/certauthz/core/audit"
+)
+
+// ScopeMiddleware is a middleware to check the authorized client has the required scopes
+// it must be wrapped by an authentication middleware which sets the client in the context
+type ScopeMiddleware struct {
+	scopes  []string
+	policy  *ScopePolicy
+	options options
+}
+
+// NewScopeMiddleware accepts the required scopes and returns a new instance of ScopeMiddleware
+func NewScopeMiddleware(scopes []string, opts ...Option) *ScopeMiddleware {
+	return &ScopeMiddleware{
+		scopes:  scopes,
+		options: newOptions(methodScope, opts),
+	}
+}
+
+// NewScopePolicyMiddleware accepts the scope policy and returns a new instance of ScopeMiddleware
+// the scopes are required by the rule matching the request, a request matching no rule is denied
+func NewScopePolicyMiddleware(policy *ScopePolicy, opts ...Option) *ScopeMiddleware {
+	return &ScopeMiddleware{
+		policy:  policy,
+		options: newOptions(methodScope, opts),
+	}
+}
+
+// Handle implements Middleware signature to check the client scopes
+func (m *ScopeMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := ClientFromContext(r.Context())
+
+		scopes := m.scopes
+		if m.policy != nil {
+			var ok bool
+			scopes, ok = m.policy.requiredScopes(r)
+			if !ok {
+				detail := fmt.Sprintf("no scope policy rule matches %s %s", r.Method, r.URL.Path)
+				m.options.trace(CheckScopes, CodeInsufficientScope, errors.New(detail))
+				m.options.deny(w, r, "", CodeInsufficientScope, detail)
+				return
+			}
+		}
+
+		if client.Scopes.HasAll(scopes) {
+			m.options.trace(CheckScopes, "", nil)
+			m.options.metrics.observeDecision(methodScope, audit.OutcomeAllow, "")
+			next(w, r)
+			return
+		}
+
+		required := strings.Join(scopes, " ")
+		detail := fmt.Sprintf("client %q has not the required scopes: %s", client.Name, required)
+		m.options.trace(CheckScopes, CodeInsufficientScope, errors.New(detail))
+
+		// the challenge tells the client the required scopes by the scheme it's authenticated with (RFC 6750 section 3.1)
+		if scheme := requestScheme(r); scheme != "" {
+			w.Header().Set(wwwAuthenticateHeader, challenge(scheme, CodeInsufficientScope, "", "scope", required))
+		}
+
+		m.options.deny(w, r, "", CodeInsufficientScope, detail)
+	}
+}
+
+// requestScheme returns the authentication scheme of the request, empty if it's authenticated by the TLS client certificate
+func requestScheme(r *http.Request) string {
+	switch {
+	case strings.HasPrefix(r.Header.Get(authorizationHeader), tokenType+" "):
+		return schemeBearer
+	case r.Header.Get(sessionIDHeader) != "":
+		return schemeSession
+	case r.Header.Get(clientCertHeader) != "" || r.Header.Get(clientCertIDHeader) != "":
+		return schemeSignature
+	default:
+		return ""
+	}
+}
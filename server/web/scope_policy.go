@@ -0,0 +1,122 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// ScopeRule requires the scopes for the requests of the path prefix, of all the methods if the methods are empty
+type ScopeRule struct {
+	PathPrefix string   `json:"path_prefix"`
+	Methods    []string `json:"methods"`
+	Scopes     []string `json:"scopes"`
+}
+
+// ScopePolicy is the rules of the required scopes by the request path and method, the longest matching path prefix wins
+type ScopePolicy struct {
+	Rules []ScopeRule `json:"rules"`
+}
+
+// ReadScopePolicy reads the scope policy from the JSON file
+func ReadScopePolicy(path string) (*ScopePolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p ScopePolicy
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode scope policy: %w", err)
+	}
+
+	for _, rule := range p.Rules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("invalid scope policy path prefix %q", rule.PathPrefix)
+		}
+	}
+
+	return &p, nil
+}
+
+// requiredScopes returns the scopes of the rule matching the request, it's false if no rule matches
+// a path with an encoded separator matches no rule, it's split into other segments by the upstream than the decoded path
+func (p *ScopePolicy) requiredScopes(r *http.Request) ([]string, bool) {
+	if hasEncodedSeparator(r.URL.RawPath) {
+		return nil, false
+	}
+	return p.RequiredScopes(r.Method, r.URL.Path)
+}
+
+// RequiredScopes returns the scopes of the rule matching the method and the path, it's false if no rule matches
+// the path is cleaned and matched by whole segments, e.g. /users matches /users and /users/1 but not /usersX
+// a gRPC call matches by its full method name as the path, e.g. /grpc.health.v1.Health/Check, and the POST method
+func (p *ScopePolicy) RequiredScopes(method, requestPath string) ([]string, bool) {
+	cleaned, ok := cleanPath(requestPath)
+	if !ok {
+		return nil, false
+	}
+
+	var matched *ScopeRule
+	for i, rule := range p.Rules {
+		if !matchesPrefix(cleaned, rule.PathPrefix) || !ruleHasMethod(rule, method) {
+			continue
+		}
+		if matched == nil || len(rule.PathPrefix) > len(matched.PathPrefix) {
+			matched = &p.Rules[i]
+		}
+	}
+
+	if matched == nil {
+		return nil, false
+	}
+
+	return matched.Scopes, true
+}
+
+// cleanPath returns the path without the dot segments and the duplicate slashes, it's false if the path is ambiguous,
+// e.g. it's relative, it still has a dot-dot segment or it has a backslash or an encoded separator
+func cleanPath(requestPath string) (string, bool) {
+	if !strings.HasPrefix(requestPath, "/") || strings.Contains(requestPath, "\\") || hasEncodedSeparator(requestPath) {
+		return "", false
+	}
+
+	cleaned := path.Clean(requestPath)
+	for _, segment := range strings.Split(cleaned, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+
+	return cleaned, true
+}
+
+// hasEncodedSeparator reports whether the path has a percent-encoded slash or backslash
+func hasEncodedSeparator(requestPath string) bool {
+	lower := strings.ToLower(requestPath)
+	return strings.Contains(lower, "%2f") || strings.Contains(lower, "%5c")
+}
+
+// matchesPrefix reports whether the cleaned path is the prefix or under the prefix by whole segments
+func matchesPrefix(cleaned, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return cleaned == prefix || strings.HasPrefix(cleaned, prefix+"/")
+}
+
+// ruleHasMethod reports whether the rule applies to the method
+func ruleHasMethod(rule ScopeRule, method string) bool {
+	if len(rule.Methods) == 0 {
+		return true
+	}
+
+	for _, m := range rule.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
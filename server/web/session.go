@@ -0,0 +1,184 @@
+package web
+
+import (
+	"container/list"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/common"
+)
+
+const (
+	// sessionIDHeader is the id of the session the request is signed by its key
+	sessionIDHeader = "X-Session-ID"
+
+	// sessionKeySize is the size of the HMAC-SHA256 session key in bytes
+	sessionKeySize = 32
+)
+
+// Session is a symmetric key of a client bound to the certificate it's authenticated by
+type Session struct {
+	ID          string
+	Key         []byte
+	Client      common.Client
+	Certificate *x509.Certificate
+	Thumbprint  string
+	ExpiresAt   time.Time
+}
+
+// sessionResponse is the body of the session creation response
+type sessionResponse struct {
+	SessionID string    `json:"session_id"`
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SessionStore holds the session keys of the clients in memory, a session expires by the TTL or its certificate expiry
+// the sessions are kept in the creation order, the oldest sessions are evicted over the size
+type SessionStore struct {
+	ttl  time.Duration
+	size int
+
+	mu       sync.Mutex
+	entries  *list.List
+	sessions map[string]*list.Element
+}
+
+// NewSessionStore returns a new instance of SessionStore holding up to size sessions
+func NewSessionStore(ttl time.Duration, size int) *SessionStore {
+	return &SessionStore{
+		ttl:      ttl,
+		size:     size,
+		entries:  list.New(),
+		sessions: make(map[string]*list.Element),
+	}
+}
+
+// Create creates a new session of the client authenticated by the certificate
+func (s *SessionStore) Create(clientCert *x509.Certificate, client common.Client) (*Session, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionKey := make([]byte, sessionKeySize)
+	_, err = rand.Read(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	if clientCert.NotAfter.Before(expiresAt) {
+		expiresAt = clientCert.NotAfter
+	}
+
+	session := &Session{
+		ID:          base64.RawURLEncoding.EncodeToString(id),
+		Key:         sessionKey,
+		Client:      client,
+		Certificate: clientCert,
+		Thumbprint:  cert.Thumbprint(clientCert.Raw),
+		ExpiresAt:   expiresAt,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the sessions expire by the TTL in the creation order, so only the expired oldest sessions are removed on creation
+	// a session expired earlier by its certificate is removed on use or once it's the oldest
+	for e := s.entries.Front(); e != nil && !now.Before(e.Value.(*Session).ExpiresAt); e = s.entries.Front() {
+		s.remove(e)
+	}
+
+	s.sessions[session.ID] = s.entries.PushBack(session)
+	for s.entries.Len() > s.size {
+		s.remove(s.entries.Front())
+	}
+
+	return session, nil
+}
+
+// Get returns the session by the id, it's false if the session is not found or expired
+func (s *SessionStore) Get(id string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.sessions[id]
+	if !ok {
+		return nil, false
+	}
+
+	session := e.Value.(*Session)
+	if !time.Now().Before(session.ExpiresAt) {
+		s.remove(e)
+		return nil, false
+	}
+
+	return session, true
+}
+
+// Delete removes the session, e.g. if its certificate is revoked
+func (s *SessionStore) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.sessions[id]; ok {
+		s.remove(e)
+	}
+}
+
+// remove removes the session element, the lock must be held
+func (s *SessionStore) remove(e *list.Element) {
+	s.entries.Remove(e)
+	delete(s.sessions, e.Value.(*Session).ID)
+}
+
+// Len returns the number of the sessions held in memory, including the expired sessions which are not removed yet
+func (s *SessionStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Handle creates a new session for the client, it must be wrapped by the certificate middleware
+// the session id and the base64-encoded key are returned, the next requests are signed by the key with HMAC-SHA256
+// the key is a bearer credential of the client, so it's returned over TLS only
+func (s *SessionStore) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeProblem(w, newProblem(CodeInvalidRequest, "session must be created by POST"))
+		return
+	}
+
+	if r.TLS == nil {
+		writeProblem(w, newProblem(CodeInvalidRequest, "session must be created over TLS"))
+		return
+	}
+
+	clientCert := ClientCertificateFromContext(r.Context())
+	if clientCert == nil {
+		writeProblem(w, newProblem(CodeMissingCredentials, "session must be created by a client certificate"))
+		return
+	}
+
+	session, err := s.Create(clientCert, ClientFromContext(r.Context()))
+	if err != nil {
+		writeProblem(w, newProblem(CodeInternalError, ""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(sessionResponse{
+		SessionID: session.ID,
+		Key:       base64.StdEncoding.EncodeToString(session.Key),
+		ExpiresAt: session.ExpiresAt,
+	})
+}
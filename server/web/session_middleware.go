@@ -0,0 +1,83 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/hmac"
+)
+
+// SessionMiddleware is a middleware to validate the requests signed by a session key with HMAC-SHA256
+// the session is created by a certificate authenticated request, so the RSA signature is verified once per session
+type SessionMiddleware struct {
+	sessions *SessionStore
+	nonces   *NonceCache
+	options  options
+}
+
+// NewSessionMiddleware accepts the session store and returns a new instance of SessionMiddleware
+func NewSessionMiddleware(sessions *SessionStore, opts ...Option) *SessionMiddleware {
+	o := newOptions(methodSession, opts)
+	return &SessionMiddleware{
+		sessions: sessions,
+		nonces:   o.nonceCache(),
+		options:  o,
+	}
+}
+
+// Handle implements Middleware signature to validate the request session signature
+func (m *SessionMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionID := r.Header.Get(sessionIDHeader)
+		if sessionID == "" {
+			m.options.deny(w, r, schemeSession, CodeMissingCredentials, "session id header is missing")
+			return
+		}
+
+		session, ok := m.sessions.Get(sessionID)
+		if !ok {
+			m.options.deny(w, r, schemeSession, CodeInvalidSession, "session is not found or expired")
+			return
+		}
+
+		// the session is valid as long as its certificate is not revoked
+		if m.options.revocation != nil {
+			err := cert.CheckRevocationAt(m.options.revocation, session.Certificate, m.options.now())
+			if err != nil {
+				m.sessions.Delete(sessionID)
+				m.options.deny(w, r, schemeSession, certificateErrorCode(err), err.Error())
+				return
+			}
+		}
+
+		params, signature, code, err := readSignedRequest(r, m.options.now())
+		if err != nil {
+			m.options.deny(w, r, schemeSession, code, err.Error())
+			return
+		}
+
+		start := time.Now()
+		err = hmac.ValidateMAC(session.Key, signature, params)
+		m.options.metrics.observeSignatureVerify(start)
+		if err != nil {
+			m.options.deny(w, r, schemeSession, CodeBadSignature, err.Error())
+			return
+		}
+
+		if !m.nonces.use(fmt.Sprintf("%s/%s", sessionID, params.Nonce), time.Now()) {
+			m.options.deny(w, r, schemeSession, CodeReplayedNonce, "nonce is already used")
+			return
+		}
+
+		m.options.allow(r, session.Client)
+
+		// set the client in the context, so the handler has access to the authorized client
+		ctx := setClient(r.Context(), session.Client)
+		ctx = setClientCertificate(ctx, session.Certificate)
+
+		r = r.WithContext(ctx)
+		next(w, r)
+	}
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/theredrad/certauthz/core/hmac"
+)
+
+const (
+	// nonceHeader random nonce header key, it's used once in the allowed time window
+	nonceHeader = "X-Nonce"
+
+	// allowedTimeWindowSec hmac signature expiration time in second since X-Timestamp header
+	allowedTimeWindowSec = 600
+
+	// maxSignedBodySize is the max size of a signed request body in bytes, the body is read in memory to be hashed
+	maxSignedBodySize = 10 << 20
+)
+
+// readSignedRequest reads the signature and the signed params of the request, the timestamp must be in the allowed time window of now
+// the error code of the problem is returned on failure
+func readSignedRequest(r *http.Request, now time.Time) (hmac.Params, string, string, error) {
+	signature, timestampStr, nonce, code, err := readSignatureHeaders(r.Header.Get, now)
+	if err != nil {
+		return hmac.Params{}, "", code, err
+	}
+
+	// calculate md5 hash of body content, the body is kept for the handler, e.g. to be proxied to the upstream
+	var bodyHash string
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+		if err != nil {
+			return hmac.Params{}, "", CodeInternalError, err
+		}
+		if len(body) > maxSignedBodySize {
+			return hmac.Params{}, "", CodeInvalidRequest, errors.New("request body is too large")
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		bodyHash, err = hmac.CalculateMD5Hash(bytes.NewReader(body))
+		if err != nil {
+			return hmac.Params{}, "", CodeInternalError, err
+		}
+	}
+
+	return hmac.Params{
+		Method:    r.Method,
+		BodyMD5:   bodyHash,
+		URI:       fmt.Sprintf("%s://%s%s", uriScheme(r), r.Host, r.RequestURI),
+		Nonce:     nonce,
+		Timestamp: timestampStr,
+	}, signature, "", nil
+}
+
+// uriScheme returns the scheme of the URI the client signed, https if the request is received over TLS
+func uriScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// readSignatureHeaders reads the signature, the timestamp and the nonce by the get function of the request headers or the gRPC metadata
+// the timestamp must be in the allowed time window of now, the error code of the problem is returned on failure
+func readSignatureHeaders(get func(key string) string, now time.Time) (string, string, string, string, error) {
+	// read hmac signature from the header
+	signature := get("X-Signature")
+
+	timestampStr := get("X-Timestamp")
+
+	if timestampStr == "" {
+		return "", "", "", CodeInvalidRequest, errors.New("timestamp header is missing")
+	}
+
+	requestTimestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return "", "", "", CodeInvalidRequest, err
+	}
+
+	// validates if signature is not expired by allowed time window config
+	timestampNow := now.Unix()
+	different := timestampNow - requestTimestamp
+	if different < -allowedTimeWindowSec || different > allowedTimeWindowSec {
+		return "", "", "", CodeStaleTimestamp, errors.New("timestamp is expired")
+	}
+
+	nonce := get(nonceHeader)
+	if nonce == "" {
+		return "", "", "", CodeInvalidRequest, errors.New("nonce header is missing")
+	}
+
+	return signature, timestampStr, nonce, "", nil
+}
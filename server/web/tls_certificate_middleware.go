@@ -1,39 +1,76 @@
 package web
 
 import (
+	"crypto/x509"
 	"net/http"
+	"time"
 
 	"github.com/theredrad/certauthz/core/cert"
 	"github.com/theredrad/certauthz/core/common"
 )
 
 // TLSCertificateMiddleware is a middleware to parse validated certificate and pass the scopes in the context
-type TLSCertificateMiddleware struct{}
+type TLSCertificateMiddleware struct {
+	options options
+}
 
 // NewTLSCertificateMiddleware returns a new instance of TLSCertificateMiddleware
-func NewTLSCertificateMiddleware() *TLSCertificateMiddleware {
-	return &TLSCertificateMiddleware{}
+func NewTLSCertificateMiddleware(opts ...Option) *TLSCertificateMiddleware {
+	return &TLSCertificateMiddleware{
+		options: newOptions(methodTLS, opts),
+	}
 }
 
 // Handle implements Middleware signature to validate the request client certificate
 func (m *TLSCertificateMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		clientCert, ok := r.TLS.PeerCertificates[0], len(r.TLS.PeerCertificates) > 0
-		if !ok {
-			http.Error(w, "client certificate not found", http.StatusUnauthorized)
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			m.options.deny(w, r, "", CodeMissingCredentials, "client certificate not found")
+			return
+		}
+		clientCert := r.TLS.PeerCertificates[0]
+
+		err := m.checkPeerCertificate(clientCert)
+		if err != nil {
+			m.options.deny(w, r, "", certificateErrorCode(err), err.Error())
 			return
 		}
 
 		// read scopes from the client cerificate
 		scopes := cert.ScopesFromCertificate(clientCert)
 
-		// set the client in the context, so the handler has access to the authorized client
-		ctx := setClient(r.Context(), common.Client{
+		client := common.Client{
 			Name:   clientCert.Subject.CommonName,
 			Scopes: scopes,
-		})
+		}
+		m.options.allow(r, client)
+
+		// set the client in the context, so the handler has access to the authorized client
+		ctx := setClient(r.Context(), client)
 
 		r = r.WithContext(ctx)
 		next(w, r)
 	}
 }
+
+// checkPeerCertificate checks the client certificate of the TLS peer,
+// the certificate chain is verified by the TLS handshake, the revocation and the inclusion are verified here
+func (m *TLSCertificateMiddleware) checkPeerCertificate(clientCert *x509.Certificate) error {
+	if m.options.revocation != nil {
+		err := cert.CheckRevocationAt(m.options.revocation, clientCert, m.options.now())
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.options.inclusion != nil {
+		start := time.Now()
+		err := m.options.inclusion.VerifyInclusion(clientCert)
+		m.options.metrics.observeCertificateVerify(methodTLS, start)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
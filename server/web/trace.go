@@ -0,0 +1,48 @@
+package web
+
+// the names of the checks of the middlewares in order, they're given to the tracer
+const (
+	CheckClientCertificate = "client certificate"
+	CheckCertificate       = "certificate validation"
+	CheckSignedRequest     = "signed request"
+	CheckSignature         = "signature"
+	CheckNonce             = "nonce"
+	CheckAuthorization     = "authorization header"
+	CheckToken             = "token validation"
+	CheckScopes            = "scopes"
+)
+
+// Check is the result of a check of a middleware, the error code of the problem and the error are set if it's failed
+type Check struct {
+	Method string
+	Name   string
+	Code   string
+	Err    error
+}
+
+// Passed reports whether the check is passed
+func (c Check) Passed() bool {
+	return c.Err == nil
+}
+
+// Tracer receives the result of every check the middleware runs, the checks after a failed one are not run
+type Tracer func(Check)
+
+// WithTracer traces the checks of the middleware, e.g. to print why a captured request is denied
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// trace gives the result of the check to the tracer if it's set, the code is dropped if the check is passed
+func (o *options) trace(name, code string, err error) {
+	if o.tracer == nil {
+		return
+	}
+
+	if err == nil {
+		code = ""
+	}
+	o.tracer(Check{Method: o.method, Name: name, Code: code, Err: err})
+}
@@ -0,0 +1,202 @@
+package web
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// forwardedClientCertHeader is the client certificate forwarded by the proxy which terminated the TLS, e.g. Envoy
+	forwardedClientCertHeader = "X-Forwarded-Client-Cert"
+)
+
+var (
+	ErrMalformedXFCC = errors.New("malformed forwarded client certificate header")
+)
+
+// ForwardedClientCert is an element of the X-Forwarded-Client-Cert header, the client certificate of a proxy hop
+// the Cert and the Chain are the URL-encoded PEM certificates, the Hash is the hex-encoded SHA-256 of the certificate in DER format
+type ForwardedClientCert struct {
+	By      string
+	Hash    string
+	Cert    string
+	Chain   string
+	Subject string
+	URI     string
+	DNS     []string
+}
+
+// parseXFCC parses the X-Forwarded-Client-Cert header value, the elements are separated by comma and the fields by semicolon,
+// a value is double-quoted if it includes a comma, a semicolon or an equal sign
+func parseXFCC(header string) ([]ForwardedClientCert, error) {
+	var (
+		elements []ForwardedClientCert
+		element  ForwardedClientCert
+		field    strings.Builder
+		fields   []string
+		quoted   bool
+		escaped  bool
+	)
+
+	flushField := func() {
+		fields = append(fields, field.String())
+		field.Reset()
+	}
+
+	flushElement := func() error {
+		flushField()
+
+		element = ForwardedClientCert{}
+		for _, f := range fields {
+			if strings.TrimSpace(f) == "" {
+				continue
+			}
+
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				return fmt.Errorf("%w: field %q has no value", ErrMalformedXFCC, f)
+			}
+
+			k, v = strings.TrimSpace(k), unquote(strings.TrimSpace(v))
+			switch strings.ToLower(k) {
+			case "by":
+				element.By = v
+			case "hash":
+				element.Hash = v
+			case "cert":
+				element.Cert = v
+			case "chain":
+				element.Chain = v
+			case "subject":
+				element.Subject = v
+			case "uri":
+				element.URI = v
+			case "dns":
+				element.DNS = append(element.DNS, v)
+			}
+		}
+
+		elements = append(elements, element)
+		fields = nil
+		return nil
+	}
+
+	for _, c := range header {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ';':
+			flushField()
+			continue
+		case !quoted && c == ',':
+			err := flushElement()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		field.WriteRune(c)
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("%w: unterminated quote", ErrMalformedXFCC)
+	}
+
+	err := flushElement()
+	if err != nil {
+		return nil, err
+	}
+
+	return elements, nil
+}
+
+// Certificate returns the client certificate in DER format, it's nil if the proxy forwarded the hash only
+func (f ForwardedClientCert) Certificate() ([]byte, error) {
+	if f.Cert == "" {
+		return nil, nil
+	}
+
+	certs, err := decodeForwardedPEM(f.Cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) != 1 {
+		return nil, fmt.Errorf("%w: expected 1 certificate, got %d", ErrMalformedXFCC, len(certs))
+	}
+
+	return certs[0], nil
+}
+
+// Intermediates returns the intermediate certificates of the forwarded chain, the client certificate is skipped
+func (f ForwardedClientCert) Intermediates(clientCert []byte) ([]*x509.Certificate, error) {
+	if f.Chain == "" {
+		return nil, nil
+	}
+
+	certs, err := decodeForwardedPEM(f.Chain)
+	if err != nil {
+		return nil, err
+	}
+
+	var intermediates []*x509.Certificate
+	for _, der := range certs {
+		if string(der) == string(clientCert) {
+			continue
+		}
+
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		intermediates = append(intermediates, c)
+	}
+
+	return intermediates, nil
+}
+
+// decodeForwardedPEM decodes the URL-encoded PEM certificates and returns them in DER format
+func decodeForwardedPEM(s string) ([][]byte, error) {
+	// the PEM is URL-encoded by the proxy, a plus sign is a base64 character not a space
+	decoded, err := url.PathUnescape(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedXFCC, err)
+	}
+
+	var (
+		certs [][]byte
+		rest  = []byte(decoded)
+		block *pem.Block
+	)
+	for {
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, block.Bytes)
+		}
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%w: no PEM certificate", ErrMalformedXFCC)
+	}
+
+	return certs, nil
+}
+
+// unquote removes the double quotes of a value and the escapes of its double quotes
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	return strings.ReplaceAll(s[1:len(s)-1], `\"`, `"`)
+}
@@ -0,0 +1,245 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theredrad/certauthz/core/cert"
+	"github.com/theredrad/certauthz/core/common"
+)
+
+var (
+	ErrInvalidProxyIdentity = errors.New("proxy identity must be a URI SAN, e.g. spiffe://cluster.local/ns/default/sa/envoy")
+)
+
+// ProxyTrust is the proxies trusted to forward the client certificates, by their address or their mTLS identity
+type ProxyTrust struct {
+	// CIDRs are the networks of the trusted proxies, e.g. the sidecar on the loopback
+	CIDRs []*net.IPNet
+
+	// Identities are the URI SANs (e.g. SPIFFE IDs) of the trusted proxies client certificates
+	// the common name is not matched, any client certificate of the CA could have the common name of a proxy
+	Identities []string
+}
+
+// ParseProxyTrust parses the CIDRs and the identities separated by space and returns a new instance of ProxyTrust
+func ParseProxyTrust(cidrs, identities string) (ProxyTrust, error) {
+	var t ProxyTrust
+	for _, s := range strings.Fields(cidrs) {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return ProxyTrust{}, err
+		}
+		t.CIDRs = append(t.CIDRs, network)
+	}
+
+	for _, s := range strings.Fields(identities) {
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" {
+			return ProxyTrust{}, fmt.Errorf("%w: %q", ErrInvalidProxyIdentity, s)
+		}
+		t.Identities = append(t.Identities, s)
+	}
+
+	return t, nil
+}
+
+// trusts reports whether the request is sent by a trusted proxy
+func (t ProxyTrust) trusts(r *http.Request) bool {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			for _, network := range t.CIDRs {
+				if network.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+
+	// the proxy is authenticated by the TLS handshake, its certificate is verified by the CA
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+
+	proxyCert := r.TLS.PeerCertificates[0]
+	for _, identity := range t.Identities {
+		for _, uri := range proxyCert.URIs {
+			if uri.String() == identity {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// XFCCMiddleware is a middleware to validate the client certificate forwarded by a proxy in the X-Forwarded-Client-Cert header,
+// e.g. by an Envoy sidecar which terminates the mTLS, the header is trusted from the trusted proxies only
+type XFCCMiddleware struct {
+	caPath  string
+	proxies ProxyTrust
+	options options
+
+	mu            sync.RWMutex
+	certValidator *cert.Validator
+}
+
+// NewXFCCMiddleware accepts CA certificate path and the trusted proxies and returns a new instance of XFCCMiddleware
+func NewXFCCMiddleware(caPath string, proxies ProxyTrust, opts ...Option) (*XFCCMiddleware, error) {
+	m := &XFCCMiddleware{
+		caPath:  caPath,
+		proxies: proxies,
+		options: newOptions(methodXFCC, opts),
+	}
+
+	err := m.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Reload reads the CA certificate from the disk, the cached validation results of the previous CA certificate are dropped
+func (m *XFCCMiddleware) Reload() error {
+	caCert, err := cert.ReadCAFromFile(m.caPath)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.certValidator = cert.NewValidator(caCert, m.options.validatorOptions()...)
+	m.mu.Unlock()
+
+	if m.options.cache != nil {
+		m.options.cache.Purge()
+	}
+
+	return nil
+}
+
+// Handle implements Middleware signature to validate the forwarded client certificate
+func (m *XFCCMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := strings.Join(r.Header.Values(forwardedClientCertHeader), ",")
+		if header == "" {
+			m.options.deny(w, r, "", CodeMissingCredentials, "forwarded client certificate header is missing")
+			return
+		}
+
+		// the header of an untrusted client is not a proof of anything
+		if !m.proxies.trusts(r) {
+			m.options.deny(w, r, "", CodeUntrustedProxy, fmt.Sprintf("%s is not a trusted proxy", r.RemoteAddr))
+			return
+		}
+
+		elements, err := parseXFCC(header)
+		if err != nil {
+			m.options.deny(w, r, "", CodeInvalidRequest, err.Error())
+			return
+		}
+
+		// the last element is appended by the nearest proxy, the one which terminated the client TLS
+		forwarded := elements[len(elements)-1]
+
+		start := time.Now()
+		clientCert, err := m.validateForwardedCertificate(forwarded)
+		m.options.metrics.observeCertificateVerify(methodXFCC, start)
+		if err != nil {
+			code := certificateErrorCode(err)
+			switch {
+			case errors.Is(err, ErrMalformedXFCC):
+				code = CodeInvalidRequest
+			case errors.Is(err, ErrUnknownCertificateID):
+				code = CodeUnknownCertificateID
+			}
+			m.options.deny(w, r, "", code, err.Error())
+			return
+		}
+
+		// read scopes from the client cerificate
+		scopes := cert.ScopesFromCertificate(clientCert)
+
+		client := common.Client{
+			Name:   clientCert.Subject.CommonName,
+			Scopes: scopes,
+		}
+		m.options.allow(r, client)
+
+		// set the client in the context, so the handler has access to the authorized client
+		ctx := setClient(r.Context(), client)
+		ctx = setClientCertificate(ctx, clientCert)
+
+		r = r.WithContext(ctx)
+		next(w, r)
+	}
+}
+
+// validateForwardedCertificate returns the validated client certificate of the forwarded element
+// a proxy forwarding the hash only is supported by the certificate store, the certificate is resolved by its thumbprint
+func (m *XFCCMiddleware) validateForwardedCertificate(forwarded ForwardedClientCert) (*x509.Certificate, error) {
+	certBytes, err := forwarded.Certificate()
+	if err != nil {
+		return nil, err
+	}
+
+	if certBytes == nil {
+		if forwarded.Hash == "" || m.options.store == nil {
+			return nil, fmt.Errorf("%w: no certificate", ErrMalformedXFCC)
+		}
+
+		digest, err := hex.DecodeString(forwarded.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMalformedXFCC, err)
+		}
+
+		certBytes, err = m.options.store.Resolve(base64.RawURLEncoding.EncodeToString(digest))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the hash is checked if it's forwarded with the certificate, they must be of the same certificate
+	if forwarded.Hash != "" {
+		digest := sha256.Sum256(certBytes)
+		if !strings.EqualFold(forwarded.Hash, hex.EncodeToString(digest[:])) {
+			return nil, errors.New("forwarded certificate hash mismatch")
+		}
+	}
+
+	intermediates, err := forwarded.Intermediates(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	certValidator := m.certValidator
+	m.mu.RUnlock()
+
+	// the cache is keyed by the client certificate only, a forwarded chain is verified on every request
+	if m.options.cache != nil && len(intermediates) == 0 {
+		return m.options.cache.Validate(certValidator, certBytes)
+	}
+
+	clientCert, err := cert.DecodeFromDERBytes(certBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = certValidator.ValidateWithIntermediates(clientCert, intermediates)
+	if err != nil {
+		return nil, err
+	}
+
+	return clientCert, nil
+}